@@ -0,0 +1,54 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Archiver keeps copies of sealed slabs somewhere other than the topic
+// directory, such as an object store, see the s3 subpackage.  Files are
+// named as in the topic directory e.g. <base>.slab and <base>.footer, an
+// Archiver serving several topics should keep each under its own prefix.
+type Archiver interface {
+	Upload(name string, r io.Reader, size int64) error // store size bytes from r as name
+	Exists(name string) (bool, error)                  // report whether name has been stored
+	Delete(name string) error                          // remove name from the store
+}
+
+// ArchiveSegment copies a sealed segment to an Archiver, its footer first
+// if it has one and then the slab itself, so a slab that Exists in the
+// archive is there in full.  A slab already archived is not uploaded again,
+// nor is a compressed slab inflated first, see CompressSegments.
+func ArchiveSegment(a Archiver, seg Segment) error {
+	name := filepath.Base(seg.Path)
+	exists, err := a.Exists(name)
+	if err != nil || exists {
+		return err
+	}
+
+	err = uploadFile(a, footerPath(seg.Path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return uploadFile(a, seg.Path)
+}
+
+// uploadFile stores the whole of path under its base name
+func uploadFile(a Archiver, path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	stat, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	return a.Upload(filepath.Base(path), io.NewSectionReader(fp, 0, stat.Size()), stat.Size())
+}
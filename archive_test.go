@@ -0,0 +1,99 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// memArchiver keeps archived files in memory
+type memArchiver map[string][]byte
+
+func (m memArchiver) Upload(name string, r io.Reader, size int64) error {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) != size {
+		return io.ErrUnexpectedEOF
+	}
+	m[name] = buf
+	return nil
+}
+
+func (m memArchiver) Exists(name string) (bool, error) {
+	_, ok := m[name]
+	return ok, nil
+}
+
+func (m memArchiver) Download(name string, w io.Writer) error {
+	buf, ok := m[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func (m memArchiver) Delete(name string) error {
+	delete(m, name)
+	return nil
+}
+
+func Test_Queuefka_Archiver(t *testing.T) {
+	archiveTopic := topic + ".archiver"
+	os.RemoveAll(archiveTopic)
+	defer os.RemoveAll(archiveTopic)
+
+	archive := memArchiver{}
+	wt, err := queuefka.NewWriter(archiveTopic, 256, queuefka.WithRetention(queuefka.RetentionPolicy{MaxSegments: 2, Archiver: archive}))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	for i := 0; i < 100; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	before := queuefka.SlabFiles(archiveTopic)
+	contents := make(map[string][]byte)
+	for _, slab := range before {
+		contents[filepath.Base(slab)], _ = ioutil.ReadFile(slab)
+	}
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+
+	// every deleted slab was uploaded in full along with its footer
+	deleted := before[:len(before)-2]
+	if len(deleted) == 0 || len(queuefka.SlabFiles(archiveTopic)) != 2 {
+		panic("queuefka: ApplyRetention did not delete the oldest slabs:")
+	}
+	for _, slab := range deleted {
+		name := filepath.Base(slab)
+		if string(archive[name]) != string(contents[name]) {
+			println(name, len(archive[name]), len(contents[name]))
+			panic("queuefka: ApplyRetention did not archive a deleted slab:")
+		}
+		if _, ok := archive[strings.TrimSuffix(name, ".slab")+".footer"]; !ok {
+			println(name)
+			panic("queuefka: ApplyRetention did not archive the footer of a deleted slab:")
+		}
+	}
+	if len(archive) != 2*len(deleted) {
+		println(len(archive), len(deleted))
+		panic("queuefka: ApplyRetention archived a slab it kept:")
+	}
+}
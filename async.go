@@ -0,0 +1,235 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrWriterClosed is returned by an AsyncWriter once it has been closed.
+	ErrWriterClosed = errors.New("queuefka: Write() writer closed")
+
+	// ErrBackpressure is returned instead of blocking by an AsyncWriter with
+	// RejectWhenFull set once its in-flight limits are reached.
+	ErrBackpressure = errors.New("queuefka: Write() too many messages in flight")
+)
+
+// AsyncError reports a message an AsyncWriter or WriterPool failed to write.
+type AsyncError struct {
+	Topic string // the topic written to, for a WriterPool
+	Value []byte // the message, which can be written again
+	Err   error
+}
+
+func (e *AsyncError) Error() string {
+	return e.Err.Error()
+}
+
+// AsyncWriter queues messages for a Writer owned by a background goroutine
+// which frames, flushes and rolls slabs, so Write returns without waiting on
+// the disk.  Write blocks once QueueLength messages or InFlightBytes bytes are
+// queued and not yet written, or fails with ErrBackpressure if RejectWhenFull
+// is set, so a slow disk cannot make memory grow without bound.  Errors are
+// passed to the ErrorHandler option if one was given,
+// otherwise they are sent on the Errors channel which must then be drained.
+type AsyncWriter struct {
+	wt      *Writer
+	queue   chan asyncRecord
+	errs    chan error
+	handler func(error)
+	done    chan struct{} // closed once the background goroutine exits
+
+	maxRecords int        // limit on inFlight records
+	maxBytes   int64      // limit on inFlight bytes, 0 means unlimited
+	reject     bool       // fail Write rather than block at a limit
+	records    int        // messages queued and not yet written
+	bytes      int64      // bytes queued and not yet written
+	inFlight   sync.Mutex // guards records and bytes
+	drained    *sync.Cond // broadcast as queued messages are written
+
+	closed       bool
+	sync.RWMutex // guards closed against sends on a closed queue
+}
+
+// asyncRecord is a queued message, or a Flush request if flushed is set
+type asyncRecord struct {
+	value   []byte
+	flushed chan error
+}
+
+// NewAsyncWriter returns an AsyncWriter around NewWriter(topic, slabSizeHint,
+// opts...).  The QueueLength option bounds how many messages may be queued
+// before Write blocks.
+func NewAsyncWriter(topic string, slabSizeHint uint64, opts ...Option) (*AsyncWriter, error) {
+	wt, err := NewWriter(topic, slabSizeHint, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	o := defaultOptions(opts)
+	if o.QueueLength < 1 {
+		o.QueueLength = 1
+	}
+	aw := &AsyncWriter{
+		wt:      wt,
+		queue:   make(chan asyncRecord, o.QueueLength),
+		errs:    make(chan error, o.QueueLength),
+		handler: o.ErrorHandler,
+		done:    make(chan struct{}),
+
+		maxRecords: o.QueueLength,
+		maxBytes:   o.InFlightBytes,
+		reject:     o.RejectWhenFull,
+	}
+	aw.drained = sync.NewCond(&aw.inFlight)
+	go aw.run()
+	return aw, nil
+}
+
+// Write queues a copy of d to be appended.
+func (aw *AsyncWriter) Write(d []byte) error {
+	aw.RLock()
+	defer aw.RUnlock()
+
+	if aw.closed {
+		return ErrWriterClosed
+	}
+	err := aw.acquire(int64(len(d)))
+	if err != nil {
+		return err
+	}
+
+	rec := asyncRecord{value: append([]byte(nil), d...)}
+	if !aw.reject {
+		aw.queue <- rec
+		return nil
+	}
+	select {
+	case aw.queue <- rec:
+		return nil
+	default:
+		// the queue is full of Flush requests
+		aw.release(int64(len(d)))
+		return ErrBackpressure
+	}
+}
+
+// acquire reserves room for a message of n bytes, waiting for the background
+// goroutine unless reject is set.  A message larger than maxBytes is let
+// through once nothing else is in flight.
+func (aw *AsyncWriter) acquire(n int64) error {
+	aw.inFlight.Lock()
+	defer aw.inFlight.Unlock()
+
+	for aw.records >= aw.maxRecords || (aw.maxBytes > 0 && aw.records > 0 && aw.bytes+n > aw.maxBytes) {
+		if aw.reject {
+			return ErrBackpressure
+		}
+		aw.drained.Wait()
+	}
+	aw.records++
+	aw.bytes += n
+	return nil
+}
+
+// release frees the room held by a message of n bytes
+func (aw *AsyncWriter) release(n int64) {
+	aw.inFlight.Lock()
+	aw.records--
+	aw.bytes -= n
+	aw.inFlight.Unlock()
+	aw.drained.Broadcast()
+}
+
+// Flush waits until every message queued before it has been written and
+// flushed, returning the error of the flush itself.  Errors writing the
+// messages are reported as usual.
+func (aw *AsyncWriter) Flush() error {
+	aw.RLock()
+	if aw.closed {
+		aw.RUnlock()
+		return ErrWriterClosed
+	}
+	flushed := make(chan error, 1)
+	aw.queue <- asyncRecord{flushed: flushed}
+	aw.RUnlock()
+
+	return <-flushed
+}
+
+// Errors returns the channel write errors are sent on, as *AsyncError, when
+// no ErrorHandler was given.  It is closed by Close.
+func (aw *AsyncWriter) Errors() <-chan error {
+	return aw.errs
+}
+
+// Close writes everything still queued then closes the Writer.
+func (aw *AsyncWriter) Close() error {
+	aw.Lock()
+	if aw.closed {
+		aw.Unlock()
+		return ErrWriterClosed
+	}
+	aw.closed = true
+	close(aw.queue)
+	aw.Unlock()
+
+	<-aw.done
+	err := aw.wt.Close()
+	close(aw.errs)
+	return err
+}
+
+// run writes queued messages until the queue is closed, flushing whenever it
+// runs dry so nothing waits in the buffer for long
+func (aw *AsyncWriter) run() {
+	defer close(aw.done)
+
+	for rec := range aw.queue {
+		aw.handle(rec)
+
+		// write whatever else is already queued before flushing
+		for more := true; more; {
+			select {
+			case rec, ok := <-aw.queue:
+				if !ok {
+					return
+				}
+				aw.handle(rec)
+			default:
+				more = false
+			}
+		}
+		err := aw.wt.Flush()
+		if err != nil {
+			aw.report(err)
+		}
+	}
+}
+
+// handle writes or flushes a single queued record
+func (aw *AsyncWriter) handle(rec asyncRecord) {
+	if rec.flushed != nil {
+		rec.flushed <- aw.wt.Flush()
+		return
+	}
+
+	err := aw.wt.Write(rec.value)
+	aw.release(int64(len(rec.value)))
+	if err != nil {
+		aw.report(&AsyncError{Value: rec.value, Err: err})
+	}
+}
+
+// report delivers a background error
+func (aw *AsyncWriter) report(err error) {
+	if aw.handler != nil {
+		aw.handler(err)
+		return
+	}
+	aw.errs <- err
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_AsyncWriter(t *testing.T) {
+	asyncTopic := topic + ".async"
+	os.RemoveAll(asyncTopic)
+	defer os.RemoveAll(asyncTopic)
+
+	var mu sync.Mutex
+	var errs []error
+	aw, err := queuefka.NewAsyncWriter(asyncTopic, 4096,
+		queuefka.WithQueueLength(16),
+		// value along with its attributes and timestamp
+		queuefka.WithMaxRecordSize(uint32(9+len(value))),
+		queuefka.WithErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}))
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if err := aw.Write(value); err != nil {
+			panic(err)
+		}
+	}
+
+	// errors come back asynchronously with the message that failed
+	big := make([]byte, len(value)+1)
+	aw.Write(big)
+	if err := aw.Flush(); err != nil {
+		panic(err)
+	}
+	if n := readAll(asyncTopic); n != 1000 {
+		println(n)
+		panic("queuefka: AsyncWriter lost messages before Flush:")
+	}
+	mu.Lock()
+	if len(errs) != 1 || errs[0].(*queuefka.AsyncError).Err != queuefka.ErrRecordTooLarge {
+		println(len(errs))
+		panic("queuefka: AsyncWriter did not report a failed write:")
+	}
+	mu.Unlock()
+
+	// Close writes out anything still queued
+	for i := 0; i < 100; i++ {
+		aw.Write(value)
+	}
+	if err := aw.Close(); err != nil {
+		panic(err)
+	}
+	if err := aw.Write(value); err != queuefka.ErrWriterClosed {
+		panic("queuefka: AsyncWriter accepted a Write after Close:")
+	}
+	if n := readAll(asyncTopic); n != 1100 {
+		println(n)
+		panic("queuefka: AsyncWriter lost messages on Close:")
+	}
+}
+
+func Test_Queuefka_Backpressure(t *testing.T) {
+	bpTopic := topic + ".backpressure"
+	os.RemoveAll(bpTopic)
+	defer os.RemoveAll(bpTopic)
+
+	// a failed write stalls the background goroutine in the handler
+	unblock := make(chan struct{})
+	stall := func(aw *queuefka.AsyncWriter) {
+		aw.Write(make([]byte, len(value)+1))
+		time.Sleep(10 * time.Millisecond)
+	}
+	opts := []queuefka.Option{
+		queuefka.WithMaxRecordSize(uint32(9 + len(value))),
+		queuefka.WithInFlightBytes(int64(3 * len(value))),
+		queuefka.WithErrorHandler(func(error) { <-unblock }),
+	}
+
+	aw, err := queuefka.NewAsyncWriter(bpTopic, segmentSizeHint, append(opts, queuefka.WithRejectWhenFull(true))...)
+	if err != nil {
+		panic(err)
+	}
+	stall(aw)
+	for i := 0; i < 3; i++ {
+		if err := aw.Write(value); err != nil {
+			panic(err)
+		}
+	}
+	if err := aw.Write(value); err != queuefka.ErrBackpressure {
+		println(err)
+		panic("queuefka: AsyncWriter queued past its in-flight limit:")
+	}
+	unblock <- struct{}{}
+	if err := aw.Flush(); err != nil {
+		panic(err)
+	}
+	if err := aw.Write(value); err != nil {
+		panic(err)
+	}
+	aw.Close()
+
+	// without RejectWhenFull the same Write blocks until there is room
+	aw, err = queuefka.NewAsyncWriter(bpTopic, segmentSizeHint, opts...)
+	if err != nil {
+		panic(err)
+	}
+	defer aw.Close()
+	stall(aw)
+	for i := 0; i < 3; i++ {
+		aw.Write(value)
+	}
+	written := make(chan struct{})
+	go func() {
+		aw.Write(value)
+		close(written)
+	}()
+	select {
+	case <-written:
+		panic("queuefka: AsyncWriter did not block at its in-flight limit:")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unblock <- struct{}{}
+	<-written
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package client produces to and consumes from a queuefka server over the
+// network.  Its Writer and Reader mirror those of the embedded library, so
+// code can switch between an embedded log and a remote broker:
+//
+//	c, _ := client.Dial("tcp", "localhost:9092")
+//	wt := c.NewWriter("orders")
+//	wt.Write([]byte("hello"))
+//	wt.Flush()
+//
+//	rd, _ := c.NewReader("orders", 0)
+//	d, err := rd.Read()
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/ubergarm/queuefka/internal/wire"
+	"github.com/ubergarm/queuefka/server"
+)
+
+// ErrClosed is returned by every call once the Client is closed.
+var ErrClosed = errors.New("client: Client closed")
+
+// Client is a connection to a server, which may be used by any number of
+// goroutines, their requests sharing the connection.
+type Client struct {
+	nc  net.Conn
+	wmu sync.Mutex // serializes requests
+	bw  *bufio.Writer
+
+	mu    sync.Mutex
+	id    uint32
+	calls map[uint32]chan *wire.Decoder
+	err   error // why the connection failed, returned by every later call
+}
+
+// Dial connects to the server at addr on the named network, e.g. "tcp", or
+// "unix" with the path of the socket of a server on the same host.
+func Dial(network, addr string) (*Client, error) {
+	nc, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(nc), nil
+}
+
+// DialTLS is like Dial but over TLS with config, which gives the root CAs
+// to verify the server by and the certificate to present if the server asks
+// for one.  Unless config says otherwise TLS 1.2 is the minimum version
+// accepted.
+func DialTLS(network, addr string, config *tls.Config) (*Client, error) {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	nc, err := tls.Dial(network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(nc), nil
+}
+
+// NewClient returns a Client talking to a server over nc, which the Client
+// closes when it is closed.
+func NewClient(nc net.Conn) *Client {
+	c := &Client{nc: nc, bw: bufio.NewWriter(nc), calls: make(map[uint32]chan *wire.Decoder)}
+	go c.receive()
+	return c
+}
+
+// Close closes the connection, failing any calls in flight with ErrClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return c.nc.Close()
+}
+
+// fail fails every call in flight and later with err, unless already failed
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	for id, call := range c.calls {
+		close(call)
+		delete(c.calls, id)
+	}
+}
+
+// receive hands each response to its call until the connection fails
+func (c *Client) receive() {
+	br := bufio.NewReader(c.nc)
+	for {
+		frame, err := wire.ReadFrame(br, server.DefaultMaxFrameSize)
+		if err != nil {
+			c.fail(err)
+			c.nc.Close()
+			return
+		}
+		d := &wire.Decoder{Buf: frame}
+		id := d.Uint32()
+		c.mu.Lock()
+		call, ok := c.calls[id]
+		delete(c.calls, id)
+		c.mu.Unlock()
+		if ok {
+			call <- d
+		}
+	}
+}
+
+// call sends a request for op with body and returns its response, or gives
+// up waiting for it once ctx is done
+func (c *Client) call(ctx context.Context, op server.Op, body wire.Encoder) (*wire.Decoder, error) {
+	call := make(chan *wire.Decoder, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.id++
+	id := c.id
+	c.calls[id] = call
+	c.mu.Unlock()
+
+	hdr := make([]byte, 4+1+4)
+	binary.LittleEndian.PutUint32(hdr, uint32(1+4+len(body)))
+	hdr[4] = uint8(op)
+	binary.LittleEndian.PutUint32(hdr[5:], id)
+	c.wmu.Lock()
+	c.bw.Write(hdr)
+	c.bw.Write(body)
+	err := c.bw.Flush()
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(err)
+		c.nc.Close()
+	}
+
+	select {
+	case d, ok := <-call:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return nil, c.err
+		}
+		if code := d.Uint8(); code != 0 {
+			return nil, server.CodeError(code, d.Buf)
+		}
+		return d, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Authenticate lets the connection in by token, for a server with an
+// Authenticator, and returns the principal it was let in as.  It must return
+// before any other call is made.
+func (c *Client) Authenticate(token []byte) (string, error) {
+	if token == nil {
+		token = []byte{}
+	}
+	var e wire.Encoder
+	e.Bytes(token)
+	d, err := c.call(context.Background(), server.OpAuth, e)
+	if err != nil {
+		return "", err
+	}
+	principal := d.String()
+	return principal, d.Err
+}
+
+// TopicInfo summarizes a topic on the server.
+type TopicInfo struct {
+	Name          string // relative to the data directory of the server
+	LowWatermark  uint64 // base address of the oldest slab left
+	HighWatermark uint64 // address just past the last whole message
+	Partitions    int    // of a partitioned topic, each a topic named e.g. orders/partition-0
+}
+
+// Topics returns every topic on the server sorted by name.
+func (c *Client) Topics() ([]TopicInfo, error) {
+	return c.topics("")
+}
+
+// Topic returns the topic name, or queuefka.ErrInvalidTopic if there is no
+// such topic.
+func (c *Client) Topic(name string) (TopicInfo, error) {
+	topics, err := c.topics(name)
+	if err != nil {
+		return TopicInfo{}, err
+	}
+	return topics[0], nil
+}
+
+// topics returns the topic name, or every topic if name is empty
+func (c *Client) topics(name string) ([]TopicInfo, error) {
+	var e wire.Encoder
+	e.String(name)
+	d, err := c.call(context.Background(), server.OpMetadata, e)
+	if err != nil {
+		return nil, err
+	}
+	topics := make([]TopicInfo, d.Uint32())
+	for i := range topics {
+		topics[i] = TopicInfo{Name: d.String(), LowWatermark: d.Uint64(), HighWatermark: d.Uint64(), Partitions: int(d.Uint32())}
+	}
+	if d.Err != nil {
+		return nil, d.Err
+	}
+	return topics, nil
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/server"
+)
+
+// testClient starts a Server of a fresh data directory on a local port and
+// returns a Client connected to it
+func testClient() (*Client, *server.Server, func()) {
+	root, err := ioutil.TempDir("", "queuefka-client")
+	if err != nil {
+		panic(err)
+	}
+	m, err := queuefka.NewManager(root, 512)
+	if err != nil {
+		panic(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := server.New(m)
+	go s.Serve(l)
+	c, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	return c, s, func() {
+		c.Close()
+		s.Close()
+		m.Close()
+		os.RemoveAll(root)
+	}
+}
+
+func Test_Client_Topics(t *testing.T) {
+	c, s, done := testClient()
+	defer done()
+
+	wt := c.NewWriter("a")
+	err := wt.Write([]byte("message"))
+	if err == nil {
+		err = wt.Flush()
+	}
+	if err != nil {
+		panic(err)
+	}
+	err = queuefka.CreatePartitions(s.Manager.Root()+"/b", 2, 512)
+	if err != nil {
+		panic(err)
+	}
+
+	topics, err := c.Topics()
+	if err != nil {
+		panic(err)
+	}
+	if len(topics) != 2 || topics[0].Name != "a" || topics[0].HighWatermark != wt.Address() || topics[1].Partitions != 2 {
+		println(len(topics))
+		panic("client: Topics did not list every topic:")
+	}
+
+	_, err = c.Topic("missing")
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("client: Topic of a missing topic did not fail:")
+	}
+
+	c.Close()
+	_, err = c.Topics()
+	if err != ErrClosed {
+		println(err)
+		panic("client: a closed Client did not fail with ErrClosed:")
+	}
+}
+
+func Test_Client_Authenticate(t *testing.T) {
+	c, s, done := testClient()
+	defer done()
+	s.Authenticator = server.StaticTokens{"secret": "alice"}
+
+	_, err := c.Topics()
+	if err != server.ErrUnauthenticated {
+		println(err)
+		panic("client: a Client was served before it authenticated:")
+	}
+	_, err = c.Authenticate([]byte("wrong"))
+	if err != server.ErrUnauthenticated {
+		println(err)
+		panic("client: a Client was let in with a wrong token:")
+	}
+	principal, err := c.Authenticate([]byte("secret"))
+	if err != nil || principal != "alice" {
+		println(err, principal)
+		panic("client: a Client was not let in with its token:")
+	}
+	_, err = c.Topics()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/internal/wire"
+	"github.com/ubergarm/queuefka/server"
+)
+
+// fetchRecords is how many messages a Reader fetches at once
+const fetchRecords = 1000
+
+// fetchWait is how long each fetch of a following Reader waits for a message
+// before asking again
+const fetchWait = 30 * time.Second
+
+// Reader reads the messages of a topic on the server in order, fetching
+// them in batches.  Unlike the embedded Reader a Reader is not safe for use
+// by several goroutines at once.
+type Reader struct {
+	c       *Client
+	topic   string
+	address uint64            // of the first message not yet fetched
+	fetched []queuefka.Record // fetched but not yet read
+	last    queuefka.Record   // most recently read
+	follow  bool
+}
+
+// NewReader returns a Reader of topic from address, which must be the
+// address of a message or the high watermark.  Unlike the embedded
+// NewReader, an address before the low watermark fails with
+// ErrAddressTruncated rather than starting from the oldest message.
+func (c *Client) NewReader(topic string, address uint64) (*Reader, error) {
+	rd := &Reader{c: c}
+	err := rd.Seek(topic, address)
+	if err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// Seek moves the Reader to address of topic, fetching from there to check
+// that it is there.
+func (rd *Reader) Seek(topic string, address uint64) error {
+	rd.topic, rd.address, rd.fetched = topic, address, nil
+	return rd.fetch(context.Background(), 0)
+}
+
+// SetFollow makes Read block at the end of the log until more is produced,
+// like tail -f, instead of returning ErrEndOfLog.
+func (rd *Reader) SetFollow(enabled bool) {
+	rd.follow = enabled
+}
+
+// fetch asks the server for the messages after those already fetched,
+// waiting up to wait for the first
+func (rd *Reader) fetch(ctx context.Context, wait time.Duration) error {
+	var e wire.Encoder
+	e.String(rd.topic)
+	e.Uint64(rd.address)
+	e.Uint32(fetchRecords)
+	e.Uint32(0)
+	e.Uint32(uint32(wait / time.Millisecond))
+	d, err := rd.c.call(ctx, server.OpFetch, e)
+	if err != nil {
+		return err
+	}
+	next := d.Uint64()
+	records := make([]queuefka.Record, d.Uint32())
+	for i := range records {
+		records[i] = d.Record()
+	}
+	if d.Err != nil {
+		return d.Err
+	}
+	rd.address, rd.fetched = next, records
+	return nil
+}
+
+// Read returns the next message, or ErrEndOfLog if there is none yet unless
+// following.
+func (rd *Reader) Read() ([]byte, error) {
+	rec, err := rd.ReadRecord()
+	return rec.Value, err
+}
+
+// ReadRecord is like Read but returns the message as a Record, including its
+// address and the address to resume from after it.
+func (rd *Reader) ReadRecord() (queuefka.Record, error) {
+	return rd.readRecord(context.Background())
+}
+
+// readRecord is ReadRecord giving up once ctx is done
+func (rd *Reader) readRecord(ctx context.Context) (queuefka.Record, error) {
+	for len(rd.fetched) == 0 {
+		var wait time.Duration
+		if rd.follow {
+			wait = fetchWait
+		}
+		err := rd.fetch(ctx, wait)
+		if err != nil {
+			return queuefka.Record{}, err
+		}
+		if len(rd.fetched) == 0 && !rd.follow {
+			return queuefka.Record{}, queuefka.ErrEndOfLog
+		}
+	}
+	rd.last, rd.fetched = rd.fetched[0], rd.fetched[1:]
+	return rd.last, nil
+}
+
+// Timestamp returns when the message most recently returned by Read was
+// written, or the zero Time if unknown.
+func (rd *Reader) Timestamp() time.Time {
+	return rd.last.Timestamp
+}
+
+// Key returns the key of the message most recently returned by Read, or nil
+// if it was written without one.
+func (rd *Reader) Key() []byte {
+	return rd.last.Key
+}
+
+// Headers returns the metadata headers of the message most recently returned
+// by Read, or nil if it was written without any.
+func (rd *Reader) Headers() []queuefka.Header {
+	return rd.last.Headers
+}
+
+// Close drops any fetched messages.  The Client stays open.
+func (rd *Reader) Close() error {
+	rd.fetched = nil
+	return nil
+}
+
+// Subscribe tails topic from address, sending every message as a Record on
+// the returned channel as it is produced.  The channel is closed once ctx is
+// done or the connection fails.
+func (c *Client) Subscribe(ctx context.Context, topic string, address uint64) (<-chan queuefka.Record, error) {
+	rd, err := c.NewReader(topic, address)
+	if err != nil {
+		return nil, err
+	}
+	rd.SetFollow(true)
+
+	records := make(chan queuefka.Record, fetchRecords)
+	go func() {
+		defer close(records)
+
+		for {
+			rec, err := rd.readRecord(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case records <- rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return records, nil
+}
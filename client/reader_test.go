@@ -0,0 +1,147 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Reader_Read(t *testing.T) {
+	c, _, done := testClient()
+	defer done()
+
+	wt := c.NewWriter("orders")
+	var batch [][]byte
+	for i := 0; i < 30; i++ {
+		batch = append(batch, []byte(fmt.Sprintf("message %d", i)))
+	}
+	err := wt.WriteBatch(batch)
+	if err != nil {
+		panic(err)
+	}
+
+	rd, err := c.NewReader("orders", 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	var third queuefka.Record
+	for i := 0; i < 30; i++ {
+		rec, err := rd.ReadRecord()
+		if err != nil {
+			panic(err)
+		}
+		if string(rec.Value) != string(batch[i]) {
+			println(i, string(rec.Value))
+			panic("client: Reader returned the wrong message:")
+		}
+		if i == 3 {
+			third = rec
+		}
+	}
+	_, err = rd.Read()
+	if err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("client: Reader did not report the end of the log:")
+	}
+
+	// seek back to a message read before
+	err = rd.Seek("orders", third.Address)
+	if err != nil {
+		panic(err)
+	}
+	d, err := rd.Read()
+	if err != nil || string(d) != string(third.Value) {
+		println(err)
+		panic("client: Seek did not move the Reader:")
+	}
+
+	_, err = c.NewReader("missing", 0)
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("client: NewReader of a missing topic did not fail:")
+	}
+}
+
+func Test_Reader_Follow(t *testing.T) {
+	c, _, done := testClient()
+	defer done()
+
+	wt := c.NewWriter("events")
+	err := wt.Write([]byte("first"))
+	if err == nil {
+		err = wt.Flush()
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	rd, err := c.NewReader("events", wt.Address())
+	if err != nil {
+		panic(err)
+	}
+	rd.SetFollow(true)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		wt.WriteKeyed([]byte("k"), []byte("second"))
+		wt.Flush()
+	}()
+	d, err := rd.Read()
+	if err != nil || string(d) != "second" || string(rd.Key()) != "k" {
+		println(err)
+		panic("client: a following Reader did not wait for the next message:")
+	}
+}
+
+func Test_Client_Subscribe(t *testing.T) {
+	c, _, done := testClient()
+	defer done()
+
+	wt := c.NewWriter("events")
+	err := wt.Write([]byte("first"))
+	if err == nil {
+		err = wt.Flush()
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	records, err := c.Subscribe(ctx, "events", 0)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		wt.Write([]byte("second"))
+		wt.Flush()
+	}()
+	for _, want := range []string{"first", "second"} {
+		select {
+		case rec := <-records:
+			if string(rec.Value) != want {
+				println(string(rec.Value))
+				panic("client: Subscribe sent the wrong message:")
+			}
+		case <-time.After(5 * time.Second):
+			panic("client: Subscribe sent nothing:")
+		}
+	}
+
+	cancel()
+	for range records {
+	}
+
+	_, err = c.Subscribe(context.Background(), "missing", 0)
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("client: Subscribe to a missing topic did not fail:")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/internal/wire"
+	"github.com/ubergarm/queuefka/server"
+)
+
+// Replicate makes replica a follower of topic on the server, the leader,
+// fetching its frames from the replica's high watermark and appending them
+// as they are, each chunk synced before the next is fetched.  It keeps
+// following the leader until ctx is done, when it returns ctx.Err(), or
+// replication fails.  Open the replica with queuefka.OpenReplica or
+// Manager.OpenReplica, and close it once Replicate returns.
+func (c *Client) Replicate(ctx context.Context, topic string, replica *queuefka.Replica) error {
+	for {
+		chunk, err := c.chunk(ctx, topic, replica.HighWatermark())
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if len(chunk.Data) == 0 {
+			continue
+		}
+		err = replica.Append(chunk)
+		if err == nil {
+			err = replica.Sync()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// chunk fetches the frames of topic from address, waiting for some if
+// there are none yet
+func (c *Client) chunk(ctx context.Context, topic string, address uint64) (queuefka.Chunk, error) {
+	var e wire.Encoder
+	e.String(topic)
+	e.Uint64(address)
+	e.Uint32(0)
+	e.Uint32(uint32(fetchWait / time.Millisecond))
+	d, err := c.call(ctx, server.OpReplicate, e)
+	if err != nil {
+		return queuefka.Chunk{}, err
+	}
+	chunk := queuefka.Chunk{Base: d.Uint64(), Offset: d.Uint64(), Sealed: d.Uint8() != 0}
+	chunk.Naming.Width = int(d.Uint8())
+	chunk.Naming.Extension = d.String()
+	chunk.Data = d.Bytes()
+	return chunk, d.Err
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Client_Replicate(t *testing.T) {
+	c, s, done := testClient()
+	defer done()
+	dir, err := ioutil.TempDir("", "queuefka-follower")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wt := c.NewWriter("orders")
+	produce := func(from, to int) {
+		for i := from; i < to; i++ {
+			err := wt.Write([]byte(fmt.Sprintf("message %d", i)))
+			if err != nil {
+				panic(err)
+			}
+		}
+		err := wt.Flush()
+		if err != nil {
+			panic(err)
+		}
+	}
+	produce(0, 50)
+
+	replica, err := queuefka.OpenReplica(filepath.Join(dir, "orders"))
+	if err != nil {
+		panic(err)
+	}
+	defer replica.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	replicated := make(chan error, 1)
+	go func() { replicated <- c.Replicate(ctx, "orders", replica) }()
+
+	// the follower catches up and then keeps up
+	rd, err := queuefka.NewReader(filepath.Join(dir, "orders"), 0, queuefka.WithFollow(true))
+	for err == queuefka.ErrInvalidTopic {
+		time.Sleep(10 * time.Millisecond)
+		rd, err = queuefka.NewReader(filepath.Join(dir, "orders"), 0, queuefka.WithFollow(true))
+	}
+	if err != nil && err != queuefka.ErrEndOfLog {
+		panic(err)
+	}
+	defer rd.Close()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		produce(50, 60)
+	}()
+	for i := 0; i < 60; i++ {
+		d, err := rd.Read()
+		if err != nil || string(d) != fmt.Sprintf("message %d", i) {
+			println(i, err)
+			panic("client: the replica did not follow the leader:")
+		}
+	}
+
+	cancel()
+	if err := <-replicated; err != context.Canceled {
+		println(err)
+		panic("client: Replicate did not stop once ctx was done:")
+	}
+	leader := queuefka.SlabFiles(filepath.Join(s.Manager.Root(), "orders"))
+	follower := queuefka.SlabFiles(filepath.Join(dir, "orders"))
+	if len(leader) != len(follower) {
+		panic("client: the replica does not have the slabs of the leader:")
+	}
+	for i := range leader {
+		l, _ := ioutil.ReadFile(leader[i])
+		f, _ := ioutil.ReadFile(follower[i])
+		if !bytes.Equal(l, f) {
+			println(leader[i])
+			panic("client: a replica slab differs from the leader's:")
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/server"
+)
+
+// testCert returns a certificate for name signed by ca, or a self signed CA
+// certificate if ca is nil
+func testCert(name string, ca *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := tmpl, interface{}(key)
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		panic(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert writes cert and its key as PEM files in dir
+func writeCert(dir string, cert tls.Certificate) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return certFile, keyFile
+}
+
+func Test_Client_DialTLS(t *testing.T) {
+	root, err := ioutil.TempDir("", "queuefka-client")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(root)
+	m, err := queuefka.NewManager(root, 512)
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	ca := testCert("ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	certFile, keyFile := writeCert(root, testCert("server", &ca))
+
+	// the server requires clients to present a certificate signed by ca
+	s := server.New(m)
+	s.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	s.Authenticator = server.TLSIdentity{}
+	s.CanProduce = func(principal, topic string) bool { return principal == "client" }
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.ServeTLS(l, certFile, keyFile) }()
+	defer func() {
+		s.Close()
+		if err := <-served; err != server.ErrServerClosed {
+			println(err)
+			panic("client: ServeTLS did not report the Server closed:")
+		}
+	}()
+	addr := l.Addr().String()
+
+	c, err := DialTLS("tcp", addr, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{testCert("client", &ca)}})
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+	wt := c.NewWriter("orders")
+	err = wt.Write([]byte("secret"))
+	if err == nil {
+		err = wt.Flush()
+	}
+	if err != nil {
+		println(err)
+		panic("client: a client was not let in by its certificate:")
+	}
+	rd, err := c.NewReader("orders", 0)
+	if err != nil {
+		panic(err)
+	}
+	d, err := rd.Read()
+	if err != nil || string(d) != "secret" {
+		println(err)
+		panic("client: Reader over TLS did not return what was produced:")
+	}
+
+	// without a client certificate, or trusting the wrong CA, nothing gets through
+	anon, err := DialTLS("tcp", addr, &tls.Config{RootCAs: pool})
+	if err == nil {
+		_, err = anon.Topics()
+		anon.Close()
+	}
+	if err == nil {
+		panic("client: a client without a certificate was served:")
+	}
+	_, err = DialTLS("tcp", addr, nil)
+	if err == nil {
+		panic("client: a server signed by an unknown CA was trusted:")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/internal/wire"
+	"github.com/ubergarm/queuefka/server"
+)
+
+// batchSize is how many bytes of messages a Writer buffers before sending
+// them without waiting for Flush
+const batchSize = 64 << 10
+
+// Writer appends messages to a topic on the server, creating it if need be.
+// Like the embedded Writer it buffers, here sending messages in one produce
+// request once enough are buffered or on Flush.  A Writer may be used by
+// any number of goroutines.
+type Writer struct {
+	sync.Mutex
+	c       *Client
+	topic   string
+	batch   wire.Encoder // messages not yet sent
+	count   uint32       // messages in batch
+	address uint64       // the next message will be written at, as last reported
+}
+
+// NewWriter returns a Writer appending to topic.  Nothing is sent until the
+// first Flush, so an invalid topic is only reported then.
+func (c *Client) NewWriter(topic string) *Writer {
+	return &Writer{c: c, topic: topic}
+}
+
+// Write appends a message.
+func (wt *Writer) Write(d []byte) error {
+	return wt.WriteHeaders(nil, d, nil)
+}
+
+// WriteKeyed appends a message with a key.
+func (wt *Writer) WriteKeyed(key, value []byte) error {
+	return wt.WriteHeaders(key, value, nil)
+}
+
+// WriteHeaders appends a message with an optional key and metadata headers.
+func (wt *Writer) WriteHeaders(key, value []byte, headers []queuefka.Header) error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	wt.batch.Message(key, value, headers)
+	wt.count++
+	if len(wt.batch) < batchSize {
+		return nil
+	}
+	return wt.flush()
+}
+
+// WriteBatch appends several messages and sends them at once with any
+// already buffered, so they all land in one slab as with the embedded
+// WriteBatch.
+func (wt *Writer) WriteBatch(batch [][]byte) error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	for _, d := range batch {
+		wt.batch.Message(nil, d, nil)
+		wt.count++
+	}
+	return wt.flush()
+}
+
+// Flush sends any buffered messages, returning once the server has written
+// them.
+func (wt *Writer) Flush() error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	return wt.flush()
+}
+
+// flush sends the buffered messages, dropping them either way so a failed
+// request is not repeated by the next
+func (wt *Writer) flush() error {
+	if wt.count == 0 {
+		return nil
+	}
+	var e wire.Encoder
+	e.String(wt.topic)
+	e.Uint32(wt.count)
+	e = append(e, wt.batch...)
+	wt.batch, wt.count = wt.batch[:0], 0
+
+	d, err := wt.c.call(context.Background(), server.OpProduce, e)
+	if err != nil {
+		return err
+	}
+	wt.address = d.Uint64()
+	return d.Err
+}
+
+// Address returns the address the next message will be written at, as of
+// the last Flush.
+func (wt *Writer) Address() uint64 {
+	wt.Lock()
+	defer wt.Unlock()
+
+	return wt.address
+}
+
+// Close flushes any buffered messages.  The Client stays open.
+func (wt *Writer) Close() error {
+	return wt.Flush()
+}
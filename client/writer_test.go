@@ -0,0 +1,85 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Writer_Write(t *testing.T) {
+	c, s, done := testClient()
+	defer done()
+
+	wt := c.NewWriter("orders")
+	for i := 0; i < 10; i++ {
+		err := wt.Write([]byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			panic(err)
+		}
+	}
+	if wt.Address() != 0 {
+		panic("client: Writer sent messages before Flush:")
+	}
+	err := wt.WriteHeaders([]byte("customer-1"), []byte("keyed"), []queuefka.Header{{Key: "trace", Value: []byte("abc")}})
+	if err == nil {
+		err = wt.WriteBatch([][]byte{[]byte("batch 0"), []byte("batch 1")})
+	}
+	if err == nil {
+		err = wt.Close()
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	// read it back from the embedded side
+	rd, err := s.Manager.NewReader("orders", 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	var n int
+	var next uint64
+	for {
+		rec, err := rd.ReadRecord()
+		if err == queuefka.ErrEndOfLog {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+		if n == 10 && (string(rec.Key) != "customer-1" || len(rec.Headers) != 1) {
+			panic("client: Writer lost the key or headers of a message:")
+		}
+		n++
+		next = rec.NextAddress
+	}
+	if n != 13 || next != wt.Address() {
+		println(n, next, wt.Address())
+		panic("client: Writer did not write every message:")
+	}
+
+	// a large message is sent without waiting for Flush
+	err = wt.Write(bytes.Repeat([]byte("x"), batchSize))
+	if err != nil {
+		panic(err)
+	}
+	if wt.Address() == 0 || wt.Address() == next {
+		panic("client: Writer kept a full batch:")
+	}
+
+	bad := c.NewWriter("../escape")
+	err = bad.Write([]byte("nowhere"))
+	if err == nil {
+		err = bad.Flush()
+	}
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("client: Writer produced outside the data directory:")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+)
+
+// Codec compresses a batch of messages written with Writer.WriteBatch.  The
+// codec ID is recorded in every compressed frame so a Reader can decompress
+// topics which mix codecs, provided each one has been registered.
+type Codec interface {
+	ID() uint8 // nonzero and unique among registered codecs
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// Codec IDs, matching kafka.  Only gzip is provided, to avoid dependencies,
+// the other IDs are reserved for implementations added with RegisterCodec.
+const (
+	CodecGzip   uint8 = 1
+	CodecSnappy uint8 = 2
+	CodecLZ4    uint8 = 3
+	CodecZstd   uint8 = 4
+)
+
+// Gzip is the built in gzip Codec.
+var Gzip Codec = gzipCodec{}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[uint8]Codec{CodecGzip: Gzip}
+)
+
+// RegisterCodec makes a Codec available to every Reader, replacing any codec
+// previously registered with the same ID.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[c.ID()] = c
+}
+
+// lookupCodec returns the registered Codec with the given ID
+func lookupCodec(id uint8) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[id]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return c, nil
+}
+
+// maxBatchSize is how large a batch may be before it is compressed, and so
+// decompress to, when no maximum record size is set: the most a frame holds
+const maxBatchSize = math.MaxUint32
+
+// encodeBatch compresses the bodies of msgs into the value of one message,
+// returning ErrRecordTooLarge if they come to more than max bytes
+func encodeBatch(codec Codec, timestamp int64, msgs []message, max uint32) (*message, error) {
+	var inner []byte
+	for i := range msgs {
+		body, err := encodeBody(FormatV2, &msgs[i])
+		if err != nil {
+			return nil, err
+		}
+		inner = appendBytes(inner, body)
+		if uint64(len(inner)) > uint64(max) {
+			return nil, ErrRecordTooLarge
+		}
+	}
+
+	value, err := codec.Compress(inner)
+	if err != nil {
+		return nil, err
+	}
+	return &message{timestamp: timestamp, codec: codec.ID(), value: value}, nil
+}
+
+// decodeBatch decompresses the messages held in the value of m, returning
+// ErrRecordTooLarge if they come to more than max bytes
+func decodeBatch(m *message, max uint32) ([]message, error) {
+	codec, err := lookupCodec(m.codec)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := decompress(codec, m.value, max)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []message
+	for len(inner) > 0 {
+		var body []byte
+		var ok bool
+		body, inner, ok = consumeBytes(inner)
+		if !ok {
+			return nil, ErrBadFormat
+		}
+
+		var msg message
+		err = decodeBody(FormatV2, body, &msg)
+		if err != nil {
+			return nil, err
+		}
+		if msg.codec != 0 {
+			// batches do not nest
+			return nil, ErrBadFormat
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() uint8 {
+	return CodecGzip
+}
+
+func (gzipCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(src)
+	if err != nil {
+		return nil, err
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCodec) Decompress(src []byte) ([]byte, error) {
+	return c.decompressLimit(src, maxBatchSize)
+}
+
+// decompressLimit stops reading once the output passes max bytes, so a
+// small corrupt or hostile frame cannot inflate into all of memory
+func (gzipCodec) decompressLimit(src []byte, max uint32) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, ErrBadFormat
+	}
+	defer zr.Close()
+	dst, err := ioutil.ReadAll(io.LimitReader(zr, int64(max)+1))
+	if err != nil {
+		return nil, ErrBadFormat
+	}
+	if uint64(len(dst)) > uint64(max) {
+		return nil, ErrRecordTooLarge
+	}
+	return dst, nil
+}
+
+// limitedCodec is a Codec which can give up part way through decompressing
+// something too large, rather than only once it is all in memory
+type limitedCodec interface {
+	decompressLimit(src []byte, max uint32) ([]byte, error)
+}
+
+// decompress returns src decompressed by codec, or ErrRecordTooLarge if it
+// comes to more than max bytes
+func decompress(codec Codec, src []byte, max uint32) ([]byte, error) {
+	if lc, ok := codec.(limitedCodec); ok {
+		return lc.decompressLimit(src, max)
+	}
+	dst, err := codec.Decompress(src)
+	if err == nil && uint64(len(dst)) > uint64(max) {
+		return nil, ErrRecordTooLarge
+	}
+	return dst, err
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// reverseCodec stands in for a third party codec registered by the user
+type reverseCodec struct{}
+
+func (reverseCodec) ID() uint8 { return 200 }
+
+func (reverseCodec) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, len(src))
+	for i := range src {
+		dst[len(src)-1-i] = src[i]
+	}
+	return dst, nil
+}
+
+func (c reverseCodec) Decompress(src []byte) ([]byte, error) {
+	return c.Compress(src)
+}
+
+func Test_Queuefka_Codec(t *testing.T) {
+	codecTopic := topic + ".codec"
+	os.RemoveAll(codecTopic)
+	defer os.RemoveAll(codecTopic)
+
+	queuefka.RegisterCodec(reverseCodec{})
+
+	wt, err := queuefka.NewWriter(codecTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+
+	// mix compressed and uncompressed batches in one topic
+	count := 0
+	sizes := make(map[string]uint64)
+	for _, codec := range []queuefka.Codec{queuefka.Gzip, nil, reverseCodec{}, queuefka.Gzip} {
+		wt.SetCodec(codec)
+		batch := make([][]byte, 50)
+		for i := range batch {
+			batch[i] = bytes.Repeat([]byte(fmt.Sprintf("%08d", count)), 16)
+			count++
+		}
+		before := wt.Address()
+		if err := wt.WriteBatch(batch); err != nil {
+			panic(err)
+		}
+		sizes[fmt.Sprint(codec)] = wt.Address() - before
+	}
+	wt.Close()
+
+	if sizes[fmt.Sprint(queuefka.Gzip)] >= sizes[fmt.Sprint(nil)] {
+		panic("queuefka: gzip batch is not smaller than an uncompressed one:")
+	}
+
+	rd, err := queuefka.NewReader(codecTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	for i := 0; i < count; i++ {
+		raw, err := rd.Read()
+		if err != nil {
+			panic(err)
+		}
+		if !bytes.Equal(raw, bytes.Repeat([]byte(fmt.Sprintf("%08d", i)), 16)) {
+			println(string(raw))
+			panic("queuefka: Read does not match compressed WriteBatch:")
+		}
+	}
+	if _, err := rd.Read(); err != queuefka.ErrEndOfLog {
+		panic("queuefka: expected ErrEndOfLog after the last message:")
+	}
+
+	total, err := queuefka.CountMessages(codecTopic)
+	if err != nil {
+		panic(err)
+	}
+	if total != uint64(count) {
+		println(total, count)
+		panic("queuefka: CountMessages does not count compressed messages:")
+	}
+}
+
+func Test_Queuefka_CodecMaxRecordSize(t *testing.T) {
+	bombTopic := topic + ".codecmax"
+	os.RemoveAll(bombTopic)
+	defer os.RemoveAll(bombTopic)
+
+	wt, err := queuefka.NewWriter(bombTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// a megabyte of zeros compresses to a frame far under the limit
+	zeros := make([]byte, 1<<20)
+	wt.SetCodec(queuefka.Gzip)
+	if err := wt.WriteBatch([][]byte{zeros}); err != nil {
+		panic(err)
+	}
+	wt.SetCodec(nil)
+	wt.Write(value)
+
+	wt.SetCodec(queuefka.Gzip)
+	wt.SetMaxRecordSize(64 << 10)
+	if wt.WriteBatch([][]byte{zeros}) != queuefka.ErrRecordTooLarge {
+		panic("queuefka: WriteBatch accepted a batch decompressing past the maximum size:")
+	}
+	wt.Flush()
+
+	// the Reader gives up on the batch as soon as it inflates past its limit
+	// and carries on after it
+	rd, err := queuefka.NewReader(bombTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	rd.SetMaxRecordSize(64 << 10)
+
+	for _, expect := range []error{queuefka.ErrRecordTooLarge, nil, queuefka.ErrEndOfLog} {
+		raw, err := rd.Read()
+		if err != expect {
+			panic(fmt.Sprintf("queuefka: expected %v got %v", expect, err))
+		}
+		if err == nil && string(raw) != string(value) {
+			println(string(raw))
+			panic("queuefka: Read does not match write:")
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/vova616/xxhash"
+)
+
+// walkFrames calls fn with the address, raw bytes and decoded message of
+// every frame of seg in turn, stopping at seg.Size or the first incomplete
+// frame.  A corrupt frame is returned as an error.
+func walkFrames(seg Segment, fn func(address uint64, frame []byte, m *message) error) error {
+	fp, err := openSlab(seg.Path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	version, hdrLen, err := slabVersion(fp)
+	if err != nil {
+		return err
+	}
+	if uint64(seg.Size) < hdrLen {
+		return nil
+	}
+	holes, err := readSlabHoles(seg.Path)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(io.NewSectionReader(fp, int64(hdrLen), seg.Size-int64(hdrLen)))
+
+	for address := seg.Base + hdrLen; ; {
+		if end, ok := holeEnd(holes, address-seg.Base); ok {
+			_, err = br.Discard(int(seg.Base + end - address))
+			if err != nil {
+				return nil
+			}
+			address = seg.Base + end
+			continue
+		}
+		peek, _ := br.Peek(maxFrameHeaderSize)
+		if len(peek) == 0 {
+			return nil
+		}
+		fh, err := decodeFrameHeader(version, peek)
+		if err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			// zeros past the end of a preallocated slab
+			if peek[0] == 0 && version >= FormatV3 {
+				return nil
+			}
+			return err
+		}
+
+		frame := make([]byte, fh.size+int(fh.dlen))
+		_, err = io.ReadFull(br, frame)
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		body := frame[fh.size:]
+		if xxhash.Checksum32(body) != fh.xx32 {
+			return ErrBadChecksum
+		}
+		var m message
+		err = decodeBody(fh.version, body, &m)
+		if err != nil {
+			return err
+		}
+
+		err = fn(address, frame, &m)
+		if err != nil {
+			return err
+		}
+		address += uint64(len(frame))
+	}
+}
+
+// Compact rewrites the sealed slabs of the topic keeping only the newest
+// message of each key, like a Kafka compacted topic, so the log can be
+// replayed to restore the latest state of every key.  A keyed message with
+// an empty value is a tombstone deleting its key, tombstones are kept until
+// older than tombstoneAge so that consumers catching up still see them.
+// Messages without a key, compressed batches and transactional messages are
+// always kept.  The active slab is never rewritten, though its messages do
+// supersede older ones.
+//
+// Each slab keeps its base address but shrinks, so the addresses and record
+// numbers of messages within compacted slabs change.  Writes wait while the
+// topic is compacted.
+func (wt *Writer) Compact(tombstoneAge time.Duration) error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	err := wt.flush()
+	if err != nil {
+		return err
+	}
+	segments, err := SealedSegments(wt.topic)
+	if err != nil {
+		return err
+	}
+	active := Segment{Base: wt.base, Path: wt.slabPath(wt.base), Size: int64(wt.address - wt.base)}
+
+	// find the newest message of every key
+	latest := make(map[string]uint64)
+	for _, seg := range append(segments, active) {
+		err = walkFrames(seg, func(address uint64, frame []byte, m *message) error {
+			if compactable(m) {
+				latest[string(m.key)] = address
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cutoff := time.Now().Add(-tombstoneAge).UnixNano()
+	for _, seg := range segments {
+		err = wt.compactSegment(seg, func(address uint64, m *message) bool {
+			if !compactable(m) {
+				return true
+			}
+			if latest[string(m.key)] != address {
+				return false
+			}
+			return len(m.value) > 0 || m.timestamp > cutoff
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncDir(wt.topic)
+}
+
+// compactable reports whether m may be dropped once a newer message with
+// the same key is written
+func compactable(m *message) bool {
+	return m.key != nil && m.codec == 0 && m.txn == 0 && m.control == 0
+}
+
+// compactSegment rewrites a sealed segment with only the frames for which
+// keep returns true, leaving it untouched if it would keep them all, and
+// then rebuilds its sidecar files.  Caller must hold the lock.
+func (wt *Writer) compactSegment(seg Segment, keep func(address uint64, m *message) bool) error {
+	fp, err := openSlab(seg.Path)
+	if err != nil {
+		return err
+	}
+	version, hdrLen, err := slabVersion(fp)
+	hdr := make([]byte, hdrLen)
+	if err == nil {
+		_, err = fp.ReadAt(hdr, 0)
+	}
+	fp.Close()
+	if err != nil {
+		return err
+	}
+
+	tmp := seg.Path + ".compact"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, wt.mode)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+	sum := xxhash.New(0)
+	bw := bufio.NewWriter(io.MultiWriter(out, sum))
+	_, err = bw.Write(hdr)
+	if err != nil {
+		return err
+	}
+
+	// copy the frames kept verbatim, indexing them as the Writer would
+	var dropped bool
+	var count uint64
+	var index []indexEntry
+	var times []timeEntry
+	var last timeEntry
+	var min, max int64
+	offset := hdrLen
+	err = walkFrames(seg, func(address uint64, frame []byte, m *message) error {
+		if !keep(address, m) {
+			dropped = true
+			return nil
+		}
+
+		indexed := hdrLen
+		if len(index) > 0 {
+			indexed = index[len(index)-1].offset
+		}
+		if offset-indexed >= wt.indexEvery {
+			index = append(index, indexEntry{offset: offset, record: count})
+			times = append(times, timeEntry{timestamp: m.timestamp, offset: offset})
+		}
+		last = timeEntry{timestamp: m.timestamp, offset: offset}
+		if m.timestamp != 0 {
+			if min == 0 || m.timestamp < min {
+				min = m.timestamp
+			}
+			if m.timestamp > max {
+				max = m.timestamp
+			}
+		}
+
+		switch {
+		case m.control != 0:
+		case m.codec != 0:
+			msgs, err := decodeBatch(m, maxBatchSize)
+			if err != nil {
+				return err
+			}
+			count += uint64(len(msgs))
+		default:
+			count++
+		}
+
+		_, err := bw.Write(frame)
+		offset += uint64(len(frame))
+		return err
+	})
+	if err != nil || !dropped {
+		return err
+	}
+	err = bw.Flush()
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		return err
+	}
+
+	// sidecars describing the old frames go first, a crash before the new
+	// ones are written leaves them to be rebuilt
+	for _, sidecar := range []string{countPath(seg.Path), indexPath(seg.Path), timeIndexPath(seg.Path), footerPath(seg.Path), metaPath(seg.Path), holesPath(seg.Path)} {
+		err = os.Remove(sidecar)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err = os.Rename(tmp, seg.Path)
+	if err != nil {
+		return err
+	}
+
+	err = writeSlabCount(seg.Path, count)
+	if err == nil {
+		err = writeSlabIndex(seg.Path, index)
+	}
+	if err == nil {
+		err = writeSlabFooter(seg.Path, Footer{Records: count, Size: int64(offset), MinTime: nanoTime(min), MaxTime: nanoTime(max), Sum: sum.Sum32()})
+	}
+	if err == nil {
+		err = writeSlabMeta(seg.Path, slabMeta{base: seg.Base, end: offset})
+	}
+	if err != nil || version < FormatV2 {
+		return err
+	}
+	if offset > hdrLen && (len(times) == 0 || times[len(times)-1] != last) {
+		times = append(times, last)
+	}
+	return writeSlabTimeIndex(seg.Path, times)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Compact(t *testing.T) {
+	compactTopic := topic + ".compact"
+	os.RemoveAll(compactTopic)
+	defer os.RemoveAll(compactTopic)
+
+	wt, err := queuefka.NewWriter(compactTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// every key is overwritten in each round, key 3 is deleted part way
+	for round := 0; round < 10; round++ {
+		for k := 0; k < 5; k++ {
+			if k == 3 && round >= 5 {
+				continue
+			}
+			wt.WriteKeyed([]byte(fmt.Sprintf("key %d", k)), []byte(fmt.Sprintf("value %d.%d", k, round)))
+		}
+		if round == 4 {
+			wt.WriteKeyed([]byte("key 3"), nil)
+		}
+		wt.Write([]byte(fmt.Sprintf("unkeyed %d", round)))
+	}
+	wt.Flush()
+
+	read := func() ([]string, []string) {
+		rd, err := queuefka.NewReader(compactTopic, 0)
+		if err != nil {
+			panic(err)
+		}
+		defer rd.Close()
+
+		var keys, values []string
+		for {
+			rec, err := rd.ReadRecord()
+			if err == queuefka.ErrEndOfLog {
+				return keys, values
+			} else if err != nil {
+				panic(err)
+			}
+			keys = append(keys, string(rec.Key))
+			values = append(values, string(rec.Value))
+		}
+	}
+	_, before := read()
+
+	// a recent tombstone is kept
+	err = wt.Compact(time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	keys, values := read()
+	if len(values) >= len(before) {
+		println(len(values), len(before))
+		panic("queuefka: Compact did not drop any messages:")
+	}
+	newest := make(map[string]string)
+	tombstone := false
+	unkeyed := 0
+	for i, key := range keys {
+		if key == "" {
+			if values[i] != fmt.Sprintf("unkeyed %d", unkeyed) {
+				println(values[i], unkeyed)
+				panic("queuefka: Compact dropped or reordered an unkeyed message:")
+			}
+			unkeyed++
+			continue
+		}
+		if _, ok := newest[key]; ok && newest[key] != "" {
+			println(key, values[i])
+			panic("queuefka: Compact left a superseded value in a sealed slab:")
+		}
+		newest[key] = values[i]
+		if key == "key 3" && values[i] == "" {
+			tombstone = true
+		}
+	}
+	if unkeyed != 10 || !tombstone || newest["key 0"] != "value 0.9" {
+		println(unkeyed, tombstone, newest["key 0"])
+		panic("queuefka: Compact lost the newest messages:")
+	}
+
+	// the record count follows the compacted slabs
+	count, err := queuefka.CountMessages(compactTopic)
+	if err != nil || count != uint64(len(values)) {
+		println(count, len(values), err)
+		panic("queuefka: CountMessages does not match a compacted topic:")
+	}
+
+	// an expired tombstone goes too, along with the key
+	err = wt.Compact(0)
+	if err != nil {
+		panic(err)
+	}
+	keys, _ = read()
+	for _, key := range keys {
+		if key == "key 3" {
+			panic("queuefka: Compact kept an expired tombstone:")
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// compressedHeaderSize is the slab header followed by the size of the slab
+// before it was compressed (8 bytes), see CompressSegments
+const compressedHeaderSize = slabHeaderSize + 8
+
+// CompressSegments compresses the body of every sealed slab but the newest
+// with codec, which must be registered for Readers to decompress it, see
+// RegisterCodec.  Readers inflate a compressed slab into memory as they
+// reach it, otherwise reading it as before, so addresses, sidecar files and
+// footers are unchanged.  Slabs already compressed, of FormatV0, or which do
+// not shrink are left alone.  Each slab is compressed without holding up
+// writes and only swapped in if nothing changed it in the meantime.
+func (wt *Writer) CompressSegments(codec Codec) error {
+	segments, err := SealedSegments(wt.topic)
+	if err != nil || len(segments) < 2 {
+		return err
+	}
+
+	for _, seg := range segments[:len(segments)-1] {
+		err = wt.compressSegment(seg, codec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressSegment writes a compressed copy of a sealed segment beside it
+// and then swaps it in under the lock
+func (wt *Writer) compressSegment(seg Segment, codec Codec) error {
+	fp, err := os.Open(seg.Path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	before, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := make([]byte, slabHeaderSize)
+	_, err = fp.ReadAt(hdr, 0)
+	if err != nil || slabFlags(fp)&slabCompressed != 0 || !bytes.Equal(hdr[:4], slabMagic) || before.Size() != seg.Size {
+		// too short, already compressed, FormatV0 or changing
+		return nil
+	}
+
+	body := make([]byte, seg.Size-slabHeaderSize)
+	_, err = fp.ReadAt(body, slabHeaderSize)
+	if err != nil {
+		return err
+	}
+	packed, err := codec.Compress(body)
+	if err != nil {
+		return err
+	}
+	if int64(len(packed)+compressedHeaderSize) >= seg.Size {
+		return nil
+	}
+
+	out := make([]byte, compressedHeaderSize, compressedHeaderSize+len(packed))
+	copy(out, hdr)
+	out[5] |= slabCompressed
+	out[6] = codec.ID()
+	binary.LittleEndian.PutUint64(out[slabHeaderSize:], uint64(seg.Size))
+	out = append(out, packed...)
+	tmp := seg.Path + ".compress"
+	err = writeFileSync(tmp, out, before.Mode())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	wt.Lock()
+	defer wt.Unlock()
+
+	// compaction, truncation or retention may have got there first
+	after, err := os.Stat(seg.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !os.SameFile(before, after) || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return nil
+	}
+	err = os.Rename(tmp, seg.Path)
+	if err != nil {
+		return err
+	}
+	return syncDir(wt.topic)
+}
+
+// compressLoop compresses the cold slabs in the background after the
+// Writer rolls, one pass at a time with another pass for any slabs rolled
+// during it, caller must hold the lock
+func (wt *Writer) compressLoop() {
+	if wt.slabCodec == nil {
+		return
+	}
+	wt.recompress = true
+	if wt.compressing {
+		return
+	}
+	wt.compressing = true
+	wt.compressed.Add(1)
+	go func(codec Codec) {
+		defer wt.compressed.Done()
+
+		wt.Lock()
+		for wt.recompress {
+			wt.recompress = false
+			wt.Unlock()
+			wt.CompressSegments(codec)
+			wt.Lock()
+		}
+		wt.compressing = false
+		wt.Unlock()
+	}(wt.slabCodec)
+}
+
+// writeFileSync writes a new file and makes sure it is on disk
+func writeFileSync(path string, data []byte, mode os.FileMode) error {
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(data)
+	if err == nil {
+		err = fp.Sync()
+	}
+	cerr := fp.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// inflateSlab returns the whole of a compressed slab as it was before it
+// was compressed
+func inflateSlab(fp *os.File) ([]byte, error) {
+	buf, err := ioutil.ReadAll(io.NewSectionReader(fp, 0, 1<<62))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < compressedHeaderSize {
+		return nil, ErrBadFormat
+	}
+	codec, err := lookupCodec(buf[6])
+	if err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint64(buf[slabHeaderSize:])
+	if size < slabHeaderSize || size-slabHeaderSize > maxBatchSize {
+		return nil, ErrBadChecksum
+	}
+	// never inflate past the size the slab was written at
+	body, err := decompress(codec, buf[compressedHeaderSize:], uint32(size-slabHeaderSize))
+	if err == ErrRecordTooLarge {
+		return nil, ErrBadChecksum
+	} else if err != nil {
+		return nil, err
+	}
+	if uint64(slabHeaderSize+len(body)) != size {
+		return nil, ErrBadChecksum
+	}
+
+	data := make([]byte, slabHeaderSize, size)
+	copy(data, buf)
+	data[5] &^= slabCompressed
+	data[6] = 0
+	return append(data, body...), nil
+}
+
+// slabData gives access to the bytes of a slab as they were written, having
+// inflated it first if it was compressed
+type slabData struct {
+	io.ReaderAt
+	fp   *os.File
+	size int64 // of the slab before any compression
+}
+
+// openSlab opens a slab to read as it was written
+func openSlab(path string) (*slabData, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if slabFlags(fp)&slabCompressed == 0 {
+		return &slabData{ReaderAt: fp, fp: fp, size: stat.Size()}, nil
+	}
+
+	data, err := inflateSlab(fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return &slabData{ReaderAt: bytes.NewReader(data), fp: fp, size: int64(len(data))}, nil
+}
+
+// Close closes the slab file
+func (d *slabData) Close() error {
+	return d.fp.Close()
+}
+
+// slabLength returns the size of a slab before any compression
+func slabLength(path string) (int64, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+	stat, err := fp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if slabFlags(fp)&slabCompressed == 0 {
+		return stat.Size(), nil
+	}
+
+	buf := make([]byte, 8)
+	_, err = fp.ReadAt(buf, slabHeaderSize)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// decompressSlab puts a compressed slab back as it was written so it can be
+// appended to or truncated
+func decompressSlab(path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	if slabFlags(fp)&slabCompressed == 0 {
+		return nil
+	}
+	stat, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	data, err := inflateSlab(fp)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".compress"
+	err = writeFileSync(tmp, data, stat.Mode())
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
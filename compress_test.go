@@ -0,0 +1,145 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_CompressSegments(t *testing.T) {
+	compressTopic := topic + ".compress"
+	os.RemoveAll(compressTopic)
+	defer os.RemoveAll(compressTopic)
+
+	wt, err := queuefka.NewWriter(compressTopic, 4096)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	var want []string
+	for i := 0; i < 500; i++ {
+		msg := fmt.Sprintf("message %d %s", i, strings.Repeat("text ", 20))
+		want = append(want, msg)
+		wt.Write([]byte(msg))
+	}
+	wt.Flush()
+
+	before, err := queuefka.SealedSegments(compressTopic)
+	if err != nil {
+		panic(err)
+	}
+	err = wt.CompressSegments(queuefka.Gzip)
+	if err != nil {
+		panic(err)
+	}
+
+	// sizes are as written, the files themselves much smaller, bar the
+	// newest sealed slab which is left alone
+	after, _ := queuefka.SealedSegments(compressTopic)
+	for i, seg := range after {
+		stat, _ := os.Stat(seg.Path)
+		if seg.Size != before[i].Size {
+			println(seg.Path, seg.Size, before[i].Size)
+			panic("queuefka: compressed segment size changed:")
+		}
+		if i < len(after)-1 && stat.Size() >= seg.Size/2 || i == len(after)-1 && stat.Size() != seg.Size {
+			println(seg.Path, stat.Size(), seg.Size)
+			panic("queuefka: segment not compressed as expected:")
+		}
+		err = queuefka.VerifySegment(seg)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Readers inflate compressed slabs transparently
+	rd, err := queuefka.NewReader(compressTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	got := readValues(rd)
+	rd.Close()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		println(len(got), len(want))
+		panic("queuefka: compressed segments read back wrong:")
+	}
+	count, _ := queuefka.CountMessages(compressTopic)
+	if count != uint64(len(want)) {
+		println(count)
+		panic("queuefka: compressed segments miscounted:")
+	}
+
+	// seeking into the middle of a compressed slab
+	rd, err = queuefka.NewReader(compressTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	err = rd.SeekNearest(after[1].Base + 100)
+	if err != nil {
+		panic(err)
+	}
+	d, err := rd.Read()
+	if err != nil || !strings.HasPrefix(string(d), "message ") {
+		println(string(d), err)
+		panic("queuefka: seek into compressed segment failed:")
+	}
+	rd.Close()
+
+	// truncating into a compressed slab inflates it to append to again
+	err = wt.Truncate(after[1].Base + uint64(after[1].Size))
+	if err != nil {
+		panic(err)
+	}
+	wt.Write([]byte("after truncate"))
+	wt.Flush()
+	rd, err = queuefka.NewReader(compressTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	got = readValues(rd)
+	rd.Close()
+	if len(got) == 0 || got[len(got)-1] != "after truncate" {
+		println(len(got))
+		panic("queuefka: append after truncating compressed segment failed:")
+	}
+}
+
+func Test_Queuefka_SegmentCompression(t *testing.T) {
+	compressTopic := topic + ".segcompress"
+	os.RemoveAll(compressTopic)
+	defer os.RemoveAll(compressTopic)
+
+	wt, err := queuefka.NewWriter(compressTopic, 4096, queuefka.WithSegmentCompression(queuefka.Gzip))
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 500; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d %s", i, strings.Repeat("text ", 20))))
+	}
+	wt.Close()
+
+	// Close waits for the background compression to finish
+	segments, _ := queuefka.SealedSegments(compressTopic)
+	stat, _ := os.Stat(segments[0].Path)
+	if stat.Size() >= segments[0].Size {
+		println(stat.Size(), segments[0].Size)
+		panic("queuefka: segment not compressed in the background:")
+	}
+	rd, err := queuefka.NewReader(compressTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	rd.SetMmap(true)
+	if got := readValues(rd); len(got) != 500 {
+		println(len(got))
+		panic("queuefka: background compressed segments read back wrong:")
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// configName is the file in a topic directory recording its TopicConfig
+const configName = "topic.json"
+
+// TopicConfig is the configuration of a topic, recorded in a topic.json
+// file in its directory when NewWriter creates it.  A Writer later opened on
+// the topic starts from the fields the topic overrides, so every Writer
+// agrees on them without each caller repeating them, with the options passed
+// to NewWriter taking precedence.  Its other fields are left to the Writer's
+// defaults, e.g. those of a Manager.
+type TopicConfig struct {
+	Created       time.Time       `json:"created"`
+	Format        uint8           `json:"format"`
+	SegmentSize   uint64          `json:"segment_size"`            // slab size hint
+	Codec         uint8           `json:"codec,omitempty"`         // ID of the WriteBatch codec
+	SegmentCodec  uint8           `json:"segment_codec,omitempty"` // ID of the slab codec
+	VarintLength  bool            `json:"varint_length,omitempty"`
+	MaxSegmentAge time.Duration   `json:"max_segment_age,omitempty"`
+	Retention     RetentionPolicy `json:"retention"` // without the Archive func or Archiver
+	SyncPolicy    SyncPolicy      `json:"sync_policy"`
+
+	// Overrides names the fields the topic sets itself, those given to
+	// NewWriter when it was created and those changed by UpdateConfig, by
+	// their JSON names with those of the policies as e.g. retention.max_age.
+	// A topic.json recorded before Overrides overrides every field.
+	Overrides []string `json:"overrides"`
+}
+
+// configFields are the fields of a TopicConfig a topic may override, by
+// their names in Overrides, with their values to compare and the Option
+// setting them
+var configFields = []struct {
+	name   string
+	value  func(c *TopicConfig) interface{}
+	option func(c *TopicConfig) (Option, error)
+}{
+	{"segment_size", func(c *TopicConfig) interface{} { return c.SegmentSize }, func(c *TopicConfig) (Option, error) {
+		// the size hint is not an Option, see newWriter
+		return func(*Options) {}, nil
+	}},
+	{"format", func(c *TopicConfig) interface{} { return c.Format }, func(c *TopicConfig) (Option, error) {
+		return WithFormat(c.Format), nil
+	}},
+	{"codec", func(c *TopicConfig) interface{} { return c.Codec }, func(c *TopicConfig) (Option, error) {
+		codec, err := configCodec(c.Codec)
+		return WithCodec(codec), err
+	}},
+	{"segment_codec", func(c *TopicConfig) interface{} { return c.SegmentCodec }, func(c *TopicConfig) (Option, error) {
+		codec, err := configCodec(c.SegmentCodec)
+		return WithSegmentCompression(codec), err
+	}},
+	{"varint_length", func(c *TopicConfig) interface{} { return c.VarintLength }, func(c *TopicConfig) (Option, error) {
+		return WithVarintLength(c.VarintLength), nil
+	}},
+	{"max_segment_age", func(c *TopicConfig) interface{} { return c.MaxSegmentAge }, func(c *TopicConfig) (Option, error) {
+		return WithMaxSegmentAge(c.MaxSegmentAge), nil
+	}},
+	{"retention.max_age", func(c *TopicConfig) interface{} { return c.Retention.MaxAge }, func(c *TopicConfig) (Option, error) {
+		v := c.Retention.MaxAge
+		return func(o *Options) { o.Retention.MaxAge = v }, nil
+	}},
+	{"retention.max_bytes", func(c *TopicConfig) interface{} { return c.Retention.MaxBytes }, func(c *TopicConfig) (Option, error) {
+		v := c.Retention.MaxBytes
+		return func(o *Options) { o.Retention.MaxBytes = v }, nil
+	}},
+	{"retention.max_segments", func(c *TopicConfig) interface{} { return c.Retention.MaxSegments }, func(c *TopicConfig) (Option, error) {
+		v := c.Retention.MaxSegments
+		return func(o *Options) { o.Retention.MaxSegments = v }, nil
+	}},
+	{"retention.interval", func(c *TopicConfig) interface{} { return c.Retention.Interval }, func(c *TopicConfig) (Option, error) {
+		v := c.Retention.Interval
+		return func(o *Options) { o.Retention.Interval = v }, nil
+	}},
+	{"sync_policy.messages", func(c *TopicConfig) interface{} { return c.SyncPolicy.Messages }, func(c *TopicConfig) (Option, error) {
+		v := c.SyncPolicy.Messages
+		return func(o *Options) { o.SyncPolicy.Messages = v }, nil
+	}},
+	{"sync_policy.bytes", func(c *TopicConfig) interface{} { return c.SyncPolicy.Bytes }, func(c *TopicConfig) (Option, error) {
+		v := c.SyncPolicy.Bytes
+		return func(o *Options) { o.SyncPolicy.Bytes = v }, nil
+	}},
+	{"sync_policy.interval", func(c *TopicConfig) interface{} { return c.SyncPolicy.Interval }, func(c *TopicConfig) (Option, error) {
+		v := c.SyncPolicy.Interval
+		return func(o *Options) { o.SyncPolicy.Interval = v }, nil
+	}},
+}
+
+// configCodec returns the codec recorded as id, nil for none
+func configCodec(id uint8) (Codec, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	return lookupCodec(id)
+}
+
+// overridden reports whether the topic sets the field name itself
+func (c *TopicConfig) overridden(name string) bool {
+	if c.Overrides == nil {
+		return true
+	}
+	for _, o := range c.Overrides {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+// override adds the fields whose values differ between from and c to those
+// c overrides
+func (c *TopicConfig) override(from *TopicConfig) {
+	if c.Overrides == nil {
+		return
+	}
+	for _, f := range configFields {
+		if f.value(c) != f.value(from) && !c.overridden(f.name) {
+			c.Overrides = append(c.Overrides, f.name)
+		}
+	}
+}
+
+// configPath returns the path of the topic.json of topic
+func configPath(topic string) string {
+	return filepath.Join(topic, configName)
+}
+
+// ReadTopicConfig returns the configuration recorded for topic, or an error
+// satisfying os.IsNotExist if none is, e.g. for topics created before
+// topic.json.
+func ReadTopicConfig(topic string) (*TopicConfig, error) {
+	buf, err := ioutil.ReadFile(configPath(topic))
+	if err != nil {
+		return nil, err
+	}
+	c := &TopicConfig{}
+	err = json.Unmarshal(buf, c)
+	if err != nil {
+		return nil, ErrBadFormat
+	}
+	return c, nil
+}
+
+// writeTopicConfig atomically and durably replaces the topic.json of topic
+func writeTopicConfig(topic string, c *TopicConfig) error {
+	buf, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := configPath(topic) + ".tmp"
+	err = writeFileSync(tmp, append(buf, '\n'), 0600)
+	if err == nil {
+		err = os.Rename(tmp, configPath(topic))
+	}
+	if err == nil {
+		err = syncDir(topic)
+	}
+	return err
+}
+
+// newTopicConfig returns the configuration of a topic created with
+// slabSizeHint and o, overriding nothing
+func newTopicConfig(slabSizeHint uint64, o Options) *TopicConfig {
+	c := &TopicConfig{
+		Created:       time.Now().UTC(),
+		Format:        o.Format,
+		SegmentSize:   slabSizeHint,
+		VarintLength:  o.VarintLength,
+		MaxSegmentAge: o.MaxSegmentAge,
+		Retention:     o.Retention,
+		SyncPolicy:    o.SyncPolicy,
+		Overrides:     []string{},
+	}
+	if o.Codec != nil {
+		c.Codec = o.Codec.ID()
+	}
+	if o.SegmentCodec != nil {
+		c.SegmentCodec = o.SegmentCodec.ID()
+	}
+	c.Retention.Archive = nil
+	c.Retention.Archiver = nil
+	return c
+}
+
+// createdConfig returns the configuration of a topic created with
+// slabSizeHint and o, overriding the fields set by the options given to
+// NewWriter, and the size hint too unless the Writer has defaults
+func createdConfig(slabSizeHint uint64, o Options, defaults, given []Option) *TopicConfig {
+	var hint uint64
+	if defaults == nil {
+		hint = slabSizeHint
+	}
+	set := newTopicConfig(hint, defaultOptions(given))
+	set.override(newTopicConfig(0, defaultOptions(nil)))
+
+	c := newTopicConfig(slabSizeHint, o)
+	c.Overrides = set.Overrides
+	return c
+}
+
+// options returns the Options setting the fields c overrides, to follow the
+// defaults of a Writer and precede any given to NewWriter.  The Archive func
+// and Archiver of the defaults' retention policy are kept.
+func (c *TopicConfig) options() ([]Option, error) {
+	var opts []Option
+	for _, f := range configFields {
+		if !c.overridden(f.name) {
+			continue
+		}
+		opt, err := f.option(c)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+// UpdateConfig changes the configuration of the Writer's topic without
+// reopening it.  update is passed the recorded TopicConfig to change, which
+// is then recorded and applied straight away, the size hint, format and slab
+// codec from the next slab, the codec, varint lengths and maximum slab age
+// from the next message, and the retention and sync policies as by
+// SetRetention and SetSyncPolicy.  The TopicConfig passed holds the
+// configuration the Writer runs with, and every field update changes is
+// recorded as overridden by the topic from then on.  The Archive func and
+// Archiver of the current retention policy are kept.
+func (wt *Writer) UpdateConfig(update func(*TopicConfig)) error {
+	wt.Lock()
+	recorded, err := ReadTopicConfig(wt.topic)
+	if os.IsNotExist(err) {
+		// a topic created before topic.json, as the Writer was opened
+		recorded, err = wt.config(), nil
+	}
+	if err != nil {
+		wt.Unlock()
+		return err
+	}
+	from := wt.config()
+	from.Created, from.Overrides = recorded.Created, recorded.Overrides
+	c := *from
+	if from.Overrides != nil {
+		c.Overrides = append([]string{}, from.Overrides...)
+	}
+	update(&c)
+	c.override(from)
+
+	// the Writer runs with every field, not only those overridden
+	all := c
+	all.Overrides = nil
+	opts, err := all.options()
+	if err != nil {
+		wt.Unlock()
+		return err
+	}
+	o := defaultOptions(opts)
+	if o.Format > FormatLatest {
+		wt.Unlock()
+		return ErrBadFormat
+	}
+	if (wt.prealloc || wt.direct || wt.mmap) && o.Format < FormatV3 {
+		wt.Unlock()
+		return ErrOldFormat
+	}
+	err = writeTopicConfig(wt.topic, &c)
+	if err != nil {
+		wt.Unlock()
+		return err
+	}
+
+	wt.slabSizeHint = c.SegmentSize
+	wt.version = o.Format
+	wt.codec = o.Codec
+	wt.slabCodec = o.SegmentCodec
+	wt.varint = o.VarintLength
+	wt.maxAge = o.MaxSegmentAge
+	o.Retention.Archive = wt.retention.Archive
+	o.Retention.Archiver = wt.retention.Archiver
+	wt.Unlock()
+
+	wt.SetRetention(o.Retention)
+	wt.SetSyncPolicy(o.SyncPolicy)
+	return nil
+}
+
+// config returns the configuration the Writer is running with, caller must
+// hold the lock
+func (wt *Writer) config() *TopicConfig {
+	return newTopicConfig(wt.slabSizeHint, Options{
+		Format:        wt.version,
+		VarintLength:  wt.varint,
+		Codec:         wt.codec,
+		SegmentCodec:  wt.slabCodec,
+		MaxSegmentAge: wt.maxAge,
+		Retention:     wt.retention,
+		SyncPolicy:    wt.syncPolicy,
+	})
+}
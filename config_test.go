@@ -0,0 +1,132 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_TopicConfig(t *testing.T) {
+	configTopic := topic + ".config"
+	os.RemoveAll(configTopic)
+	defer os.RemoveAll(configTopic)
+
+	_, err := queuefka.ReadTopicConfig(configTopic)
+	if !os.IsNotExist(err) {
+		println(err)
+		panic("queuefka: ReadTopicConfig found a config for a missing topic:")
+	}
+
+	wt, err := queuefka.NewWriter(configTopic, 512,
+		queuefka.WithFormat(queuefka.FormatV2),
+		queuefka.WithCodec(queuefka.Gzip),
+		queuefka.WithMaxSegmentAge(time.Hour),
+		queuefka.WithRetention(queuefka.RetentionPolicy{MaxSegments: 3, Archive: func(queuefka.Segment) error { return nil }}))
+	if err != nil {
+		panic(err)
+	}
+	wt.Close()
+
+	c, err := queuefka.ReadTopicConfig(configTopic)
+	if err != nil {
+		panic(err)
+	}
+	if c.Format != queuefka.FormatV2 || c.SegmentSize != 512 || c.Codec != queuefka.CodecGzip ||
+		c.MaxSegmentAge != time.Hour || c.Retention.MaxSegments != 3 || c.Created.IsZero() {
+		println(c.Format, c.SegmentSize, c.Codec, c.Retention.MaxSegments)
+		panic("queuefka: NewWriter did not record the topic config:")
+	}
+
+	// a later Writer starts from the recorded config
+	wt, err = queuefka.NewWriter(configTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 60; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+	wt.Close()
+	if n := len(queuefka.SlabFiles(configTopic)); n != 3 {
+		println(n)
+		panic("queuefka: NewWriter did not use the recorded config:")
+	}
+
+	// options passed to NewWriter take precedence
+	wt, err = queuefka.NewWriter(configTopic, 0, queuefka.WithRetention(queuefka.RetentionPolicy{}))
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 60; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.ApplyRetention()
+	wt.Close()
+	if n := len(queuefka.SlabFiles(configTopic)); n <= 3 {
+		println(n)
+		panic("queuefka: NewWriter options did not override the recorded config:")
+	}
+}
+
+func Test_Queuefka_UpdateConfig(t *testing.T) {
+	configTopic := topic + ".updateconfig"
+	os.RemoveAll(configTopic)
+	defer os.RemoveAll(configTopic)
+
+	wt, err := queuefka.NewWriter(configTopic, 4096)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	for i := 0; i < 20; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+
+	err = wt.UpdateConfig(func(c *queuefka.TopicConfig) {
+		c.SegmentSize = 256
+		c.Retention.MaxSegments = 2
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 60; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+	if n := len(queuefka.SlabFiles(configTopic)); n != 2 {
+		println(n)
+		panic("queuefka: UpdateConfig did not apply the new config:")
+	}
+	c, err := queuefka.ReadTopicConfig(configTopic)
+	if err != nil {
+		panic(err)
+	}
+	if c.SegmentSize != 256 || c.Retention.MaxSegments != 2 {
+		println(c.SegmentSize, c.Retention.MaxSegments)
+		panic("queuefka: UpdateConfig did not record the new config:")
+	}
+
+	err = wt.UpdateConfig(func(c *queuefka.TopicConfig) { c.Format = 99 })
+	if err != queuefka.ErrBadFormat {
+		println(err)
+		panic("queuefka: UpdateConfig accepted an unknown format:")
+	}
+	c, _ = queuefka.ReadTopicConfig(configTopic)
+	if c.Format != queuefka.FormatLatest {
+		println(c.Format)
+		panic("queuefka: UpdateConfig recorded a rejected config:")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import "context"
+
+// WriteContext is like Write but gives up with ctx.Err() if ctx is done
+// before the message is appended, for instance while another goroutine holds
+// the Writer through a stalled disk or a slab roll.  Nothing is written when
+// it gives up.  Once appended the message stays in the log, a context done
+// afterwards does not undo it.
+func (wt *Writer) WriteContext(ctx context.Context, d []byte) error {
+	return wt.write(ctx, &message{value: d})
+}
+
+// FlushContext is like Flush but gives up with ctx.Err() if ctx is done
+// before the Writer could be locked.
+func (wt *Writer) FlushContext(ctx context.Context) error {
+	err := wt.lockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer wt.Unlock()
+
+	return wt.flush()
+}
+
+// lockContext locks the Writer unless ctx is done first
+func (wt *Writer) lockContext(ctx context.Context) error {
+	if ctx.Done() == nil {
+		wt.Lock()
+		return nil
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		wt.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// hand the lock straight back once the goroutine gets it
+		go func() {
+			<-locked
+			wt.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// ReadContext is like Read but a Reader in follow mode stops waiting for a
+// message with ctx.Err() once ctx is done.
+func (rd *Reader) ReadContext(ctx context.Context) ([]byte, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	return rd.read(ctx, false)
+}
+
+// SeekContext is like Seek but a Reader in follow mode waits for address to
+// be written, or for the topic to be created, instead of returning
+// ErrOutOfBounds or ErrInvalidTopic, until ctx is done.
+func (rd *Reader) SeekContext(ctx context.Context, address uint64) error {
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		err = rd.Seek(rd.topic, address)
+		if !rd.follow || (err != ErrOutOfBounds && err != ErrInvalidTopic) {
+			return err
+		}
+		err = rd.wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_WriteContext(t *testing.T) {
+	ctxTopic := topic + ".ctx"
+	os.RemoveAll(ctxTopic)
+	defer os.RemoveAll(ctxTopic)
+
+	wt, err := queuefka.NewWriter(ctxTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	err = wt.WriteContext(context.Background(), value)
+	if err != nil {
+		panic(err)
+	}
+
+	// an already cancelled context writes nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	address := wt.Address()
+	if err := wt.WriteContext(ctx, value); err != context.Canceled {
+		println(err)
+		panic("queuefka: WriteContext ignored a cancelled context:")
+	}
+	if wt.Address() != address {
+		panic("queuefka: WriteContext wrote after cancel:")
+	}
+
+	// a deadline expires while another goroutine holds the Writer
+	wt.Lock()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := wt.WriteContext(ctx, value); err != context.DeadlineExceeded {
+		println(err)
+		panic("queuefka: WriteContext did not time out:")
+	}
+	if err := wt.FlushContext(ctx); err != context.DeadlineExceeded {
+		println(err)
+		panic("queuefka: FlushContext did not time out:")
+	}
+	wt.Unlock()
+
+	// the Writer is still usable and holds exactly one message
+	if err := wt.FlushContext(context.Background()); err != nil {
+		panic(err)
+	}
+	if wt.Address() != address {
+		panic("queuefka: WriteContext left a partial write:")
+	}
+
+	rd, err := queuefka.NewReader(ctxTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	if _, err := rd.Read(); err != nil {
+		panic(err)
+	}
+	if _, err := rd.Read(); err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("queuefka: WriteContext wrote more than one message:")
+	}
+}
+
+func Test_Queuefka_ReadContext(t *testing.T) {
+	ctxTopic := topic + ".readctx"
+	os.RemoveAll(ctxTopic)
+	defer os.RemoveAll(ctxTopic)
+
+	wt, err := queuefka.NewWriter(ctxTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	rd, err := queuefka.NewReader(ctxTopic, 0, queuefka.WithFollow(true))
+	if err != nil && err != queuefka.ErrEndOfLog {
+		panic(err)
+	}
+	defer rd.Close()
+
+	// a caught up following Reader gives up at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = rd.ReadContext(ctx)
+	if err != context.DeadlineExceeded {
+		println(err)
+		panic("queuefka: ReadContext did not give up at the deadline:")
+	}
+
+	// seeking ahead of the flushed log waits for the address to be written
+	wt.Write(value)
+	second := wt.Address()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		wt.Write(value)
+		wt.Flush()
+	}()
+	err = rd.SeekContext(context.Background(), second)
+	if err != nil && err != queuefka.ErrEndOfLog {
+		panic(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d, err := rd.ReadContext(ctx)
+	if err != nil || string(d) != string(value) {
+		println(err)
+		panic("queuefka: ReadContext did not return the message after SeekContext:")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"io"
+
+	"github.com/vova616/xxhash"
+)
+
+// resyncWindow is how many bytes of a slab are searched at a time for the
+// next intact frame after a corrupt one
+const resyncWindow = 64 * 1024
+
+// CorruptFunc is called with the address range of a corrupt frame skipped by
+// a Reader and the error reading it would otherwise have returned.
+type CorruptFunc func(from, to uint64, err error)
+
+// SetSkipCorrupt makes Read carry on past corrupt frames instead of
+// returning an error, calling handler with the address range skipped and the
+// error which would otherwise have been returned.  A frame whose header is
+// intact is simply skipped, otherwise the rest of the slab is searched for
+// the next frame whose header and payload checksums both match.  Slabs older
+// than FormatV1 have no header checksum to search for so the rest of the
+// slab is skipped.  A nil handler turns skipping off.
+func (rd *Reader) SetSkipCorrupt(handler CorruptFunc) {
+	rd.onCorrupt = handler
+}
+
+// corrupt reports whether err means the frame being read is damaged
+func corrupt(err error) bool {
+	switch err {
+	case ErrBadChecksum, ErrBadHeader, ErrBadFormat, ErrUnknownCodec:
+		return true
+	}
+	return false
+}
+
+// skipCorrupt moves past the damaged frame at from which failed with err
+func (rd *Reader) skipCorrupt(from uint64, err error) error {
+	rd.pending = nil
+	if rd.address > from {
+		// the frame was intact enough to step over
+		rd.onCorrupt(rd.last, rd.address, err)
+		return nil
+	}
+
+	to, rerr := rd.resync()
+	if rerr != nil {
+		return rerr
+	}
+	rd.onCorrupt(from, to, err)
+	rd.address = to
+	return rd.rewind(nil)
+}
+
+// resync returns the address of the next intact frame after the current
+// address, or the end of the slab if there is none
+func (rd *Reader) resync() (uint64, error) {
+	size, err := rd.slabSize()
+	if err != nil {
+		return 0, err
+	}
+	end := rd.base + uint64(size)
+	if rd.version < FormatV1 {
+		return end, nil
+	}
+
+	window := make([]byte, resyncWindow+maxFrameHeaderSize)
+	for start := rd.address + 1; start < end; start += resyncWindow {
+		n, err := rd.readAt(window, int64(start-rd.base))
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := 0; i < n && i < resyncWindow; i++ {
+			if rd.version >= FormatV3 && window[i] != frameMagic {
+				continue
+			}
+			at := start + uint64(i)
+			if rd.intact(at, window[i:n], end) {
+				return at, nil
+			}
+		}
+	}
+	return end, nil
+}
+
+// intact reports whether a whole frame with matching checksums starts at
+// address, buf holds the bytes from there on that have been read already
+func (rd *Reader) intact(address uint64, buf []byte, end uint64) bool {
+	if len(buf) > maxFrameHeaderSize {
+		buf = buf[:maxFrameHeaderSize]
+	}
+	fh, err := decodeFrameHeader(rd.version, buf)
+	if err != nil || address+uint64(fh.size)+uint64(fh.dlen) > end {
+		return false
+	}
+
+	payload := make([]byte, fh.dlen)
+	_, err = rd.readAt(payload, int64(address-rd.base)+int64(fh.size))
+	return err == nil && xxhash.Checksum32(payload) == fh.xx32
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_SkipCorrupt(t *testing.T) {
+	corruptTopic := topic + ".corrupt"
+	os.RemoveAll(corruptTopic)
+	defer os.RemoveAll(corruptTopic)
+
+	wt, err := queuefka.NewWriter(corruptTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	var addresses []uint64
+	for i := 0; i < 10; i++ {
+		addresses = append(addresses, wt.Address())
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	addresses = append(addresses, wt.Address())
+	wt.Close()
+
+	// damage the payload of message 3 and the header of message 6
+	fp, err := os.OpenFile(queuefka.SlabFiles(corruptTopic)[0], os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	fp.WriteAt([]byte{0xff}, int64(addresses[4]-1))
+	fp.WriteAt([]byte{0xff}, int64(addresses[6]))
+	fp.Close()
+
+	// by default a corrupt frame stops the Reader
+	rd, err := queuefka.NewReader(corruptTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 3; i++ {
+		rd.Read()
+	}
+	_, err = rd.Read()
+	rd.Close()
+	if err != queuefka.ErrBadChecksum {
+		println(err)
+		panic("queuefka: Read did not report a corrupt payload:")
+	}
+
+	// skipping reports each damaged frame and carries on after it
+	type skipped struct {
+		from, to uint64
+		err      error
+	}
+	var skips []skipped
+	rd, err = queuefka.NewReader(corruptTopic, 0, queuefka.WithSkipCorrupt(func(from, to uint64, err error) {
+		skips = append(skips, skipped{from, to, err})
+	}))
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	var values []string
+	for {
+		d, err := rd.Read()
+		if err == queuefka.ErrEndOfLog {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		values = append(values, string(d))
+	}
+	want := []string{"message 0", "message 1", "message 2", "message 4", "message 5", "message 7", "message 8", "message 9"}
+	if fmt.Sprint(values) != fmt.Sprint(want) {
+		println(fmt.Sprint(values))
+		panic("queuefka: skipping corrupt frames returned the wrong messages:")
+	}
+	if len(skips) != 2 ||
+		skips[0] != (skipped{addresses[3], addresses[4], queuefka.ErrBadChecksum}) ||
+		skips[1] != (skipped{addresses[6], addresses[7], queuefka.ErrBadHeader}) {
+		println(fmt.Sprint(skips))
+		panic("queuefka: skipping corrupt frames reported the wrong ranges:")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DeleteTopic removes a topic along with all its slabs, sidecar files and
+// metadata.  It takes the topic lock first so it fails with ErrTopicLocked
+// while a Writer has the topic open, WithStealLock applies as for NewWriter.
+// The topic directory is renamed out of the way before anything in it is
+// removed, so the topic disappears all at once.  Readers which already have
+// a slab open may finish reading it but find nothing after it.
+func DeleteTopic(topic string, opts ...Option) error {
+	o := defaultOptions(opts)
+	_, err := os.Stat(topic)
+	if os.IsNotExist(err) {
+		return ErrInvalidTopic
+	} else if err != nil {
+		return err
+	}
+
+	lock, err := lockTopic(topic, o.FileMode, o.StealLock)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Clean(topic)
+	deleted := fmt.Sprintf("%s.deleted-%d", dir, time.Now().UnixNano())
+	err = os.Rename(dir, deleted)
+	unlockTopic(lock)
+	if err != nil {
+		return err
+	}
+	err = syncDir(filepath.Dir(dir))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(deleted)
+}
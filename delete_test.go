@@ -0,0 +1,64 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_DeleteTopic(t *testing.T) {
+	delTopic := topic + ".delete"
+	os.RemoveAll(delTopic)
+	defer os.RemoveAll(delTopic)
+
+	wt, err := queuefka.NewWriter(delTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 50; i++ {
+		wt.Write(value)
+	}
+	wt.Flush()
+
+	// an open Writer keeps the topic
+	err = queuefka.DeleteTopic(delTopic)
+	if err != queuefka.ErrTopicLocked {
+		println(err)
+		panic("queuefka: DeleteTopic removed a topic with an open Writer:")
+	}
+	wt.Close()
+
+	err = queuefka.DeleteTopic(delTopic)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := os.Stat(delTopic); !os.IsNotExist(err) {
+		panic("queuefka: DeleteTopic left the topic directory behind:")
+	}
+	leftovers, _ := filepath.Glob(delTopic + ".deleted-*")
+	if len(leftovers) != 0 {
+		panic("queuefka: DeleteTopic left the renamed topic directory behind:")
+	}
+
+	err = queuefka.DeleteTopic(delTopic)
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("queuefka: DeleteTopic of a missing topic did not return ErrInvalidTopic:")
+	}
+
+	// the topic may then be created afresh
+	wt, err = queuefka.NewWriter(delTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	wt.Close()
+	if len(queuefka.SlabFiles(delTopic)) != 1 {
+		panic("queuefka: topic was not created afresh after DeleteTopic:")
+	}
+}
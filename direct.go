@@ -0,0 +1,91 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+// directAlign is the alignment of offsets, lengths and memory for O_DIRECT
+const directAlign = 4096
+
+// directBlocks is how many aligned blocks a directFile buffers
+const directBlocks = 16
+
+// directFile writes to a slab opened with O_DIRECT.  Every Write goes straight
+// to disk as whole aligned blocks, with the partially filled last block
+// padded with zeros and kept to be rewritten in place by the next Write.
+type directFile struct {
+	fp  *os.File
+	buf []byte // aligned in memory, a whole number of blocks
+	off int64  // file offset of buf[0], always aligned
+	n   int    // bytes of buf holding data
+}
+
+// alignedBlocks returns n zeroed blocks aligned in memory for O_DIRECT
+func alignedBlocks(n int) []byte {
+	buf := make([]byte, (n+1)*directAlign)
+	skip := 0
+	if r := int(uintptr(unsafe.Pointer(&buf[0])) % directAlign); r != 0 {
+		skip = directAlign - r
+	}
+	return buf[skip : skip+n*directAlign]
+}
+
+// newDirectFile returns a directFile appending at offset end of fp, reading
+// back the data already in the block containing end
+func newDirectFile(fp *os.File, end int64) (*directFile, error) {
+	df := &directFile{fp: fp, buf: alignedBlocks(directBlocks)}
+	df.off = end &^ (directAlign - 1)
+	df.n = int(end - df.off)
+	if df.n > 0 {
+		got, err := fp.ReadAt(df.buf[:directAlign], df.off)
+		if got < df.n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return df, nil
+}
+
+// Write copies p into the block buffer and writes it out
+func (df *directFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		c := copy(df.buf[df.n:], p)
+		df.n += c
+		p = p[c:]
+
+		err := df.writeOut()
+		if err != nil {
+			return written, err
+		}
+		written += c
+	}
+	return written, nil
+}
+
+// writeOut writes every buffered block, zero padding the last, then drops
+// all but a partially filled last block from the buffer
+func (df *directFile) writeOut() error {
+	end := (df.n + directAlign - 1) &^ (directAlign - 1)
+	for i := df.n; i < end; i++ {
+		df.buf[i] = 0
+	}
+	_, err := df.fp.WriteAt(df.buf[:end], df.off)
+	if err != nil {
+		return err
+	}
+
+	full := df.n &^ (directAlign - 1)
+	copy(df.buf, df.buf[full:df.n])
+	df.n -= full
+	df.off += int64(full)
+	return nil
+}
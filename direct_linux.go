@@ -0,0 +1,26 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package queuefka
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect opens a slab with O_DIRECT for appending at offset end
+func openDirect(path string, end int64) (*directFile, error) {
+	fp, err := os.OpenFile(path, os.O_RDWR|syscall.O_DIRECT, 0600)
+	if err != nil {
+		return nil, err
+	}
+	df, err := newDirectFile(fp, end)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return df, nil
+}
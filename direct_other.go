@@ -0,0 +1,14 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package queuefka
+
+import "errors"
+
+// openDirect always fails as O_DIRECT is Linux only
+func openDirect(path string, end int64) (*directFile, error) {
+	return nil, errors.New("queuefka: direct I/O is not supported on this platform")
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_DirectIO(t *testing.T) {
+	directTopic := topic + ".direct"
+	os.RemoveAll(directTopic)
+	defer os.RemoveAll(directTopic)
+
+	wt, err := queuefka.NewWriter(directTopic, 16*1024, queuefka.WithDirectIO(true))
+	if err != nil {
+		t.Skip("direct I/O unavailable:", err)
+	}
+
+	// messages straddle blocks and the padded last block is rewritten
+	big := bytes.Repeat(value, 300)
+	for i := 0; i < 50; i++ {
+		wt.Write(value)
+		wt.Write(big)
+		wt.Flush()
+	}
+	if n := readAll(directTopic); n != 100 {
+		println(n)
+		panic("queuefka: DirectIO read the wrong number of messages:")
+	}
+
+	// reopening picks up inside the padded block
+	address := wt.Address()
+	wt.Close()
+	wt, err = queuefka.NewWriter(directTopic, 16*1024, queuefka.WithDirectIO(true))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	if wt.Address() != address {
+		println(wt.Address(), address)
+		panic("queuefka: DirectIO lost the end after Close:")
+	}
+	wt.Write(value)
+	wt.Flush()
+	if n := readAll(directTopic); n != 101 {
+		println(n)
+		panic("queuefka: DirectIO lost messages after reopening:")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+	"time"
+)
+
+// SyncPolicy controls when a Writer flushes and fsyncs the current slab.
+// Flush alone only hands data to the OS, a power loss can still drop it.
+// Any combination of the fields may be set, a zero field is ignored.
+type SyncPolicy struct {
+	Messages uint64        `json:"messages,omitempty"` // fsync once this many messages are unsynced
+	Bytes    uint64        `json:"bytes,omitempty"`    // fsync once this many bytes are unsynced
+	Interval time.Duration `json:"interval,omitempty"` // fsync on a timer
+}
+
+var (
+	SyncNever  = SyncPolicy{}            // leave it to the OS, the default
+	SyncAlways = SyncPolicy{Messages: 1} // fsync after every Write
+)
+
+// SyncEveryN returns a SyncPolicy which fsyncs after every n messages.
+func SyncEveryN(n uint64) SyncPolicy {
+	return SyncPolicy{Messages: n}
+}
+
+// SyncInterval returns a SyncPolicy which fsyncs every d.
+func SyncInterval(d time.Duration) SyncPolicy {
+	return SyncPolicy{Interval: d}
+}
+
+// SetSyncPolicy changes when the Writer fsyncs, starting or stopping a
+// background goroutine for a SyncPolicy with an Interval.
+func (wt *Writer) SetSyncPolicy(policy SyncPolicy) {
+	wt.Lock()
+	defer wt.Unlock()
+
+	wt.syncPolicy = policy
+	wt.stopSyncLoop()
+	if policy.Interval > 0 && !wt.pooled {
+		wt.syncStop = make(chan struct{})
+		go wt.syncLoop(policy.Interval, wt.syncStop)
+	}
+}
+
+// Sync flushes buffered data and fsyncs the current slab to disk.
+func (wt *Writer) Sync() error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	return wt.sync()
+}
+
+// sync flushes and fsyncs the current slab, caller must hold the lock
+func (wt *Writer) sync() error {
+	err := wt.flush()
+	if err != nil {
+		return err
+	}
+	wt.unsyncedMessages, wt.unsyncedBytes = 0, 0
+	if wt.mm != nil {
+		err = wt.mm.sync()
+		if err != nil {
+			return err
+		}
+	}
+	err = wt.fp.Sync()
+	if err != nil {
+		return err
+	}
+
+	// release any group commit waiters this fsync covered
+	wt.syncedSeq = wt.appendSeq
+	wt.synced.Broadcast()
+	return nil
+}
+
+// SetGroupCommit makes every Write and WriteBatch return only once its
+// messages are fsynced, like SyncAlways.  Goroutines writing concurrently
+// share a single flush and fsync: one leads the fsync while the others keep
+// appending, then every caller it covered is released together.
+func (wt *Writer) SetGroupCommit(enabled bool) {
+	wt.Lock()
+	defer wt.Unlock()
+
+	wt.groupCommit = enabled
+}
+
+// commit blocks until the message numbered seq is fsynced, caller must hold
+// the lock which is released while waiting or fsyncing
+func (wt *Writer) commit(seq uint64) error {
+	for wt.syncedSeq < seq {
+		if wt.syncing {
+			wt.synced.Wait()
+			continue
+		}
+
+		// lead an fsync covering everything appended so far
+		wt.syncing = true
+		target := wt.appendSeq
+		err := wt.flush()
+		fp := wt.fp
+		wt.Unlock()
+		if err == nil {
+			err = fp.Sync()
+		}
+		wt.Lock()
+		wt.syncing = false
+
+		// a slab rolled over in the meantime was fsynced by roll
+		if err != nil && fp != wt.fp {
+			err = nil
+		}
+		if err != nil {
+			wt.synced.Broadcast()
+			return err
+		}
+		if target > wt.syncedSeq {
+			wt.syncedSeq = target
+		}
+		wt.synced.Broadcast()
+	}
+	return nil
+}
+
+// maybeSync fsyncs if the policy says enough has been written since the
+// last time, caller must hold the lock
+func (wt *Writer) maybeSync() error {
+	p := wt.syncPolicy
+	if (p.Messages > 0 && wt.unsyncedMessages >= p.Messages) ||
+		(p.Bytes > 0 && wt.unsyncedBytes >= p.Bytes) {
+		return wt.sync()
+	}
+	return nil
+}
+
+// syncLoop fsyncs every interval until stop is closed
+func (wt *Writer) syncLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			wt.Sync()
+		}
+	}
+}
+
+// stopSyncLoop stops any background fsync goroutine, caller must hold the lock
+func (wt *Writer) stopSyncLoop() {
+	if wt.syncStop != nil {
+		close(wt.syncStop)
+		wt.syncStop = nil
+	}
+}
+
+// syncDir fsyncs a directory so file creations and renames within it survive
+// a crash
+func syncDir(dir string) error {
+	fp, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return fp.Sync()
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// slabSize returns the on disk size of the only slab in a topic
+func slabSize(topic string) int64 {
+	stat, err := os.Stat(queuefka.SlabFiles(topic)[0])
+	if err != nil {
+		panic(err)
+	}
+	return stat.Size()
+}
+
+func Test_Queuefka_SyncPolicy(t *testing.T) {
+	syncTopic := topic + ".sync"
+	os.RemoveAll(syncTopic)
+	defer os.RemoveAll(syncTopic)
+
+	wt, err := queuefka.NewWriter(syncTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// every message reaches the disk without a Flush
+	wt.SetSyncPolicy(queuefka.SyncAlways)
+	before := slabSize(syncTopic)
+	wt.Write(value)
+	if slabSize(syncTopic) == before {
+		panic("queuefka: SyncAlways did not write through:")
+	}
+
+	// nothing reaches the disk until the Nth message
+	wt.SetSyncPolicy(queuefka.SyncEveryN(3))
+	before = slabSize(syncTopic)
+	wt.Write(value)
+	wt.Write(value)
+	if slabSize(syncTopic) != before {
+		panic("queuefka: SyncEveryN synced too early:")
+	}
+	wt.Write(value)
+	if slabSize(syncTopic) == before {
+		panic("queuefka: SyncEveryN did not sync on the Nth message:")
+	}
+
+	// the background goroutine syncs on its own
+	wt.SetSyncPolicy(queuefka.SyncInterval(10 * time.Millisecond))
+	before = slabSize(syncTopic)
+	wt.Write(value)
+	time.Sleep(100 * time.Millisecond)
+	if slabSize(syncTopic) == before {
+		panic("queuefka: SyncInterval did not sync:")
+	}
+
+	// manual control
+	wt.SetSyncPolicy(queuefka.SyncNever)
+	before = slabSize(syncTopic)
+	wt.Write(value)
+	if err := wt.Sync(); err != nil {
+		panic(err)
+	}
+	if slabSize(syncTopic) == before {
+		panic("queuefka: Sync did not write through:")
+	}
+}
+
+func Test_Queuefka_GroupCommit(t *testing.T) {
+	groupTopic := topic + ".group"
+	os.RemoveAll(groupTopic)
+	defer os.RemoveAll(groupTopic)
+
+	wt, err := queuefka.NewWriter(groupTopic, 4096, queuefka.WithGroupCommit(true))
+	if err != nil {
+		panic(err)
+	}
+
+	// every Write is on disk by the time it returns
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := wt.Write(value); err != nil {
+					panic(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := queuefka.CountMessages(groupTopic)
+	if err != nil {
+		panic(err)
+	}
+	if count != 800 {
+		println(count)
+		panic("queuefka: GroupCommit lost messages before Close:")
+	}
+	wt.Close()
+}
+
+// benchmarkSyncWrite writes from several goroutines with every Write durable
+func benchmarkSyncWrite(b *testing.B, opt queuefka.Option) {
+	benchTopic := topic + ".bench"
+	os.RemoveAll(benchTopic)
+	defer os.RemoveAll(benchTopic)
+
+	wt, err := queuefka.NewWriter(benchTopic, segmentSizeHint, opt)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wt.Write(value)
+		}
+	})
+}
+
+func Benchmark_Queuefka_Write_SyncAlways(b *testing.B) {
+	benchmarkSyncWrite(b, queuefka.WithSyncPolicy(queuefka.SyncAlways))
+}
+
+func Benchmark_Queuefka_Write_GroupCommit(b *testing.B) {
+	benchmarkSyncWrite(b, queuefka.WithGroupCommit(true))
+}
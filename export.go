@@ -0,0 +1,165 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/vova616/xxhash"
+)
+
+// exportMagic starts every snapshot written by Export
+var exportMagic = []byte("QFKX")
+
+// exportVersion is the snapshot format written by Export
+const exportVersion uint8 = 1
+
+// exportHeaderSize is magic (4 bytes) + version (1 byte) + reserved (3 bytes)
+const exportHeaderSize = 8
+
+// topicFiles are the files describing a whole topic rather than one slab
+var topicFiles = []string{"slab.naming", "low.watermark", "archived.slabs", configName}
+
+// Export writes a snapshot of topic to w as a single stream, every slab and
+// its sidecar files each with its own checksum, for backups or copying the
+// topic to another machine, see Import.  A Writer may keep appending while
+// the topic is exported, the snapshot then ends with the last whole message
+// of the active slab when Export reaches it.
+func Export(topic string, w io.Writer) error {
+	slabs := SlabFiles(topic)
+	if len(slabs) <= 0 {
+		return ErrInvalidTopic
+	}
+
+	bw := bufio.NewWriter(w)
+	hdr := make([]byte, exportHeaderSize)
+	copy(hdr, exportMagic)
+	hdr[4] = exportVersion
+	_, err := bw.Write(hdr)
+	if err != nil {
+		return err
+	}
+
+	var entries uint64
+	export := func(path string, size int64) error {
+		err := exportFile(bw, path, size)
+		if os.IsNotExist(err) {
+			// deleted by retention since the slabs were listed
+			return nil
+		} else if err == nil {
+			entries++
+		}
+		return err
+	}
+	for _, name := range topicFiles {
+		err = export(filepath.Join(topic, name), -1)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, slab := range slabs {
+		size := int64(-1)
+		sidecars := sidecarExtensions
+		if i == len(slabs)-1 {
+			// only as far as the last whole message of the active slab,
+			// whose recorded end may already be out of date
+			base, err := slabBase(slab)
+			if err != nil {
+				return err
+			}
+			size, err = activeEnd(Segment{Base: base, Path: slab})
+			if err != nil {
+				return err
+			}
+			sidecars = []string{".producers", ".holes"}
+		}
+		err = export(slab, size)
+		if err != nil {
+			return err
+		}
+		for _, ext := range sidecars {
+			err = export(slabStem(slab)+ext, -1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// an empty name ends the snapshot, followed by the number of files so
+	// a truncated snapshot is detected
+	trailer := make([]byte, 2+8)
+	binary.LittleEndian.PutUint64(trailer[2:], entries)
+	_, err = bw.Write(trailer)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// exportFile writes the first size bytes of path, or all of it if size is
+// negative, to a snapshot as its name length, name, size, data and the
+// xxhash of name and data
+func exportFile(w io.Writer, path string, size int64) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	if size < 0 {
+		stat, err := fp.Stat()
+		if err != nil {
+			return err
+		}
+		size = stat.Size()
+	}
+
+	name := filepath.Base(path)
+	buf := make([]byte, 2+len(name)+8)
+	binary.LittleEndian.PutUint16(buf, uint16(len(name)))
+	copy(buf[2:], name)
+	binary.LittleEndian.PutUint64(buf[2+len(name):], uint64(size))
+	_, err = w.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	h := xxhash.New(0)
+	h.Write([]byte(name))
+	n, err := io.Copy(io.MultiWriter(w, h), io.NewSectionReader(fp, 0, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return io.ErrUnexpectedEOF
+	}
+	sum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sum, h.Sum32())
+	_, err = w.Write(sum)
+	return err
+}
+
+// activeEnd returns the offset just past the last whole message of the
+// active slab seg, however much is on disk
+func activeEnd(seg Segment) (int64, error) {
+	stat, err := os.Stat(seg.Path)
+	if err != nil {
+		return 0, err
+	}
+	seg.Size = stat.Size()
+	rd, err := NewSegmentReader(seg)
+	defer rd.Close()
+	for err == nil {
+		_, err = rd.Read()
+	}
+	if err != ErrEndOfLog {
+		return 0, err
+	}
+	return int64(rd.address - seg.Base), nil
+}
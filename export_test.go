@@ -0,0 +1,83 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Export(t *testing.T) {
+	exportTopic := topic + ".export"
+	os.RemoveAll(exportTopic)
+	defer os.RemoveAll(exportTopic)
+
+	if err := queuefka.Export(exportTopic, &bytes.Buffer{}); err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("queuefka: Export of a missing topic did not fail:")
+	}
+
+	wt, err := queuefka.NewWriter(exportTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	for i := 0; i < 40; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	// the snapshot holds every slab and sidecar by name, ending with how
+	// many files it holds
+	var buf bytes.Buffer
+	err = queuefka.Export(exportTopic, &buf)
+	if err != nil {
+		panic(err)
+	}
+	snapshot := buf.Bytes()
+	if string(snapshot[:4]) != "QFKX" || snapshot[4] != 1 {
+		panic("queuefka: Export wrote no snapshot header:")
+	}
+	sizes := map[string]int{}
+	var entries uint64
+	for at := 8; ; {
+		n := int(binary.LittleEndian.Uint16(snapshot[at:]))
+		if n == 0 {
+			entries = binary.LittleEndian.Uint64(snapshot[at+2:])
+			if at+10 != len(snapshot) {
+				panic("queuefka: Export wrote past its trailer:")
+			}
+			break
+		}
+		name := string(snapshot[at+2 : at+2+n])
+		size := int(binary.LittleEndian.Uint64(snapshot[at+2+n:]))
+		sizes[name] = size
+		at += 2 + n + 8 + size + 4
+	}
+	if uint64(len(sizes)) != entries {
+		println(len(sizes), entries)
+		panic("queuefka: Export trailer miscounts its files:")
+	}
+
+	slabs := queuefka.SlabFiles(exportTopic)
+	for i, slab := range slabs {
+		stat, _ := os.Stat(slab)
+		if sizes[filepath.Base(slab)] != int(stat.Size()) {
+			println(slab, sizes[filepath.Base(slab)], stat.Size())
+			panic("queuefka: Export did not hold the whole slab:")
+		}
+		footer := filepath.Base(slab[:len(slab)-len(".slab")] + ".footer")
+		if _, ok := sizes[footer]; ok != (i < len(slabs)-1) {
+			println(footer)
+			panic("queuefka: Export did not hold the sealed footers:")
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+	"time"
+)
+
+// groupLockExt names the lock file serializing commits of each group
+const groupLockExt = ".lock"
+
+// Fence starts a new epoch for group, returning it along with the address
+// last committed, 0 if none has been.  A consumer taking over a group calls
+// Fence and commits with CommitEpoch at the new epoch, after which commits
+// from any earlier epoch, e.g. by a zombie consumer believed dead which is
+// still running, fail with ErrFenced.  Storing the results of processing
+// under the same epoch, rejecting stale ones, makes a pipeline effectively
+// once.  Epochs are serialized between processes with flock(2) as topic
+// locks are.
+func (s *OffsetStore) Fence(group string) (uint64, uint64, error) {
+	path, err := s.offsetPath(group)
+	if err != nil {
+		return 0, 0, err
+	}
+	lock, err := s.lockGroup(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlockTopic(lock)
+
+	o, err := readOffset(path)
+	if err != nil && err != ErrNoOffset {
+		return 0, 0, err
+	}
+	o.epoch++
+	err = s.writeOffset(path, o)
+	if err != nil {
+		return 0, 0, err
+	}
+	return o.epoch, o.address, nil
+}
+
+// CommitEpoch records that group has processed the topic up to address as
+// Commit does, unless epoch is older than the group's, see Fence, when it
+// returns ErrFenced and records nothing.
+func (s *OffsetStore) CommitEpoch(group string, epoch, address uint64) error {
+	path, err := s.offsetPath(group)
+	if err != nil {
+		return err
+	}
+	lock, err := s.lockGroup(path)
+	if err != nil {
+		return err
+	}
+	defer unlockTopic(lock)
+
+	o, err := readOffset(path)
+	if err != nil && err != ErrNoOffset {
+		return err
+	}
+	if epoch < o.epoch {
+		return ErrFenced
+	}
+	return s.writeOffset(path, offset{address: address, epoch: epoch})
+}
+
+// FetchEpoch returns the address group last committed along with its
+// current epoch, or ErrNoOffset if it has never committed or been fenced.
+func (s *OffsetStore) FetchEpoch(group string) (uint64, uint64, error) {
+	path, err := s.offsetPath(group)
+	if err != nil {
+		return 0, 0, err
+	}
+	o, err := readOffset(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return o.address, o.epoch, nil
+}
+
+// lockGroup waits for the lock on the offset file at path
+func (s *OffsetStore) lockGroup(path string) (*os.File, error) {
+	for {
+		fp, err := tryLock(path+groupLockExt, 0600)
+		if err != ErrTopicLocked {
+			return fp, err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Fence(t *testing.T) {
+	fenceTopic := topic + ".fence"
+	os.RemoveAll(fenceTopic)
+	defer os.RemoveAll(fenceTopic)
+
+	store, err := queuefka.NewOffsetStore(fenceTopic)
+	if err != nil {
+		panic(err)
+	}
+	err = store.Commit("etl", 100)
+	if err != nil {
+		panic(err)
+	}
+
+	// the first consumer takes the group, later found to be a zombie
+	zombie, address, err := store.Fence("etl")
+	if err != nil {
+		panic(err)
+	}
+	if zombie != 1 || address != 100 {
+		println(zombie, address)
+		panic("queuefka: Fence did not start a new epoch at the committed address:")
+	}
+	err = store.CommitEpoch("etl", zombie, 200)
+	if err != nil {
+		panic(err)
+	}
+
+	// its replacement fences it off
+	epoch, address, err := store.Fence("etl")
+	if err != nil {
+		panic(err)
+	}
+	if epoch != 2 || address != 200 {
+		println(epoch, address)
+		panic("queuefka: Fence did not return the latest commit:")
+	}
+	if store.CommitEpoch("etl", zombie, 300) != queuefka.ErrFenced || store.Commit("etl", 300) != queuefka.ErrFenced {
+		panic("queuefka: a stale epoch committed:")
+	}
+	err = store.CommitEpoch("etl", epoch, 400)
+	if err != nil {
+		panic(err)
+	}
+	address, current, err := store.FetchEpoch("etl")
+	if err != nil {
+		panic(err)
+	}
+	if address != 400 || current != 2 {
+		println(address, current)
+		panic("queuefka: FetchEpoch did not return the fenced commit:")
+	}
+
+	// concurrent fences each get an epoch of their own
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	epochs := make(map[uint64]bool)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := queuefka.NewOffsetStore(fenceTopic)
+			if err != nil {
+				panic(err)
+			}
+			e, _, err := s.Fence("etl")
+			if err != nil {
+				panic(err)
+			}
+			mu.Lock()
+			epochs[e] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(epochs) != 10 {
+		println(len(epochs))
+		panic("queuefka: concurrent fences shared an epoch:")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// followPoll is how often a following Reader looks for new messages when it
+// cannot be notified of them, and the longest it waits for a notification
+// as writes through WithMmap raise none.
+const followPoll = 100 * time.Millisecond
+
+// SetFollow makes Read block at the end of the log until the Writer appends
+// more, like tail -f, instead of returning ErrEndOfLog.  A Reader bounded by
+// SetEndAddress still returns ErrEndOfLog once it gets there.  On Linux the
+// topic is watched with inotify, elsewhere it is polled.
+func (rd *Reader) SetFollow(enabled bool) {
+	rd.follow = enabled
+	if enabled && rd.watch == nil {
+		// watch before reading so no append can slip in unnoticed
+		watch, err := watchTopic(rd.topic)
+		if err == nil && watch != nil {
+			rd.watch, rd.events = watch, make([]byte, 4096)
+		}
+	} else if !enabled && rd.watch != nil {
+		rd.watch.Close()
+		rd.watch, rd.events = nil, nil
+	}
+}
+
+// read returns the next message, in follow mode waiting for one to be
+// written until ctx is done
+func (rd *Reader) read(ctx context.Context, reuse bool) ([]byte, error) {
+	for {
+		from := rd.address
+		d, err := rd.next(ctx, reuse)
+		if rd.onCorrupt != nil && corrupt(err) {
+			err = rd.skipCorrupt(from, err)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != ErrEndOfLog || !rd.follow || (rd.end > 0 && rd.address >= rd.end) {
+			return d, err
+		}
+		err = rd.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wait blocks until the topic may have changed, at most followPoll, or
+// until ctx is done
+func (rd *Reader) wait(ctx context.Context) error {
+	if rd.watch == nil {
+		timer := time.NewTimer(followPoll)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		}
+	}
+
+	// the read deadline doubles as the poll interval, cancelling ctx cuts
+	// it short
+	rd.watch.SetReadDeadline(time.Now().Add(followPoll))
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				rd.watch.SetReadDeadline(time.Now())
+			case <-done:
+			}
+		}()
+	}
+
+	// the events themselves do not matter, only that something changed
+	_, err := rd.watch.Read(rd.events)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil && !os.IsTimeout(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package queuefka
+
+import (
+	"os"
+	"syscall"
+)
+
+// watchTopic returns an inotify instance which becomes readable whenever a
+// slab in topic is written to or created
+func watchTopic(topic string) (*os.File, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	_, err = syscall.InotifyAddWatch(fd, topic, syscall.IN_MODIFY|syscall.IN_CREATE|syscall.IN_MOVED_TO)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), topic), nil
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package queuefka
+
+import "os"
+
+// watchTopic returns nil so a following Reader polls as inotify is Linux only
+func watchTopic(topic string) (*os.File, error) {
+	return nil, nil
+}
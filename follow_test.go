@@ -0,0 +1,60 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Follow(t *testing.T) {
+	followTopic := topic + ".follow"
+	os.RemoveAll(followTopic)
+	defer os.RemoveAll(followTopic)
+
+	// small slabs so following has to roll over to new ones
+	wt, err := queuefka.NewWriter(followTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	rd, err := queuefka.NewReader(followTopic, 0, queuefka.WithFollow(true))
+	if err != nil && err != queuefka.ErrEndOfLog {
+		panic(err)
+	}
+	defer rd.Close()
+
+	// Read blocks on the caught up Reader until each message is flushed
+	go func() {
+		for i := 0; i < 20; i++ {
+			time.Sleep(5 * time.Millisecond)
+			wt.Write([]byte(fmt.Sprintf("message %d", i)))
+			wt.Flush()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		d, err := rd.Read()
+		if err != nil {
+			panic(err)
+		}
+		if string(d) != fmt.Sprintf("message %d", i) {
+			println(i, string(d))
+			panic("queuefka: following Reader returned the wrong message:")
+		}
+	}
+
+	// a bounded Reader still stops at its end
+	rd.SetEndAddress(wt.Address())
+	_, err = rd.Read()
+	if err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("queuefka: following Reader blocked past its end address:")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/vova616/xxhash"
+)
+
+// footerSize is records, size, min and max timestamp (8 bytes each) and the
+// slab checksum followed by an xxhash of them (4 bytes each)
+const footerSize = 40
+
+// Footer summarises a sealed slab.  It is recorded in a <base>.footer
+// sidecar file as the slab is rolled, which marks the slab as sealed, so a
+// sealed slab may be validated or archived without reading it.
+type Footer struct {
+	Records uint64    // messages in the slab
+	Size    int64     // length of the slab in bytes including its header
+	MinTime time.Time // when the earliest message was written, zero if unknown
+	MaxTime time.Time // when the latest message was written, zero if unknown
+	Sum     uint32    // xxhash of every byte of the slab, see VerifySegment
+}
+
+// footerPath returns the sidecar file sealing a slab e.g. <base>.footer
+func footerPath(slab string) string {
+	return slabStem(slab) + ".footer"
+}
+
+// unixNano returns t as unix nanoseconds, 0 for the zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// nanoTime returns unix nanoseconds as a time, the zero time for 0
+func nanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// writeSlabFooter atomically records the footer of a sealed slab
+func writeSlabFooter(slab string, f Footer) error {
+	buf := make([]byte, footerSize)
+	binary.LittleEndian.PutUint64(buf, f.Records)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(f.Size))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(unixNano(f.MinTime)))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(unixNano(f.MaxTime)))
+	binary.LittleEndian.PutUint32(buf[32:], f.Sum)
+	binary.LittleEndian.PutUint32(buf[36:], xxhash.Checksum32(buf[:36]))
+
+	tmp := footerPath(slab) + ".tmp"
+	err := ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, footerPath(slab))
+}
+
+// readSlabFooter returns the footer recorded for a sealed slab
+func readSlabFooter(slab string) (Footer, error) {
+	buf, err := ioutil.ReadFile(footerPath(slab))
+	if err != nil {
+		return Footer{}, err
+	}
+	if len(buf) != footerSize || binary.LittleEndian.Uint32(buf[36:]) != xxhash.Checksum32(buf[:36]) {
+		return Footer{}, ErrBadChecksum
+	}
+	return Footer{
+		Records: binary.LittleEndian.Uint64(buf),
+		Size:    int64(binary.LittleEndian.Uint64(buf[8:])),
+		MinTime: nanoTime(int64(binary.LittleEndian.Uint64(buf[16:]))),
+		MaxTime: nanoTime(int64(binary.LittleEndian.Uint64(buf[24:]))),
+		Sum:     binary.LittleEndian.Uint32(buf[32:]),
+	}, nil
+}
+
+// slabSum returns the xxhash of the first size bytes of a slab
+func slabSum(slab string, size int64) (uint32, error) {
+	data, err := openSlab(slab)
+	if err != nil {
+		return 0, err
+	}
+	defer data.Close()
+
+	h := xxhash.New(0)
+	n, err := io.Copy(h, bufio.NewReader(io.NewSectionReader(data, 0, size)))
+	if err != nil {
+		return 0, err
+	}
+	if n != size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return h.Sum32(), nil
+}
+
+// scanSlabFooter builds the footer of a segment by reading every message
+func scanSlabFooter(seg Segment) (Footer, error) {
+	sum, err := slabSum(seg.Path, seg.Size)
+	if err != nil {
+		return Footer{}, err
+	}
+	f := Footer{Size: seg.Size, Sum: sum}
+	rd, err := NewSegmentReader(seg)
+	if err == ErrEndOfLog {
+		rd.Close()
+		return f, nil
+	} else if err != nil {
+		return f, err
+	}
+	defer rd.Close()
+
+	var min, max int64
+	for {
+		_, err := rd.Read()
+		if err == ErrEndOfLog {
+			break
+		} else if err != nil {
+			return f, err
+		}
+		f.Records++
+		if ts := rd.msg.timestamp; ts != 0 {
+			if min == 0 || ts < min {
+				min = ts
+			}
+			if ts > max {
+				max = ts
+			}
+		}
+	}
+	f.MinTime, f.MaxTime = nanoTime(min), nanoTime(max)
+	return f, nil
+}
+
+// SegmentFooter returns the footer of a sealed segment from its .footer
+// sidecar file, rebuilding it by scanning the segment if missing.  A footer
+// whose checksum is wrong, or which no longer matches the size of the slab,
+// returns ErrBadChecksum.
+func SegmentFooter(seg Segment) (Footer, error) {
+	f, err := readSlabFooter(seg.Path)
+	if os.IsNotExist(err) {
+		f, err = scanSlabFooter(seg)
+		if err != nil {
+			return f, err
+		}
+		return f, writeSlabFooter(seg.Path, f)
+	} else if err != nil {
+		return f, err
+	}
+	if f.Size != seg.Size {
+		return f, ErrBadChecksum
+	}
+	return f, nil
+}
+
+// writeFooter seals the current slab with a footer, from what the Writer
+// has seen of it if it wrote every message or else by scanning it, and the
+// checksum of what reached the disk, caller must hold the lock
+func (wt *Writer) writeFooter() error {
+	seg := Segment{Base: wt.base, Path: wt.slabPath(wt.base), Size: int64(wt.address - wt.base)}
+	if !wt.counted {
+		f, err := scanSlabFooter(seg)
+		if err != nil {
+			return err
+		}
+		return writeSlabFooter(seg.Path, f)
+	}
+	sum, err := slabSum(seg.Path, seg.Size)
+	if err != nil {
+		return err
+	}
+	return writeSlabFooter(seg.Path, Footer{
+		Records: wt.count,
+		Size:    seg.Size,
+		MinTime: nanoTime(wt.minTime),
+		MaxTime: nanoTime(wt.maxTime),
+		Sum:     sum,
+	})
+}
+
+// VerifySegment checks a sealed segment against the checksum in its footer,
+// reading the slab through once without decoding any message, so that a
+// slab kept for a long time can be checked for silent corruption on disk.
+// It returns ErrBadChecksum if the slab or its footer has changed since it
+// was sealed, and the error opening the footer if it has none.
+func VerifySegment(seg Segment) error {
+	f, err := readSlabFooter(seg.Path)
+	if err != nil {
+		return err
+	}
+	if f.Size != seg.Size {
+		return ErrBadChecksum
+	}
+	sum, err := slabSum(seg.Path, seg.Size)
+	if err != nil {
+		return err
+	}
+	if sum != f.Sum {
+		return ErrBadChecksum
+	}
+	return nil
+}
+
+// sealed reports whether the slab being loaded was sealed at its current
+// size, in which case it needs no scan for a torn frame
+func sealed(slab string, size int64) bool {
+	f, err := readSlabFooter(slab)
+	return err == nil && f.Size == size
+}
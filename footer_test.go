@@ -0,0 +1,141 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Footer(t *testing.T) {
+	footerTopic := topic + ".footer"
+	os.RemoveAll(footerTopic)
+	defer os.RemoveAll(footerTopic)
+
+	start := time.Now()
+	wt, err := queuefka.NewWriter(footerTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 50; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+
+	// a reopened Writer has not seen the messages in the slab it rolls
+	wt, err = queuefka.NewWriter(footerTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	for i := 50; i < 100; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	segments, err := queuefka.SealedSegments(footerTopic)
+	if err != nil {
+		panic(err)
+	}
+	var records uint64
+	for _, seg := range segments {
+		if _, err := os.Stat(strings.TrimSuffix(seg.Path, ".slab") + ".footer"); err != nil {
+			panic("queuefka: sealed slab has no footer:")
+		}
+		f, err := queuefka.SegmentFooter(seg)
+		if err != nil {
+			panic(err)
+		}
+		if f.Size != seg.Size || f.Records == 0 || f.MinTime.Before(start) || f.MaxTime.Before(f.MinTime) {
+			println(seg.Path, f.Size, f.Records)
+			panic("queuefka: footer does not describe its slab:")
+		}
+		records += f.Records
+	}
+	count, _ := queuefka.CountMessages(footerTopic)
+	active, _ := queuefka.NewReader(footerTopic, segments[len(segments)-1].Base+uint64(segments[len(segments)-1].Size))
+	records += uint64(len(readValues(active)))
+	active.Close()
+	if records != count {
+		println(records, count)
+		panic("queuefka: footers do not count every message:")
+	}
+
+	// a damaged footer is reported, a missing one rebuilt
+	seg := segments[0]
+	path := strings.TrimSuffix(seg.Path, ".slab") + ".footer"
+	buf, _ := ioutil.ReadFile(path)
+	buf[0]++
+	ioutil.WriteFile(path, buf, 0600)
+	_, err = queuefka.SegmentFooter(seg)
+	if err != queuefka.ErrBadChecksum {
+		println(err)
+		panic("queuefka: SegmentFooter did not detect a damaged footer:")
+	}
+	os.Remove(path)
+	f, err := queuefka.SegmentFooter(seg)
+	if err != nil {
+		panic(err)
+	}
+	if f.Size != seg.Size || f.Records == 0 {
+		panic("queuefka: SegmentFooter did not rebuild a missing footer:")
+	}
+}
+
+func Test_Queuefka_VerifySegment(t *testing.T) {
+	verifyTopic := topic + ".verifysegment"
+	os.RemoveAll(verifyTopic)
+	defer os.RemoveAll(verifyTopic)
+
+	wt, err := queuefka.NewWriter(verifyTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	for i := 0; i < 100; i++ {
+		wt.WriteKeyed([]byte(fmt.Sprintf("key %d", i%5)), []byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	// compacted slabs are sealed afresh
+	err = wt.Compact(time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	segments, err := queuefka.SealedSegments(verifyTopic)
+	if err != nil {
+		panic(err)
+	}
+	for _, seg := range segments {
+		err = queuefka.VerifySegment(seg)
+		if err != nil {
+			println(seg.Path)
+			panic(err)
+		}
+	}
+
+	// flip a bit in the middle of a slab without changing its size
+	seg := segments[len(segments)/2]
+	fp, err := os.OpenFile(seg.Path, os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	b := make([]byte, 1)
+	fp.ReadAt(b, seg.Size/2)
+	b[0] ^= 0x10
+	fp.WriteAt(b, seg.Size/2)
+	fp.Close()
+	err = queuefka.VerifySegment(seg)
+	if err != queuefka.ErrBadChecksum {
+		println(err)
+		panic("queuefka: VerifySegment did not detect a damaged slab:")
+	}
+}
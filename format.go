@@ -0,0 +1,398 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/vova616/xxhash"
+)
+
+// On disk format versions.  The version is recorded once at the start of
+// every slab so a topic may mix slabs written by different releases.  From
+// FormatV3 on every frame also starts with its own magic byte and version.
+const (
+	FormatV0 uint8 = 0 // no slab header, frame is length + crc + payload
+	FormatV1 uint8 = 1 // slab header, frame is length + crc + header crc + payload
+	FormatV2 uint8 = 2 // as FormatV1 but payload is a message body, see encodeBody
+	FormatV3 uint8 = 3 // as FormatV2 but frame starts with frameMagic + version
+
+	FormatLatest = FormatV3 // newest format this release can read and write
+)
+
+// frameMagic starts every frame in a FormatV3 or later slab
+const frameMagic uint8 = 0x51
+
+// slabMagic starts every slab written with FormatV1 or later.  FormatV0 slabs
+// have no header so their first bytes are the length of the first message.
+// A legacy slab starting with a ~1GiB message would be mistaken for a
+// versioned slab, which is an accepted limitation.
+var slabMagic = []byte("QFKA")
+
+// slabHeaderSize is magic (4 bytes) + version (1 byte) + flags (1 byte) +
+// reserved (2 bytes)
+const slabHeaderSize = 8
+
+// slab header flags
+const (
+	slabPreallocated uint8 = 1 << 0 // zeros past the logical end, see preallocate
+	slabCompressed   uint8 = 1 << 1 // codec ID in byte 6, see CompressSegments
+)
+
+// slabHeader returns the header bytes written at the start of a new slab
+func slabHeader(version uint8) []byte {
+	if version == FormatV0 {
+		return nil
+	}
+	hdr := make([]byte, slabHeaderSize)
+	copy(hdr, slabMagic)
+	hdr[4] = version
+	return hdr
+}
+
+// slabVersion reads the format version and header length of an open slab
+func slabVersion(fp io.ReaderAt) (uint8, uint64, error) {
+	hdr := make([]byte, slabHeaderSize)
+	_, err := fp.ReadAt(hdr, 0)
+	if err == io.EOF {
+		// too short to hold a header so it must be a legacy slab
+		return FormatV0, 0, nil
+	} else if err != nil {
+		return FormatV0, 0, err
+	}
+	if !bytes.Equal(hdr[:4], slabMagic) {
+		return FormatV0, 0, nil
+	}
+	if hdr[4] > FormatLatest {
+		return hdr[4], slabHeaderSize, ErrBadFormat
+	}
+	return hdr[4], slabHeaderSize, nil
+}
+
+// slabFlags returns the header flags of an open slab
+func slabFlags(fp io.ReaderAt) uint8 {
+	hdr := make([]byte, slabHeaderSize)
+	_, err := fp.ReadAt(hdr, 0)
+	if err != nil || !bytes.Equal(hdr[:4], slabMagic) {
+		return 0
+	}
+	return hdr[5]
+}
+
+// frameVarint is set in the version byte of a FormatV3 or later frame whose
+// length is a uvarint and whose header crc is truncated to 16 bits
+const frameVarint uint8 = 0x80
+
+// maxFrameHeaderSize is the largest header of any frame format
+const maxFrameHeaderSize = 14
+
+// frameHeader describes the header preceding a single payload
+type frameHeader struct {
+	version uint8  // format of this frame
+	varint  bool   // compact variant with a uvarint length
+	size    int    // header bytes preceding the payload
+	dlen    uint32 // payload length
+	xx32    uint32 // payload checksum
+}
+
+// encodeFrameHeader returns the frame header for a payload of dlen bytes
+func encodeFrameHeader(version uint8, varint bool, dlen, xx32 uint32) []byte {
+	if version < FormatV3 {
+		varint = false
+	}
+
+	hdr := make([]byte, 0, maxFrameHeaderSize)
+	if version >= FormatV3 {
+		flags := version
+		if varint {
+			flags |= frameVarint
+		}
+		hdr = append(hdr, frameMagic, flags)
+	}
+
+	var n [binary.MaxVarintLen32]byte
+	if varint {
+		hdr = append(hdr, n[:binary.PutUvarint(n[:], uint64(dlen))]...)
+	} else {
+		binary.LittleEndian.PutUint32(n[:], dlen)
+		hdr = append(hdr, n[:4]...)
+	}
+	binary.LittleEndian.PutUint32(n[:], xx32)
+	hdr = append(hdr, n[:4]...)
+
+	if version >= FormatV1 {
+		binary.LittleEndian.PutUint32(n[:], xxhash.Checksum32(hdr))
+		if varint {
+			hdr = append(hdr, n[:2]...)
+		} else {
+			hdr = append(hdr, n[:4]...)
+		}
+	}
+	return hdr
+}
+
+// decodeFrameHeader parses the frame header at the start of buf for a slab in
+// the given format.  buf holds as many bytes as are available up to
+// maxFrameHeaderSize, io.ErrUnexpectedEOF is returned if that is too few and
+// ErrBadHeader if the header is corrupt.
+func decodeFrameHeader(slab uint8, buf []byte) (fh frameHeader, err error) {
+	fh.version = slab
+	n := 0
+
+	// newer slabs record the format of each frame in its first bytes
+	if slab >= FormatV3 {
+		if len(buf) < 2 {
+			return fh, io.ErrUnexpectedEOF
+		}
+		if buf[0] != frameMagic {
+			return fh, ErrBadHeader
+		}
+		fh.version = buf[1] &^ frameVarint
+		fh.varint = buf[1]&frameVarint != 0
+		if fh.version < FormatV3 || fh.version > FormatLatest {
+			return fh, ErrBadFormat
+		}
+		n = 2
+	}
+
+	if fh.varint {
+		dlen, l := binary.Uvarint(buf[n:])
+		if l == 0 && len(buf) < maxFrameHeaderSize {
+			return fh, io.ErrUnexpectedEOF
+		} else if l <= 0 || dlen > math.MaxUint32 {
+			return fh, ErrBadHeader
+		}
+		fh.dlen = uint32(dlen)
+		n += l
+	} else {
+		if len(buf) < n+4 {
+			return fh, io.ErrUnexpectedEOF
+		}
+		fh.dlen = binary.LittleEndian.Uint32(buf[n:])
+		n += 4
+	}
+
+	if len(buf) < n+4 {
+		return fh, io.ErrUnexpectedEOF
+	}
+	fh.xx32 = binary.LittleEndian.Uint32(buf[n:])
+	n += 4
+
+	if fh.version >= FormatV1 {
+		sum := xxhash.Checksum32(buf[:n])
+		if fh.varint {
+			if len(buf) < n+2 {
+				return fh, io.ErrUnexpectedEOF
+			}
+			if binary.LittleEndian.Uint16(buf[n:]) != uint16(sum) {
+				return fh, ErrBadHeader
+			}
+			n += 2
+		} else {
+			if len(buf) < n+4 {
+				return fh, io.ErrUnexpectedEOF
+			}
+			if binary.LittleEndian.Uint32(buf[n:]) != sum {
+				return fh, ErrBadHeader
+			}
+			n += 4
+		}
+	}
+
+	fh.size = n
+	return fh, nil
+}
+
+// bodyHeaderSize is attributes (1 byte) + timestamp (8 bytes).  Attribute
+// bits flag optional sections which follow the timestamp so new per message
+// fields can be added without another format version.
+const bodyHeaderSize = 9
+
+// message body attribute flags
+const (
+	attrKey      uint8 = 1 << 0 // uvarint key length + key bytes
+	attrHeaders  uint8 = 1 << 1 // uvarint count + length prefixed key/value pairs
+	attrCodec    uint8 = 1 << 2 // codec id byte, value is a compressed batch
+	attrProducer uint8 = 1 << 3 // uvarint producer id + uvarint sequence number
+	attrTxn      uint8 = 1 << 4 // uvarint transaction id
+	attrControl  uint8 = 1 << 5 // transaction marker byte, value is empty
+)
+
+// Header is a key/value pair of metadata carried alongside a message.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// message holds the decoded contents of a single frame payload
+type message struct {
+	timestamp int64 // unix nanoseconds when the message was written
+	key       []byte
+	headers   []Header
+	codec     uint8  // nonzero if value is a compressed batch, see encodeBatch
+	producer  uint64 // nonzero for an idempotent write, see WriteIdempotent
+	sequence  uint64 // sequence number of an idempotent write
+	txn       uint64 // nonzero if written in a transaction, see Writer.Begin
+	control   uint8  // nonzero for a transaction marker, see txnCommit
+	value     []byte
+}
+
+// encodeBody returns the frame payload for a message in the given format
+func encodeBody(version uint8, m *message) ([]byte, error) {
+	if version < FormatV2 {
+		if m.key != nil || m.headers != nil || m.codec != 0 || m.producer != 0 || m.txn != 0 {
+			return nil, ErrOldFormat
+		}
+		return m.value, nil
+	}
+
+	var attrs uint8
+	if m.key != nil {
+		attrs |= attrKey
+	}
+	if len(m.headers) > 0 {
+		attrs |= attrHeaders
+	}
+	if m.codec != 0 {
+		attrs |= attrCodec
+	}
+	if m.producer != 0 {
+		attrs |= attrProducer
+	}
+	if m.txn != 0 {
+		attrs |= attrTxn
+	}
+	if m.control != 0 {
+		attrs |= attrControl
+	}
+
+	body := make([]byte, bodyHeaderSize, bodyHeaderSize+binary.MaxVarintLen64+len(m.key)+len(m.value))
+	body[0] = attrs
+	binary.LittleEndian.PutUint64(body[1:], uint64(m.timestamp))
+	if attrs&attrKey != 0 {
+		body = appendBytes(body, m.key)
+	}
+	if attrs&attrHeaders != 0 {
+		var n [binary.MaxVarintLen64]byte
+		body = append(body, n[:binary.PutUvarint(n[:], uint64(len(m.headers)))]...)
+		for _, h := range m.headers {
+			body = appendBytes(body, []byte(h.Key))
+			body = appendBytes(body, h.Value)
+		}
+	}
+	if attrs&attrCodec != 0 {
+		body = append(body, m.codec)
+	}
+	if attrs&attrProducer != 0 {
+		var n [binary.MaxVarintLen64]byte
+		body = append(body, n[:binary.PutUvarint(n[:], m.producer)]...)
+		body = append(body, n[:binary.PutUvarint(n[:], m.sequence)]...)
+	}
+	if attrs&attrTxn != 0 {
+		var n [binary.MaxVarintLen64]byte
+		body = append(body, n[:binary.PutUvarint(n[:], m.txn)]...)
+	}
+	if attrs&attrControl != 0 {
+		body = append(body, m.control)
+	}
+	return append(body, m.value...), nil
+}
+
+// decodeBody parses a frame payload into m, slices of m alias body
+func decodeBody(version uint8, body []byte, m *message) error {
+	if version < FormatV2 {
+		*m = message{value: body}
+		return nil
+	}
+	if len(body) < bodyHeaderSize {
+		return ErrBadFormat
+	}
+
+	attrs := body[0]
+	*m = message{timestamp: int64(binary.LittleEndian.Uint64(body[1:]))}
+	body = body[bodyHeaderSize:]
+
+	var ok bool
+	if attrs&attrKey != 0 {
+		m.key, body, ok = consumeBytes(body)
+		if !ok {
+			return ErrBadFormat
+		}
+	}
+	if attrs&attrHeaders != 0 {
+		n, l := binary.Uvarint(body)
+		if l <= 0 || n > uint64(len(body)) {
+			return ErrBadFormat
+		}
+		body = body[l:]
+		m.headers = make([]Header, n)
+		for i := range m.headers {
+			var key []byte
+			key, body, ok = consumeBytes(body)
+			if !ok {
+				return ErrBadFormat
+			}
+			m.headers[i].Key = string(key)
+			m.headers[i].Value, body, ok = consumeBytes(body)
+			if !ok {
+				return ErrBadFormat
+			}
+		}
+	}
+	if attrs&attrCodec != 0 {
+		if len(body) < 1 || body[0] == 0 {
+			return ErrBadFormat
+		}
+		m.codec, body = body[0], body[1:]
+	}
+	if attrs&attrProducer != 0 {
+		var l1, l2 int
+		m.producer, l1 = binary.Uvarint(body)
+		if l1 <= 0 || m.producer == 0 {
+			return ErrBadFormat
+		}
+		m.sequence, l2 = binary.Uvarint(body[l1:])
+		if l2 <= 0 {
+			return ErrBadFormat
+		}
+		body = body[l1+l2:]
+	}
+	if attrs&attrTxn != 0 {
+		var l int
+		m.txn, l = binary.Uvarint(body)
+		if l <= 0 || m.txn == 0 {
+			return ErrBadFormat
+		}
+		body = body[l:]
+	}
+	if attrs&attrControl != 0 {
+		if len(body) < 1 || m.txn == 0 || (body[0] != txnCommit && body[0] != txnAbort) {
+			return ErrBadFormat
+		}
+		m.control, body = body[0], body[1:]
+	}
+	m.value = body
+	return nil
+}
+
+// appendBytes appends b to buf prefixed with its uvarint length
+func appendBytes(buf, b []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(b)))]...)
+	return append(buf, b...)
+}
+
+// consumeBytes is the inverse of appendBytes returning the remaining buf
+func consumeBytes(buf []byte) ([]byte, []byte, bool) {
+	n, l := binary.Uvarint(buf)
+	if l <= 0 || n > uint64(len(buf)-l) {
+		return nil, buf, false
+	}
+	buf = buf[l:]
+	return buf[:n], buf[n:], true
+}
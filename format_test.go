@@ -0,0 +1,376 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_FormatV1(t *testing.T) {
+	v1Topic := topic + ".v1"
+	os.RemoveAll(v1Topic)
+	defer os.RemoveAll(v1Topic)
+
+	wt, err := queuefka.NewWriterFormat(v1Topic, segmentSizeHint, queuefka.FormatV1)
+	if err != nil {
+		panic(err)
+	}
+	wt.Write(value)
+	wt.Close()
+
+	rd, err := queuefka.NewReader(v1Topic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	raw, err := rd.Read()
+	if err != nil {
+		panic(err)
+	}
+	rd.Close()
+
+	if string(raw) != string(value) {
+		println(string(raw))
+		panic("queuefka: Read does not match write:")
+	}
+}
+
+func Test_Queuefka_BadHeader(t *testing.T) {
+	v1Topic := topic + ".badheader"
+	os.RemoveAll(v1Topic)
+	defer os.RemoveAll(v1Topic)
+
+	wt, err := queuefka.NewWriterFormat(v1Topic, segmentSizeHint, queuefka.FormatV1)
+	if err != nil {
+		panic(err)
+	}
+	wt.Write(value)
+	wt.Close()
+
+	// flip the high bit of the length field which follows the 8 byte slab header
+	slab := queuefka.SlabFiles(v1Topic)[0]
+	fp, err := os.OpenFile(slab, os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	b := make([]byte, 1)
+	fp.ReadAt(b, 8+3)
+	b[0] ^= 0x80
+	fp.WriteAt(b, 8+3)
+	fp.Close()
+
+	rd, err := queuefka.NewReader(v1Topic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	_, err = rd.Read()
+	if err != queuefka.ErrBadHeader {
+		println(err)
+		panic("queuefka: corrupt length did not return ErrBadHeader:")
+	}
+}
+
+func Test_Queuefka_Timestamp(t *testing.T) {
+	v2Topic := topic + ".v2"
+	os.RemoveAll(v2Topic)
+	defer os.RemoveAll(v2Topic)
+
+	wt, err := queuefka.NewWriterFormat(v2Topic, segmentSizeHint, queuefka.FormatV2)
+	if err != nil {
+		panic(err)
+	}
+	before := time.Now()
+	wt.Write(value)
+	after := time.Now()
+	wt.Close()
+
+	rd, err := queuefka.NewReader(v2Topic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	raw, err := rd.Read()
+	if err != nil {
+		panic(err)
+	}
+	if string(raw) != string(value) {
+		println(string(raw))
+		panic("queuefka: Read does not match write:")
+	}
+
+	ts := rd.Timestamp()
+	if ts.Before(before) || ts.After(after) {
+		println(ts.String())
+		panic("queuefka: Timestamp is not the time of the write:")
+	}
+}
+
+func Test_Queuefka_WriteKeyed(t *testing.T) {
+	keyTopic := topic + ".keyed"
+	os.RemoveAll(keyTopic)
+	defer os.RemoveAll(keyTopic)
+
+	wt, err := queuefka.NewWriterFormat(keyTopic, segmentSizeHint, queuefka.FormatV2)
+	if err != nil {
+		panic(err)
+	}
+	wt.WriteKeyed([]byte("user-42"), value)
+	wt.Write(value)
+	wt.WriteKeyed([]byte{}, value)
+	wt.Close()
+
+	rd, err := queuefka.NewReader(keyTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	for _, key := range []string{"user-42", "", ""} {
+		raw, err := rd.Read()
+		if err != nil {
+			panic(err)
+		}
+		if string(raw) != string(value) {
+			println(string(raw))
+			panic("queuefka: Read does not match write:")
+		}
+		if string(rd.Key()) != key {
+			println(string(rd.Key()))
+			panic("queuefka: Key does not match WriteKeyed:")
+		}
+	}
+
+	// legacy slabs have nowhere to store a key
+	oldTopic := topic + ".keyed.v0"
+	os.RemoveAll(oldTopic)
+	defer os.RemoveAll(oldTopic)
+
+	wt, err = queuefka.NewWriterFormat(oldTopic, segmentSizeHint, queuefka.FormatV0)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	if wt.WriteKeyed([]byte("user-42"), value) != queuefka.ErrOldFormat {
+		panic("queuefka: WriteKeyed to a FormatV0 slab should fail:")
+	}
+}
+
+func Test_Queuefka_WriteHeaders(t *testing.T) {
+	headerTopic := topic + ".headers"
+	os.RemoveAll(headerTopic)
+	defer os.RemoveAll(headerTopic)
+
+	wt, err := queuefka.NewWriterFormat(headerTopic, segmentSizeHint, queuefka.FormatV2)
+	if err != nil {
+		panic(err)
+	}
+	headers := []queuefka.Header{
+		{Key: "trace-id", Value: []byte("4bf92f3577b34da6")},
+		{Key: "content-type", Value: []byte("text/plain")},
+		{Key: "empty", Value: []byte{}},
+	}
+	wt.WriteHeaders([]byte("user-42"), value, headers)
+	wt.Write(value)
+	wt.Close()
+
+	rd, err := queuefka.NewReader(headerTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	raw, err := rd.Read()
+	if err != nil {
+		panic(err)
+	}
+	if string(raw) != string(value) || string(rd.Key()) != "user-42" {
+		println(string(raw))
+		panic("queuefka: Read does not match write:")
+	}
+	if len(rd.Headers()) != len(headers) {
+		panic("queuefka: Headers does not match WriteHeaders:")
+	}
+	for i, h := range rd.Headers() {
+		if h.Key != headers[i].Key || string(h.Value) != string(headers[i].Value) {
+			println(h.Key)
+			panic("queuefka: Headers does not match WriteHeaders:")
+		}
+	}
+
+	// headers belong to a single message
+	raw, err = rd.Read()
+	if err != nil {
+		panic(err)
+	}
+	if rd.Headers() != nil || rd.Key() != nil {
+		panic("queuefka: Headers leaked into the next message:")
+	}
+}
+
+func Test_Queuefka_MixedFormats(t *testing.T) {
+	mixedTopic := topic + ".mixed"
+	os.RemoveAll(mixedTopic)
+	defer os.RemoveAll(mixedTopic)
+
+	// each Writer appends to the latest slab in its format then rolls over
+	// into slabs of its own format
+	count := 0
+	for _, version := range []uint8{queuefka.FormatV0, queuefka.FormatV1, queuefka.FormatV2, queuefka.FormatV3} {
+		wt, err := queuefka.NewWriterFormat(mixedTopic, 1024, version)
+		if err != nil {
+			panic(err)
+		}
+		for i := 0; i < 100; i++ {
+			wt.Write([]byte(fmt.Sprintf("message %012d", count)))
+			count++
+		}
+		wt.Close()
+	}
+
+	rd, err := queuefka.NewReader(mixedTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	for i := 0; i < count; i++ {
+		raw, err := rd.Read()
+		if err != nil {
+			panic(err)
+		}
+		if string(raw) != fmt.Sprintf("message %012d", i) {
+			println(string(raw))
+			panic("queuefka: Read does not match write across formats:")
+		}
+	}
+	if _, err := rd.Read(); err != queuefka.ErrEndOfLog {
+		panic("queuefka: expected ErrEndOfLog after the last message:")
+	}
+}
+
+func Test_Queuefka_BadFrameMagic(t *testing.T) {
+	v3Topic := topic + ".badmagic"
+	os.RemoveAll(v3Topic)
+	defer os.RemoveAll(v3Topic)
+
+	wt, err := queuefka.NewWriterFormat(v3Topic, segmentSizeHint, queuefka.FormatV3)
+	if err != nil {
+		panic(err)
+	}
+	wt.Write(value)
+	wt.Close()
+
+	// clobber the magic byte of the first frame after the slab header
+	fp, err := os.OpenFile(queuefka.SlabFiles(v3Topic)[0], os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	fp.WriteAt([]byte{0x00}, 8)
+	fp.Close()
+
+	rd, err := queuefka.NewReader(v3Topic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	if _, err := rd.Read(); err != queuefka.ErrBadHeader {
+		panic("queuefka: corrupt frame magic did not return ErrBadHeader:")
+	}
+
+	if _, err := queuefka.NewWriterFormat(v3Topic, segmentSizeHint, queuefka.FormatLatest+1); err != queuefka.ErrBadFormat {
+		panic("queuefka: NewWriterFormat accepted an unknown format:")
+	}
+}
+
+func Test_Queuefka_SealedBadLength(t *testing.T) {
+	v0Topic := topic + ".badlength"
+	os.RemoveAll(v0Topic)
+	defer os.RemoveAll(v0Topic)
+
+	wt, err := queuefka.NewWriterFormat(v0Topic, 1024, queuefka.FormatV0)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 100; i++ {
+		wt.Write(value)
+	}
+	wt.Close()
+
+	// legacy frames have no header checksum, make the first one claim ~2GiB
+	fp, err := os.OpenFile(queuefka.SlabFiles(v0Topic)[0], os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	fp.WriteAt([]byte{0xff, 0xff, 0xff, 0x7f}, 0)
+	fp.Close()
+
+	rd, err := queuefka.NewReader(v0Topic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	if _, err := rd.Read(); err != queuefka.ErrBadHeader {
+		panic("queuefka: length overrunning a sealed slab did not return ErrBadHeader:")
+	}
+}
+
+func Test_Queuefka_VarintLength(t *testing.T) {
+	varintTopic := topic + ".varint"
+	os.RemoveAll(varintTopic)
+	defer os.RemoveAll(varintTopic)
+
+	wt, err := queuefka.NewWriter(varintTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+
+	// interleave both kinds of frame within one slab
+	var fixed, compact uint64
+	for i := 0; i < 100; i++ {
+		wt.SetVarintLength(i%2 == 0)
+		before := wt.Address()
+		wt.Write([]byte(fmt.Sprintf("message %012d", i)))
+		if i%2 == 0 {
+			compact += wt.Address() - before
+		} else {
+			fixed += wt.Address() - before
+		}
+	}
+	wt.Close()
+
+	if compact >= fixed {
+		println(compact, fixed)
+		panic("queuefka: varint frames are not smaller:")
+	}
+
+	rd, err := queuefka.NewReader(varintTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	for i := 0; i < 100; i++ {
+		raw, err := rd.Read()
+		if err != nil {
+			panic(err)
+		}
+		if string(raw) != fmt.Sprintf("message %012d", i) {
+			println(string(raw))
+			panic("queuefka: Read does not match write:")
+		}
+	}
+	if _, err := rd.Read(); err != queuefka.ErrEndOfLog {
+		panic("queuefka: expected ErrEndOfLog after the last message:")
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSessionTimeout is how long a GroupConsumer may go without a
+// heartbeat unless WithSessionTimeout says otherwise
+const defaultSessionTimeout = 10 * time.Second
+
+// groupsDir is the directory in a partitioned topic holding a directory of
+// member files per consumer group
+const groupsDir = "groups"
+
+// memberExt names the heartbeat file of each member of a group
+const memberExt = ".member"
+
+// GroupConsumer reads a partitioned topic as one member of a consumer group,
+// the partitions shared out between the members so each is read by only one
+// of them.  Members may be in one process or many sharing the topic's
+// directory.  Each keeps a heartbeat file in the topic's groups directory
+// fresh, and every member assigns itself its share of the partitions from
+// the sorted names of the members with fresh heartbeats, so the partitions
+// are rebalanced as members join, leave or stop heartbeating for longer than
+// the session timeout.  A partition newly assigned is read from the offset
+// last committed for the group in its OffsetStore, or from its start.
+// Delivery is at least once, messages read but not committed before a
+// partition moves are read again by its new member.  A GroupConsumer is not
+// safe for concurrent use.
+type GroupConsumer struct {
+	topic   string
+	group   string
+	member  string
+	dir     string // member files of the group
+	opts    []Option
+	timeout time.Duration
+
+	assigned  []int           // partitions being read, in order
+	next      int             // index in assigned to read from first
+	readers   map[int]*Reader // open readers of assigned partitions
+	positions map[int]uint64  // address to resume each assigned partition from
+	committed map[int]uint64  // address last committed for each
+	stores    map[int]*OffsetStore
+	stop      chan struct{} // closed to stop heartbeatLoop
+	stopped   sync.WaitGroup
+
+	sync.Mutex       // guards pending
+	pending    []int // assignment found by the heartbeat, not yet applied
+	changed    bool  // pending is set
+}
+
+// JoinGroup joins member to the consumer group of the partitioned topic,
+// returning a GroupConsumer reading its share of the partitions.  An empty
+// member is given a name unique to the process.  Readers are opened with
+// opts, and the session timeout may be set WithSessionTimeout.
+func JoinGroup(topic, group, member string, opts ...Option) (*GroupConsumer, error) {
+	if member == "" {
+		host, _ := os.Hostname()
+		member = fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	}
+	if !validGroup(group) || !validGroup(member) {
+		return nil, ErrBadGroup
+	}
+	n, err := Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, ErrInvalidTopic
+	}
+
+	o := defaultOptions(opts)
+	gc := &GroupConsumer{
+		topic:     topic,
+		group:     group,
+		member:    member,
+		dir:       filepath.Join(topic, groupsDir, group),
+		opts:      opts,
+		timeout:   o.SessionTimeout,
+		readers:   make(map[int]*Reader),
+		positions: make(map[int]uint64),
+		committed: make(map[int]uint64),
+		stores:    make(map[int]*OffsetStore),
+		stop:      make(chan struct{}),
+	}
+	err = os.MkdirAll(gc.dir, dirMode(0600))
+	if err != nil {
+		return nil, err
+	}
+	assignment, err := gc.heartbeat()
+	if err == nil {
+		err = gc.apply(assignment)
+	}
+	if err != nil {
+		gc.leave()
+		return nil, err
+	}
+
+	gc.stopped.Add(1)
+	go gc.heartbeatLoop()
+	return gc, nil
+}
+
+// Member returns the name of the member in its group.
+func (gc *GroupConsumer) Member() string {
+	return gc.member
+}
+
+// Assignment returns the partitions currently assigned to the member.
+func (gc *GroupConsumer) Assignment() ([]int, error) {
+	err := gc.rebalance()
+	if err != nil {
+		return nil, err
+	}
+	return append([]int(nil), gc.assigned...), nil
+}
+
+// ReadRecord returns the next message of one of the assigned partitions
+// along with which, taking the partitions in turn, or ErrEndOfLog if none
+// has anything more for now.
+func (gc *GroupConsumer) ReadRecord() (int, Record, error) {
+	err := gc.rebalance()
+	if err != nil {
+		return -1, Record{}, err
+	}
+
+	for i := range gc.assigned {
+		at := (gc.next + i) % len(gc.assigned)
+		p := gc.assigned[at]
+		rd, err := gc.reader(p)
+		if err != nil {
+			return p, Record{}, err
+		}
+		rec, err := rd.ReadRecord()
+		if err == ErrEndOfLog {
+			continue
+		} else if err != nil {
+			return p, Record{}, err
+		}
+		gc.positions[p] = rec.NextAddress
+		gc.next = at + 1
+		return p, rec, nil
+	}
+	return -1, Record{}, ErrEndOfLog
+}
+
+// Commit records, for each assigned partition, that the group has processed
+// every message ReadRecord has returned from it.
+func (gc *GroupConsumer) Commit() error {
+	for _, p := range gc.assigned {
+		address := gc.positions[p]
+		if address == gc.committed[p] {
+			continue
+		}
+		err := gc.stores[p].Commit(gc.group, address)
+		if err != nil {
+			return err
+		}
+		gc.committed[p] = address
+	}
+	return nil
+}
+
+// Close leaves the group, so the other members take over its partitions,
+// without committing.
+func (gc *GroupConsumer) Close() error {
+	close(gc.stop)
+	gc.stopped.Wait()
+	for p := range gc.readers {
+		gc.closeReader(p)
+	}
+	return gc.leave()
+}
+
+// leave removes the member's heartbeat file
+func (gc *GroupConsumer) leave() error {
+	err := os.Remove(gc.memberPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// memberPath returns the heartbeat file of the member
+func (gc *GroupConsumer) memberPath() string {
+	return filepath.Join(gc.dir, gc.member+memberExt)
+}
+
+// heartbeat freshens the member's heartbeat file and returns the partitions
+// it should be reading given the other members with fresh heartbeats
+func (gc *GroupConsumer) heartbeat() ([]int, error) {
+	now := time.Now()
+	err := os.Chtimes(gc.memberPath(), now, now)
+	if os.IsNotExist(err) {
+		err = ioutil.WriteFile(gc.memberPath(), nil, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := readDirInfos(gc.dir)
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasSuffix(name, memberExt) && now.Sub(info.ModTime()) < gc.timeout {
+			members = append(members, strings.TrimSuffix(name, memberExt))
+		}
+	}
+	sort.Strings(members)
+
+	n, err := Partitions(gc.topic)
+	if err != nil {
+		return nil, err
+	}
+	return assignPartitions(members, gc.member, n), nil
+}
+
+// assignPartitions returns the partitions, of n, of member among the sorted
+// members, each taking every len(members)th partition
+func assignPartitions(members []string, member string, n int) []int {
+	var assigned []int
+	for p := 0; p < n; p++ {
+		if len(members) > 0 && members[p%len(members)] == member {
+			assigned = append(assigned, p)
+		}
+	}
+	return assigned
+}
+
+// heartbeatLoop heartbeats a few times per session timeout until stopped,
+// leaving any new assignment for the next read to apply
+func (gc *GroupConsumer) heartbeatLoop() {
+	defer gc.stopped.Done()
+
+	ticker := time.NewTicker(gc.timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gc.stop:
+			return
+		case <-ticker.C:
+			assignment, err := gc.heartbeat()
+			if err != nil {
+				continue
+			}
+			gc.Lock()
+			gc.pending, gc.changed = assignment, true
+			gc.Unlock()
+		}
+	}
+}
+
+// rebalance applies any assignment found by the heartbeat since last time
+func (gc *GroupConsumer) rebalance() error {
+	gc.Lock()
+	assignment, changed := gc.pending, gc.changed
+	gc.pending, gc.changed = nil, false
+	gc.Unlock()
+	if !changed {
+		return nil
+	}
+	return gc.apply(assignment)
+}
+
+// apply stops reading the partitions no longer assigned and looks up where
+// to resume those newly assigned
+func (gc *GroupConsumer) apply(assignment []int) error {
+	keep := make(map[int]bool, len(assignment))
+	for _, p := range assignment {
+		keep[p] = true
+	}
+	for _, p := range gc.assigned {
+		if !keep[p] {
+			gc.closeReader(p)
+			delete(gc.positions, p)
+			delete(gc.committed, p)
+			delete(gc.stores, p)
+		}
+	}
+
+	for _, p := range assignment {
+		if _, ok := gc.stores[p]; ok {
+			continue
+		}
+		store, err := NewOffsetStore(PartitionPath(gc.topic, p))
+		if err != nil {
+			return err
+		}
+		address, err := store.Fetch(gc.group)
+		if err != nil && err != ErrNoOffset {
+			return err
+		}
+		gc.stores[p] = store
+		gc.positions[p] = address
+		gc.committed[p] = address
+	}
+	gc.assigned = assignment
+	gc.next = 0
+	return nil
+}
+
+// reader returns the Reader of assigned partition p, opening it at the
+// partition's position if need be.  It stays open until the partition is
+// revoked or the GroupConsumer closed
+func (gc *GroupConsumer) reader(p int) (*Reader, error) {
+	rd, ok := gc.readers[p]
+	if ok {
+		return rd, nil
+	}
+	rd, err := NewReader(PartitionPath(gc.topic, p), gc.positions[p], gc.opts...)
+	if err == ErrAddressTruncated && rd != nil {
+		// deleted by retention since it was committed, go on from the oldest
+		err = nil
+	} else if err == ErrEndOfLog && rd != nil {
+		// nothing to read yet, keep it open to read from once there is
+		err = nil
+	}
+	if err != nil {
+		if rd != nil {
+			rd.Close()
+		}
+		return nil, err
+	}
+	gc.readers[p] = rd
+	return rd, nil
+}
+
+// closeReader closes the Reader of partition p if it is open
+func (gc *GroupConsumer) closeReader(p int) {
+	rd, ok := gc.readers[p]
+	if ok {
+		rd.Close()
+		delete(gc.readers, p)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// waitAssigned waits for gc to be assigned n partitions
+func waitAssigned(gc *queuefka.GroupConsumer, n int) []int {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		assigned, err := gc.Assignment()
+		if err != nil {
+			panic(err)
+		}
+		if len(assigned) == n || time.Now().After(deadline) {
+			return assigned
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_Queuefka_GroupConsumer(t *testing.T) {
+	groupTopic := topic + ".group"
+	os.RemoveAll(groupTopic)
+	defer os.RemoveAll(groupTopic)
+
+	err := queuefka.CreatePartitions(groupTopic, 4, 512)
+	if err != nil {
+		panic(err)
+	}
+	pw, err := queuefka.NewPartitionedWriter(groupTopic, nil, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 40; i++ {
+		pw.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	pw.Close()
+
+	timeout := queuefka.WithSessionTimeout(150 * time.Millisecond)
+	a, err := queuefka.JoinGroup(groupTopic, "billing", "a", timeout)
+	if err != nil {
+		panic(err)
+	}
+	if assigned := waitAssigned(a, 4); len(assigned) != 4 {
+		println(len(assigned))
+		panic("queuefka: a lone member was not assigned every partition:")
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		_, rec, err := a.ReadRecord()
+		if err != nil {
+			panic(err)
+		}
+		seen[string(rec.Value)]++
+	}
+	err = a.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	// a second member takes half the partitions
+	b, err := queuefka.JoinGroup(groupTopic, "billing", "b", timeout)
+	if err != nil {
+		panic(err)
+	}
+	pa, pb := waitAssigned(a, 2), waitAssigned(b, 2)
+	if len(pa) != 2 || len(pb) != 2 || pa[0] == pb[0] || pa[1] == pb[1] {
+		println(len(pa), len(pb))
+		panic("queuefka: the group did not rebalance when a member joined:")
+	}
+
+	for _, gc := range []*queuefka.GroupConsumer{a, b} {
+		for {
+			_, rec, err := gc.ReadRecord()
+			if err == queuefka.ErrEndOfLog {
+				break
+			} else if err != nil {
+				panic(err)
+			}
+			seen[string(rec.Value)]++
+		}
+		err = gc.Commit()
+		if err != nil {
+			panic(err)
+		}
+	}
+	if len(seen) != 40 {
+		println(len(seen))
+		panic("queuefka: the group did not read every message:")
+	}
+	for value, n := range seen {
+		if n != 1 {
+			println(value, n)
+			panic("queuefka: the group read a committed message twice:")
+		}
+	}
+
+	// once b leaves a takes every partition again, resuming from b's commits
+	b.Close()
+	if assigned := waitAssigned(a, 4); len(assigned) != 4 {
+		println(len(assigned))
+		panic("queuefka: the group did not rebalance when a member left:")
+	}
+	_, _, err = a.ReadRecord()
+	if err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("queuefka: a member did not resume from the committed offsets:")
+	}
+	a.Close()
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// A hole is punched over frames deleted in place, which keeps the addresses
+// of every other message and frees the disk blocks under the frames on
+// filesystems that support it.  The holes of a slab are recorded in its
+// <base>.holes sidecar as pairs of offsets (8 bytes each), the start of the
+// first frame deleted and the end of the last, so Readers step over them.
+
+// hole is a range of a slab whose frames were deleted
+type hole struct {
+	start uint64 // offset of the first frame deleted
+	end   uint64 // offset just past the last frame deleted
+}
+
+// holesPath returns the sidecar file recording the holes punched in a slab
+// e.g. <base>.holes
+func holesPath(slab string) string {
+	return slabStem(slab) + ".holes"
+}
+
+// writeSlabHoles atomically records the holes punched in a slab
+func writeSlabHoles(slab string, holes []hole) error {
+	if len(holes) == 0 {
+		err := os.Remove(holesPath(slab))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	buf := make([]byte, 16*len(holes))
+	for i, h := range holes {
+		binary.LittleEndian.PutUint64(buf[16*i:], h.start)
+		binary.LittleEndian.PutUint64(buf[16*i+8:], h.end)
+	}
+	tmp := holesPath(slab) + ".tmp"
+	err := ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, holesPath(slab))
+}
+
+// readSlabHoles returns the holes punched in a slab ordered by offset, none
+// if it has none
+func readSlabHoles(slab string) ([]hole, error) {
+	buf, err := ioutil.ReadFile(holesPath(slab))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(buf)%16 != 0 {
+		return nil, ErrBadChecksum
+	}
+	holes := make([]hole, len(buf)/16)
+	for i := range holes {
+		holes[i] = hole{start: binary.LittleEndian.Uint64(buf[16*i:]), end: binary.LittleEndian.Uint64(buf[16*i+8:])}
+	}
+	return holes, nil
+}
+
+// addHole returns holes with h added, merged with any it touches
+func addHole(holes []hole, h hole) []hole {
+	merged := make([]hole, 0, len(holes)+1)
+	for _, o := range holes {
+		if o.end < h.start || o.start > h.end {
+			merged = append(merged, o)
+			continue
+		}
+		if o.start < h.start {
+			h.start = o.start
+		}
+		if o.end > h.end {
+			h.end = o.end
+		}
+	}
+	merged = append(merged, h)
+	sort.Slice(merged, func(i, j int) bool { return merged[i].start < merged[j].start })
+	return merged
+}
+
+// holeEnd returns the end of the hole offset falls in, false if it is not
+// in one
+func holeEnd(holes []hole, offset uint64) (uint64, bool) {
+	i := sort.Search(len(holes), func(i int) bool { return holes[i].end > offset })
+	if i < len(holes) && holes[i].start <= offset {
+		return holes[i].end, true
+	}
+	return 0, false
+}
+
+// clipHoles returns the parts of holes before offset end
+func clipHoles(holes []hole, end uint64) []hole {
+	var clipped []hole
+	for _, h := range holes {
+		if h.start >= end {
+			break
+		}
+		if h.end > end {
+			h.end = end
+		}
+		clipped = append(clipped, h)
+	}
+	return clipped
+}
+
+// PunchHole deletes the messages whose frames lie from address start up to
+// end, which must be the start of a frame or the end of the slab, within a
+// single sealed slab.  The addresses of every other message are unchanged,
+// Readers step over the hole and CountMessages no longer counts the
+// messages deleted.  On Linux the disk blocks wholly inside the hole are
+// freed, elsewhere the frames are overwritten with zeros.
+func (wt *Writer) PunchHole(start, end uint64) error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	if start >= end {
+		return nil
+	}
+	if end > wt.base {
+		// the active slab is still being appended to
+		return ErrOutOfBounds
+	}
+	slabs := SlabFiles(wt.topic)
+	i, base := findSlab(slabs, start)
+	if start < base {
+		return ErrAddressTruncated
+	}
+	size, err := slabLength(slabs[i])
+	if err != nil {
+		return err
+	}
+	if end > base+uint64(size) {
+		return ErrOutOfBounds
+	}
+
+	// both ends must fall on frames, the end of the slab or either end of a
+	// hole punched already
+	holes, err := readSlabHoles(slabs[i])
+	if err != nil {
+		return err
+	}
+	starts, ends := false, end == base+uint64(size)
+	for _, h := range holes {
+		starts = starts || start-base == h.start || start-base == h.end
+		ends = ends || end-base == h.start || end-base == h.end
+	}
+	seg := Segment{Base: base, Path: slabs[i], Size: size}
+	err = wt.punchSegment(seg, func(address uint64, frame []byte, _ *message) bool {
+		starts = starts || address == start
+		ends = ends || address == end
+		return address >= start && address+uint64(len(frame)) <= end
+	}, func() bool { return starts && ends })
+	return err
+}
+
+// Erase deletes every message of the sealed slabs of the topic for which
+// match returns true by punching holes over their frames, e.g. to forget
+// every message with a given key.  Messages in compressed batches, which
+// cannot be deleted one at a time, are not passed to match.  The Record
+// passed to match is only valid during the call.
+func (wt *Writer) Erase(match func(Record) bool) error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	segments, err := SealedSegments(wt.topic)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		err = wt.punchSegment(seg, func(address uint64, frame []byte, m *message) bool {
+			if m.codec != 0 || m.control != 0 {
+				return false
+			}
+			return match(Record{
+				Address:     address,
+				NextAddress: address + uint64(len(frame)),
+				Timestamp:   nanoTime(m.timestamp),
+				Key:         m.key,
+				Value:       m.value,
+				Headers:     m.headers,
+			})
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// punchSegment punches holes over the frames of a sealed segment for which
+// drop returns true, provided ok returns true once they have all been seen
+// if it is not nil, and then rebuilds its sidecar files.  Caller must hold
+// the lock.
+func (wt *Writer) punchSegment(seg Segment, drop func(address uint64, frame []byte, m *message) bool, ok func() bool) error {
+	var punch []hole
+	err := walkFrames(seg, func(address uint64, frame []byte, m *message) error {
+		if !drop(address, frame, m) {
+			return nil
+		}
+		h := hole{start: address - seg.Base, end: address - seg.Base + uint64(len(frame))}
+		if n := len(punch); n > 0 && punch[n-1].end == h.start {
+			punch[n-1].end = h.end
+		} else {
+			punch = append(punch, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ok != nil && !ok() {
+		return ErrOutOfBounds
+	}
+	if len(punch) == 0 {
+		return nil
+	}
+
+	// a compressed slab cannot have holes punched in it
+	err = decompressSlab(seg.Path)
+	if err != nil {
+		return err
+	}
+
+	// the holes are recorded first, a crash before they are punched leaves
+	// the frames unread rather than zeros where a frame should be
+	holes, err := readSlabHoles(seg.Path)
+	if err != nil {
+		return err
+	}
+	for _, h := range punch {
+		holes = addHole(holes, h)
+	}
+	err = writeSlabHoles(seg.Path, holes)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.OpenFile(seg.Path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	for _, h := range punch {
+		err = punchHole(fp, int64(h.start), int64(h.end-h.start))
+		if err != nil {
+			fp.Close()
+			return err
+		}
+	}
+	err = fp.Sync()
+	cerr := fp.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// the count, indexes and footer are rebuilt without the frames deleted
+	for _, sidecar := range []string{countPath(seg.Path), indexPath(seg.Path), timeIndexPath(seg.Path), footerPath(seg.Path)} {
+		err = os.Remove(sidecar)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	_, err = SegmentFooter(seg)
+	if err != nil {
+		return err
+	}
+	return syncDir(wt.topic)
+}
+
+// zeroRange overwrites length bytes of fp from offset with zeros
+func zeroRange(fp *os.File, offset, length int64) error {
+	zeros := make([]byte, 64*1024)
+	for length > 0 {
+		n := int64(len(zeros))
+		if n > length {
+			n = length
+		}
+		_, err := fp.WriteAt(zeros[:n], offset)
+		if err != nil {
+			return err
+		}
+		offset += n
+		length -= n
+	}
+	return nil
+}
+
+// skipHole moves the Reader past any hole punched where its next frame was
+func (rd *Reader) skipHole() error {
+	end, ok := holeEnd(rd.holes, rd.address-rd.base)
+	if !ok {
+		return nil
+	}
+	rd.address = rd.base + end
+	return rd.rewind(nil)
+}
+
+// punched reports whether a hole has been punched where the next frame was
+// since the Reader reached the slab, in which case it moves past it
+func (rd *Reader) punched() bool {
+	holes, err := readSlabHoles(rd.fp.Name())
+	if err != nil {
+		return false
+	}
+	end, ok := holeEnd(holes, rd.address-rd.base)
+	if !ok {
+		return false
+	}
+	rd.holes = holes
+	rd.address = rd.base + end
+	return rd.rewind(nil) == nil
+}
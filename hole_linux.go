@@ -0,0 +1,29 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package queuefka
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocate modes, missing from package syscall
+const (
+	fallocKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+)
+
+// punchHole frees the disk blocks under length bytes of fp from offset,
+// which then read as zeros.  Filesystems without hole punching fall back to
+// overwriting them with zeros.
+func punchHole(fp *os.File, offset, length int64) error {
+	err := syscall.Fallocate(int(fp.Fd()), fallocPunchHole|fallocKeepSize, offset, length)
+	if err == syscall.EOPNOTSUPP {
+		return zeroRange(fp, offset, length)
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package queuefka
+
+import "os"
+
+// punchHole overwrites length bytes of fp from offset with zeros
+func punchHole(fp *os.File, offset, length int64) error {
+	return zeroRange(fp, offset, length)
+}
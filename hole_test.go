@@ -0,0 +1,119 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_PunchHole(t *testing.T) {
+	holeTopic := topic + ".hole"
+	os.RemoveAll(holeTopic)
+	defer os.RemoveAll(holeTopic)
+
+	wt, err := queuefka.NewWriter(holeTopic, 1024)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	for i := 0; i < 100; i++ {
+		wt.WriteKeyed([]byte(fmt.Sprintf("user-%d", i%5)), []byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(holeTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	var addresses []uint64
+	for i := 0; i < 8; i++ {
+		rec, err := rd.ReadRecord()
+		if err != nil {
+			panic(err)
+		}
+		addresses = append(addresses, rec.Address)
+	}
+
+	// both ends of a hole must fall on frames
+	err = wt.PunchHole(addresses[3]+1, addresses[7])
+	if err != queuefka.ErrOutOfBounds {
+		println(err)
+		panic("queuefka: PunchHole cut a frame in two:")
+	}
+	err = wt.PunchHole(addresses[3], addresses[7])
+	if err != nil {
+		panic(err)
+	}
+
+	// the Reader already past the hole carries on, a new one steps over it
+	// and the messages either side keep their addresses
+	if values := readValues(rd); len(values) != 92 || values[0] != "message 8" {
+		println(len(values))
+		panic("queuefka: Reader open across PunchHole read the wrong messages:")
+	}
+	rd, err = queuefka.NewReader(holeTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 96 || values[2] != "message 2" || values[3] != "message 7" {
+		println(len(values))
+		panic("queuefka: Reader did not step over the hole:")
+	}
+	if _, err = rd.ReadAt(addresses[4]); err != queuefka.ErrOutOfBounds {
+		println(err)
+		panic("queuefka: ReadAt read a deleted message:")
+	}
+	if d, _ := rd.ReadAt(addresses[7]); string(d) != "message 7" {
+		println(string(d))
+		panic("queuefka: message after the hole moved:")
+	}
+	if count, _ := queuefka.CountMessages(holeTopic); count != 96 {
+		println(count)
+		panic("queuefka: CountMessages counted deleted messages:")
+	}
+	segments, _ := queuefka.SealedSegments(holeTopic)
+	err = queuefka.VerifySegment(segments[0])
+	if err != nil {
+		panic(err)
+	}
+	buf, _ := ioutil.ReadFile(segments[0].Path)
+	for _, b := range buf[addresses[3]-segments[0].Base : addresses[7]-segments[0].Base] {
+		if b != 0 {
+			panic("queuefka: deleted messages are still on disk:")
+		}
+	}
+
+	// erase every message with a key
+	err = wt.Erase(func(rec queuefka.Record) bool { return string(rec.Key) == "user-2" })
+	if err != nil {
+		panic(err)
+	}
+	rd, err = queuefka.NewReader(holeTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	active := segments[len(segments)-1].Base + uint64(segments[len(segments)-1].Size)
+	for {
+		rec, err := rd.ReadRecord()
+		if err == queuefka.ErrEndOfLog {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		if string(rec.Key) == "user-2" && rec.Address < active {
+			println(string(rec.Value))
+			panic("queuefka: Erase left a matching message:")
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vova616/xxhash"
+)
+
+// Import restores a snapshot written by Export as topic, which must not
+// exist yet, ready for NewWriter and NewReader.  The snapshot is laid down
+// in a temporary directory beside topic and checked, every file against its
+// checksum and every sealed slab against its footer, before it is renamed
+// into place, so a damaged or truncated snapshot leaves nothing behind.
+func Import(topic string, r io.Reader) error {
+	_, err := os.Stat(topic)
+	if err == nil {
+		return ErrTopicExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp := fmt.Sprintf("%s.import-%d", filepath.Clean(topic), time.Now().UnixNano())
+	err = os.MkdirAll(tmp, dirMode(0600))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = importFiles(tmp, bufio.NewReader(r))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	err = verifyImport(tmp)
+	if err != nil {
+		return err
+	}
+	err = syncDir(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, topic)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(filepath.Clean(topic)))
+}
+
+// importFiles writes every file of a snapshot read from r to dir
+func importFiles(dir string, r io.Reader) error {
+	hdr := make([]byte, exportHeaderSize)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hdr[:4], exportMagic) || hdr[4] > exportVersion {
+		return ErrBadFormat
+	}
+
+	seen := make(map[string]bool)
+	buf := make([]byte, 8)
+	for {
+		_, err = io.ReadFull(r, buf[:2])
+		if err != nil {
+			return err
+		}
+		n := int(binary.LittleEndian.Uint16(buf))
+		if n == 0 {
+			break
+		}
+		name := make([]byte, n)
+		_, err = io.ReadFull(r, name)
+		if err != nil {
+			return err
+		}
+		if !importable(string(name)) || seen[string(name)] {
+			return ErrBadFormat
+		}
+		seen[string(name)] = true
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return err
+		}
+
+		err = importFile(filepath.Join(dir, string(name)), r, int64(binary.LittleEndian.Uint64(buf)))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the trailer counts the files so a truncated snapshot is caught
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(buf) != uint64(len(seen)) {
+		return ErrBadChecksum
+	}
+	return nil
+}
+
+// importable reports whether a snapshot may create a file called name in a
+// topic directory
+func importable(name string) bool {
+	if name == "." || name == ".." || name == lockName {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\x00")
+}
+
+// importFile copies size bytes of a snapshot from r to path and checks them
+// against the checksum which follows
+func importFile(path string, r io.Reader, size int64) error {
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	h := xxhash.New(0)
+	h.Write([]byte(filepath.Base(path)))
+	_, err = io.CopyN(io.MultiWriter(fp, h), r, size)
+	if err != nil {
+		return err
+	}
+	sum := make([]byte, 4)
+	_, err = io.ReadFull(r, sum)
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(sum) != h.Sum32() {
+		return ErrBadChecksum
+	}
+	err = fp.Sync()
+	if err != nil {
+		return err
+	}
+	return fp.Close()
+}
+
+// verifyImport checks every sealed slab imported to dir against its footer
+func verifyImport(dir string) error {
+	slabs := SlabFiles(dir)
+	if len(slabs) <= 0 {
+		return ErrInvalidTopic
+	}
+	for _, slab := range slabs[:len(slabs)-1] {
+		_, err := os.Stat(footerPath(slab))
+		if os.IsNotExist(err) {
+			continue
+		}
+		base, err := slabBase(slab)
+		if err != nil {
+			return err
+		}
+		size, err := slabLength(slab)
+		if err != nil {
+			return err
+		}
+		err = VerifySegment(Segment{Base: base, Path: slab, Size: size})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
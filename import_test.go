@@ -0,0 +1,92 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Import(t *testing.T) {
+	exportTopic := topic + ".export2"
+	importTopic := topic + ".import"
+	os.RemoveAll(exportTopic)
+	os.RemoveAll(importTopic)
+	defer os.RemoveAll(exportTopic)
+	defer os.RemoveAll(importTopic)
+
+	wt, err := queuefka.NewWriter(exportTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 40; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+	var buf bytes.Buffer
+	err = queuefka.Export(exportTopic, &buf)
+	if err != nil {
+		panic(err)
+	}
+	snapshot := buf.Bytes()
+
+	// a damaged or truncated snapshot leaves nothing behind
+	damaged := append([]byte{}, snapshot...)
+	damaged[len(damaged)/2]++
+	err = queuefka.Import(importTopic, bytes.NewReader(damaged))
+	if err != queuefka.ErrBadChecksum {
+		println(err)
+		panic("queuefka: Import accepted a damaged snapshot:")
+	}
+	err = queuefka.Import(importTopic, bytes.NewReader(snapshot[:len(snapshot)-20]))
+	if err != io.ErrUnexpectedEOF {
+		println(err)
+		panic("queuefka: Import accepted a truncated snapshot:")
+	}
+	if _, err := os.Stat(importTopic); !os.IsNotExist(err) {
+		panic("queuefka: failed Import left a topic behind:")
+	}
+	if names, _ := filepath.Glob(importTopic + ".import-*"); len(names) > 0 {
+		println(names[0])
+		panic("queuefka: failed Import left its temporary directory:")
+	}
+
+	err = queuefka.Import(importTopic, bytes.NewReader(snapshot))
+	if err != nil {
+		panic(err)
+	}
+	if err = queuefka.Import(importTopic, bytes.NewReader(snapshot)); err != queuefka.ErrTopicExists {
+		println(err)
+		panic("queuefka: Import overwrote a topic:")
+	}
+
+	// the restored topic reads and appends like the original
+	wt, err = queuefka.NewWriter(importTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	wt.Write([]byte("after import"))
+	wt.Close()
+	rd, err := queuefka.NewReader(importTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 41 || values[0] != "message 0" || values[40] != "after import" {
+		println(len(values))
+		panic("queuefka: imported topic read back wrong:")
+	}
+	if count, _ := queuefka.CountMessages(importTopic); count != 41 {
+		println(count)
+		panic("queuefka: imported topic miscounted:")
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// defaultIndexInterval is how many bytes of frames a slab index skips
+// between entries unless WithIndexInterval says otherwise
+const defaultIndexInterval = 4096
+
+// indexEntrySize is the on disk size of an indexEntry
+const indexEntrySize = 16
+
+// indexEntry locates a frame in a slab, sealed slabs have a sparse
+// <base>.index sidecar of them so a Reader can jump close to any address or
+// record number instead of scanning the slab from its start
+type indexEntry struct {
+	offset uint64 // offset of the frame from the start of the slab
+	record uint64 // records in the slab before the frame
+}
+
+// timeEntry pairs a frame in a slab with when it was written, sealed slabs
+// of FormatV2 or later have a <base>.timeindex sidecar of them sampled like
+// their index and always ending with their last frame
+type timeEntry struct {
+	timestamp int64  // unix nanoseconds the frame was written at
+	offset    uint64 // offset of the frame from the start of the slab
+}
+
+// indexFrame adds the frame about to be appended, written at timestamp, to
+// the indexes of the current slab if it is far enough past the last entry,
+// caller must hold the lock
+func (wt *Writer) indexFrame(timestamp int64) {
+	if !wt.counted {
+		return
+	}
+	if timestamp != 0 {
+		if wt.minTime == 0 || timestamp < wt.minTime {
+			wt.minTime = timestamp
+		}
+		if timestamp > wt.maxTime {
+			wt.maxTime = timestamp
+		}
+	}
+	last := uint64(len(slabHeader(wt.slabVersion)))
+	if len(wt.index) > 0 {
+		last = wt.index[len(wt.index)-1].offset
+	}
+	offset := wt.address - wt.base
+	if offset-last >= wt.indexEvery {
+		wt.index = append(wt.index, indexEntry{offset: offset, record: wt.count})
+		if wt.slabVersion >= FormatV2 {
+			wt.times = append(wt.times, timeEntry{timestamp: timestamp, offset: offset})
+		}
+	}
+	wt.lastFrame = timeEntry{timestamp: timestamp, offset: offset}
+}
+
+// writeIndexes records the indexes of the current slab as it is sealed,
+// caller must hold the lock
+func (wt *Writer) writeIndexes() error {
+	slab := wt.slabPath(wt.base)
+	err := writeSlabIndex(slab, wt.index)
+	if err != nil || wt.slabVersion < FormatV2 {
+		return err
+	}
+
+	times := wt.times
+	if wt.count > 0 && (len(times) == 0 || times[len(times)-1] != wt.lastFrame) {
+		times = append(times, wt.lastFrame)
+	}
+	return writeSlabTimeIndex(slab, times)
+}
+
+// indexPath returns the sidecar file indexing a sealed slab e.g. <base>.index
+func indexPath(slab string) string {
+	return slabStem(slab) + ".index"
+}
+
+// writeSlabIndex atomically records the index of a sealed slab
+func writeSlabIndex(slab string, index []indexEntry) error {
+	buf := make([]byte, len(index)*indexEntrySize)
+	for i, e := range index {
+		binary.LittleEndian.PutUint64(buf[i*indexEntrySize:], e.offset)
+		binary.LittleEndian.PutUint64(buf[i*indexEntrySize+8:], e.record)
+	}
+
+	tmp := indexPath(slab) + ".tmp"
+	err := ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, indexPath(slab))
+}
+
+// readSlabIndex returns the index recorded for a sealed slab
+func readSlabIndex(slab string) ([]indexEntry, error) {
+	buf, err := ioutil.ReadFile(indexPath(slab))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf)%indexEntrySize != 0 {
+		return nil, ErrBadChecksum
+	}
+	index := make([]indexEntry, len(buf)/indexEntrySize)
+	for i := range index {
+		index[i].offset = binary.LittleEndian.Uint64(buf[i*indexEntrySize:])
+		index[i].record = binary.LittleEndian.Uint64(buf[i*indexEntrySize+8:])
+	}
+	return index, nil
+}
+
+// scanSlabIndex indexes a segment by reading every message
+func scanSlabIndex(seg Segment) ([]indexEntry, error) {
+	rd, err := NewSegmentReader(seg)
+	if err == ErrEndOfLog {
+		rd.Close()
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	var index []indexEntry
+	var record uint64
+	last := rd.address - seg.Base
+	for {
+		from := rd.address
+		_, err := rd.Read()
+		if err == ErrEndOfLog {
+			return index, nil
+		} else if err != nil {
+			return index, err
+		}
+
+		// only the first message read out of a frame starts it
+		if rd.last >= from && rd.last-seg.Base-last >= defaultIndexInterval {
+			last = rd.last - seg.Base
+			index = append(index, indexEntry{offset: last, record: record})
+		}
+		record++
+	}
+}
+
+// segmentIndex returns the index of a sealed segment from its .index sidecar
+// file, rebuilding it by scanning the segment if missing
+func segmentIndex(seg Segment) ([]indexEntry, error) {
+	index, err := readSlabIndex(seg.Path)
+	if err == nil {
+		return index, nil
+	}
+	index, err = scanSlabIndex(seg)
+	if err != nil {
+		return index, err
+	}
+	return index, writeSlabIndex(seg.Path, index)
+}
+
+// timeIndexPath returns the sidecar file of frame timestamps of a sealed slab
+// e.g. <base>.timeindex
+func timeIndexPath(slab string) string {
+	return slabStem(slab) + ".timeindex"
+}
+
+// writeSlabTimeIndex atomically records the time index of a sealed slab
+func writeSlabTimeIndex(slab string, times []timeEntry) error {
+	buf := make([]byte, len(times)*indexEntrySize)
+	for i, e := range times {
+		binary.LittleEndian.PutUint64(buf[i*indexEntrySize:], uint64(e.timestamp))
+		binary.LittleEndian.PutUint64(buf[i*indexEntrySize+8:], e.offset)
+	}
+
+	tmp := timeIndexPath(slab) + ".tmp"
+	err := ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, timeIndexPath(slab))
+}
+
+// readSlabTimeIndex returns the time index recorded for a sealed slab
+func readSlabTimeIndex(slab string) ([]timeEntry, error) {
+	buf, err := ioutil.ReadFile(timeIndexPath(slab))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf)%indexEntrySize != 0 {
+		return nil, ErrBadChecksum
+	}
+	times := make([]timeEntry, len(buf)/indexEntrySize)
+	for i := range times {
+		times[i].timestamp = int64(binary.LittleEndian.Uint64(buf[i*indexEntrySize:]))
+		times[i].offset = binary.LittleEndian.Uint64(buf[i*indexEntrySize+8:])
+	}
+	return times, nil
+}
+
+// scanSlabTimeIndex builds the time index of a segment by reading every
+// message, messages without a timestamp are left out
+func scanSlabTimeIndex(seg Segment) ([]timeEntry, error) {
+	rd, err := NewSegmentReader(seg)
+	if err == ErrEndOfLog {
+		rd.Close()
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	var times []timeEntry
+	var final timeEntry
+	last := rd.address - seg.Base
+	for {
+		from := rd.address
+		_, err := rd.Read()
+		if err == ErrEndOfLog {
+			break
+		} else if err != nil {
+			return times, err
+		}
+		if rd.last < from || rd.msg.timestamp == 0 {
+			continue
+		}
+
+		final = timeEntry{timestamp: rd.msg.timestamp, offset: rd.last - seg.Base}
+		if final.offset-last >= defaultIndexInterval {
+			last = final.offset
+			times = append(times, final)
+		}
+	}
+	if final.timestamp != 0 && (len(times) == 0 || times[len(times)-1] != final) {
+		times = append(times, final)
+	}
+	return times, nil
+}
+
+// segmentTimeIndex returns the time index of a sealed segment from its
+// .timeindex sidecar file, rebuilding it by scanning the segment if missing
+func segmentTimeIndex(seg Segment) ([]timeEntry, error) {
+	times, err := readSlabTimeIndex(seg.Path)
+	if err == nil {
+		return times, nil
+	}
+	times, err = scanSlabTimeIndex(seg)
+	if err != nil {
+		return times, err
+	}
+	return times, writeSlabTimeIndex(seg.Path, times)
+}
+
+// SeekNearest positions the Reader at the first frame starting at or after
+// address, which unlike Seek need not be the address of a frame.  For a
+// sealed slab its .index sidecar file finds the closest frame before
+// address, otherwise the slab is scanned from its start, and the Reader
+// skips over frame headers from there.  Seeking past the end of the log
+// returns ErrOutOfBounds.
+func (rd *Reader) SeekNearest(address uint64) error {
+	slabs := SlabFiles(rd.topic)
+	if len(slabs) <= 0 {
+		return ErrInvalidTopic
+	}
+
+	i, base := findSlab(slabs, address)
+	start := base
+	if i < len(slabs)-1 {
+		size, err := slabLength(slabs[i])
+		if err != nil {
+			return err
+		}
+		index, err := segmentIndex(Segment{Base: base, Path: slabs[i], Size: size})
+		if err != nil {
+			return err
+		}
+		j := sort.Search(len(index), func(j int) bool { return base+index[j].offset > address })
+		if j > 0 {
+			start = base + index[j-1].offset
+		}
+	}
+
+	err := rd.Seek(rd.topic, start)
+	for err == nil && rd.address < address {
+		err = rd.skip()
+	}
+	if err == ErrEndOfLog {
+		if rd.address < address && i == len(slabs)-1 {
+			return ErrOutOfBounds
+		}
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_SeekNearest(t *testing.T) {
+	nearTopic := topic + ".nearest"
+	os.RemoveAll(nearTopic)
+	defer os.RemoveAll(nearTopic)
+
+	// a short interval so every sealed slab has several index entries
+	wt, err := queuefka.NewWriter(nearTopic, 1024, queuefka.WithIndexInterval(64))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	var addresses []uint64
+	for i := 0; i < 100; i++ {
+		addresses = append(addresses, wt.Address())
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	end := wt.Address()
+	wt.Flush()
+
+	indexes, _ := filepath.Glob(nearTopic + "/*.index")
+	if len(indexes) == 0 || len(indexes) != len(queuefka.SlabFiles(nearTopic))-1 {
+		println(len(indexes))
+		panic("queuefka: sealed slabs have no .index sidecar files:")
+	}
+
+	check := func() {
+		rd, err := queuefka.NewReader(nearTopic, 0)
+		if err != nil {
+			panic(err)
+		}
+		defer rd.Close()
+
+		// every address lands on the first frame at or after it
+		next := 0
+		for address := uint64(0); address < end; address++ {
+			for next < len(addresses) && addresses[next] < address {
+				next++
+			}
+			err := rd.SeekNearest(address)
+			if err != nil {
+				panic(err)
+			}
+			if next == len(addresses) {
+				continue
+			}
+			rec, err := rd.ReadRecord()
+			if err != nil {
+				panic(err)
+			}
+			if string(rec.Value) != fmt.Sprintf("message %d", next) {
+				println(address, next, string(rec.Value))
+				panic("queuefka: SeekNearest landed on the wrong frame:")
+			}
+		}
+		err = rd.SeekNearest(end + 1)
+		if err != queuefka.ErrOutOfBounds {
+			println(err)
+			panic("queuefka: SeekNearest past the end of the log did not return ErrOutOfBounds:")
+		}
+
+		// record numbers are found through the index too
+		for _, n := range []uint64{0, 17, 50, 99} {
+			err := rd.SeekToRecord(n)
+			if err != nil {
+				panic(err)
+			}
+			d, _ := rd.Read()
+			if string(d) != fmt.Sprintf("message %d", n) {
+				println(n, string(d))
+				panic("queuefka: SeekToRecord through the index returned the wrong message:")
+			}
+		}
+	}
+	check()
+
+	// missing index files are rebuilt by scanning their slab
+	for _, index := range indexes {
+		os.Remove(index)
+	}
+	check()
+	rebuilt, _ := filepath.Glob(nearTopic + "/*.index")
+	if len(rebuilt) != len(indexes) {
+		println(len(rebuilt), len(indexes))
+		panic("queuefka: missing .index sidecar files were not rebuilt:")
+	}
+}
+
+func Test_Queuefka_TimeIndex(t *testing.T) {
+	timeTopic := topic + ".timeindex"
+	os.RemoveAll(timeTopic)
+	defer os.RemoveAll(timeTopic)
+
+	wt, err := queuefka.NewWriter(timeTopic, 1024, queuefka.WithIndexInterval(64))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// groups of messages start part way through slabs
+	var starts []time.Time
+	for group := 0; group < 8; group++ {
+		time.Sleep(2 * time.Millisecond)
+		starts = append(starts, time.Now())
+		for i := 0; i < 15; i++ {
+			wt.Write([]byte(fmt.Sprintf("message %d.%d", group, i)))
+		}
+	}
+	wt.Flush()
+
+	times, _ := filepath.Glob(timeTopic + "/*.timeindex")
+	if len(times) == 0 || len(times) != len(queuefka.SlabFiles(timeTopic))-1 {
+		println(len(times))
+		panic("queuefka: sealed slabs have no .timeindex sidecar files:")
+	}
+
+	check := func() {
+		rd, err := queuefka.NewReader(timeTopic, 0)
+		if err != nil {
+			panic(err)
+		}
+		defer rd.Close()
+
+		for group, start := range starts {
+			err = rd.SeekToTime(start)
+			if err != nil {
+				panic(err)
+			}
+			d, err := rd.Read()
+			if err != nil || string(d) != fmt.Sprintf("message %d.0", group) {
+				println(group, string(d), err)
+				panic("queuefka: SeekToTime through the time index missed the first message at its time:")
+			}
+		}
+	}
+	check()
+
+	// missing time index files are rebuilt by scanning their slab
+	for _, index := range times {
+		os.Remove(index)
+	}
+	check()
+	rebuilt, _ := filepath.Glob(timeTopic + "/*.timeindex")
+	if len(rebuilt) != len(times) {
+		println(len(rebuilt), len(times))
+		panic("queuefka: missing .timeindex sidecar files were not rebuilt:")
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package wire encodes and decodes the frames and fields of the network
+// protocol described in the server package, shared by the server and the
+// client.  Everything is little endian, strings are a uint16 length and
+// bytes a uint32 length followed by the data, a length of NoBytes meaning
+// nil.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// NoBytes is the length of nil bytes
+const NoBytes = 0xffffffff
+
+// Errors, exported by the server package as its own
+var (
+	ErrBadRequest    = errors.New("server: malformed request")
+	ErrFrameTooLarge = errors.New("server: frame exceeds maximum size")
+)
+
+// ReadFrame returns the next frame from r no longer than max.
+func ReadFrame(r io.Reader, max uint32) ([]byte, error) {
+	hdr := make([]byte, 4)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr)
+	if n > max {
+		return nil, ErrFrameTooLarge
+	}
+	frame := make([]byte, n)
+	_, err = io.ReadFull(r, frame)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return frame, err
+}
+
+// Encoder appends the fields of a frame.
+type Encoder []byte
+
+// NewEncoder returns an Encoder with room for the frame length, see Frame.
+func NewEncoder() Encoder {
+	return make(Encoder, 4, 64)
+}
+
+// Frame returns the frame of an Encoder from NewEncoder with its length
+// filled in.
+func (e Encoder) Frame() []byte {
+	binary.LittleEndian.PutUint32(e, uint32(len(e)-4))
+	return e
+}
+
+func (e *Encoder) Uint8(v uint8) {
+	*e = append(*e, v)
+}
+
+func (e *Encoder) Uint16(v uint16) {
+	*e = append(*e, byte(v), byte(v>>8))
+}
+
+func (e *Encoder) Uint32(v uint32) {
+	*e = append(*e, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (e *Encoder) Uint64(v uint64) {
+	e.Uint32(uint32(v))
+	e.Uint32(uint32(v >> 32))
+}
+
+func (e *Encoder) String(s string) {
+	e.Uint16(uint16(len(s)))
+	*e = append(*e, s...)
+}
+
+func (e *Encoder) Bytes(b []byte) {
+	if b == nil {
+		e.Uint32(NoBytes)
+		return
+	}
+	e.Uint32(uint32(len(b)))
+	*e = append(*e, b...)
+}
+
+// Message appends the key, value and headers of a message.
+func (e *Encoder) Message(key, value []byte, headers []queuefka.Header) {
+	e.Bytes(key)
+	e.Bytes(value)
+	e.Uint16(uint16(len(headers)))
+	for _, h := range headers {
+		e.String(h.Key)
+		e.Bytes(h.Value)
+	}
+}
+
+// Record appends a Record returned by a fetch.
+func (e *Encoder) Record(rec queuefka.Record) {
+	e.Uint64(rec.Address)
+	e.Uint64(rec.NextAddress)
+	var nanos int64
+	if !rec.Timestamp.IsZero() {
+		nanos = rec.Timestamp.UnixNano()
+	}
+	e.Uint64(uint64(nanos))
+	e.Message(rec.Key, rec.Value, rec.Headers)
+}
+
+// Decoder reads the fields of a frame from Buf, any short field leaving Err
+// set to ErrBadRequest and every later field zero.
+type Decoder struct {
+	Buf []byte // not yet read
+	Err error
+}
+
+// Next returns the next n bytes of the frame.
+func (d *Decoder) Next(n int) []byte {
+	if d.Err != nil || n > len(d.Buf) {
+		d.Err = ErrBadRequest
+		return nil
+	}
+	b := d.Buf[:n:n]
+	d.Buf = d.Buf[n:]
+	return b
+}
+
+func (d *Decoder) Uint8() uint8 {
+	b := d.Next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *Decoder) Uint16() uint16 {
+	b := d.Next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *Decoder) Uint32() uint32 {
+	b := d.Next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *Decoder) Uint64() uint64 {
+	b := d.Next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// String reads a string field, it is not a fmt.Stringer.
+func (d *Decoder) String() string {
+	return string(d.Next(int(d.Uint16())))
+}
+
+func (d *Decoder) Bytes() []byte {
+	n := d.Uint32()
+	if n == NoBytes || d.Err != nil {
+		return nil
+	}
+	return d.Next(int(n))
+}
+
+// Message returns the key, value and headers of a message.
+func (d *Decoder) Message() ([]byte, []byte, []queuefka.Header) {
+	key := d.Bytes()
+	value := d.Bytes()
+	n := int(d.Uint16())
+	var headers []queuefka.Header
+	for i := 0; i < n && d.Err == nil; i++ {
+		headers = append(headers, queuefka.Header{Key: d.String(), Value: d.Bytes()})
+	}
+	return key, value, headers
+}
+
+// Record returns a Record sent in reply to a fetch.
+func (d *Decoder) Record() queuefka.Record {
+	rec := queuefka.Record{Address: d.Uint64(), NextAddress: d.Uint64()}
+	if nanos := int64(d.Uint64()); nanos != 0 {
+		rec.Timestamp = time.Unix(0, nanos)
+	}
+	rec.Key, rec.Value, rec.Headers = d.Message()
+	return rec
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"time"
+)
+
+// Record is a single message together with where it lives in the log.
+type Record struct {
+	Address     uint64    // address of the frame holding the message
+	NextAddress uint64    // address to resume from after the message
+	Timestamp   time.Time // when the message was written, zero before FormatV2
+	Key         []byte    // optional message key
+	Value       []byte    // message payload
+	Headers     []Header  // optional metadata headers
+}
+
+// record returns the message most recently returned by Read as a Record
+func (rd *Reader) record(address, next uint64, d []byte) Record {
+	return Record{
+		Address:     address,
+		NextAddress: next,
+		Timestamp:   rd.Timestamp(),
+		Key:         rd.msg.key,
+		Value:       d,
+		Headers:     rd.msg.headers,
+	}
+}
+
+// ReadFiltered returns the next message for which match returns true,
+// skipping the rest.  Messages are read into a reused buffer and only copied
+// once they match, so the Record passed to match is only valid during the
+// call.  In follow mode it waits for a message to match.
+func (rd *Reader) ReadFiltered(match func(Record) bool) (Record, error) {
+	for {
+		d, err := rd.read(context.Background(), true)
+		if err != nil {
+			return Record{}, err
+		}
+		rec := rd.record(rd.last, rd.resume(), d)
+		if match(rec) {
+			return rec.clone(), nil
+		}
+	}
+}
+
+// clone returns a copy of r which shares no memory with it
+func (r Record) clone() Record {
+	r.Key = append([]byte(nil), r.Key...)
+	r.Value = append([]byte(nil), r.Value...)
+	if r.Headers != nil {
+		headers := make([]Header, len(r.Headers))
+		for i, h := range r.Headers {
+			headers[i] = Header{Key: h.Key, Value: append([]byte(nil), h.Value...)}
+		}
+		r.Headers = headers
+	}
+	return r
+}
+
+// ReadBatch returns up to maxRecords messages whose values total at most
+// maxBytes, or the next message alone if it is larger, zero means no limit.
+// The messages come out of the Reader's buffer so a batch of small messages
+// costs a single read of the slab.  It stops early at the end of the log,
+// returning ErrEndOfLog only if there are no messages at all, and in follow
+// mode only waits for the first.  On any other error the messages read
+// before it are returned along with it.
+func (rd *Reader) ReadBatch(maxRecords, maxBytes int) ([]Record, error) {
+	var records []Record
+	var size int
+	for maxRecords <= 0 || len(records) < maxRecords {
+		batched := len(rd.pending) > 0
+		var d []byte
+		var err error
+		if len(records) == 0 {
+			d, err = rd.Read()
+		} else {
+			d, err = rd.next(context.Background(), false)
+		}
+		if err == ErrEndOfLog && len(records) > 0 {
+			break
+		} else if err != nil {
+			return records, err
+		}
+
+		// put back a message which does not fit for the next call
+		if maxBytes > 0 && size+len(d) > maxBytes && len(records) > 0 {
+			return records, rd.unread(batched)
+		}
+		size += len(d)
+		records = append(records, rd.record(rd.last, rd.resume(), d))
+	}
+
+	return records, nil
+}
+
+// unread puts back the message last returned by next so it is returned
+// again, batched says whether it came out of an already expanded batch
+func (rd *Reader) unread(batched bool) error {
+	if batched {
+		rd.pending = append([]message{rd.msg}, rd.pending...)
+		return nil
+	}
+	rd.pending = nil
+	rd.address = rd.last
+	return rd.rewind(nil)
+}
+
+// Iterator wraps a Reader in a loop friendly cursor:
+//
+//	it, _ := queuefka.NewIterator("./mytopic", 0)
+//	for it.Next() {
+//		println(string(it.Record().Value))
+//	}
+//	if it.Err() != nil { ... }
+//
+// Reaching the end of the log simply ends the loop, calling Next again later
+// picks up anything written since.  Position returns the address to pass to
+// NewIterator to resume iteration later.
+type Iterator struct {
+	rd       *Reader
+	rec      Record
+	position uint64
+	err      error
+}
+
+// NewIterator returns an Iterator starting at the specified topic and address
+func NewIterator(topic string, address uint64, opts ...Option) (*Iterator, error) {
+	rd, err := NewReader(topic, address, opts...)
+	if err != nil && err != ErrEndOfLog {
+		rd.Close()
+		return nil, err
+	}
+	return rd.Iterator(), nil
+}
+
+// Iterator returns an Iterator continuing from the Reader's current position.
+// The Reader should not be used directly while the Iterator is in use.
+func (rd *Reader) Iterator() *Iterator {
+	return &Iterator{rd: rd, position: rd.address}
+}
+
+// Next advances to the next message, returning false at the end of the log or
+// on an error, which Err then reports.
+func (it *Iterator) Next() bool {
+	return it.NextContext(context.Background())
+}
+
+// NextContext is like Next but in follow mode stops waiting for a message
+// once ctx is done, which Err then reports.
+func (it *Iterator) NextContext(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+
+	rec, err := it.rd.readRecord(ctx)
+	if err == ErrEndOfLog {
+		return false
+	} else if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.rec = rec
+	it.position = rec.NextAddress
+	return true
+}
+
+// Record returns the message Next most recently advanced to
+func (it *Iterator) Record() Record {
+	return it.rec
+}
+
+// Err returns the error which stopped iteration, or nil if it stopped at the
+// end of the log.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Position returns the address to resume iteration from, e.g. with
+// NewIterator, after the message Next most recently advanced to.
+func (it *Iterator) Position() uint64 {
+	return it.position
+}
+
+// Close closes the underlying Reader
+func (it *Iterator) Close() error {
+	return it.rd.Close()
+}
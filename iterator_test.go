@@ -0,0 +1,187 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Iterator(t *testing.T) {
+	itTopic := topic + ".iterator"
+	os.RemoveAll(itTopic)
+	defer os.RemoveAll(itTopic)
+
+	wt, err := queuefka.NewWriter(itTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// an empty topic simply has nothing to iterate over yet
+	it, err := queuefka.NewIterator(itTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer it.Close()
+	if it.Next() || it.Err() != nil {
+		panic("queuefka: Iterator over an empty topic did not stop cleanly:")
+	}
+
+	for i := 0; i < 4; i++ {
+		wt.WriteKeyed([]byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	// iteration picks up messages written since it stopped
+	var position uint64
+	for i := 0; i < 2; i++ {
+		if !it.Next() {
+			println(i, it.Err())
+			panic("queuefka: Iterator stopped early:")
+		}
+		rec := it.Record()
+		if string(rec.Key) != fmt.Sprintf("key %d", i) || string(rec.Value) != fmt.Sprintf("message %d", i) {
+			println(i, string(rec.Key), string(rec.Value))
+			panic("queuefka: Iterator returned the wrong record:")
+		}
+		if rec.NextAddress != it.Position() {
+			println(rec.NextAddress, it.Position())
+			panic("queuefka: Iterator position does not follow its record:")
+		}
+		position = it.Position()
+	}
+
+	// a new Iterator resumes from a saved position
+	resumed, err := queuefka.NewIterator(itTopic, position)
+	if err != nil {
+		panic(err)
+	}
+	defer resumed.Close()
+	count := 0
+	for resumed.Next() {
+		if string(resumed.Record().Value) != fmt.Sprintf("message %d", count+2) {
+			println(count, string(resumed.Record().Value))
+			panic("queuefka: Iterator did not resume from its position:")
+		}
+		count++
+	}
+	if resumed.Err() != nil || count != 2 {
+		println(count, resumed.Err())
+		panic("queuefka: resumed Iterator did not reach the end of the log:")
+	}
+}
+
+func Test_Queuefka_ReadBatch(t *testing.T) {
+	batchTopic := topic + ".readbatch"
+	os.RemoveAll(batchTopic)
+	defer os.RemoveAll(batchTopic)
+
+	wt, err := queuefka.NewWriter(batchTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	var addresses []uint64
+	for i := 0; i < 10; i++ {
+		addresses = append(addresses, wt.Address())
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.SetCodec(queuefka.Gzip)
+	wt.WriteBatch([][]byte{[]byte("message 10"), []byte("message 11"), []byte("message 12")})
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(batchTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	// the byte limit cuts batches at 3 messages of 9 bytes or 2 of 10, the
+	// last of which are members of a compressed batch
+	next := 0
+	for _, want := range []int{3, 3, 3, 2, 2} {
+		records, err := rd.ReadBatch(4, 27)
+		if err != nil {
+			panic(err)
+		}
+		if len(records) != want {
+			println(next, len(records), want)
+			panic("queuefka: ReadBatch returned the wrong number of records:")
+		}
+		for _, rec := range records {
+			if string(rec.Value) != fmt.Sprintf("message %d", next) {
+				println(next, string(rec.Value))
+				panic("queuefka: ReadBatch returned the wrong record:")
+			}
+			if next < 10 && rec.Address != addresses[next] {
+				println(next, rec.Address, addresses[next])
+				panic("queuefka: ReadBatch returned the wrong address:")
+			}
+			next++
+		}
+	}
+
+	_, err = rd.ReadBatch(4, 0)
+	if err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("queuefka: ReadBatch at the end of the log did not return ErrEndOfLog:")
+	}
+}
+
+func Test_Queuefka_ReadFiltered(t *testing.T) {
+	filterTopic := topic + ".filtered"
+	os.RemoveAll(filterTopic)
+	defer os.RemoveAll(filterTopic)
+
+	wt, err := queuefka.NewWriter(filterTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	for i := 0; i < 20; i++ {
+		key := "odd"
+		if i%2 == 0 {
+			key = "even"
+		}
+		wt.WriteKeyed([]byte(key), []byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(filterTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	even := func(rec queuefka.Record) bool { return string(rec.Key) == "even" }
+	var matched []queuefka.Record
+	for {
+		rec, err := rd.ReadFiltered(even)
+		if err == queuefka.ErrEndOfLog {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		matched = append(matched, rec)
+	}
+
+	// matches are copied out so later reads do not overwrite them
+	if len(matched) != 10 {
+		println(len(matched))
+		panic("queuefka: ReadFiltered returned the wrong number of records:")
+	}
+	for i, rec := range matched {
+		if string(rec.Key) != "even" || string(rec.Value) != fmt.Sprintf("message %d", i*2) {
+			println(i, string(rec.Key), string(rec.Value))
+			panic("queuefka: ReadFiltered returned the wrong record:")
+		}
+	}
+}
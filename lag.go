@@ -0,0 +1,157 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ConsumerLag is how far a consumer is behind the end of a topic, see Lag.
+type ConsumerLag struct {
+	Position      uint64 // address the consumer resumes from
+	HighWatermark uint64 // address just past the last whole message
+	Bytes         uint64 // bytes from Position, or the low watermark, to HighWatermark
+	Records       uint64 // messages still to be read, if Counted
+	Counted       bool   // false if a sealed slab had no .count sidecar
+}
+
+// Lag returns how far a consumer resuming from position, e.g. an address
+// committed to an OffsetStore, is behind the last whole message of topic.
+// Messages deleted by retention are not counted, a position before the low
+// watermark lags from the low watermark.  The messages still to be read are
+// counted from the .count sidecar files of the sealed slabs between the two,
+// reading only the rest of the slab holding position and the active slab,
+// and are left uncounted if any sidecar is missing rather than scanning the
+// slab.  A Writer may keep appending while Lag is called.
+func Lag(topic string, position uint64) (ConsumerLag, error) {
+	lag := ConsumerLag{Position: position}
+	slabs := SlabFiles(topic)
+	if len(slabs) <= 0 {
+		return lag, ErrInvalidTopic
+	}
+	active := slabs[len(slabs)-1]
+	base, err := slabBase(active)
+	if err != nil {
+		return lag, err
+	}
+	end, err := activeEnd(Segment{Base: base, Path: active})
+	if err != nil {
+		return lag, err
+	}
+	lag.HighWatermark = base + uint64(end)
+
+	low, _, err := LowWatermark(topic)
+	if err != nil {
+		return lag, err
+	}
+	if position < low {
+		position = low
+	}
+	if position >= lag.HighWatermark {
+		lag.Counted = true
+		return lag, nil
+	}
+	lag.Bytes = lag.HighWatermark - position
+
+	// the rest of the slab holding position
+	i, _ := findSlab(slabs, position)
+	next := lag.HighWatermark
+	if i < len(slabs)-1 {
+		next, err = slabBase(slabs[i+1])
+		if err != nil {
+			return lag, err
+		}
+	}
+	records, err := countRecords(topic, position, next)
+	if err != nil {
+		return lag, err
+	}
+	if i == len(slabs)-1 {
+		lag.Records, lag.Counted = records, true
+		return lag, nil
+	}
+
+	for _, slab := range slabs[i+1 : len(slabs)-1] {
+		count, err := readSlabCount(slab)
+		if os.IsNotExist(err) {
+			return lag, nil
+		} else if err != nil {
+			return lag, err
+		}
+		records += count
+	}
+	count, err := countRecords(topic, base, lag.HighWatermark)
+	if err != nil {
+		return lag, err
+	}
+	lag.Records, lag.Counted = records+count, true
+	return lag, nil
+}
+
+// countRecords counts the messages of topic in frames starting from address
+// from up to address to
+func countRecords(topic string, from, to uint64) (uint64, error) {
+	rd := &Reader{topic: topic, end: to}
+	defer rd.Close()
+	err := rd.Seek(topic, from)
+
+	var count uint64
+	for err == nil {
+		_, err = rd.Read()
+		if err == nil {
+			count++
+		}
+	}
+	if err != ErrEndOfLog {
+		return count, err
+	}
+	return count, nil
+}
+
+// Lag returns how far group is behind the end of the store's topic from
+// the address it last committed, or from the start of the topic if it has
+// never committed one, see Lag.
+func (s *OffsetStore) Lag(group string) (ConsumerLag, error) {
+	path, err := s.offsetPath(group)
+	if err != nil {
+		return ConsumerLag{}, err
+	}
+	o, err := readOffset(path)
+	if err != nil && err != ErrNoOffset {
+		return ConsumerLag{}, err
+	}
+	return Lag(filepath.Dir(s.dir), o.address)
+}
+
+// GroupLag returns how far group is behind in each partition of topic,
+// ordered by partition, or in topic itself if it is not partitioned, for
+// monitoring to alert on a consumer group falling behind.  A partition the
+// group has never committed an offset for lags from its start.
+func GroupLag(topic, group string) ([]ConsumerLag, error) {
+	n, err := Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	topics := []string{topic}
+	if n > 0 && len(SlabFiles(topic)) == 0 {
+		topics = topics[:0]
+		for i := 0; i < n; i++ {
+			topics = append(topics, PartitionPath(topic, i))
+		}
+	}
+
+	lags := make([]ConsumerLag, 0, len(topics))
+	for _, t := range topics {
+		// not NewOffsetStore, which would create the offsets directory
+		store := &OffsetStore{dir: filepath.Join(t, offsetsDir)}
+		lag, err := store.Lag(group)
+		if err != nil {
+			return nil, err
+		}
+		lags = append(lags, lag)
+	}
+	return lags, nil
+}
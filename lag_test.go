@@ -0,0 +1,152 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Lag(t *testing.T) {
+	lagTopic := topic + ".lag"
+	os.RemoveAll(lagTopic)
+	defer os.RemoveAll(lagTopic)
+
+	wt, err := queuefka.NewWriter(lagTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 60; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+
+	lag, err := queuefka.Lag(lagTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	if !lag.Counted || lag.Records != 60 || lag.Bytes != lag.HighWatermark {
+		println(lag.Counted, lag.Records, lag.Bytes, lag.HighWatermark)
+		panic("queuefka: Lag from the start is not the whole topic:")
+	}
+
+	// a consumer part way through a sealed slab
+	rd, err := queuefka.NewReader(lagTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	var rec queuefka.Record
+	for i := 0; i < 25; i++ {
+		rec, err = rd.ReadRecord()
+		if err != nil {
+			panic(err)
+		}
+	}
+	rd.Close()
+	lag, err = queuefka.Lag(lagTopic, rec.NextAddress)
+	if err != nil {
+		panic(err)
+	}
+	if !lag.Counted || lag.Records != 35 || lag.Bytes != lag.HighWatermark-rec.NextAddress {
+		println(lag.Counted, lag.Records, lag.Bytes)
+		panic("queuefka: Lag miscounted a consumer part way through:")
+	}
+
+	// caught up
+	lag, err = queuefka.Lag(lagTopic, lag.HighWatermark)
+	if err != nil {
+		panic(err)
+	}
+	if !lag.Counted || lag.Records != 0 || lag.Bytes != 0 {
+		println(lag.Records, lag.Bytes)
+		panic("queuefka: Lag found a caught up consumer behind:")
+	}
+
+	// without the sidecars the bytes are still known but not the records
+	counts, _ := filepath.Glob(filepath.Join(lagTopic, "*.count"))
+	for _, path := range counts {
+		os.Remove(path)
+	}
+	lag, err = queuefka.Lag(lagTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	if lag.Counted || lag.Bytes != lag.HighWatermark {
+		println(lag.Counted, lag.Bytes)
+		panic("queuefka: Lag counted records without the .count sidecars:")
+	}
+}
+
+func Test_Queuefka_GroupLag(t *testing.T) {
+	lagTopic := topic + ".grouplag"
+	os.RemoveAll(lagTopic)
+	defer os.RemoveAll(lagTopic)
+
+	err := queuefka.CreatePartitions(lagTopic, 2, 512)
+	if err != nil {
+		panic(err)
+	}
+	pw, err := queuefka.NewPartitionedWriter(lagTopic, nil, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 20; i++ {
+		err = pw.WritePartition(i%2, nil, []byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			panic(err)
+		}
+	}
+	pw.Close()
+
+	// the group has read half of partition 0 and nothing of partition 1
+	rd, err := queuefka.NewReader(queuefka.PartitionPath(lagTopic, 0), 0)
+	if err != nil {
+		panic(err)
+	}
+	var rec queuefka.Record
+	for i := 0; i < 5; i++ {
+		rec, err = rd.ReadRecord()
+		if err != nil {
+			panic(err)
+		}
+	}
+	rd.Close()
+	store, err := queuefka.NewOffsetStore(queuefka.PartitionPath(lagTopic, 0))
+	if err != nil {
+		panic(err)
+	}
+	err = store.Commit("billing", rec.NextAddress)
+	if err != nil {
+		panic(err)
+	}
+
+	lags, err := queuefka.GroupLag(lagTopic, "billing")
+	if err != nil {
+		panic(err)
+	}
+	if len(lags) != 2 || lags[0].Records != 5 || lags[1].Records != 10 || lags[1].Position != 0 {
+		println(len(lags))
+		panic("queuefka: GroupLag miscounted the partitions:")
+	}
+	lag, err := store.Lag("billing")
+	if err != nil {
+		panic(err)
+	}
+	if lag != lags[0] {
+		panic("queuefka: OffsetStore.Lag differs from GroupLag:")
+	}
+	if _, err = os.Stat(filepath.Join(queuefka.PartitionPath(lagTopic, 1), "offsets")); !os.IsNotExist(err) {
+		panic("queuefka: GroupLag created an offsets directory:")
+	}
+	if _, err = queuefka.GroupLag(lagTopic, strings.Repeat("../", 2)); err != queuefka.ErrBadGroup {
+		println(err)
+		panic("queuefka: GroupLag accepted a group outside the store:")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockName is the file in a topic directory locked by its Writer
+const lockName = "writer.lock"
+
+// lockTopic takes the exclusive lock on a topic for a new Writer, recording
+// our pid in the lock file.  If steal is set a lock whose recorded holder is
+// no longer running is broken, which only happens if the lock file was
+// inherited by another process or lives on a filesystem which does not
+// release locks of dead processes.
+func lockTopic(topic string, mode os.FileMode, steal bool) (*os.File, error) {
+	err := os.MkdirAll(topic, dirMode(mode))
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(topic, lockName)
+	fp, err := tryLock(path, mode)
+	if err == ErrTopicLocked && steal && !processAlive(lockHolder(path)) {
+		// a new lock file leaves the stale holder locking an unlinked one
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+		fp, err = tryLock(path, mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = fp.Truncate(0)
+	if err == nil {
+		_, err = fp.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		unlockTopic(fp)
+		return nil, err
+	}
+	return fp, nil
+}
+
+// tryLock opens and locks the lock file at path without waiting
+func tryLock(path string, mode os.FileMode) (*os.File, error) {
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = flock(fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return fp, nil
+}
+
+// lockHolder returns the pid recorded in a lock file, or 0 if unknown
+func lockHolder(path string) int {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// unlockTopic releases a lock taken by lockTopic
+func unlockTopic(fp *os.File) error {
+	if fp == nil {
+		return nil
+	}
+	funlock(fp)
+	return fp.Close()
+}
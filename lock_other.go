@@ -0,0 +1,24 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package queuefka
+
+import "os"
+
+// flock does nothing as topic locks need flock(2)
+func flock(fp *os.File) error {
+	return nil
+}
+
+// funlock does nothing as topic locks need flock(2)
+func funlock(fp *os.File) error {
+	return nil
+}
+
+// processAlive assumes every process is running
+func processAlive(pid int) bool {
+	return true
+}
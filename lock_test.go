@@ -0,0 +1,57 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_TopicLock(t *testing.T) {
+	lockTopic := topic + ".lock"
+	os.RemoveAll(lockTopic)
+	defer os.RemoveAll(lockTopic)
+
+	wt, err := queuefka.NewWriter(lockTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+
+	// a second Writer is refused while the first is open
+	_, err = queuefka.NewWriter(lockTopic, segmentSizeHint)
+	if err != queuefka.ErrTopicLocked {
+		println(err)
+		panic("queuefka: NewWriter opened a locked topic:")
+	}
+
+	// the holder is alive so its lock is not stale
+	_, err = queuefka.NewWriter(lockTopic, segmentSizeHint, queuefka.WithStealLock(true))
+	if err != queuefka.ErrTopicLocked {
+		println(err)
+		panic("queuefka: NewWriter stole a live lock:")
+	}
+
+	// a holder which has gone away can be stolen from
+	err = ioutil.WriteFile(lockTopic+"/writer.lock", []byte("999999999\n"), 0600)
+	if err != nil {
+		panic(err)
+	}
+	stolen, err := queuefka.NewWriter(lockTopic, segmentSizeHint, queuefka.WithStealLock(true))
+	if err != nil {
+		panic(err)
+	}
+	stolen.Close()
+	wt.Close()
+
+	// and Close releases it
+	wt, err = queuefka.NewWriter(lockTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	wt.Close()
+}
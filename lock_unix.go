@@ -0,0 +1,35 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package queuefka
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock takes an exclusive lock on fp or returns ErrTopicLocked
+func flock(fp *os.File) error {
+	err := syscall.Flock(int(fp.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrTopicLocked
+	}
+	return err
+}
+
+// funlock releases a lock taken by flock
+func funlock(fp *os.File) error {
+	return syscall.Flock(int(fp.Fd()), syscall.LOCK_UN)
+}
+
+// processAlive reports whether a process with the given pid exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return true
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Manager keeps the topics of an application in one data directory, each
+// topic a subdirectory, sharing one set of default options between them.
+// It opens at most one Writer per topic, kept open until the topic is closed
+// or deleted, and runs the timed retention and fsyncs of all of them from a
+// single goroutine rather than one or two per Writer.
+type Manager struct {
+	sync.Mutex
+	root         string
+	slabSizeHint uint64
+	opts         []Option
+	writers      map[string]*managed
+	wake         chan struct{} // nudges loop to reschedule
+	stop         chan struct{} // closed to stop loop
+	stopped      sync.WaitGroup
+	closed       bool
+}
+
+// managed is an open Writer of a Manager and when its timers last ran
+type managed struct {
+	wt       *Writer
+	retained time.Time
+	synced   time.Time
+}
+
+// NewManager returns a Manager of the topics in root, creating it if need
+// be.  Writers are opened with slabSizeHint and opts as defaults, which the
+// configuration recorded for a topic overrides, see UpdateConfig, and
+// Readers with opts followed by any given to NewReader.  The Intervals of
+// each topic's RetentionPolicy and SyncPolicy are applied by the Manager.
+func NewManager(root string, slabSizeHint uint64, opts ...Option) (*Manager, error) {
+	o := defaultOptions(opts)
+	err := os.MkdirAll(root, dirMode(o.FileMode))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		root:         root,
+		slabSizeHint: slabSizeHint,
+		opts:         opts,
+		writers:      make(map[string]*managed),
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+	m.stopped.Add(1)
+	go m.loop()
+	return m, nil
+}
+
+// Root returns the data directory of the Manager.
+func (m *Manager) Root() string {
+	return m.root
+}
+
+// topicPath returns the directory of the topic name, which may be nested in
+// namespaces, e.g. orders/eu/created.  A topic cannot be within another
+// topic or be a namespace holding others.
+func (m *Manager) topicPath(name string) (string, error) {
+	path, err := m.namespacePath(name)
+	if err != nil || name == "" {
+		return "", ErrInvalidTopic
+	}
+	for dir := filepath.Dir(path); dir != filepath.Clean(m.root); dir = filepath.Dir(dir) {
+		if isTopic(dir) {
+			return "", ErrInvalidTopic
+		}
+	}
+	if !isTopic(path) {
+		var holds bool
+		walkTopics(path, func(string, string) error {
+			holds = true
+			return nil
+		})
+		if holds {
+			return "", ErrInvalidTopic
+		}
+	}
+	return path, nil
+}
+
+// transient reports whether name is a directory left by DeleteTopic or
+// Import part way through rather than a topic
+func transient(name string) bool {
+	return strings.Contains(name, ".deleted-") || strings.Contains(name, ".import-")
+}
+
+// OpenTopic returns the Writer of the topic name, creating the topic if it
+// does not exist.  Every call for a topic returns the same Writer, which the
+// Manager closes, so it must not be closed by the caller, see CloseTopic.
+func (m *Manager) OpenTopic(name string) (*Writer, error) {
+	path, err := m.topicPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	w, ok := m.writers[name]
+	if ok {
+		return w.wt, nil
+	}
+	wt, err := newWriter(path, m.sizeHint(path), m.opts, []Option{withPooledTimers()})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	m.writers[name] = &managed{wt: wt, retained: now, synced: now}
+	m.nudge()
+	return wt, nil
+}
+
+// sizeHint returns the size hint to open the topic at path with, 0 for the
+// one it records if it overrides the Manager's
+func (m *Manager) sizeHint(path string) uint64 {
+	c, err := ReadTopicConfig(path)
+	if err == nil && c.SegmentSize > 0 && c.overridden("segment_size") {
+		return 0
+	}
+	return m.slabSizeHint
+}
+
+// UpdateConfig changes the configuration recorded for the topic name with
+// update, overriding the Manager's defaults for it, see Writer.UpdateConfig.
+// An open topic applies the change straight away, a topic which is not is
+// opened to apply it, creating it if need be, and closed again.
+func (m *Manager) UpdateConfig(name string, update func(*TopicConfig)) error {
+	path, err := m.topicPath(name)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	w, ok := m.writers[name]
+	if !ok {
+		// opening the topic applies and records the change
+		wt, err := newWriter(path, m.sizeHint(path), m.opts, []Option{withPooledTimers()})
+		if err != nil {
+			return err
+		}
+		err = wt.UpdateConfig(update)
+		cerr := wt.Close()
+		if err != nil {
+			return err
+		}
+		return cerr
+	}
+	err = w.wt.UpdateConfig(update)
+	m.nudge()
+	return err
+}
+
+// NewReader returns a Reader of the topic name from address, with the
+// options of the Manager followed by opts.
+func (m *Manager) NewReader(name string, address uint64, opts ...Option) (*Reader, error) {
+	path, err := m.topicPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(path, address, append(append([]Option{}, m.opts...), opts...)...)
+}
+
+// ReadChunk returns whole frames of the topic name from address, see
+// ReadChunk.
+func (m *Manager) ReadChunk(name string, address uint64, max int) (Chunk, error) {
+	path, err := m.topicPath(name)
+	if err != nil {
+		return Chunk{}, err
+	}
+	return ReadChunk(path, address, max)
+}
+
+// OpenReplica opens the topic name as a replica, see OpenReplica.  The
+// Manager cannot open a Writer of the topic until the Replica is closed.
+func (m *Manager) OpenReplica(name string) (*Replica, error) {
+	path, err := m.topicPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return OpenReplica(path)
+}
+
+// CloseTopic closes the Writer of the topic name if it is open, freeing its
+// file handles until the topic is next opened.
+func (m *Manager) CloseTopic(name string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.closeTopic(name)
+}
+
+// closeTopic closes the Writer of the topic name if it is open, caller must
+// hold the lock
+func (m *Manager) closeTopic(name string) error {
+	w, ok := m.writers[name]
+	if !ok {
+		return nil
+	}
+	delete(m.writers, name)
+	return w.wt.Close()
+}
+
+// DeleteTopic closes the topic name if it is open and removes it, see
+// DeleteTopic.
+func (m *Manager) DeleteTopic(name string) error {
+	path, err := m.topicPath(name)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	err = m.closeTopic(name)
+	if err != nil {
+		return err
+	}
+	return DeleteTopic(path, m.opts...)
+}
+
+// ListTopics returns the names of the topics in the data directory, whether
+// open or not, including those in namespaces, sorted.
+func (m *Manager) ListTopics() ([]string, error) {
+	return m.ListNamespace("")
+}
+
+// Close stops the background goroutine and closes every open Writer,
+// returning the first error.
+func (m *Manager) Close() error {
+	m.Lock()
+	closed := m.closed
+	m.closed = true
+	m.Unlock()
+	if !closed {
+		close(m.stop)
+		m.stopped.Wait()
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	var err error
+	for name := range m.writers {
+		cerr := m.closeTopic(name)
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// nudge wakes loop to reschedule after a Writer was opened or changed,
+// caller must hold the lock
+func (m *Manager) nudge() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop applies retention and fsyncs every open Writer as their policies'
+// Intervals come round until stopped
+func (m *Manager) loop() {
+	defer m.stopped.Done()
+
+	timer := time.NewTimer(m.run(time.Now()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+		timer = time.NewTimer(m.run(time.Now()))
+	}
+}
+
+// managerIdle is how long loop sleeps with no timers due
+const managerIdle = time.Minute
+
+// run applies retention and fsyncs every open Writer whose Interval has
+// passed since they last ran, holding the lock so none is closed meanwhile,
+// and returns how long until the next is due
+func (m *Manager) run(now time.Time) time.Duration {
+	m.Lock()
+	defer m.Unlock()
+
+	next := managerIdle
+	due := func(last *time.Time, interval time.Duration, fn func()) {
+		if interval <= 0 {
+			return
+		}
+		if now.Sub(*last) >= interval {
+			fn()
+			*last = now
+		}
+		if wait := last.Add(interval).Sub(now); wait < next {
+			next = wait
+		}
+	}
+	for _, w := range m.writers {
+		w.wt.Lock()
+		retention, syncEvery := w.wt.retention.Interval, w.wt.syncPolicy.Interval
+		w.wt.Unlock()
+		due(&w.retained, retention, func() { w.wt.ApplyRetention() })
+		due(&w.synced, syncEvery, func() { w.wt.Sync() })
+	}
+	return next
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Manager(t *testing.T) {
+	root := topic + ".manager"
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+
+	m, err := queuefka.NewManager(root, 512, queuefka.WithRetention(queuefka.RetentionPolicy{MaxSegments: 2, Interval: 10 * time.Millisecond}))
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	writers := make(map[string]*queuefka.Writer)
+	for _, name := range []string{"orders", "clicks"} {
+		wt, err := m.OpenTopic(name)
+		if err != nil {
+			panic(err)
+		}
+		writers[name] = wt
+		for i := 0; i < 50; i++ {
+			wt.Write([]byte(fmt.Sprintf("%s %d", name, i)))
+		}
+		wt.Flush()
+	}
+	again, err := m.OpenTopic("orders")
+	if err != nil {
+		panic(err)
+	}
+	orders := filepath.Join(root, "orders")
+	_, err = queuefka.NewWriter(orders, 512)
+	if err != queuefka.ErrTopicLocked {
+		println(err)
+		panic("queuefka: Manager did not keep its Writer open:")
+	}
+	if again != writers["orders"] {
+		panic("queuefka: OpenTopic returned a second Writer:")
+	}
+
+	names, err := m.ListTopics()
+	if err != nil {
+		panic(err)
+	}
+	if len(names) != 2 || names[0] != "clicks" || names[1] != "orders" {
+		println(len(names))
+		panic("queuefka: ListTopics did not list the topics:")
+	}
+	_, err = m.OpenTopic("../escape")
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("queuefka: OpenTopic opened a topic outside the data directory:")
+	}
+
+	// the Manager applies retention to every open Writer
+	deadline := time.Now().Add(5 * time.Second)
+	for len(queuefka.SlabFiles(orders)) > 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(queuefka.SlabFiles(orders)); n > 2 {
+		println(n)
+		panic("queuefka: Manager did not apply retention:")
+	}
+
+	rd, err := m.NewReader("clicks", 0)
+	if err != nil {
+		panic(err)
+	}
+	values := readValues(rd)
+	rd.Close()
+	if len(values) == 0 || values[len(values)-1] != "clicks 49" {
+		println(len(values))
+		panic("queuefka: Manager Reader did not read the topic:")
+	}
+
+	err = m.DeleteTopic("orders")
+	if err != nil {
+		panic(err)
+	}
+	names, _ = m.ListTopics()
+	if len(names) != 1 || names[0] != "clicks" {
+		println(len(names))
+		panic("queuefka: DeleteTopic did not delete an open topic:")
+	}
+}
+
+func Test_Queuefka_ManagerOverrides(t *testing.T) {
+	root := topic + ".overrides"
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+
+	m, err := queuefka.NewManager(root, 512, queuefka.WithRetention(queuefka.RetentionPolicy{MaxSegments: 2, Interval: 10 * time.Millisecond}))
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	// one topic keeps everything, overriding the Manager's retention
+	err = m.UpdateConfig("keep", func(c *queuefka.TopicConfig) {
+		c.Retention = queuefka.RetentionPolicy{}
+	})
+	if err != nil {
+		panic(err)
+	}
+	for _, name := range []string{"keep", "trim"} {
+		wt, err := m.OpenTopic(name)
+		if err != nil {
+			panic(err)
+		}
+		for i := 0; i < 50; i++ {
+			wt.Write([]byte(fmt.Sprintf("%s %d", name, i)))
+		}
+		wt.Flush()
+	}
+
+	trim := filepath.Join(root, "trim")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(queuefka.SlabFiles(trim)) > 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	keep := len(queuefka.SlabFiles(filepath.Join(root, "keep")))
+	if n := len(queuefka.SlabFiles(trim)); n > 2 || keep <= 2 {
+		println(n, keep)
+		panic("queuefka: Manager did not apply per-topic retention:")
+	}
+
+	// a change to an open topic applies straight away
+	err = m.UpdateConfig("keep", func(c *queuefka.TopicConfig) {
+		c.Retention = queuefka.RetentionPolicy{MaxSegments: 1, Interval: 10 * time.Millisecond}
+	})
+	if err != nil {
+		panic(err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for len(queuefka.SlabFiles(filepath.Join(root, "keep"))) > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(queuefka.SlabFiles(filepath.Join(root, "keep"))); n != 1 {
+		println(n)
+		panic("queuefka: UpdateConfig did not change an open topic:")
+	}
+}
+
+func Test_Queuefka_ManagerRestartArchives(t *testing.T) {
+	root := topic + ".restart"
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+
+	m, err := queuefka.NewManager(root, 256, queuefka.WithRetention(queuefka.RetentionPolicy{MaxSegments: 3}))
+	if err != nil {
+		panic(err)
+	}
+	wt, err := m.OpenTopic("orders")
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 100; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	m.Close()
+
+	// the restarted Manager's defaults, Archiver and all, reach the topic
+	archive := memArchiver{}
+	m, err = queuefka.NewManager(root, 256, queuefka.WithRetention(queuefka.RetentionPolicy{MaxSegments: 1, Archiver: archive}))
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+	wt, err = m.OpenTopic("orders")
+	if err != nil {
+		panic(err)
+	}
+	orders := filepath.Join(root, "orders")
+	before := len(queuefka.SlabFiles(orders))
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+	after := len(queuefka.SlabFiles(orders))
+	var archived int
+	for name := range archive {
+		if strings.HasSuffix(name, ".slab") {
+			archived++
+		}
+	}
+	if before <= 1 || after != 1 || archived != before-after {
+		println(before, after, archived)
+		panic("queuefka: Manager defaults did not apply to a topic after a restart:")
+	}
+
+	// only what UpdateConfig changes is recorded as the topic's own
+	c, err := queuefka.ReadTopicConfig(orders)
+	if err != nil {
+		panic(err)
+	}
+	if len(c.Overrides) != 0 {
+		println(len(c.Overrides))
+		panic("queuefka: the Manager's defaults were recorded as overrides:")
+	}
+	err = m.UpdateConfig("orders", func(c *queuefka.TopicConfig) { c.Retention.MaxSegments = 2 })
+	if err != nil {
+		panic(err)
+	}
+	c, err = queuefka.ReadTopicConfig(orders)
+	if err != nil {
+		panic(err)
+	}
+	if len(c.Overrides) != 1 || c.Overrides[0] != "retention.max_segments" || c.Retention.MaxSegments != 2 {
+		println(len(c.Overrides))
+		panic("queuefka: UpdateConfig did not record only what it changed:")
+	}
+}
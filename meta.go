@@ -0,0 +1,100 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+
+	"github.com/vova616/xxhash"
+)
+
+// The size of a slab file need not be its logical size: a preallocated slab
+// is followed by zeros, a compressed one is smaller and a crash may leave a
+// torn frame at the end.  So the base address and logical end of every slab
+// are recorded in a <base>.meta sidecar as it is created, sealed and when the
+// Writer is closed, and a Writer loading the slab takes the logical end from
+// there, only reading the frames appended after it by a Writer which crashed.
+
+// metaSize is base address and logical end (8 bytes each) and an xxhash32 of
+// both
+const metaSize = 20
+
+// slabMeta is what a .meta sidecar records about its slab
+type slabMeta struct {
+	base uint64 // absolute address of the first byte of the slab
+	end  uint64 // offset just past the last frame known to be written
+}
+
+// metaPath returns the sidecar file recording the metadata of a slab e.g.
+// <base>.meta
+func metaPath(slab string) string {
+	return slabStem(slab) + ".meta"
+}
+
+// writeSlabMeta atomically records the metadata of a slab
+func writeSlabMeta(slab string, m slabMeta) error {
+	buf := make([]byte, metaSize)
+	binary.LittleEndian.PutUint64(buf, m.base)
+	binary.LittleEndian.PutUint64(buf[8:], m.end)
+	binary.LittleEndian.PutUint32(buf[16:], xxhash.Checksum32(buf[:16]))
+
+	tmp := metaPath(slab) + ".tmp"
+	err := ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, metaPath(slab))
+}
+
+// readSlabMeta returns the metadata recorded for a slab, falling back to the
+// .end sidecar of a preallocated slab written before .meta sidecars were
+func readSlabMeta(slab string) (slabMeta, error) {
+	base, err := slabBase(slab)
+	if err != nil {
+		return slabMeta{}, err
+	}
+	buf, err := ioutil.ReadFile(metaPath(slab))
+	if os.IsNotExist(err) {
+		end, err := readSlabEnd(slab)
+		return slabMeta{base: base, end: end}, err
+	} else if err != nil {
+		return slabMeta{}, err
+	}
+	if len(buf) != metaSize || binary.LittleEndian.Uint32(buf[16:]) != xxhash.Checksum32(buf[:16]) {
+		return slabMeta{}, ErrBadChecksum
+	}
+	m := slabMeta{base: binary.LittleEndian.Uint64(buf), end: binary.LittleEndian.Uint64(buf[8:])}
+	if m.base != base {
+		// copied from another slab, it describes some other file
+		return slabMeta{}, ErrBadChecksum
+	}
+	return m, nil
+}
+
+// writeMeta records the logical end of the current slab, caller must hold
+// the lock
+func (wt *Writer) writeMeta() error {
+	return writeSlabMeta(wt.slabPath(wt.base), slabMeta{base: wt.base, end: wt.address - wt.base})
+}
+
+// loadEnd returns the logical end of the slab being loaded, which is size
+// bytes long, starting from the end its metadata records, caller must hold
+// the lock
+func (wt *Writer) loadEnd(slab string, hdrLen uint64, size int64) int64 {
+	if sealed(slab, size) {
+		return size
+	}
+	start := hdrLen
+	m, err := readSlabMeta(slab)
+	if err == nil && m.end >= hdrLen && m.end <= uint64(size) {
+		start = m.end
+	}
+
+	// frames may have been appended after the recorded end if the Writer
+	// crashed, so read on from there
+	return wt.scanEnd(start)
+}
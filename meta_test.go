@@ -0,0 +1,79 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_SlabMeta(t *testing.T) {
+	metaTopic := topic + ".meta"
+	os.RemoveAll(metaTopic)
+	defer os.RemoveAll(metaTopic)
+
+	wt, err := queuefka.NewWriter(metaTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 40; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+
+	// every slab records its base and logical end
+	for _, slab := range queuefka.SlabFiles(metaTopic) {
+		buf, err := ioutil.ReadFile(strings.TrimSuffix(slab, ".slab") + ".meta")
+		if err != nil {
+			panic(err)
+		}
+		stat, _ := os.Stat(slab)
+		if len(buf) != 20 || binary.LittleEndian.Uint64(buf[8:]) != uint64(stat.Size()) {
+			println(slab, len(buf), stat.Size())
+			panic("queuefka: slab metadata does not match its slab:")
+		}
+	}
+
+	// frames appended after the recorded end by a Writer which crashed are
+	// still found
+	wt, err = queuefka.NewWriter(metaTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	wt.Write([]byte("before crash"))
+	wt.Flush()
+	end := wt.Address()
+	err = ioutil.WriteFile(metaTopic+"/writer.lock", []byte("999999999\n"), 0600)
+	if err != nil {
+		panic(err)
+	}
+	stolen, err := queuefka.NewWriter(metaTopic, 512, queuefka.WithStealLock(true))
+	if err != nil {
+		panic(err)
+	}
+	if stolen.Address() != end {
+		println(stolen.Address(), end)
+		panic("queuefka: NewWriter lost frames after the recorded end:")
+	}
+	stolen.Write([]byte("after crash"))
+	stolen.Close()
+
+	rd, err := queuefka.NewReader(metaTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 42 || values[40] != "before crash" || values[41] != "after crash" {
+		println(len(values))
+		panic("queuefka: messages around a crash were not readable:")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import "os"
+
+// mmapFile writes to a slab through a shared memory map of the whole file.
+// The file is extended with zeros ahead of the data so the map can grow in
+// steps of at least grow bytes rather than with every Write.
+type mmapFile struct {
+	fp   *os.File
+	data []byte // the mapped file
+	off  int64  // offset of the next byte to write
+	grow int64  // minimum amount to extend the file by
+}
+
+// openMmap maps fp for appending at offset end, extending it by at least
+// grow bytes of zeros
+func openMmap(fp *os.File, end, grow int64) (*mmapFile, error) {
+	mf := &mmapFile{fp: fp, off: end, grow: grow}
+	err := mf.remap(end)
+	if err != nil {
+		return nil, err
+	}
+	return mf, nil
+}
+
+// Write copies p into the map, growing it if necessary
+func (mf *mmapFile) Write(p []byte) (int, error) {
+	if mf.off+int64(len(p)) > int64(len(mf.data)) {
+		err := mf.remap(mf.off + int64(len(p)))
+		if err != nil {
+			return 0, err
+		}
+	}
+	copy(mf.data[mf.off:], p)
+	mf.off += int64(len(p))
+	return len(p), nil
+}
+
+// remap replaces the map with one at least grow bytes larger than size
+func (mf *mmapFile) remap(size int64) error {
+	err := mf.close()
+	if err != nil {
+		return err
+	}
+
+	size += mf.grow
+	size = (size + int64(os.Getpagesize()) - 1) &^ int64(os.Getpagesize()-1)
+	err = extend(mf.fp, size)
+	if err != nil {
+		return err
+	}
+	mf.data, err = mmap(mf.fp, int(size))
+	return err
+}
+
+// sync flushes dirty pages of the map to disk
+func (mf *mmapFile) sync() error {
+	if mf.data == nil {
+		return nil
+	}
+	return msync(mf.data)
+}
+
+// close unmaps the file, leaving it open
+func (mf *mmapFile) close() error {
+	if mf.data == nil {
+		return nil
+	}
+	err := munmap(mf.data)
+	mf.data = nil
+	return err
+}
+
+// unmap drops the map of the current slab if there is one, caller must hold
+// the lock
+func (wt *Writer) unmap() {
+	if wt.mm != nil {
+		wt.mm.close()
+		wt.mm = nil
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package queuefka
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mmap maps the first size bytes of fp shared and writable
+func mmap(fp *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(fp.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+// mmapReadOnly maps the first size bytes of fp shared and read only
+func mmapReadOnly(fp *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(fp.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap releases a map returned by mmap
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
+
+// msync writes the dirty pages of a map to disk and waits for them
+func msync(data []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package queuefka
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoMmap is returned when a memory mapped Writer is unsupported
+var errNoMmap = errors.New("queuefka: memory mapped slabs are not supported on this platform")
+
+// mmap always fails as memory mapped slabs are Linux only
+func mmap(fp *os.File, size int) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+// mmapReadOnly always fails as memory mapped slabs are Linux only
+func mmapReadOnly(fp *os.File, size int) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+// munmap always fails as memory mapped slabs are Linux only
+func munmap(data []byte) error {
+	return errNoMmap
+}
+
+// msync always fails as memory mapped slabs are Linux only
+func msync(data []byte) error {
+	return errNoMmap
+}
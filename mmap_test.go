@@ -0,0 +1,69 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Mmap(t *testing.T) {
+	mmapTopic := topic + ".mmap"
+	os.RemoveAll(mmapTopic)
+	defer os.RemoveAll(mmapTopic)
+
+	wt, err := queuefka.NewWriter(mmapTopic, 4096, queuefka.WithMmap(true))
+	if err != nil {
+		t.Skip("memory mapped slabs unavailable:", err)
+	}
+
+	// a message bigger than the map grows it, others roll the slab
+	big := bytes.Repeat(value, 1000)
+	wt.Write(big)
+	for i := 0; i < 500; i++ {
+		wt.Write(value)
+	}
+	if err := wt.Sync(); err != nil {
+		panic(err)
+	}
+	if n := readAll(mmapTopic); n != 501 {
+		println(n)
+		panic("queuefka: Mmap read the wrong number of messages:")
+	}
+
+	// reopening carries on from the logical end
+	address := wt.Address()
+	wt.Close()
+	wt, err = queuefka.NewWriter(mmapTopic, 4096, queuefka.WithMmap(true))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	if wt.Address() != address {
+		println(wt.Address(), address)
+		panic("queuefka: Mmap lost the end after Close:")
+	}
+	wt.Write(value)
+	wt.Flush()
+	if n := readAll(mmapTopic); n != 502 {
+		println(n)
+		panic("queuefka: Mmap lost messages after reopening:")
+	}
+}
+
+func Benchmark_Queuefka_Write_Mmap(b *testing.B) {
+	benchTopic := topic + ".bench"
+	os.RemoveAll(benchTopic)
+	defer os.RemoveAll(benchTopic)
+
+	wt, _ := queuefka.NewWriter(benchTopic, segmentSizeHint, queuefka.WithMmap(true))
+	for i := 0; i < b.N; i++ {
+		wt.Write(value)
+	}
+	wt.Close()
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"io"
+)
+
+// SetMmap makes the Reader memory map each sealed slab it reads and decode
+// frames straight out of the map, instead of copying them through its bufio
+// buffer, which speeds up replaying whole segments.  Only sealed slabs are
+// mapped as their size is final, the active slab and any slab which cannot
+// be mapped, e.g. on platforms other than Linux, is read as usual.  Takes
+// effect from the next slab.
+func (rd *Reader) SetMmap(enabled bool) {
+	rd.mmapRead = enabled
+	if !enabled && !rd.inflated {
+		rd.unmapSlab()
+	}
+}
+
+// mapSlab maps the current slab of size bytes if mmap reads are enabled and
+// it is sealed, otherwise the slab is read through bufio
+func (rd *Reader) mapSlab(size int64) {
+	rd.unmapSlab()
+	if !rd.mmapRead || size <= 0 || !rd.sealed() {
+		return
+	}
+	data, err := mmapReadOnly(rd.fp, int(size))
+	if err != nil {
+		return
+	}
+	rd.mapped, rd.moff = data, int(rd.address-rd.base)
+}
+
+// unmapSlab releases any map of the current slab
+func (rd *Reader) unmapSlab() {
+	if rd.mapped != nil && !rd.inflated {
+		munmap(rd.mapped)
+	}
+	rd.mapped, rd.inflated = nil, false
+}
+
+// slabSize returns the size of the current slab, which is final once mapped
+func (rd *Reader) slabSize() (int64, error) {
+	if rd.mapped != nil {
+		return int64(len(rd.mapped)), nil
+	}
+	stat, err := rd.fp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// readAt reads len(buf) bytes of the current slab from off, which may be
+// anywhere in the slab
+func (rd *Reader) readAt(buf []byte, off int64) (int, error) {
+	if rd.mapped == nil {
+		return rd.fp.ReadAt(buf, off)
+	}
+	if off >= int64(len(rd.mapped)) {
+		return 0, io.EOF
+	}
+	n := copy(buf, rd.mapped[off:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// peek returns the next n bytes of the slab without consuming them
+func (rd *Reader) peek(n int) ([]byte, error) {
+	if rd.mapped == nil {
+		return rd.rd.Peek(n)
+	}
+	if rd.moff+n > len(rd.mapped) {
+		return rd.mapped[rd.moff:], io.EOF
+	}
+	return rd.mapped[rd.moff : rd.moff+n], nil
+}
+
+// discard skips the next n bytes of the slab
+func (rd *Reader) discard(n int) (int, error) {
+	if rd.mapped == nil {
+		return rd.rd.Discard(n)
+	}
+	if rd.moff+n > len(rd.mapped) {
+		n = len(rd.mapped) - rd.moff
+		rd.moff += n
+		return n, io.EOF
+	}
+	rd.moff += n
+	return n, nil
+}
+
+// readFull fills buf with the next bytes of the slab
+func (rd *Reader) readFull(buf []byte) error {
+	if rd.mapped == nil {
+		_, err := io.ReadFull(rd.rd, buf)
+		return err
+	}
+	if rd.moff+len(buf) > len(rd.mapped) {
+		return io.ErrUnexpectedEOF
+	}
+	rd.moff += copy(buf, rd.mapped[rd.moff:])
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_MmapRead(t *testing.T) {
+	mapTopic := topic + ".mmapread"
+	os.RemoveAll(mapTopic)
+	defer os.RemoveAll(mapTopic)
+
+	// small slabs so most of them are sealed and mapped
+	wt, err := queuefka.NewWriter(mapTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	for i := 0; i < 100; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.SetCodec(queuefka.Gzip)
+	wt.WriteBatch([][]byte{[]byte("message 100"), []byte("message 101")})
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(mapTopic, 0, queuefka.WithMmapRead(true))
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	// ReadInto leaves a message too large for its buffer in the map
+	buf := make([]byte, 64)
+	n, err := rd.ReadInto(buf[:4])
+	if err == nil {
+		panic("queuefka: mapped ReadInto did not report a short buffer:")
+	}
+	for i := 0; i < 102; i++ {
+		n, err = rd.ReadInto(buf)
+		if err != nil {
+			panic(err)
+		}
+		if string(buf[:n]) != fmt.Sprintf("message %d", i) {
+			println(i, string(buf[:n]))
+			panic("queuefka: mapped Reader returned the wrong message:")
+		}
+	}
+	_, err = rd.Read()
+	if err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("queuefka: mapped Reader did not stop at the end of the log:")
+	}
+}
+
+func Benchmark_Queuefka_Read_Mmap(b *testing.B) {
+	rd, _ := queuefka.NewReader(topic, 0x0000, queuefka.WithMmapRead(true))
+	for i := 0; i < b.N; i++ {
+		_, err := rd.Read()
+		if err != nil {
+			if err == queuefka.ErrEndOfLog {
+				println("Not enough data in queuefka log to test fully benchmark Read()")
+				break
+			}
+			panic(err)
+		}
+	}
+	rd.Close()
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// namespacePath returns the directory of the namespace ns, its elements
+// separated by slashes, or the data directory itself for ""
+func (m *Manager) namespacePath(ns string) (string, error) {
+	if ns == "" {
+		return m.root, nil
+	}
+	for _, elem := range strings.Split(ns, "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, "\\\x00") || transient(elem) {
+			return "", ErrInvalidTopic
+		}
+	}
+	return filepath.Join(m.root, filepath.FromSlash(ns)), nil
+}
+
+// ListNamespace returns the names of the topics within the namespace ns,
+// e.g. orders/eu/created within orders, sorted.
+func (m *Manager) ListNamespace(ns string) ([]string, error) {
+	dir, err := m.namespacePath(ns)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ns
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var names []string
+	err = walkTopics(dir, func(name, path string) error {
+		names = append(names, prefix+name)
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// DeleteNamespace closes and deletes every topic within the namespace ns,
+// see DeleteTopic, then removes its directories if nothing else is left in
+// them.  Topics deleted before an error are not restored.
+func (m *Manager) DeleteNamespace(ns string) error {
+	dir, err := m.namespacePath(ns)
+	if err != nil || ns == "" {
+		return ErrInvalidTopic
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	err = m.eachTopic(ns, dir, func(name, path string) error {
+		err := m.closeTopic(name)
+		if err != nil {
+			return err
+		}
+		return DeleteTopic(path, m.opts...)
+	})
+	if err != nil {
+		return err
+	}
+	return removeEmpty(dir)
+}
+
+// ApplyRetention applies the retention policy of every topic within the
+// namespace ns, or the whole data directory for "", see
+// Writer.ApplyRetention.  Topics which are not open are opened to apply it
+// and closed again, as is each partition of a partitioned topic.  Every
+// topic is tried, the first error is returned.
+func (m *Manager) ApplyRetention(ns string) error {
+	dir, err := m.namespacePath(ns)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	var first error
+	err = m.eachTopic(ns, dir, func(name, path string) error {
+		var err error
+		if w, ok := m.writers[name]; ok {
+			err = w.wt.ApplyRetention()
+		} else if n, _ := Partitions(path); n > 0 && len(SlabFiles(path)) == 0 {
+			for i := 0; i < n; i++ {
+				perr := m.retain(PartitionPath(path, i))
+				if err == nil {
+					err = perr
+				}
+			}
+		} else {
+			err = m.retain(path)
+		}
+		if first == nil {
+			first = err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return first
+}
+
+// retain opens the topic in path just to apply its retention policy
+func (m *Manager) retain(path string) error {
+	wt, err := newWriter(path, m.slabSizeHint, m.opts, []Option{withPooledTimers()})
+	if err != nil {
+		return err
+	}
+	err = wt.ApplyRetention()
+	cerr := wt.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// eachTopic calls fn with the name and path of every topic within the
+// namespace ns in dir, caller must hold the lock
+func (m *Manager) eachTopic(ns, dir string, fn func(name, path string) error) error {
+	prefix := ns
+	if prefix != "" {
+		prefix += "/"
+	}
+	return walkTopics(dir, func(name, path string) error {
+		return fn(prefix+name, path)
+	})
+}
+
+// removeEmpty removes dir and the directories within it, deepest first,
+// leaving any which still hold something
+func removeEmpty(dir string) error {
+	infos, err := readDirInfos(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	left := 0
+	for _, info := range infos {
+		left++
+		if !info.IsDir() {
+			continue
+		}
+		sub := filepath.Join(dir, info.Name())
+		err = removeEmpty(sub)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stat(sub)
+		if os.IsNotExist(err) {
+			left--
+		}
+	}
+	if left > 0 {
+		return nil
+	}
+	return os.Remove(dir)
+}
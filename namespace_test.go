@@ -0,0 +1,97 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Namespaces(t *testing.T) {
+	root := topic + ".namespaces"
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+
+	m, err := queuefka.NewManager(root, 512, queuefka.WithRetention(queuefka.RetentionPolicy{MaxSegments: 1}))
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	names := []string{"orders/eu/created", "orders/eu/shipped", "orders/us/created", "clicks"}
+	for _, name := range names {
+		wt, err := m.OpenTopic(name)
+		if err != nil {
+			panic(err)
+		}
+		for i := 0; i < 40; i++ {
+			wt.Write([]byte(fmt.Sprintf("%s %d", name, i)))
+		}
+	}
+	m.CloseTopic("orders/us/created")
+
+	// a topic can neither hold nor be inside another
+	for _, name := range []string{"orders", "orders/eu", "clicks/more", "orders//eu", "orders/../clicks"} {
+		_, err = m.OpenTopic(name)
+		if err != queuefka.ErrInvalidTopic {
+			println(name, err)
+			panic("queuefka: OpenTopic opened a namespace as a topic:")
+		}
+	}
+
+	listed, err := m.ListTopics()
+	if err != nil {
+		panic(err)
+	}
+	if strings.Join(listed, ",") != "clicks,orders/eu/created,orders/eu/shipped,orders/us/created" {
+		println(strings.Join(listed, ","))
+		panic("queuefka: ListTopics did not list nested topics:")
+	}
+	listed, err = m.ListNamespace("orders/eu")
+	if err != nil {
+		panic(err)
+	}
+	if strings.Join(listed, ",") != "orders/eu/created,orders/eu/shipped" {
+		println(strings.Join(listed, ","))
+		panic("queuefka: ListNamespace did not list the namespace:")
+	}
+	infos, err := queuefka.ListTopics(root)
+	if err != nil {
+		panic(err)
+	}
+	if len(infos) != 4 || infos[3].Name != "orders/us/created" {
+		println(len(infos))
+		panic("queuefka: ListTopics did not find nested topics:")
+	}
+
+	// retention applies to the open and closed topics of a namespace only
+	err = m.ApplyRetention("orders")
+	if err != nil {
+		panic(err)
+	}
+	for _, name := range names {
+		n := len(queuefka.SlabFiles(filepath.Join(root, filepath.FromSlash(name))))
+		if (n == 1) != strings.HasPrefix(name, "orders/") {
+			println(name, n)
+			panic("queuefka: ApplyRetention did not apply to the namespace:")
+		}
+	}
+
+	err = m.DeleteNamespace("orders/eu")
+	if err != nil {
+		panic(err)
+	}
+	listed, _ = m.ListTopics()
+	_, err = os.Stat(filepath.Join(root, "orders", "eu"))
+	if strings.Join(listed, ",") != "clicks,orders/us/created" || !os.IsNotExist(err) {
+		println(strings.Join(listed, ","), err)
+		panic("queuefka: DeleteNamespace did not delete the namespace:")
+	}
+}
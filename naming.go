@@ -0,0 +1,134 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SlabNaming says how the slab files of a topic are named, the base address
+// zero padded to Width digits followed by Extension e.g. 00000000000000000000.slab
+// by default.  The naming is chosen when a topic is created and recorded in
+// a slab.naming file in the topic directory unless it is the default, so
+// Readers and later Writers find the slabs without being told.
+type SlabNaming struct {
+	Width     int    // digits the base address is zero padded to, at most 20
+	Extension string // follows the base address, may be empty
+}
+
+// defaultSlabNaming is how slabs were always named before SlabNaming
+var defaultSlabNaming = SlabNaming{Width: 20, Extension: ".slab"}
+
+// sidecarExtensions are the files kept alongside a slab e.g. <base>.count,
+// which a slab extension must not clash with
+var sidecarExtensions = []string{".count", ".index", ".timeindex", ".producers", ".end", ".footer", ".meta", ".holes"}
+
+// valid reports whether slabs named by n can be told apart from every other
+// file in a topic directory
+func (n SlabNaming) valid() bool {
+	if n.Width < 1 || n.Width > 20 {
+		return false
+	}
+	if strings.ContainsAny(n.Extension, "/\\\n") {
+		return false
+	}
+	if n.Extension != "" && n.Extension[0] >= '0' && n.Extension[0] <= '9' {
+		return false
+	}
+	for _, ext := range sidecarExtensions {
+		if n.Extension == ext {
+			return false
+		}
+	}
+	return true
+}
+
+// name returns the file name of the slab starting at base
+func (n SlabNaming) name(base uint64) string {
+	return fmt.Sprintf("%0*d%s", n.Width, base, n.Extension)
+}
+
+// parse returns the base address of a slab named name, false if some other
+// file is named name
+func (n SlabNaming) parse(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, n.Extension) {
+		return 0, false
+	}
+	digits := strings.TrimSuffix(name, n.Extension)
+	if digits == "" || digits != leadingDigits(digits) {
+		return 0, false
+	}
+	base, err := strconv.ParseUint(digits, 10, 64)
+	return base, err == nil
+}
+
+// leadingDigits returns the decimal digits name starts with
+func leadingDigits(name string) string {
+	i := 0
+	for i < len(name) && name[i] >= '0' && name[i] <= '9' {
+		i++
+	}
+	return name[:i]
+}
+
+// namingPath returns the file recording the slab naming of a topic
+func namingPath(topic string) string {
+	return filepath.Join(topic, "slab.naming")
+}
+
+// writeNaming atomically records the slab naming of a new topic
+func writeNaming(topic string, n SlabNaming) error {
+	tmp := namingPath(topic) + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d\n%s\n", n.Width, n.Extension)), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, namingPath(topic))
+}
+
+// topicNaming returns the slab naming of topic, the default if none is
+// recorded or it cannot be read
+func topicNaming(topic string) SlabNaming {
+	buf, err := ioutil.ReadFile(namingPath(topic))
+	if err != nil {
+		return defaultSlabNaming
+	}
+	lines := strings.Split(string(buf), "\n")
+	if len(lines) != 3 || lines[2] != "" {
+		return defaultSlabNaming
+	}
+	width, err := strconv.Atoi(lines[0])
+	n := SlabNaming{Width: width, Extension: lines[1]}
+	if err != nil || !n.valid() {
+		return defaultSlabNaming
+	}
+	return n
+}
+
+// createNaming records the slab naming of a topic being created, the
+// default if naming is the zero SlabNaming, caller must hold the lock
+func (wt *Writer) createNaming(naming SlabNaming) error {
+	if naming == (SlabNaming{}) {
+		naming = defaultSlabNaming
+	}
+	if !naming.valid() {
+		return ErrBadNaming
+	}
+	wt.naming = naming
+
+	if naming == defaultSlabNaming {
+		err := os.Remove(namingPath(wt.topic))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeNaming(wt.topic, naming)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_SlabNaming(t *testing.T) {
+	namingTopic := topic + ".naming"
+	os.RemoveAll(namingTopic)
+	defer os.RemoveAll(namingTopic)
+
+	_, err := queuefka.NewWriter(namingTopic, 256, queuefka.WithSlabNaming(queuefka.SlabNaming{Width: 8, Extension: ".count"}))
+	if err != queuefka.ErrBadNaming {
+		println(err)
+		panic("queuefka: NewWriter accepted a slab extension clashing with a sidecar file:")
+	}
+
+	naming := queuefka.WithSlabNaming(queuefka.SlabNaming{Width: 12, Extension: ".log"})
+	wt, err := queuefka.NewWriter(namingTopic, 256, naming)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 50; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+
+	// files which merely look like slabs are left alone
+	for _, name := range []string{"README.log", "12ab.log", "000000000001.slab"} {
+		ioutil.WriteFile(filepath.Join(namingTopic, name), []byte("not a slab"), 0600)
+	}
+
+	slabs := queuefka.SlabFiles(namingTopic)
+	if len(slabs) < 2 {
+		println(len(slabs))
+		panic("queuefka: SlabFiles did not find the slabs of a renamed topic:")
+	}
+	for _, slab := range slabs {
+		name := filepath.Base(slab)
+		if len(name) != len("000000000000.log") || !strings.HasSuffix(name, ".log") {
+			println(name)
+			panic("queuefka: slab was not named as configured:")
+		}
+	}
+
+	// a Writer reopening the topic keeps its naming without being told
+	wt, err = queuefka.NewWriter(namingTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	for i := 50; i < 100; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+	for _, slab := range queuefka.SlabFiles(namingTopic) {
+		if !strings.HasSuffix(slab, ".log") {
+			println(slab)
+			panic("queuefka: reopened topic lost its slab naming:")
+		}
+	}
+
+	rd, err := queuefka.NewReader(namingTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 100 || values[99] != "message 99" {
+		println(len(values))
+		panic("queuefka: Reader did not read every slab of a renamed topic:")
+	}
+	count, err := queuefka.CountMessages(namingTopic)
+	if err != nil || count != 100 {
+		println(count, err)
+		panic("queuefka: CountMessages did not count a renamed topic:")
+	}
+}
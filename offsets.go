@@ -0,0 +1,154 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vova616/xxhash"
+)
+
+// offsetsDir is the directory in a topic holding its committed offsets
+const offsetsDir = "offsets"
+
+// offsetExt names the file of each consumer group in offsetsDir
+const offsetExt = ".offset"
+
+// offsetSize is address (8 bytes) + epoch (8 bytes) + xxhash32 (4 bytes)
+const offsetSize = 20
+
+// legacyOffsetSize is an offset written before epochs, address (8 bytes) +
+// xxhash32 (4 bytes)
+const legacyOffsetSize = 12
+
+// OffsetStore records how far each consumer group has got through a topic,
+// in an offsets directory inside the topic with a file per group, so
+// consumers can resume where they left off after a restart.  Each Commit
+// replaces the group's file atomically and fsyncs it, so a crash leaves
+// either the old address or the new one, and several processes may share
+// a store.
+type OffsetStore struct {
+	dir string
+}
+
+// NewOffsetStore returns the OffsetStore of topic, which may be a partition
+// of a partitioned topic.
+func NewOffsetStore(topic string) (*OffsetStore, error) {
+	dir := filepath.Join(topic, offsetsDir)
+	err := os.MkdirAll(dir, dirMode(0600))
+	if err != nil {
+		return nil, err
+	}
+	return &OffsetStore{dir: dir}, nil
+}
+
+// offsetPath returns the file recording the offset of group
+func (s *OffsetStore) offsetPath(group string) (string, error) {
+	if !validGroup(group) {
+		return "", ErrBadGroup
+	}
+	return filepath.Join(s.dir, group+offsetExt), nil
+}
+
+// validGroup reports whether name may name a consumer group or member
+func validGroup(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// Commit records that group has processed the topic up to address, the
+// address to resume reading from, e.g. a Record's NextAddress.  It is
+// CommitEpoch at epoch 0, so fails with ErrFenced once the group is fenced.
+func (s *OffsetStore) Commit(group string, address uint64) error {
+	return s.CommitEpoch(group, 0, address)
+}
+
+// Fetch returns the address group last committed, or ErrNoOffset if it has
+// never committed one.
+func (s *OffsetStore) Fetch(group string) (uint64, error) {
+	address, _, err := s.FetchEpoch(group)
+	return address, err
+}
+
+// offset is what a group last committed
+type offset struct {
+	address uint64
+	epoch   uint64
+}
+
+// readOffset returns the offset recorded in path
+func readOffset(path string) (offset, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return offset{}, ErrNoOffset
+	} else if err != nil {
+		return offset{}, err
+	}
+	n := len(buf) - 4
+	if (len(buf) != offsetSize && len(buf) != legacyOffsetSize) || binary.LittleEndian.Uint32(buf[n:]) != xxhash.Checksum32(buf[:n]) {
+		return offset{}, ErrBadChecksum
+	}
+	o := offset{address: binary.LittleEndian.Uint64(buf)}
+	if len(buf) == offsetSize {
+		o.epoch = binary.LittleEndian.Uint64(buf[8:])
+	}
+	return o, nil
+}
+
+// writeOffset atomically replaces the offset recorded in path
+func (s *OffsetStore) writeOffset(path string, o offset) error {
+	buf := make([]byte, offsetSize)
+	binary.LittleEndian.PutUint64(buf, o.address)
+	binary.LittleEndian.PutUint64(buf[8:], o.epoch)
+	binary.LittleEndian.PutUint32(buf[16:], xxhash.Checksum32(buf[:16]))
+
+	// a tmp file of its own so concurrent commits cannot interleave
+	tmp := fmt.Sprintf("%s.tmp-%d-%d", path, os.Getpid(), time.Now().UnixNano())
+	err := writeFileSync(tmp, buf, 0600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// Groups returns the consumer groups which have committed an offset, sorted.
+func (s *OffsetStore) Groups() ([]string, error) {
+	infos, err := readDirInfos(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), offsetExt) {
+			groups = append(groups, strings.TrimSuffix(info.Name(), offsetExt))
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// Delete forgets the offset of group, which then starts again from wherever
+// it chooses.
+func (s *OffsetStore) Delete(group string) error {
+	path, err := s.offsetPath(group)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(s.dir)
+}
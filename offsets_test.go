@@ -0,0 +1,113 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_OffsetStore(t *testing.T) {
+	offsetTopic := topic + ".offsets"
+	os.RemoveAll(offsetTopic)
+	defer os.RemoveAll(offsetTopic)
+
+	wt, err := queuefka.NewWriter(offsetTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 20; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+
+	store, err := queuefka.NewOffsetStore(offsetTopic)
+	if err != nil {
+		panic(err)
+	}
+	_, err = store.Fetch("billing")
+	if err != queuefka.ErrNoOffset {
+		println(err)
+		panic("queuefka: Fetch found an offset never committed:")
+	}
+	if store.Commit("../billing", 0) != queuefka.ErrBadGroup {
+		panic("queuefka: Commit accepted a group outside the store:")
+	}
+
+	// consume half the topic and commit where to resume
+	rd, err := queuefka.NewReader(offsetTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	var rec queuefka.Record
+	for i := 0; i < 10; i++ {
+		rec, err = rd.ReadRecord()
+		if err != nil {
+			panic(err)
+		}
+	}
+	rd.Close()
+	err = store.Commit("billing", rec.NextAddress)
+	if err == nil {
+		err = store.Commit("audit", 0)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	// a new store, as after a restart, resumes from the committed address
+	store, err = queuefka.NewOffsetStore(offsetTopic)
+	if err != nil {
+		panic(err)
+	}
+	address, err := store.Fetch("billing")
+	if err != nil {
+		panic(err)
+	}
+	rd, err = queuefka.NewReader(offsetTopic, address)
+	if err != nil {
+		panic(err)
+	}
+	values := readValues(rd)
+	rd.Close()
+	if len(values) != 10 || values[0] != "message 10" {
+		println(len(values))
+		panic("queuefka: Fetch did not return the committed address:")
+	}
+
+	groups, err := store.Groups()
+	if err != nil {
+		panic(err)
+	}
+	if len(groups) != 2 || groups[0] != "audit" || groups[1] != "billing" {
+		println(len(groups))
+		panic("queuefka: Groups did not list the committed groups:")
+	}
+	err = store.Delete("audit")
+	if err != nil {
+		panic(err)
+	}
+	_, err = store.Fetch("audit")
+	if err != queuefka.ErrNoOffset {
+		println(err)
+		panic("queuefka: Delete did not forget the offset:")
+	}
+
+	// a damaged offset file is detected
+	path := filepath.Join(offsetTopic, "offsets", "billing.offset")
+	buf, _ := ioutil.ReadFile(path)
+	buf[0] ^= 0xff
+	ioutil.WriteFile(path, buf, 0600)
+	_, err = store.Fetch("billing")
+	if err != queuefka.ErrBadChecksum {
+		println(err)
+		panic("queuefka: Fetch returned a damaged offset:")
+	}
+}
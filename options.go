@@ -0,0 +1,265 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+	"time"
+)
+
+// Options configures a Writer or Reader.  Settings which do not apply to one
+// or the other are ignored, so the same options may be passed to both.
+type Options struct {
+	Format          uint8       // Writer: on disk format for new slabs
+	VarintLength    bool        // Writer: compact frames, see SetVarintLength
+	Codec           Codec       // Writer: compress WriteBatch, see SetCodec
+	SyncPolicy      SyncPolicy  // Writer: when to fsync, see SetSyncPolicy
+	GroupCommit     bool        // Writer: see SetGroupCommit
+	BufferSize      int         // Writer: bufio buffer size, 0 for the default
+	FileMode        os.FileMode // Writer: permissions of new slab files
+	Preallocate     bool        // Writer: see WithPreallocate
+	DirectIO        bool        // Writer: see WithDirectIO
+	Mmap            bool        // Writer: see WithMmap
+	StealLock       bool        // Writer: see WithStealLock
+	IndexInterval   int         // Writer: see WithIndexInterval
+	SlabNaming      SlabNaming  // Writer: see WithSlabNaming
+	SegmentCodec    Codec       // Writer: see WithSegmentCompression
+	QueueLength     int         // AsyncWriter, Subscribe, Prefetcher: messages queued
+	InFlightBytes   int64       // AsyncWriter: bytes queued before Write blocks, 0 for no limit
+	RejectWhenFull  bool        // AsyncWriter: Write returns ErrBackpressure instead of blocking
+	ErrorHandler    func(error) // AsyncWriter, Subscribe: called with each error
+	MaxRecordSize   uint32      // both: see SetMaxRecordSize
+	RateLimit       RateLimit   // Reader: see SetRateLimit
+	EndAddress      uint64      // Reader: see SetEndAddress
+	Quota           RateLimit   // Writer: see SetQuota
+	RejectOverQuota bool        // Writer: see SetQuota
+	ReadCommitted   bool        // Reader: see SetReadCommitted
+	Follow          bool        // Reader: see SetFollow
+	ReadBufferSize  int         // Reader: see SetBufferSize
+	ReadAhead       bool        // Reader: see SetReadAhead
+	MmapRead        bool        // Reader: see Reader.SetMmap
+	SkipCorrupt     CorruptFunc // Reader: see SetSkipCorrupt
+	Fetcher         Fetcher     // Reader: see SetTieredStorage
+	TierCache       string      // Reader: see SetTieredStorage
+
+	MaxSegmentAge time.Duration   // Writer: see WithMaxSegmentAge
+	Retention     RetentionPolicy // Writer: see SetRetention
+
+	SessionTimeout time.Duration // GroupConsumer: see WithSessionTimeout
+
+	pooled bool // Writer: a Manager runs the sync and retention timers
+}
+
+// Option sets a field of Options, pass any number to NewWriter or NewReader.
+type Option func(*Options)
+
+// defaultOptions returns Options with every option applied
+func defaultOptions(opts []Option) Options {
+	o := Options{Format: FormatLatest, FileMode: 0600, QueueLength: 1024, IndexInterval: defaultIndexInterval, SessionTimeout: defaultSessionTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// dirMode returns the permissions for a topic directory holding files of mode
+func dirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// WithFormat creates new slabs in the given on disk format version.
+func WithFormat(version uint8) Option {
+	return func(o *Options) { o.Format = version }
+}
+
+// WithVarintLength writes compact frames with uvarint lengths.
+func WithVarintLength(enabled bool) Option {
+	return func(o *Options) { o.VarintLength = enabled }
+}
+
+// WithCodec compresses each WriteBatch with codec.
+func WithCodec(codec Codec) Option {
+	return func(o *Options) { o.Codec = codec }
+}
+
+// WithSyncPolicy sets when the Writer fsyncs.
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(o *Options) { o.SyncPolicy = policy }
+}
+
+// WithGroupCommit makes writes wait for a shared fsync.
+func WithGroupCommit(enabled bool) Option {
+	return func(o *Options) { o.GroupCommit = enabled }
+}
+
+// WithPreallocate reserves slabSizeHint bytes on disk whenever a new slab is
+// created so it is written sequentially into already allocated space.  The
+// slab is trimmed back to the end of its frames once it is sealed.  Requires
+// FormatV3 or later as readers rely on frameMagic to tell frames from the
+// zeros still past the end.
+func WithPreallocate(enabled bool) Option {
+	return func(o *Options) { o.Preallocate = enabled }
+}
+
+// WithDirectIO writes new slabs with O_DIRECT, bypassing the page cache.
+// Slabs are written in whole aligned blocks with the last one padded with
+// zeros, so like WithPreallocate it requires FormatV3 or later.  Only
+// supported on Linux, and not by every filesystem e.g. tmpfs.
+func WithDirectIO(enabled bool) Option {
+	return func(o *Options) { o.DirectIO = enabled }
+}
+
+// WithMmap writes new slabs by copying messages into a shared memory map of
+// the slab instead of write calls, fsyncs still follow the SyncPolicy.  The
+// slab is mapped slabSizeHint bytes at a time with zeros past the last frame,
+// so like WithPreallocate it requires FormatV3 or later.  Ignored along with
+// WithDirectIO, and only supported on Linux.
+func WithMmap(enabled bool) Option {
+	return func(o *Options) { o.Mmap = enabled }
+}
+
+// WithQueueLength sets how many messages an AsyncWriter queues before Write
+// blocks, Subscribe buffers in its channel or a Prefetcher reads ahead.
+func WithQueueLength(n int) Option {
+	return func(o *Options) { o.QueueLength = n }
+}
+
+// WithInFlightBytes sets how many bytes of messages an AsyncWriter queues
+// before Write blocks.
+func WithInFlightBytes(n int64) Option {
+	return func(o *Options) { o.InFlightBytes = n }
+}
+
+// WithRejectWhenFull makes an AsyncWriter's Write return ErrBackpressure
+// rather than block once its queue is full.
+func WithRejectWhenFull(enabled bool) Option {
+	return func(o *Options) { o.RejectWhenFull = enabled }
+}
+
+// WithErrorHandler passes an AsyncWriter's write errors to handler, from its
+// background goroutine, instead of the Errors channel.  Subscribe passes its
+// read errors to it too.
+func WithErrorHandler(handler func(error)) Option {
+	return func(o *Options) { o.ErrorHandler = handler }
+}
+
+// WithStealLock breaks a topic lock held by a process which is no longer
+// running instead of returning ErrTopicLocked.
+func WithStealLock(enabled bool) Option {
+	return func(o *Options) { o.StealLock = enabled }
+}
+
+// WithIndexInterval sets how many bytes of frames apart the entries of each
+// slab's sparse .index sidecar file are, smaller intervals make seeking to an
+// arbitrary address or record faster at the cost of larger index files.
+func WithIndexInterval(bytes int) Option {
+	return func(o *Options) { o.IndexInterval = bytes }
+}
+
+// WithSlabNaming names the slabs of a topic the Writer creates, an existing
+// topic keeps the naming it was created with.
+func WithSlabNaming(naming SlabNaming) Option {
+	return func(o *Options) { o.SlabNaming = naming }
+}
+
+// WithMaxSegmentAge rolls a fresh slab for the next message written once the
+// first message of the current slab is older than age, as well as when it
+// reaches the size hint, so each slab spans a bounded stretch of time.  Zero
+// means slabs only roll by size.
+func WithMaxSegmentAge(age time.Duration) Option {
+	return func(o *Options) { o.MaxSegmentAge = age }
+}
+
+// WithRetention sets which sealed slabs the Writer deletes.
+func WithRetention(policy RetentionPolicy) Option {
+	return func(o *Options) { o.Retention = policy }
+}
+
+// WithBufferSize sets the size of the Writer's bufio buffer.
+func WithBufferSize(size int) Option {
+	return func(o *Options) { o.BufferSize = size }
+}
+
+// WithFileMode sets the permissions of new slab files, the topic directory
+// is created with the matching execute bits added.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *Options) { o.FileMode = mode }
+}
+
+// WithMaxRecordSize rejects messages whose encoded length, key and headers
+// included, is larger than size bytes.
+func WithMaxRecordSize(size uint32) Option {
+	return func(o *Options) { o.MaxRecordSize = size }
+}
+
+// WithRateLimit caps how fast a Reader returns messages.
+func WithRateLimit(limit RateLimit) Option {
+	return func(o *Options) { o.RateLimit = limit }
+}
+
+// WithEndAddress bounds a Reader at address.
+func WithEndAddress(address uint64) Option {
+	return func(o *Options) { o.EndAddress = address }
+}
+
+// WithQuota limits how fast a Writer may write, rejecting writes over the
+// quota with ErrQuotaExceeded if reject is set and delaying them otherwise.
+func WithQuota(limit RateLimit, reject bool) Option {
+	return func(o *Options) { o.Quota, o.RejectOverQuota = limit, reject }
+}
+
+// WithReadCommitted makes a Reader skip uncommitted transactions.
+func WithReadCommitted(enabled bool) Option {
+	return func(o *Options) { o.ReadCommitted = enabled }
+}
+
+// WithFollow makes a Reader block at the end of the log for new messages.
+func WithFollow(enabled bool) Option {
+	return func(o *Options) { o.Follow = enabled }
+}
+
+// WithReadBufferSize sets the size of the Reader's bufio buffer.
+func WithReadBufferSize(size int) Option {
+	return func(o *Options) { o.ReadBufferSize = size }
+}
+
+// WithReadAhead tunes a Reader for sequential scans.
+func WithReadAhead(enabled bool) Option {
+	return func(o *Options) { o.ReadAhead = enabled }
+}
+
+// WithMmapRead makes a Reader decode sealed slabs straight out of a memory
+// map.
+func WithMmapRead(enabled bool) Option {
+	return func(o *Options) { o.MmapRead = enabled }
+}
+
+// WithSkipCorrupt makes a Reader skip corrupt frames, reporting each to
+// handler.
+func WithSkipCorrupt(handler CorruptFunc) Option {
+	return func(o *Options) { o.SkipCorrupt = handler }
+}
+
+// WithTieredStorage reads archived slabs, see SetTieredStorage.
+func WithTieredStorage(fetcher Fetcher, cache string) Option {
+	return func(o *Options) { o.Fetcher, o.TierCache = fetcher, cache }
+}
+
+// WithSegmentCompression makes a Writer compress cold slabs with codec in
+// the background each time it rolls, see CompressSegments.
+func WithSegmentCompression(codec Codec) Option {
+	return func(o *Options) { o.SegmentCodec = codec }
+}
+
+// WithSessionTimeout sets how long a GroupConsumer may go without a
+// heartbeat before the other members of its group take its partitions.
+func WithSessionTimeout(timeout time.Duration) Option {
+	return func(o *Options) { o.SessionTimeout = timeout }
+}
+
+// withPooledTimers leaves a Writer's sync and retention timers to a Manager
+func withPooledTimers() Option {
+	return func(o *Options) { o.pooled = true }
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Options(t *testing.T) {
+	optTopic := topic + ".options"
+	os.RemoveAll(optTopic)
+	defer os.RemoveAll(optTopic)
+
+	opts := []queuefka.Option{
+		queuefka.WithFormat(queuefka.FormatV1),
+		queuefka.WithFileMode(0640),
+		queuefka.WithBufferSize(64 * 1024),
+		queuefka.WithSyncPolicy(queuefka.SyncAlways),
+		queuefka.WithMaxRecordSize(uint32(2 * len(value))),
+	}
+
+	wt, err := queuefka.NewWriter(optTopic, segmentSizeHint, opts...)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	if wt.Write(bytes.Repeat(value, 3)) != queuefka.ErrRecordTooLarge {
+		panic("queuefka: WithMaxRecordSize was not applied to the Writer:")
+	}
+	wt.Write(value)
+	end := wt.Address()
+	wt.Write(value)
+
+	slab := queuefka.SlabFiles(optTopic)[0]
+	stat, err := os.Stat(slab)
+	if err != nil {
+		panic(err)
+	}
+	if stat.Mode().Perm() != 0640 {
+		panic("queuefka: WithFileMode was not applied to the slab:")
+	}
+	dir, err := os.Stat(optTopic)
+	if err != nil {
+		panic(err)
+	}
+	if dir.Mode().Perm() != 0750 {
+		panic("queuefka: WithFileMode was not applied to the topic:")
+	}
+
+	// SyncAlways means the data is on disk without a Flush
+	raw := make([]byte, 5)
+	fp, _ := os.Open(slab)
+	fp.Read(raw)
+	fp.Close()
+	if string(raw[:4]) != "QFKA" || raw[4] != queuefka.FormatV1 {
+		panic("queuefka: WithFormat was not applied to the slab:")
+	}
+
+	// the same options apply to a Reader, plus some of its own
+	opts = append(opts, queuefka.WithEndAddress(end))
+	rd, err := queuefka.NewReader(optTopic, 0x0000, opts...)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	if _, err := rd.Read(); err != nil {
+		panic(err)
+	}
+	if _, err := rd.Read(); err != queuefka.ErrEndOfLog {
+		panic("queuefka: WithEndAddress was not applied to the Reader:")
+	}
+}
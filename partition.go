@@ -0,0 +1,85 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// partitionPrefix names the partition directories of a partitioned topic
+const partitionPrefix = "partition-"
+
+// PartitionPath returns the directory of partition n of a partitioned topic,
+// e.g. topic/partition-3.  Each partition has its own chain of slabs and
+// addresses and is written and read like any other topic, by passing its
+// path to NewWriter and NewReader, so the partitions of a topic may be
+// written and consumed in parallel.
+func PartitionPath(topic string, n int) string {
+	return filepath.Join(topic, fmt.Sprintf("%s%d", partitionPrefix, n))
+}
+
+// Partitions returns how many partitions topic has, numbered from 0, or 0 if
+// it is not a partitioned topic.
+func Partitions(topic string) (int, error) {
+	dir, err := os.Open(topic)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	found := make(map[int]bool, len(names))
+	for _, name := range names {
+		if !strings.HasPrefix(name, partitionPrefix) {
+			continue
+		}
+		n, err := strconv.Atoi(name[len(partitionPrefix):])
+		if err == nil && n >= 0 {
+			found[n] = true
+		}
+	}
+	// partitions are only ever added after the last one
+	n := 0
+	for found[n] {
+		n++
+	}
+	return n, nil
+}
+
+// CreatePartitions lays topic out as n partitions, each starting with an
+// empty slab created as NewWriter would with slabSizeHint and opts, so
+// readers can open a partition before anything is written to it.  A topic
+// which already has fewer partitions gains the missing ones, partitions are
+// never removed since that would lose their messages.  A topic which holds
+// slabs of its own cannot be partitioned and returns ErrInvalidTopic.
+func CreatePartitions(topic string, n int, slabSizeHint uint64, opts ...Option) error {
+	if len(SlabFiles(topic)) > 0 {
+		return ErrInvalidTopic
+	}
+	have, err := Partitions(topic)
+	if err != nil {
+		return err
+	}
+	for i := have; i < n; i++ {
+		wt, err := NewWriter(PartitionPath(topic, i), slabSizeHint, opts...)
+		if err != nil {
+			return err
+		}
+		err = wt.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Partitions(t *testing.T) {
+	partTopic := topic + ".partitions"
+	os.RemoveAll(partTopic)
+	defer os.RemoveAll(partTopic)
+
+	err := queuefka.CreatePartitions(partTopic, 4, 512)
+	if err != nil {
+		panic(err)
+	}
+	n, err := queuefka.Partitions(partTopic)
+	if err != nil {
+		panic(err)
+	}
+	if n != 4 {
+		println(n)
+		panic("queuefka: CreatePartitions did not create every partition:")
+	}
+	_, err = queuefka.NewWriter(partTopic, 512)
+	if err != queuefka.ErrPartitioned {
+		println(err)
+		panic("queuefka: NewWriter opened a partitioned topic:")
+	}
+
+	// every partition is its own topic written in parallel
+	var wg sync.WaitGroup
+	for p := 0; p < n; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			wt, err := queuefka.NewWriter(queuefka.PartitionPath(partTopic, p), 512)
+			if err != nil {
+				panic(err)
+			}
+			defer wt.Close()
+			for i := 0; i < 20; i++ {
+				wt.Write([]byte(fmt.Sprintf("partition %d message %d", p, i)))
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	for p := 0; p < n; p++ {
+		rd, err := queuefka.NewReader(queuefka.PartitionPath(partTopic, p), 0)
+		if err != nil {
+			panic(err)
+		}
+		values := readValues(rd)
+		rd.Close()
+		if len(values) != 20 || values[19] != fmt.Sprintf("partition %d message 19", p) {
+			println(p, len(values))
+			panic("queuefka: partition did not read back its own messages:")
+		}
+	}
+
+	// partitions can be added but not removed
+	err = queuefka.CreatePartitions(partTopic, 6, 512)
+	if err == nil {
+		err = queuefka.CreatePartitions(partTopic, 2, 512)
+	}
+	if err != nil {
+		panic(err)
+	}
+	n, _ = queuefka.Partitions(partTopic)
+	slabs := queuefka.SlabFiles(queuefka.PartitionPath(partTopic, 5))
+	if n != 6 || len(slabs) != 1 {
+		println(n, len(slabs))
+		panic("queuefka: CreatePartitions did not add partitions:")
+	}
+
+	// a topic with slabs of its own cannot be partitioned
+	err = queuefka.CreatePartitions(queuefka.PartitionPath(partTopic, 0), 2, 512)
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("queuefka: CreatePartitions partitioned an existing topic:")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+// A Partitioner picks which partition of a partitioned topic a message is
+// written to.  It is called from every goroutine writing to a
+// PartitionedWriter, so must be safe for concurrent use.
+type Partitioner interface {
+	// Partition returns the partition, from 0 to n-1, for a message with
+	// key, which is nil for a message without one.
+	Partition(key []byte, n int) int
+}
+
+// PartitionFunc adapts an ordinary func to a Partitioner.
+type PartitionFunc func(key []byte, n int) int
+
+// Partition returns f(key, n).
+func (f PartitionFunc) Partition(key []byte, n int) int {
+	return f(key, n)
+}
+
+// defaultPartitioner sends messages with the same key to the same partition
+// and spreads those without one round-robin
+type defaultPartitioner struct {
+	keyed   HashPartitioner
+	unkeyed RoundRobinPartitioner
+}
+
+func (p *defaultPartitioner) Partition(key []byte, n int) int {
+	if key == nil {
+		return p.unkeyed.Partition(key, n)
+	}
+	return p.keyed.Partition(key, n)
+}
+
+// PartitionedWriter appends to every partition of a partitioned topic, see
+// CreatePartitions, routing each message to one of them with a Partitioner.
+// Each partition is written by its own Writer, with its own slabs and
+// addresses, so writes to different partitions do not contend.
+type PartitionedWriter struct {
+	topic       string
+	partitioner Partitioner
+	writers     []*Writer
+}
+
+// NewPartitionedWriter opens a Writer on each partition of topic with
+// slabSizeHint and opts.  A nil partitioner sends messages with the same key
+// to the same partition and spreads those without a key round-robin.  A
+// topic which is not partitioned returns ErrInvalidTopic.
+func NewPartitionedWriter(topic string, partitioner Partitioner, slabSizeHint uint64, opts ...Option) (*PartitionedWriter, error) {
+	n, err := Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, ErrInvalidTopic
+	}
+	if partitioner == nil {
+		partitioner = &defaultPartitioner{}
+	}
+
+	pw := &PartitionedWriter{topic: topic, partitioner: partitioner}
+	for i := 0; i < n; i++ {
+		wt, err := NewWriter(PartitionPath(topic, i), slabSizeHint, opts...)
+		if err != nil {
+			pw.Close()
+			return nil, err
+		}
+		pw.writers = append(pw.writers, wt)
+	}
+	return pw, nil
+}
+
+// Partitions returns how many partitions the PartitionedWriter writes to,
+// those added to the topic after it was opened are not written.
+func (pw *PartitionedWriter) Partitions() int {
+	return len(pw.writers)
+}
+
+// Partition returns the Writer of partition n, e.g. for its Address, or nil
+// if there is no such partition.
+func (pw *PartitionedWriter) Partition(n int) *Writer {
+	if n < 0 || n >= len(pw.writers) {
+		return nil
+	}
+	return pw.writers[n]
+}
+
+// Write appends a message without a key to the partition chosen by the
+// Partitioner, returning which it was.
+func (pw *PartitionedWriter) Write(d []byte) (int, error) {
+	return pw.WriteKeyed(nil, d)
+}
+
+// WriteKeyed appends a message with a key to the partition chosen by the
+// Partitioner, returning which it was.
+func (pw *PartitionedWriter) WriteKeyed(key, value []byte) (int, error) {
+	n := pw.partitioner.Partition(key, len(pw.writers))
+	return n, pw.WritePartition(n, key, value)
+}
+
+// WritePartition appends a message to partition n, bypassing the
+// Partitioner.  A nil key writes a message without one.  A partition which
+// does not exist returns ErrOutOfBounds.
+func (pw *PartitionedWriter) WritePartition(n int, key, value []byte) error {
+	wt := pw.Partition(n)
+	if wt == nil {
+		return ErrOutOfBounds
+	}
+	if key == nil {
+		return wt.Write(value)
+	}
+	return wt.WriteKeyed(key, value)
+}
+
+// Flush flushes every partition.
+func (pw *PartitionedWriter) Flush() error {
+	var err error
+	for _, wt := range pw.writers {
+		ferr := wt.Flush()
+		if err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// Close closes every partition, returning the first error.
+func (pw *PartitionedWriter) Close() error {
+	var err error
+	for _, wt := range pw.writers {
+		cerr := wt.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
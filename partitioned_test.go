@@ -0,0 +1,116 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_PartitionedWriter(t *testing.T) {
+	partTopic := topic + ".partitioned"
+	os.RemoveAll(partTopic)
+	defer os.RemoveAll(partTopic)
+
+	_, err := queuefka.NewPartitionedWriter(partTopic, nil, 512)
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("queuefka: NewPartitionedWriter opened a topic without partitions:")
+	}
+	err = queuefka.CreatePartitions(partTopic, 3, 512)
+	if err != nil {
+		panic(err)
+	}
+
+	pw, err := queuefka.NewPartitionedWriter(partTopic, nil, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 60; i++ {
+		_, err = pw.WriteKeyed([]byte(fmt.Sprintf("key %d", i%7)), []byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			panic(err)
+		}
+	}
+	// messages without a key go round-robin
+	counts := make([]int, pw.Partitions())
+	for i := 0; i < 6; i++ {
+		p, err := pw.Write([]byte("unkeyed"))
+		if err != nil {
+			panic(err)
+		}
+		counts[p]++
+	}
+	if counts[0] != 2 || counts[1] != 2 || counts[2] != 2 {
+		println(counts[0], counts[1], counts[2])
+		panic("queuefka: PartitionedWriter did not spread unkeyed messages:")
+	}
+	if pw.WritePartition(3, nil, []byte("nowhere")) != queuefka.ErrOutOfBounds {
+		panic("queuefka: WritePartition wrote to a missing partition:")
+	}
+	err = pw.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	// every message of a key is in the same partition, in order
+	found := make(map[string]int)
+	total := 0
+	for p := 0; p < 3; p++ {
+		rd, err := queuefka.NewReader(queuefka.PartitionPath(partTopic, p), 0)
+		if err != nil {
+			panic(err)
+		}
+		for {
+			rec, err := rd.ReadRecord()
+			if err == queuefka.ErrEndOfLog {
+				break
+			} else if err != nil {
+				panic(err)
+			}
+			total++
+			if rec.Key == nil {
+				continue
+			}
+			if q, ok := found[string(rec.Key)]; ok && q != p {
+				println(string(rec.Key), q, p)
+				panic("queuefka: PartitionedWriter split a key across partitions:")
+			}
+			found[string(rec.Key)] = p
+		}
+		rd.Close()
+	}
+	if total != 66 || len(found) != 7 {
+		println(total, len(found))
+		panic("queuefka: PartitionedWriter lost messages:")
+	}
+
+	// a custom Partitioner places every message
+	pw, err = queuefka.NewPartitionedWriter(partTopic, queuefka.PartitionFunc(func(key []byte, n int) int {
+		return n - 1
+	}), 512)
+	if err != nil {
+		panic(err)
+	}
+	before := pw.Partition(2).Address()
+	p, err := pw.WriteKeyed([]byte("key 0"), []byte("last"))
+	if err != nil {
+		panic(err)
+	}
+	pw.Close()
+	rd, err := queuefka.NewReader(queuefka.PartitionPath(partTopic, 2), before)
+	if err != nil {
+		panic(err)
+	}
+	values := readValues(rd)
+	rd.Close()
+	if p != 2 || len(values) != 1 || values[0] != "last" {
+		println(p, len(values))
+		panic("queuefka: PartitionedWriter ignored its Partitioner:")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/vova616/xxhash"
+)
+
+// HashPartitioner sends every message with the same key to the same
+// partition, by the xxhash of the key, so each key's messages stay in order.
+// Messages without a key all go to the same partition.
+type HashPartitioner struct{}
+
+// Partition returns the xxhash of key modulo n.
+func (HashPartitioner) Partition(key []byte, n int) int {
+	return int(xxhash.Checksum32(key) % uint32(n))
+}
+
+// Murmur2Partitioner sends every message with the same key to the same
+// partition as Kafka's default partitioner does, by the murmur2 hash of the
+// key, so keys land in the same partitions as in a Kafka topic with as many
+// partitions.  Messages without a key all go to the same partition.
+type Murmur2Partitioner struct{}
+
+// Partition returns the positive murmur2 hash of key modulo n.
+func (Murmur2Partitioner) Partition(key []byte, n int) int {
+	return int(murmur2(key)&0x7fffffff) % n
+}
+
+// murmur2 returns the 32 bit murmur2 hash of data with Kafka's seed
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// RoundRobinPartitioner spreads messages evenly over the partitions one
+// after another, ignoring their keys.  The zero value is ready to use.
+type RoundRobinPartitioner struct {
+	next uint32
+}
+
+// Partition returns the partition after the one it last returned.
+func (p *RoundRobinPartitioner) Partition(key []byte, n int) int {
+	return int((atomic.AddUint32(&p.next, 1) - 1) % uint32(n))
+}
+
+// StickyPartitioner sends Batch messages in a row to one partition before
+// moving on to the next, ignoring their keys, so each partition's Writer
+// receives runs of messages it can buffer together while over time the
+// messages are still spread evenly.
+type StickyPartitioner struct {
+	Batch int // messages sent to a partition in a row, at least 1
+
+	sync.Mutex
+	current int // partition being sent to
+	sent    int // messages sent to it so far
+}
+
+// Partition returns the current partition, moving on once it has been sent
+// Batch messages.
+func (p *StickyPartitioner) Partition(key []byte, n int) int {
+	p.Lock()
+	defer p.Unlock()
+
+	batch := p.Batch
+	if batch < 1 {
+		batch = 1
+	}
+	if p.sent >= batch || p.current >= n {
+		p.current = (p.current + 1) % n
+		p.sent = 0
+	}
+	p.sent++
+	return p.current
+}
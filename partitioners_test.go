@@ -0,0 +1,52 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Partitioners(t *testing.T) {
+	// keys hash to the same partitions as in Kafka
+	murmur := queuefka.Murmur2Partitioner{}
+	for key, want := range map[string]int{"21": 1173551340, "foobar": 1357151166} {
+		if got := murmur.Partition([]byte(key), math.MaxInt32); got != want {
+			println(key, got, want)
+			panic("queuefka: Murmur2Partitioner does not match Kafka:")
+		}
+	}
+
+	// a key always lands in the same partition
+	for _, p := range []queuefka.Partitioner{queuefka.HashPartitioner{}, murmur} {
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("key %d", i))
+			n := p.Partition(key, 7)
+			if n < 0 || n >= 7 || p.Partition(key, 7) != n {
+				println(i, n)
+				panic("queuefka: hash partitioner moved a key:")
+			}
+		}
+	}
+
+	rr := &queuefka.RoundRobinPartitioner{}
+	for i := 0; i < 9; i++ {
+		if n := rr.Partition([]byte("same"), 3); n != i%3 {
+			println(i, n)
+			panic("queuefka: RoundRobinPartitioner did not go round:")
+		}
+	}
+
+	sticky := &queuefka.StickyPartitioner{Batch: 4}
+	for i := 0; i < 24; i++ {
+		if n := sticky.Partition(nil, 3); n != (i/4)%3 {
+			println(i, n)
+			panic("queuefka: StickyPartitioner did not stick to a partition:")
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vova616/xxhash"
+)
+
+// PoolConfig sizes a WriterPool.
+type PoolConfig struct {
+	Workers    int           // goroutines appending, each owning the topics hashed to it
+	MaxOpen    int           // Writers kept open, the least recently used closed past it
+	FlushEvery time.Duration // flush on a timer, 0 to flush whenever a worker's queue runs dry
+}
+
+// WriterPool appends to any number of topics from a fixed number of worker
+// goroutines, for applications writing to hundreds of topics where a Writer
+// or AsyncWriter each would hold too many buffers, file handles and
+// goroutines.  Each topic is always handled by the same worker, so its
+// messages are appended in the order written, and a worker keeps its share
+// of at most MaxOpen Writers open, closing the least recently used to open
+// another.  Like an AsyncWriter, Write queues the message and returns, each
+// worker queue holding QueueLength messages, and errors are passed to the
+// ErrorHandler option or sent on the Errors channel, as *AsyncError.
+type WriterPool struct {
+	workers []*poolWorker
+	errs    chan error
+	handler func(error)
+	done    sync.WaitGroup // done once every worker exits
+
+	closed       bool
+	sync.RWMutex // guards closed against sends on a closed queue
+}
+
+// poolWorker appends the messages of the topics hashed to it, owning their
+// Writers
+type poolWorker struct {
+	pool         *WriterPool
+	queue        chan poolRecord
+	slabSizeHint uint64
+	opts         []Option
+	maxOpen      int
+	flushEvery   time.Duration
+	writers      map[string]*poolWriter
+	used         uint64 // counts appends to order writers by last use
+}
+
+// poolWriter is an open Writer of a worker
+type poolWriter struct {
+	wt    *Writer
+	used  uint64 // poolWorker.used as of the last append
+	dirty bool   // appended to since the last flush
+}
+
+// poolRecord is a queued message, or a Flush request if flushed is set
+type poolRecord struct {
+	topic   string
+	key     []byte
+	value   []byte
+	flushed chan error
+}
+
+// NewWriterPool returns a WriterPool sized by config opening each topic as
+// NewWriter(topic, slabSizeHint, opts...) when it is first written.
+func NewWriterPool(config PoolConfig, slabSizeHint uint64, opts ...Option) *WriterPool {
+	o := defaultOptions(opts)
+	if o.QueueLength < 1 {
+		o.QueueLength = 1
+	}
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	maxOpen := config.MaxOpen / config.Workers
+	if maxOpen < 1 {
+		maxOpen = 1
+	}
+
+	p := &WriterPool{
+		errs:    make(chan error, o.QueueLength),
+		handler: o.ErrorHandler,
+	}
+	for i := 0; i < config.Workers; i++ {
+		w := &poolWorker{
+			pool:         p,
+			queue:        make(chan poolRecord, o.QueueLength),
+			slabSizeHint: slabSizeHint,
+			opts:         opts,
+			maxOpen:      maxOpen,
+			flushEvery:   config.FlushEvery,
+			writers:      make(map[string]*poolWriter),
+		}
+		p.workers = append(p.workers, w)
+		p.done.Add(1)
+		go w.run()
+	}
+	return p
+}
+
+// Write queues a copy of d to be appended to topic.
+func (p *WriterPool) Write(topic string, d []byte) error {
+	return p.queue(poolRecord{topic: topic, value: append([]byte(nil), d...)})
+}
+
+// WriteKeyed queues a copy of a message with a key to be appended to topic.
+func (p *WriterPool) WriteKeyed(topic string, key, value []byte) error {
+	return p.queue(poolRecord{topic: topic, key: append([]byte(nil), key...), value: append([]byte(nil), value...)})
+}
+
+// queue hands rec to the worker of its topic
+func (p *WriterPool) queue(rec poolRecord) error {
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.closed {
+		return ErrWriterClosed
+	}
+	p.workers[xxhash.Checksum32([]byte(rec.topic))%uint32(len(p.workers))].queue <- rec
+	return nil
+}
+
+// Flush waits until every message queued before it has been written and
+// flushed, returning the first error flushing.  Errors writing the messages
+// are reported as usual.
+func (p *WriterPool) Flush() error {
+	p.RLock()
+	if p.closed {
+		p.RUnlock()
+		return ErrWriterClosed
+	}
+	flushed := make([]chan error, len(p.workers))
+	for i, w := range p.workers {
+		flushed[i] = make(chan error, 1)
+		w.queue <- poolRecord{flushed: flushed[i]}
+	}
+	p.RUnlock()
+
+	var err error
+	for _, ch := range flushed {
+		ferr := <-ch
+		if err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// Errors returns the channel write errors are sent on, as *AsyncError, when
+// no ErrorHandler was given.  It is closed by Close.
+func (p *WriterPool) Errors() <-chan error {
+	return p.errs
+}
+
+// Close writes everything still queued then closes every Writer, returning
+// the first error closing one.
+func (p *WriterPool) Close() error {
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return ErrWriterClosed
+	}
+	p.closed = true
+	for _, w := range p.workers {
+		close(w.queue)
+	}
+	p.Unlock()
+
+	p.done.Wait()
+	var err error
+	for _, w := range p.workers {
+		cerr := w.closeAll()
+		if err == nil {
+			err = cerr
+		}
+	}
+	close(p.errs)
+	return err
+}
+
+// report delivers a background error
+func (p *WriterPool) report(err error) {
+	if p.handler != nil {
+		p.handler(err)
+		return
+	}
+	p.errs <- err
+}
+
+// run appends queued messages until the queue is closed, flushing on the
+// timer or whenever the queue runs dry
+func (w *poolWorker) run() {
+	defer w.pool.done.Done()
+
+	var tick <-chan time.Time
+	if w.flushEvery > 0 {
+		ticker := time.NewTicker(w.flushEvery)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case rec, ok := <-w.queue:
+			if !ok {
+				return
+			}
+			w.handle(rec)
+			if w.flushEvery > 0 || len(w.queue) > 0 {
+				continue
+			}
+		case <-tick:
+		}
+		err := w.flush()
+		if err != nil {
+			w.pool.report(err)
+		}
+	}
+}
+
+// handle appends or flushes a single queued record
+func (w *poolWorker) handle(rec poolRecord) {
+	if rec.flushed != nil {
+		rec.flushed <- w.flush()
+		return
+	}
+
+	pw, err := w.writer(rec.topic)
+	if err == nil && rec.key != nil {
+		err = pw.wt.WriteKeyed(rec.key, rec.value)
+	} else if err == nil {
+		err = pw.wt.Write(rec.value)
+	}
+	if err != nil {
+		w.pool.report(&AsyncError{Topic: rec.topic, Value: rec.value, Err: err})
+		return
+	}
+	w.used++
+	pw.used = w.used
+	pw.dirty = true
+}
+
+// writer returns the open Writer of topic, closing the least recently used
+// to open it if the worker already has maxOpen
+func (w *poolWorker) writer(topic string) (*poolWriter, error) {
+	pw, ok := w.writers[topic]
+	if ok {
+		return pw, nil
+	}
+
+	if len(w.writers) >= w.maxOpen {
+		var oldest string
+		for name, pw := range w.writers {
+			if oldest == "" || pw.used < w.writers[oldest].used {
+				oldest = name
+			}
+		}
+		err := w.writers[oldest].wt.Close()
+		delete(w.writers, oldest)
+		if err != nil {
+			w.pool.report(err)
+		}
+	}
+
+	wt, err := NewWriter(topic, w.slabSizeHint, w.opts...)
+	if err != nil {
+		return nil, err
+	}
+	pw = &poolWriter{wt: wt}
+	w.writers[topic] = pw
+	return pw, nil
+}
+
+// flush flushes every Writer appended to since it was last flushed,
+// returning the first error
+func (w *poolWorker) flush() error {
+	var err error
+	for _, pw := range w.writers {
+		if !pw.dirty {
+			continue
+		}
+		pw.dirty = false
+		ferr := pw.wt.Flush()
+		if err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// closeAll closes every open Writer once the worker has exited, returning
+// the first error
+func (w *poolWorker) closeAll() error {
+	var err error
+	for topic, pw := range w.writers {
+		cerr := pw.wt.Close()
+		if err == nil {
+			err = cerr
+		}
+		delete(w.writers, topic)
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_WriterPool(t *testing.T) {
+	root := topic + ".pool"
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+	os.MkdirAll(root, 0700)
+
+	// a file where a topic directory should be cannot be opened
+	bad := filepath.Join(root, "bad")
+	ioutil.WriteFile(bad, []byte("not a topic"), 0600)
+	var mu sync.Mutex
+	var errs []error
+	handler := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	// more topics than Writers may be open at once
+	p := queuefka.NewWriterPool(queuefka.PoolConfig{Workers: 3, MaxOpen: 3, FlushEvery: 10 * time.Millisecond}, 512,
+		queuefka.WithErrorHandler(handler))
+	for i := 0; i < 30; i++ {
+		for n := 0; n < 10; n++ {
+			err := p.Write(filepath.Join(root, fmt.Sprintf("topic%d", n)), []byte(fmt.Sprintf("topic %d message %d", n, i)))
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	p.Write(bad, []byte("lost"))
+	err := p.Flush()
+	if err != nil {
+		panic(err)
+	}
+
+	for n := 0; n < 10; n++ {
+		rd, err := queuefka.NewReader(filepath.Join(root, fmt.Sprintf("topic%d", n)), 0)
+		if err != nil {
+			panic(err)
+		}
+		values := readValues(rd)
+		rd.Close()
+		for i, v := range values {
+			if v != fmt.Sprintf("topic %d message %d", n, i) {
+				println(n, i, v)
+				panic("queuefka: WriterPool appended out of order:")
+			}
+		}
+		if len(values) != 30 {
+			println(n, len(values))
+			panic("queuefka: WriterPool lost messages:")
+		}
+	}
+
+	err = p.Close()
+	if err != nil {
+		panic(err)
+	}
+	if p.Write(bad, []byte("closed")) != queuefka.ErrWriterClosed {
+		panic("queuefka: WriterPool wrote after Close:")
+	}
+	if len(errs) != 1 {
+		println(len(errs))
+		panic("queuefka: WriterPool did not report a failed write:")
+	}
+	ae, ok := errs[0].(*queuefka.AsyncError)
+	if !ok || ae.Topic != bad || string(ae.Value) != "lost" {
+		println(errs[0].Error())
+		panic("queuefka: WriterPool reported the wrong write:")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+)
+
+// A preallocated slab is longer than the frames written to it, the rest is
+// zeros.  Its logical end is found by reading frames until the first byte
+// which is not frameMagic, starting from the end recorded in its .meta
+// sidecar so the Writer need not read the whole slab to find it.  Older
+// releases recorded it in an .end sidecar instead.
+
+// endPath returns the sidecar file older releases recorded the logical end
+// of a preallocated slab in e.g. <base>.end
+func endPath(slab string) string {
+	return slabStem(slab) + ".end"
+}
+
+// readSlabEnd returns the logical end offset recorded for a slab
+func readSlabEnd(slab string) (uint64, error) {
+	buf, err := ioutil.ReadFile(endPath(slab))
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 8 {
+		return 0, ErrBadChecksum
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// reclaim zeroes everything past the logical end of the preallocated slab
+// being loaded so a torn frame is never taken for the start of a new one,
+// caller must hold the lock
+func (wt *Writer) reclaim(fp *os.File, end int64) error {
+	err := fp.Truncate(end)
+	if err != nil {
+		return err
+	}
+	err = preallocate(fp, int64(wt.slabSizeHint))
+	if err != nil {
+		return err
+	}
+
+	// a legacy record goes stale as soon as anything is appended
+	err = os.Remove(endPath(wt.slabPath(wt.base)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// trim cuts the zeros off the current slab as it is sealed, caller must hold
+// the lock
+func (wt *Writer) trim() error {
+	err := wt.flush()
+	if err != nil {
+		return err
+	}
+	if wt.mm != nil {
+		// the mapping must not outlive the bytes it covers
+		err = wt.mm.sync()
+		if err != nil {
+			return err
+		}
+		wt.unmap()
+	}
+	return wt.fp.Truncate(int64(wt.address - wt.base))
+}
+
+// extend grows fp with zeros to size bytes if it is shorter
+func extend(fp *os.File, size int64) error {
+	stat, err := fp.Stat()
+	if err != nil || stat.Size() >= size {
+		return err
+	}
+	return fp.Truncate(size)
+}
+
+// unwritten reports whether n bytes at the read position of a preallocated
+// slab which fail to decode may simply not have been written yet
+func (rd *Reader) unwritten(n uint64) bool {
+	if !rd.prealloc {
+		return false
+	}
+	if !rd.sealed() {
+		return true
+	}
+
+	// a slab is trimmed as it is sealed, which may have only just happened
+	size, err := rd.slabSize()
+	return err == nil && rd.base+uint64(size) < rd.address+n
+}
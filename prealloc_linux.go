@@ -0,0 +1,23 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package queuefka
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate allocates disk blocks for the first size bytes of fp, extending
+// it with zeros if it is shorter.  Filesystems without fallocate fall back to
+// a sparse extension.
+func preallocate(fp *os.File, size int64) error {
+	err := syscall.Fallocate(int(fp.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP {
+		return extend(fp, size)
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package queuefka
+
+import "os"
+
+// preallocate extends fp with zeros to size bytes if it is shorter
+func preallocate(fp *os.File, size int64) error {
+	return extend(fp, size)
+}
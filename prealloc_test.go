@@ -0,0 +1,112 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// readAll returns how many messages can be read from the start of topic
+func readAll(topic string) int {
+	rd, err := queuefka.NewReader(topic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	n := 0
+	for {
+		_, err := rd.Read()
+		if err == queuefka.ErrEndOfLog {
+			return n
+		} else if err != nil {
+			panic(err)
+		}
+		n++
+	}
+}
+
+func Test_Queuefka_Preallocate(t *testing.T) {
+	preTopic := topic + ".prealloc"
+	os.RemoveAll(preTopic)
+	defer os.RemoveAll(preTopic)
+
+	_, err := queuefka.NewWriter(preTopic, 4096, queuefka.WithFormat(queuefka.FormatV2), queuefka.WithPreallocate(true))
+	if err != queuefka.ErrOldFormat {
+		println(err)
+		panic("queuefka: Preallocate accepted a format without frame magic:")
+	}
+
+	wt, err := queuefka.NewWriter(preTopic, 4096, queuefka.WithPreallocate(true))
+	if err != nil {
+		panic(err)
+	}
+	if slabSize(preTopic) < 4096 {
+		panic("queuefka: Preallocate did not reserve the slab:")
+	}
+
+	// zeros past the end read as the end of the log
+	for i := 0; i < 10; i++ {
+		wt.Write(value)
+	}
+	wt.Flush()
+	if n := readAll(preTopic); n != 10 {
+		println(n)
+		panic("queuefka: Preallocate read the wrong number of messages:")
+	}
+
+	// a clean reopen carries on from the logical end
+	address := wt.Address()
+	wt.Close()
+	wt, err = queuefka.NewWriter(preTopic, 4096, queuefka.WithPreallocate(true))
+	if err != nil {
+		panic(err)
+	}
+	if wt.Address() != address {
+		println(wt.Address(), address)
+		panic("queuefka: Preallocate lost the end after Close:")
+	}
+
+	// so does one after a crash, which leaves no .end record
+	wt.Write(value)
+	wt.Flush()
+	address = wt.Address()
+	os.Remove(preTopic + "/writer.lock") // as released by the dying process
+	wt, err = queuefka.NewWriter(preTopic, 4096, queuefka.WithPreallocate(true))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	if wt.Address() != address {
+		println(wt.Address(), address)
+		panic("queuefka: Preallocate lost the end after a crash:")
+	}
+
+	// sealed slabs are trimmed to their frames
+	for i := 0; i < 300; i++ {
+		wt.Write(value)
+	}
+	wt.Flush()
+	segments, err := queuefka.SealedSegments(preTopic)
+	if err != nil {
+		panic(err)
+	}
+	if len(segments) == 0 {
+		panic("queuefka: Preallocate test did not roll:")
+	}
+	for _, seg := range segments {
+		if seg.Size >= 4096+100 {
+			println(seg.Path, seg.Size)
+			panic("queuefka: Preallocate did not trim a sealed slab:")
+		}
+	}
+	if n := readAll(preTopic); n != 311 {
+		println(n)
+		panic("queuefka: Preallocate lost messages across slabs:")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"sync"
+)
+
+// Prefetcher reads and decodes messages on a background goroutine ahead of
+// its consumer, into a queue of up to WithQueueLength records, so disk reads
+// overlap with processing during sequential replay.
+type Prefetcher struct {
+	rd     *Reader
+	follow bool // Read waits at the end of the log rather than returning ErrEndOfLog
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []prefetched
+	depth  int  // most records queued at once
+	atEnd  bool // the goroutine found nothing more to read
+	closed bool
+}
+
+// prefetched is a record read by the goroutine or the error reading it
+type prefetched struct {
+	rec Record
+	err error
+}
+
+// NewPrefetcher returns a Prefetcher starting at the specified topic and
+// address.  Options apply as for NewReader.
+func NewPrefetcher(topic string, address uint64, opts ...Option) (*Prefetcher, error) {
+	o := defaultOptions(opts)
+	rd, err := NewReader(topic, address, opts...)
+	if err != nil && err != ErrEndOfLog {
+		rd.Close()
+		return nil, err
+	}
+
+	// watch the topic for the goroutine to wait on, but leave reporting the
+	// end of the log to Read
+	rd.SetFollow(true)
+	rd.follow = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Prefetcher{rd: rd, follow: o.Follow, cancel: cancel, done: make(chan struct{}), depth: o.QueueLength}
+	if p.depth <= 0 {
+		p.depth = 1
+	}
+	p.cond = sync.NewCond(&p.mu)
+	go p.run(ctx)
+
+	return p, nil
+}
+
+// run reads ahead until the Prefetcher is closed
+func (p *Prefetcher) run(ctx context.Context) {
+	defer close(p.done)
+
+	for {
+		p.mu.Lock()
+		for len(p.queue) >= p.depth && !p.closed {
+			p.cond.Wait()
+		}
+		p.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+
+		from := p.rd.address
+		rec, err := p.rd.readRecord(ctx)
+
+		p.mu.Lock()
+		p.atEnd = err == ErrEndOfLog
+		if err == nil {
+			p.queue = append(p.queue, prefetched{rec: rec})
+		} else if err != ErrEndOfLog {
+			p.queue = append(p.queue, prefetched{err: err})
+		}
+		p.cond.Broadcast()
+		p.mu.Unlock()
+
+		// wait for more to be written rather than spin
+		if (err == ErrEndOfLog || (err != nil && p.rd.address == from)) && p.rd.wait(ctx) != nil {
+			return
+		}
+	}
+}
+
+// Read returns the next record, or ErrEndOfLog once the goroutine has caught
+// up with the end of the log unless the Prefetcher is in follow mode, in
+// which case it waits for more.  Errors reading a message are returned in
+// its place.
+func (p *Prefetcher) Read() (Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.queue) == 0 && !p.closed && (!p.atEnd || p.follow) {
+		p.cond.Wait()
+	}
+	if len(p.queue) == 0 {
+		return Record{}, ErrEndOfLog
+	}
+
+	item := p.queue[0]
+	p.queue = p.queue[1:]
+	p.cond.Broadcast()
+	return item.rec, item.err
+}
+
+// Close stops the goroutine and closes the underlying Reader
+func (p *Prefetcher) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	p.cancel()
+	<-p.done
+	return p.rd.Close()
+}
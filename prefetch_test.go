@@ -0,0 +1,78 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Prefetcher(t *testing.T) {
+	preTopic := topic + ".prefetch"
+	os.RemoveAll(preTopic)
+	defer os.RemoveAll(preTopic)
+
+	wt, err := queuefka.NewWriter(preTopic, 4096)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	for i := 0; i < 500; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	p, err := queuefka.NewPrefetcher(preTopic, 0, queuefka.WithQueueLength(16))
+	if err != nil {
+		panic(err)
+	}
+	defer p.Close()
+
+	read := func(from, to int) {
+		for i := from; i < to; i++ {
+			rec, err := p.Read()
+			if err != nil || string(rec.Value) != fmt.Sprintf("message %d", i) {
+				println(i, string(rec.Value), err)
+				panic("queuefka: Prefetcher returned the wrong record:")
+			}
+		}
+	}
+	read(0, 500)
+
+	// once caught up it reports the end of the log
+	start := time.Now()
+	for {
+		_, err = p.Read()
+		if err == queuefka.ErrEndOfLog {
+			break
+		} else if err != nil || time.Since(start) > time.Second {
+			println(err)
+			panic("queuefka: Prefetcher did not report the end of the log:")
+		}
+	}
+
+	// and picks up messages written afterwards
+	wt.Write([]byte("message 500"))
+	wt.Flush()
+	start = time.Now()
+	for {
+		rec, err := p.Read()
+		if err == nil {
+			if string(rec.Value) != "message 500" {
+				panic("queuefka: Prefetcher returned the wrong record after catching up:")
+			}
+			break
+		} else if err != queuefka.ErrEndOfLog || time.Since(start) > time.Second {
+			println(err)
+			panic("queuefka: Prefetcher did not pick up a new message:")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+)
+
+// Idempotent writes carry a producer id and sequence number in the message
+// body.  The Writer remembers the last sequence appended for every producer
+// so a retry after an ambiguous failure is dropped instead of duplicated.
+//
+// The table is not read until the first idempotent write.  It is rebuilt from
+// a <base>.producers sidecar holding the table as of the start of the current
+// slab plus the idempotent messages in that slab.  A slab only ever holds
+// idempotent messages if it has a sidecar, so topics which never use them
+// never pay for reading one.
+
+// WriteIdempotent appends d as message number sequence of producer, which
+// must be nonzero.  Each producer's sequence numbers must increase by one
+// from wherever it starts.  A sequence number which was already appended is
+// a retry and is dropped returning nil, skipping ahead of the next one
+// returns ErrOutOfSequence.  Requires FormatV2 or later.
+func (wt *Writer) WriteIdempotent(producer, sequence uint64, d []byte) error {
+	if producer == 0 {
+		return ErrOutOfSequence
+	}
+	return wt.write(context.Background(), &message{producer: producer, sequence: sequence, value: d})
+}
+
+// Producer returns the producer id and sequence number of the message most
+// recently returned by Read, or zeros if it was not written idempotently.
+func (rd *Reader) Producer() (uint64, uint64) {
+	return rd.msg.producer, rd.msg.sequence
+}
+
+// checkSequence reports whether sequence was already appended for producer
+// or returns ErrOutOfSequence if it skips ahead, caller must hold the lock
+func (wt *Writer) checkSequence(producer, sequence uint64) (bool, error) {
+	if wt.producers == nil {
+		err := wt.loadProducers()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	last, ok := wt.producers[producer]
+	if ok && sequence <= last {
+		return true, nil
+	}
+	if ok && sequence != last+1 {
+		return false, ErrOutOfSequence
+	}
+
+	// the slab is about to hold an idempotent message
+	if !wt.snapshotted {
+		err := writeProducers(wt.slabPath(wt.base), wt.producers)
+		if err != nil {
+			return false, err
+		}
+		wt.snapshotted = true
+	}
+	return false, nil
+}
+
+// loadProducers rebuilds the producer table from the current slab's sidecar
+// and the idempotent messages in it, caller must hold the lock
+func (wt *Writer) loadProducers() error {
+	path := wt.slabPath(wt.base)
+	producers, err := readProducers(path)
+	if os.IsNotExist(err) {
+		wt.producers, wt.snapshotted = map[uint64]uint64{}, false
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = wt.flush()
+	if err != nil {
+		return err
+	}
+	rd, err := NewSegmentReader(Segment{Base: wt.base, Path: path, Size: int64(wt.address - wt.base)})
+	for err == nil {
+		address := rd.address
+		_, err = rd.Read()
+		if err == nil && rd.msg.producer != 0 {
+			producers[rd.msg.producer] = rd.msg.sequence
+		} else if err != nil && err != ErrEndOfLog && rd.address > address {
+			// skip a frame which is intact but cannot be decoded
+			err = nil
+		}
+	}
+	rd.Close()
+	if err != ErrEndOfLog {
+		return err
+	}
+
+	wt.producers, wt.snapshotted = producers, true
+	return nil
+}
+
+// rollProducers carries the producer table over to the slab about to be
+// created at wt.address, caller must hold the lock
+func (wt *Writer) rollProducers() error {
+	if wt.producers == nil {
+		_, err := os.Stat(producersPath(wt.slabPath(wt.base)))
+		if os.IsNotExist(err) {
+			// nothing idempotent was ever written
+			return nil
+		}
+		err = wt.loadProducers()
+		if err != nil {
+			return err
+		}
+	}
+
+	wt.snapshotted = len(wt.producers) > 0
+	if !wt.snapshotted {
+		return nil
+	}
+	return writeProducers(wt.slabPath(wt.address), wt.producers)
+}
+
+// producersPath returns the sidecar file holding the producer table as of the
+// start of a slab e.g. <base>.producers
+func producersPath(slab string) string {
+	return slabStem(slab) + ".producers"
+}
+
+// writeProducers atomically records the producer table for a slab as a
+// uvarint count followed by uvarint producer id and sequence pairs
+func writeProducers(slab string, producers map[uint64]uint64) error {
+	var n [binary.MaxVarintLen64]byte
+	buf := append([]byte{}, n[:binary.PutUvarint(n[:], uint64(len(producers)))]...)
+	for producer, sequence := range producers {
+		buf = append(buf, n[:binary.PutUvarint(n[:], producer)]...)
+		buf = append(buf, n[:binary.PutUvarint(n[:], sequence)]...)
+	}
+
+	tmp := producersPath(slab) + ".tmp"
+	err := ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, producersPath(slab))
+}
+
+// readProducers returns the producer table recorded for a slab
+func readProducers(slab string) (map[uint64]uint64, error) {
+	buf, err := ioutil.ReadFile(producersPath(slab))
+	if err != nil {
+		return nil, err
+	}
+
+	count, l := binary.Uvarint(buf)
+	if l <= 0 || count > uint64(len(buf)) {
+		return nil, ErrBadFormat
+	}
+	buf = buf[l:]
+	producers := make(map[uint64]uint64, count)
+	for i := uint64(0); i < count; i++ {
+		producer, l1 := binary.Uvarint(buf)
+		if l1 <= 0 {
+			return nil, ErrBadFormat
+		}
+		sequence, l2 := binary.Uvarint(buf[l1:])
+		if l2 <= 0 {
+			return nil, ErrBadFormat
+		}
+		producers[producer] = sequence
+		buf = buf[l1+l2:]
+	}
+	return producers, nil
+}
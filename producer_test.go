@@ -0,0 +1,91 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_WriteIdempotent(t *testing.T) {
+	idemTopic := topic + ".idempotent"
+	os.RemoveAll(idemTopic)
+	defer os.RemoveAll(idemTopic)
+
+	wt, err := queuefka.NewWriter(idemTopic, 1024)
+	if err != nil {
+		panic(err)
+	}
+
+	// retries of anything already appended are dropped
+	for seq := uint64(1); seq <= 100; seq++ {
+		if err := wt.WriteIdempotent(7, seq, value); err != nil {
+			panic(err)
+		}
+		if err := wt.WriteIdempotent(7, seq, value); err != nil {
+			panic(err)
+		}
+		wt.Write(value)
+	}
+	if err := wt.WriteIdempotent(7, 50, value); err != nil {
+		panic(err)
+	}
+	if err := wt.WriteIdempotent(7, 102, value); err != queuefka.ErrOutOfSequence {
+		println(err)
+		panic("queuefka: WriteIdempotent accepted a gap:")
+	}
+
+	// the table survives reopening the topic, across slabs
+	wt.Close()
+	wt, err = queuefka.NewWriter(idemTopic, 1024)
+	if err != nil {
+		panic(err)
+	}
+	if err := wt.WriteIdempotent(7, 100, value); err != nil {
+		panic(err)
+	}
+	if err := wt.WriteIdempotent(7, 101, value); err != nil {
+		panic(err)
+	}
+	if err := wt.WriteIdempotent(8, 1, value); err != nil {
+		panic(err)
+	}
+	wt.Close()
+
+	rd, err := queuefka.NewReader(idemTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	var idempotent, plain int
+	var last uint64
+	for {
+		_, err := rd.Read()
+		if err == queuefka.ErrEndOfLog {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		producer, seq := rd.Producer()
+		if producer == 0 {
+			plain++
+			continue
+		}
+		if producer == 7 {
+			if seq != last+1 {
+				println(seq, last)
+				panic("queuefka: WriteIdempotent wrote a duplicate:")
+			}
+			last = seq
+		}
+		idempotent++
+	}
+	if idempotent != 102 || plain != 100 {
+		println(idempotent, plain)
+		panic("queuefka: WriteIdempotent wrote the wrong messages:")
+	}
+}
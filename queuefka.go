@@ -11,15 +11,15 @@ package queuefka
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/vova616/xxhash"
 )
@@ -29,79 +29,187 @@ var (
 	ErrEndOfLog     = errors.New("queuefka: Read() end of log")
 	ErrOutOfBounds  = errors.New("queuefka: Read() topic address out of bounds")
 	ErrBadChecksum  = errors.New("queuefka: Read() checksum mismatch")
+	ErrBadHeader    = errors.New("queuefka: Read() header checksum mismatch")
+	ErrBadFormat    = errors.New("queuefka: Read() malformed message")
+	ErrOldFormat    = errors.New("queuefka: Write() slab format does not support message")
+	ErrUnknownCodec = errors.New("queuefka: Read() unknown compression codec")
+
+	ErrRecordTooLarge = errors.New("queuefka: message exceeds maximum record size")
+	ErrOutOfSequence  = errors.New("queuefka: Write() producer sequence number out of order")
+	ErrTxnDone        = errors.New("queuefka: Write() transaction already committed or aborted")
+	ErrTxnOpen        = errors.New("queuefka: Truncate() transaction still open")
+	ErrQuotaExceeded  = errors.New("queuefka: Write() topic write quota exceeded")
+	ErrTopicLocked    = errors.New("queuefka: NewWriter() topic is locked by another Writer")
+	ErrBadNaming      = errors.New("queuefka: NewWriter() invalid slab naming")
+	ErrBadFooter      = errors.New("queuefka: Verify() slab does not match its footer")
+	ErrTopicExists    = errors.New("queuefka: Import() topic already exists")
+	ErrPartitioned    = errors.New("queuefka: NewWriter() topic is partitioned, open one of its partitions")
+	ErrBadGroup       = errors.New("queuefka: Commit() invalid consumer group name")
+	ErrNoOffset       = errors.New("queuefka: Fetch() no offset committed")
+	ErrFenced         = errors.New("queuefka: Commit() epoch fenced by a newer consumer")
+
+	ErrAddressTruncated = errors.New("queuefka: Read() address was deleted by retention")
 )
 
 // Reader implements Append Only Log functionality for an bufio.Reader object.
+// A Reader is not safe for concurrent use, Clone gives each goroutine its own
+// cursor.
 type Reader struct {
-	topic string // path to directory which holds *.slab files
-	base  uint64 // address of first message in current slab file e.g. <base>.slab
-	fp    *os.File
-	rd    *bufio.Reader
+	topic     string    // path to directory which holds *.slab files
+	base      uint64    // address of first message in current slab file e.g. <base>.slab
+	version   uint8     // on disk format of current slab file
+	address   uint64    // absolute address of the next message to read
+	last      uint64    // address of the frame of the message last returned
+	end       uint64    // stop reading at this address, 0 means unbounded
+	msg       message   // the message most recently returned by Read
+	pending   []message // rest of a compressed batch still to be returned
+	maxSize   uint32    // refuse payloads larger than this, 0 means unlimited
+	prealloc  bool      // current slab may hold zeros past its logical end
+	holes     []hole    // punched out of the current slab, see PunchHole
+	follow    bool      // block at the end of the log, see SetFollow
+	watch     *os.File  // notified of appends in follow mode, nil to poll
+	events    []byte    // buffer for draining watch
+	scratch   []byte    // frame buffer reused by ReadInto
+	bufSize   int       // bufio buffer size, see SetBufferSize
+	readAhead bool      // tune for sequential scans, see SetReadAhead
+	mmapRead  bool      // map sealed slabs, see SetMmap
+	mapped    []byte    // map of the current slab, nil to read through rd
+	moff      int       // read position within mapped
+	inflated  bool      // mapped holds a compressed slab inflated into memory
+	fp        *os.File
+	rd        *bufio.Reader
+
+	committed bool            // skip messages of open or aborted transactions
+	txns      map[uint64]bool // outcome of transactions found by looking ahead
+	ahead     *Reader         // reads ahead for transaction markers
+	aheadFrom uint64          // ahead has seen every marker after this address
+	lookahead bool            // this is the ahead Reader of another Reader
+
+	byteLimit *tokenBucket // optional bytes/sec cap, see SetRateLimit
+	msgLimit  *tokenBucket // optional messages/sec cap, see SetRateLimit
+	onCorrupt CorruptFunc  // skips corrupt frames, see SetSkipCorrupt
+	tier      *tier        // fetches archived slabs, see SetTieredStorage
 }
 
-// Seek sets up Reader file pointer, bufio reader, for a given absoulute log address
+// Seek sets up Reader file pointer, bufio reader, for a given absoulute log address.
+// An address before the low watermark returns ErrAddressTruncated with the
+// Reader at the oldest message left, see LowWatermark.
 func (rd *Reader) Seek(topic string, address uint64) error {
 	// close any existing file pointer
+	rd.unmapSlab()
 	if rd.fp != nil {
 		rd.fp.Close()
 	}
 
-	slabs := SlabFiles(rd.topic)
+	slabs := rd.slabs()
 
 	// error if there are no .slab files found
 	if len(slabs) <= 0 {
 		return ErrInvalidTopic
 	}
 
-	// sequentially search through all slab files until one contains offset
-	// assumes fixed style slab file name e.g. "< 20 characters >.slab"
-	slabFile := slabs[0]
-	for i := 0; i < len(slabs); i++ {
-		basename := slabs[i][(len(slabs[i]) - 25):(len(slabs[i]) - 5)]
-		d, _ := strconv.Atoi(basename)
-		if address < uint64(d) {
-			break
-		}
-		slabFile = slabs[i]
-		rd.base = uint64(d)
+	// binary search the slab files for the last one starting at or before
+	// address
+	i, base := findSlab(slabs, address)
+	slabFile := slabs[i]
+	rd.base = base
+
+	// anything before the oldest slab was deleted by retention, the Reader
+	// is left at the low watermark and address 0 simply means to start there
+	truncated := i == 0 && address < base && address != 0
+	if address < base {
+		address = base
 	}
 
-	// open file
+	// open file, fetching it first if it was archived
+	if rd.tier != nil {
+		err := rd.tier.fetch(slabFile)
+		if err != nil {
+			return err
+		}
+	}
 	fp, err := os.OpenFile(slabFile, os.O_RDONLY, 0600)
 	if err != nil {
 		return err
 	}
 	rd.fp = fp
+	rd.pending = nil
 
-	// check out of bounds
+	// detect slab format, messages start after any slab header
+	version, hdrLen, err := slabVersion(rd.fp)
+	if err != nil {
+		return err
+	}
+	rd.version = version
+	rd.prealloc = slabFlags(rd.fp)&slabPreallocated != 0
+
+	offset := address - rd.base
+	if offset < hdrLen {
+		offset = hdrLen
+	}
+
+	// an address in a hole moves on to the first message after it
+	rd.holes, err = readSlabHoles(slabFile)
+	if err != nil {
+		return err
+	}
+	if end, ok := holeEnd(rd.holes, offset); ok {
+		offset = end
+	}
+
+	// a compressed slab is inflated into memory and read from there
 	stat, _ := rd.fp.Stat()
-	if (address - rd.base) > uint64(stat.Size()) {
-		return ErrOutOfBounds
+	size := stat.Size()
+	if slabFlags(rd.fp)&slabCompressed != 0 {
+		data, err := inflateSlab(rd.fp)
+		if err != nil {
+			return err
+		}
+		rd.mapped, rd.inflated = data, true
+		size = int64(len(data))
 	}
 
-	// check if end of log
-	if (address - rd.base) == uint64(stat.Size()) {
-		// new buffered reader at begginning of fp
-		rd.rd = bufio.NewReader(rd.fp)
-		return ErrEndOfLog
+	// check out of bounds
+	if offset > uint64(size) {
+		return ErrOutOfBounds
 	}
 
 	// seek file cursor to offset
-	offset := int64(rd.base - address)
-	_, err = rd.fp.Seek(offset, os.SEEK_SET)
+	_, err = rd.fp.Seek(int64(offset), os.SEEK_SET)
 	if err != nil {
 		return err
 	}
+	rd.address = rd.base + offset
 
 	// new buffered reader at the cursor location of fp
-	rd.rd = bufio.NewReader(rd.fp)
+	rd.buffer(size)
+	if rd.inflated {
+		rd.moff = int(offset)
+	} else {
+		rd.mapSlab(size)
+	}
+
+	if truncated {
+		return ErrAddressTruncated
+	}
+
+	// check if end of log
+	if offset == uint64(size) {
+		return ErrEndOfLog
+	}
 
 	return nil
 }
 
 // NewReader returns a new Reader starting at the specified topic and address
-func NewReader(topic string, address uint64) (*Reader, error) {
-	rd := &Reader{topic: topic}
+func NewReader(topic string, address uint64, opts ...Option) (*Reader, error) {
+	o := defaultOptions(opts)
+	rd := &Reader{topic: topic, end: o.EndAddress, maxSize: o.MaxRecordSize, committed: o.ReadCommitted}
+	rd.SetRateLimit(o.RateLimit)
+	rd.SetFollow(o.Follow)
+	rd.bufSize, rd.readAhead, rd.mmapRead = o.ReadBufferSize, o.ReadAhead, o.MmapRead
+	rd.SetSkipCorrupt(o.SkipCorrupt)
+	rd.SetTieredStorage(o.Fetcher, o.TierCache)
 
 	err := rd.Seek(topic, address)
 	if err != nil {
@@ -111,171 +219,1044 @@ func NewReader(topic string, address uint64) (*Reader, error) {
 	return rd, nil
 }
 
-// TODO: possibly optimize by having caller pass in a buffer reference?
-//       also need to give user the address so they can keep track of it
-// returns single messages sequentially
+// Clone returns a new Reader with the same settings positioned at the same
+// message, even part way through a compressed batch.  The clone has its own
+// file handle and cursor so the two may be used from different goroutines.
+func (rd *Reader) Clone() (*Reader, error) {
+	c := &Reader{
+		topic:     rd.topic,
+		end:       rd.end,
+		maxSize:   rd.maxSize,
+		committed: rd.committed,
+		bufSize:   rd.bufSize,
+		readAhead: rd.readAhead,
+		mmapRead:  rd.mmapRead,
+		onCorrupt: rd.onCorrupt,
+		tier:      rd.tier,
+	}
+	var limit RateLimit
+	if rd.byteLimit != nil {
+		limit.BytesPerSec = rd.byteLimit.rate
+	}
+	if rd.msgLimit != nil {
+		limit.MessagesPerSec = rd.msgLimit.rate
+	}
+	c.SetRateLimit(limit)
+	c.SetFollow(rd.follow)
+
+	err := c.Seek(rd.topic, rd.address)
+	if err != nil && err != ErrEndOfLog {
+		c.Close()
+		return nil, err
+	}
+	c.last, c.msg = rd.last, rd.msg
+	c.pending = append([]message(nil), rd.pending...)
+	if rd.txns != nil {
+		c.txns = make(map[uint64]bool, len(rd.txns))
+		for id, committed := range rd.txns {
+			c.txns[id] = committed
+		}
+	}
+
+	return c, nil
+}
+
+// SetRateLimit caps how fast Read returns messages.  Read blocks as needed
+// to stay under the limit.  A zero RateLimit removes any existing limit.
+func (rd *Reader) SetRateLimit(limit RateLimit) {
+	rd.byteLimit, rd.msgLimit = nil, nil
+	if limit.BytesPerSec > 0 {
+		rd.byteLimit = newTokenBucket(limit.BytesPerSec)
+	}
+	if limit.MessagesPerSec > 0 {
+		rd.msgLimit = newTokenBucket(limit.MessagesPerSec)
+	}
+}
+
+// throttle blocks until reading n more bytes stays within the rate limit,
+// or until ctx is done
+func (rd *Reader) throttle(ctx context.Context, n int) error {
+	var wait time.Duration
+	if rd.byteLimit != nil {
+		if d := rd.byteLimit.take(float64(n)); d > wait {
+			wait = d
+		}
+	}
+	if rd.msgLimit != nil {
+		if d := rd.msgLimit.take(1); d > wait {
+			wait = d
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetEndAddress bounds the Reader so Read returns ErrEndOfLog once it reaches
+// address, even if more data exists beyond it.  Zero means unbounded.
+func (rd *Reader) SetEndAddress(address uint64) {
+	rd.end = address
+}
+
+// SetMaxRecordSize makes Read skip any message whose declared length, which
+// includes its key and headers, is larger than size bytes returning
+// ErrRecordTooLarge instead of allocating a buffer for it.  Zero means
+// unlimited.
+func (rd *Reader) SetMaxRecordSize(size uint32) {
+	rd.maxSize = size
+}
+
+// Read returns single messages sequentially, see ReadRecord for their
+// addresses and ReadInto to reuse a buffer instead of allocating each one.
+//
+// Read is stable against a concurrent Writer, even one in another process:
+// it checks the slab size before consuming a frame and never returns a frame
+// whose header and payload are not entirely on disk.  A partially flushed
+// frame is left unread and reported as ErrEndOfLog so a later Read can try
+// again once the Writer has flushed the rest of it, or in follow mode waits
+// for it.
 func (rd *Reader) Read() ([]byte, error) {
-	var dlen, xx32 uint32
-	buf := make([]byte, 4)
-
-	// read 4 bytes length
-	for cnt := 0; cnt < 4; {
-		rx, err := rd.rd.Read(buf[cnt:])
-		if err == io.EOF {
-			offset, _ := rd.fp.Seek(0, os.SEEK_CUR)
-			//TODO test this reader changing slab file code, seems brittle
-			// issues with reader outpacing writer?? file locks? ugh?
-			rd.base += uint64(offset)
-			err := rd.Seek(rd.topic, rd.base)
+	return rd.read(context.Background(), false)
+}
+
+// ReadInto is like Read but copies the message into buf and returns its
+// length, so a consumer which reuses buf does not allocate per message.  If
+// buf is too small the message is left unread and its length is returned
+// with io.ErrShortBuffer, so it can be read again into a larger buf.  Key and
+// Headers are only valid until the next ReadInto.
+func (rd *Reader) ReadInto(buf []byte) (int, error) {
+	batched := len(rd.pending) > 0
+	d, err := rd.read(context.Background(), true)
+	if err != nil {
+		return 0, err
+	}
+	if len(d) > len(buf) {
+		err = rd.unread(batched)
+		if err != nil {
+			return 0, err
+		}
+		return len(d), io.ErrShortBuffer
+	}
+	return copy(buf, d), nil
+}
+
+// next returns the next message, or ErrEndOfLog if there is none yet.  With
+// reuse the frame is read into scratch, which the message then aliases.  A
+// message held back by the rate limit until ctx is done is put back.
+func (rd *Reader) next(ctx context.Context, reuse bool) ([]byte, error) {
+	// finish returning any compressed batch first
+	if len(rd.pending) > 0 {
+		rd.msg, rd.pending = rd.pending[0], rd.pending[1:]
+		err := rd.throttle(ctx, len(rd.msg.value))
+		if err != nil {
+			rd.unread(true)
+			return nil, err
+		}
+		return rd.msg.value, nil
+	}
+
+	err := rd.skipHole()
+	if err != nil {
+		return nil, err
+	}
+
+	// stop at the end of a bounded replay
+	if rd.end > 0 && rd.address >= rd.end {
+		return nil, ErrEndOfLog
+	}
+
+	// find how many bytes are on disk, rolling over to the next slab file
+	// once the current one is used up
+	var avail uint64
+	for {
+		size, err := rd.slabSize()
+		if err != nil {
+			return nil, err
+		}
+		avail = rd.base + uint64(size) - rd.address
+		if avail > 0 {
+			break
+		}
+		//TODO test this reader changing slab file code, seems brittle
+		// issues with reader outpacing writer?? file locks? ugh?
+		err = rd.roll()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// read header once it is all on disk
+	n := uint64(maxFrameHeaderSize)
+	if avail < n {
+		n = avail
+	}
+	peek, err := rd.peek(int(n))
+	if err != nil {
+		return nil, rd.rewind(err)
+	}
+	fh, err := decodeFrameHeader(rd.version, peek)
+	if err == io.ErrUnexpectedEOF {
+		return nil, ErrEndOfLog
+	} else if err != nil {
+		if rd.unwritten(1) {
+			return nil, rd.rewind(ErrEndOfLog)
+		}
+		if rd.punched() {
+			return rd.next(ctx, reuse)
+		}
+		return nil, err
+	}
+	rd.discard(fh.size)
+	flen := uint64(fh.size) + uint64(fh.dlen)
+
+	// leave a partially flushed payload for a later Read, unless the slab is
+	// sealed in which case the length must be corrupt.  Either way a bogus
+	// length never gets as far as allocating a buffer.
+	if avail < flen {
+		if rd.sealed() {
+			// the writer flushes a slab before rolling so its size is final
+			// once a newer slab exists, check again in case it just rolled
+			size, err := rd.slabSize()
 			if err != nil {
 				return nil, err
 			}
-			continue
-		} else if err != nil {
-			return nil, err
+			if rd.base+uint64(size)-rd.address < flen {
+				return nil, ErrBadHeader
+			}
 		}
-		cnt += rx
+		return nil, rd.rewind(ErrEndOfLog)
 	}
-	dlen = binary.LittleEndian.Uint32(buf)
 
-	// read 4 bytes crc
-	for cnt := 0; cnt < 4; {
-		rx, err := rd.rd.Read(buf[cnt:])
+	// skip over a message larger than the sanity limit without allocating it
+	if rd.maxSize > 0 && fh.dlen > rd.maxSize {
+		_, err = rd.discard(int(fh.dlen))
 		if err != nil {
-			return nil, err
+			return nil, rd.rewind(err)
 		}
-		cnt += rx
+		rd.address += flen
+		return nil, ErrRecordTooLarge
 	}
-	xx32 = binary.LittleEndian.Uint32(buf)
 
 	// read data payload
-	buf = make([]byte, dlen)
-	for cnt := 0; uint32(cnt) < dlen; {
-		rx, err := rd.rd.Read(buf[cnt:])
-		if err != nil {
-			return nil, err
+	var buf []byte
+	if reuse && cap(rd.scratch) >= int(fh.dlen) {
+		buf = rd.scratch[:fh.dlen]
+	} else {
+		buf = make([]byte, fh.dlen)
+		if reuse {
+			rd.scratch = buf
 		}
-		cnt += rx
+	}
+	err = rd.readFull(buf)
+	if err != nil {
+		return nil, rd.rewind(err)
 	}
 
 	// check crc
-	if xx32 != xxhash.Checksum32(buf) {
+	ok := fh.xx32 == xxhash.Checksum32(buf)
+	if !ok && rd.unwritten(flen) {
+		return nil, rd.rewind(ErrEndOfLog)
+	}
+	if !ok && rd.punched() {
+		return rd.next(ctx, reuse)
+	}
+	rd.last = rd.address
+	rd.address += flen
+	if !ok {
 		return buf, ErrBadChecksum
 	}
 
-	return buf, nil
+	err = decodeBody(fh.version, buf, &rd.msg)
+	if err != nil {
+		return buf, err
+	}
+
+	// transaction markers are never returned, in committed mode neither are
+	// messages of a transaction until it is known to have committed
+	if rd.msg.control != 0 {
+		rd.resolve(&rd.msg)
+		return rd.next(ctx, reuse)
+	}
+	if rd.committed && rd.msg.txn != 0 {
+		committed, err := rd.outcome(rd.msg.txn, rd.address-flen)
+		if err == ErrEndOfLog {
+			rd.address -= flen
+			return nil, rd.rewind(err)
+		} else if err != nil {
+			return nil, err
+		}
+		if !committed {
+			return rd.next(ctx, reuse)
+		}
+	}
+
+	// expand a compressed batch and return its messages one at a time
+	if rd.msg.codec != 0 {
+		max := uint32(maxBatchSize)
+		if rd.maxSize > 0 {
+			max = rd.maxSize
+		}
+		rd.pending, err = decodeBatch(&rd.msg, max)
+		if err != nil {
+			return nil, err
+		}
+		return rd.next(ctx, reuse)
+	}
+
+	err = rd.throttle(ctx, len(rd.msg.value))
+	if err != nil {
+		if uerr := rd.unread(false); uerr != nil {
+			return nil, uerr
+		}
+		return nil, err
+	}
+
+	return rd.msg.value, nil
+}
+
+// ReadRecord is like Read but returns the message as a Record, including its
+// address and the address to resume from after it, e.g. with NewReader.
+// Every message of a compressed batch has the address of the batch, which is
+// also where to resume from until its last message, so resuming part way
+// through a batch returns its earlier messages again.  The Record's slices
+// are only valid until the next read.
+func (rd *Reader) ReadRecord() (Record, error) {
+	return rd.readRecord(context.Background())
+}
+
+// readRecord is ReadRecord giving up once ctx is done
+func (rd *Reader) readRecord(ctx context.Context) (Record, error) {
+	d, err := rd.read(ctx, false)
+	if d == nil {
+		return Record{}, err
+	}
+	return rd.record(rd.last, rd.resume(), d), err
+}
+
+// resume returns the address to resume from after the message last returned
+func (rd *Reader) resume() uint64 {
+	if len(rd.pending) > 0 {
+		return rd.last
+	}
+	return rd.address
+}
+
+// ReadAt returns the message whose frame starts at address, e.g. one returned
+// by ReadRecord, without moving the Reader's cursor.  The frame is checked
+// against its checksum like any other, and an address which is not the start
+// of a frame is reported as ErrBadHeader or ErrBadChecksum.  For a compressed
+// batch the first message of the batch is returned.
+func (rd *Reader) ReadAt(address uint64) ([]byte, error) {
+	rec, err := rd.ReadRecordAt(address)
+	return rec.Value, err
+}
+
+// ReadRecordAt is like ReadAt but returns the message as a Record
+func (rd *Reader) ReadRecordAt(address uint64) (Record, error) {
+	at := &Reader{topic: rd.topic, maxSize: rd.maxSize, committed: rd.committed}
+	defer at.Close()
+
+	err := at.Seek(rd.topic, address)
+	if err != nil {
+		return Record{}, err
+	}
+	if at.address != address {
+		return Record{}, ErrOutOfBounds
+	}
+
+	// read exactly one frame, never a later one past a marker
+	at.end = address + 1
+	return at.ReadRecord()
+}
+
+// Timestamp returns when the message most recently returned by Read was
+// written, or the zero Time for messages in slabs older than FormatV2.
+func (rd *Reader) Timestamp() time.Time {
+	if rd.msg.timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, rd.msg.timestamp)
+}
+
+// Key returns the key of the message most recently returned by Read, or nil
+// if it was written without one.
+func (rd *Reader) Key() []byte {
+	return rd.msg.key
+}
+
+// Headers returns the metadata headers of the message most recently returned
+// by Read, or nil if it was written without any.
+func (rd *Reader) Headers() []Header {
+	return rd.msg.headers
+}
+
+// roll moves on from the end of the current slab to the next one.  That
+// usually starts right where the current one ends, but a compacted slab ends
+// short of the next so then it is found by name.
+func (rd *Reader) roll() error {
+	err := rd.Seek(rd.topic, rd.address)
+	if err != ErrEndOfLog && err != ErrOutOfBounds {
+		return err
+	}
+	slabs := rd.slabs()
+	i, _ := findSlab(slabs, rd.address)
+	if i+1 >= len(slabs) {
+		return err
+	}
+	next, _ := slabBase(slabs[i+1])
+	return rd.Seek(rd.topic, next)
+}
+
+// sealed reports whether a newer slab than the current one exists
+func (rd *Reader) sealed() bool {
+	slabs := rd.slabs()
+	if len(slabs) <= 0 {
+		return false
+	}
+	base, err := slabBase(slabs[len(slabs)-1])
+	return err == nil && base > rd.base
+}
+
+// rewind moves the file cursor back to the start of the unread frame.  A
+// short read despite the size check means the slab shrank underneath us,
+// which is also reported as the end of the log.
+func (rd *Reader) rewind(err error) error {
+	if rd.mapped != nil {
+		rd.moff = int(rd.address - rd.base)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrEndOfLog
+		}
+		return err
+	}
+
+	_, serr := rd.fp.Seek(int64(rd.address-rd.base), os.SEEK_SET)
+	if serr != nil {
+		return serr
+	}
+	rd.rd.Reset(rd.fp)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrEndOfLog
+	}
+	return err
 }
 
 // cleanup Reader
 func (rd *Reader) Close() error {
+	if rd.ahead != nil {
+		rd.ahead.Close()
+	}
+	if rd.watch != nil {
+		rd.watch.Close()
+	}
+	rd.unmapSlab()
+	if rd.fp == nil {
+		return nil
+	}
 	return rd.fp.Close()
 }
 
 // Writer implements Append Only Log functionality for a bufio.Writer object.
 type Writer struct {
-	topic        string   // path to directory which holds *.slab files
-	address      uint64   // absolute address of whole log in bytes
-	base         uint64   // absolute offset of current slab file e.g. <base>.slab
-	fp           *os.File // file pointer for writing to log address
+	topic        string     // path to directory which holds *.slab files
+	lock         *os.File   // exclusive lock on the topic, see lockTopic
+	naming       SlabNaming // how slab files are named, see WithSlabNaming
+	address      uint64     // absolute address of whole log in bytes
+	base         uint64     // absolute offset of current slab file e.g. <base>.slab
+	fp           *os.File   // file pointer for writing to log address
 	wt           *bufio.Writer
-	slabSizeHint uint64 // once a slab exceeds this size roll a fresh one
-	sync.Mutex          // mutex to lock while writing to log address
+	slabSizeHint uint64      // once a slab exceeds this size roll a fresh one
+	indexEvery   uint64      // bytes of frames between index entries, see WithIndexInterval
+	version      uint8       // on disk format for newly created slabs, at most FormatLatest
+	slabVersion  uint8       // on disk format of the current slab
+	varint       bool        // write compact frames with uvarint lengths
+	codec        Codec       // compress each WriteBatch, nil for none
+	maxSize      uint32      // reject messages larger than this, 0 means unlimited
+	bufSize      int         // size of bufio buffer, 0 for the default
+	mode         os.FileMode // permissions of new slab files
+	prealloc     bool        // preallocate new slabs, see WithPreallocate
+	direct       bool        // bypass the page cache, see WithDirectIO
+	mmap         bool        // write through a memory map, see WithMmap
+	mm           *mmapFile   // mapping of the current slab if mmap is set
+	slabPrealloc bool        // the current slab has zeros past its logical end
+
+	syncPolicy       SyncPolicy    // when to fsync, see SetSyncPolicy
+	syncStop         chan struct{} // closed to stop the SyncInterval goroutine
+	unsyncedMessages uint64        // messages appended since the last fsync
+	unsyncedBytes    uint64        // bytes appended since the last fsync
+
+	maxAge        time.Duration   // roll slabs older than this, see WithMaxSegmentAge
+	slabStart     int64           // when the first message of the current slab was written, 0 if none
+	retention     RetentionPolicy // which sealed slabs to delete, see SetRetention
+	retentionStop chan struct{}   // closed to stop the retention goroutine
+	pooled        bool            // a Manager runs the sync and retention timers
+
+	groupCommit bool              // Write waits for a shared fsync, see SetGroupCommit
+	appendSeq   uint64            // messages appended since the Writer was opened
+	syncedSeq   uint64            // appendSeq as of the last completed fsync
+	syncing     bool              // a group commit fsync is in progress
+	compressing bool              // compressLoop is running
+	recompress  bool              // slabs rolled since compressLoop last looked
+	synced      *sync.Cond        // broadcast when syncedSeq advances
+	txnSeq      uint64            // id of the most recently begun transaction
+	openTxns    map[uint64]bool   // transactions begun but not yet ended
+	producers   map[uint64]uint64 // last sequence per producer, nil until needed
+	snapshotted bool              // the current slab has a .producers sidecar
+	count       uint64            // messages written to the current slab
+	counted     bool              // false if count is unknown e.g. slab was loaded
+	index       []indexEntry      // sparse index of the current slab if counted
+	times       []timeEntry       // sparse time index of the current slab if counted
+	lastFrame   timeEntry         // the most recently appended frame
+	minTime     int64             // earliest timestamp in the current slab if counted
+	maxTime     int64             // latest timestamp in the current slab if counted
+	sync.Mutex                    // guards every field above once the Writer is shared
+
+	quota      quota          // write rate limit, see SetQuota
+	slabCodec  Codec          // compress sealed slabs, see WithSegmentCompression
+	compressed sync.WaitGroup // done when compressLoop finishes
 }
 
-// return names of all slab files present in wt.topic
+// SlabFiles returns the paths of every slab file in topic ordered by base
+// address, files not named as the topic's SlabNaming says e.g. <base>.slab
+// are ignored
 func SlabFiles(topic string) []string {
-	files, err := filepath.Glob(topic + "/*.slab")
+	dir, err := os.Open(topic)
+	if err != nil {
+		return nil
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
 	if err != nil {
 		log.Panic(err)
 	}
-	return files
+
+	naming := topicNaming(topic)
+	slabs := make([]string, 0, len(names))
+	bases := make(map[string]uint64, len(names))
+	for _, name := range names {
+		base, ok := naming.parse(name)
+		if !ok {
+			continue
+		}
+		file := filepath.Join(topic, name)
+		slabs = append(slabs, file)
+		bases[file] = base
+	}
+	sort.SliceStable(slabs, func(i, j int) bool { return bases[slabs[i]] < bases[slabs[j]] })
+	return slabs
 }
 
-// load and validate *.slab files from wt.topic
+// load and validate *.slab files from wt.topic, caller must hold the lock
 func (wt *Writer) load() {
-	files, err := filepath.Glob(wt.topic + "/*.slab")
+	files := SlabFiles(wt.topic)
+	latest := files[len(files)-1]
+
+	// the latest slab is appended to so it cannot stay compressed
+	err := decompressSlab(latest)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	latest := files[len(files)-1]
-
 	// open slab file with highest log address in name
-	fp, err := os.OpenFile(latest, os.O_APPEND|os.O_RDWR, 0600)
+	fp, err := os.OpenFile(latest, os.O_RDWR, 0600)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	// the absolute address is (biggest segment name + biggest segment size)
+	// keep appending in whatever format the latest slab was written in
+	var hdrLen uint64
+	wt.slabVersion, hdrLen, err = slabVersion(fp)
+	if err != nil {
+		log.Panic(err)
+	}
+	wt.slabPrealloc = slabFlags(fp)&slabPreallocated != 0
+
+	// the absolute address is the biggest segment name plus the logical end
+	// of its slab, which may be short of its size if the slab was
+	// preallocated or ends with a frame torn by a crash
 	stat, _ := fp.Stat()
-	i, _ := strconv.Atoi(stat.Name()[:len(stat.Name())-5])
-	wt.base = uint64(i)
-	wt.address = wt.base + uint64(stat.Size())
+	wt.base, _ = slabBase(latest)
+	end := wt.loadEnd(latest, hdrLen, stat.Size())
+	if wt.slabPrealloc {
+		err = wt.reclaim(fp, end)
+	} else if end < stat.Size() {
+		err = recoverTail(fp, end)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	err = wt.setFile(fp, end)
+	if err != nil {
+		log.Panic(err)
+	}
+	wt.address = wt.base + uint64(end)
+
+	// the age of the slab is that of its first message
+	wt.slabStart = wt.firstTimestamp(latest)
+
+	// messages already in the slab are unknown, CountMessages rebuilds them
+	// along with its index
+	wt.counted, wt.index, wt.times = false, nil, nil
+	wt.minTime, wt.maxTime = 0, 0
+}
+
+// setFile makes fp the current slab with the next frame written at offset
+// end, caller must hold the lock
+func (wt *Writer) setFile(fp *os.File, end int64) error {
+	wt.unmap()
+
+	// direct I/O and memory maps write zeros past the last frame so they
+	// need a slab flagged as having them
+	if wt.direct && wt.slabPrealloc {
+		df, err := openDirect(wt.slabPath(wt.base), end)
+		if err != nil {
+			return err
+		}
+		fp.Close()
+		wt.fp = df.fp
+		wt.wt = bufio.NewWriterSize(df, wt.bufSize)
+		return nil
+	}
+	if wt.mmap && wt.slabPrealloc {
+		mf, err := openMmap(fp, end, int64(wt.slabSizeHint))
+		if err != nil {
+			return err
+		}
+		wt.fp = fp
+		wt.mm = mf
+		wt.wt = bufio.NewWriterSize(mf, wt.bufSize)
+		return nil
+	}
+
+	_, err := fp.Seek(end, os.SEEK_SET)
+	if err != nil {
+		return err
+	}
 	wt.fp = fp
-	wt.wt = bufio.NewWriter(wt.fp)
-	wt.Flush()
+	wt.wt = bufio.NewWriterSize(wt.fp, wt.bufSize)
+	return nil
 }
 
-// create a new log slab in wt.topic
+// create a new log slab in wt.topic, caller must hold the lock
 func (wt *Writer) create() error {
 	// create topic if necessary
-	err := os.MkdirAll(wt.topic, 0700)
+	err := os.MkdirAll(wt.topic, dirMode(wt.mode))
 	if err != nil {
 		return err
 	}
 
-	// create a new slab file
-	fname := fmt.Sprintf("%s/%020d.slab", wt.topic, wt.address)
+	// create a new slab file under a temporary name
+	fname := wt.slabPath(wt.address)
 	wt.base = wt.address
 
-	fp, err := os.OpenFile(fname, os.O_CREATE|os.O_RDWR, 0600)
+	fp, err := os.OpenFile(fname+".tmp", os.O_CREATE|os.O_TRUNC|os.O_RDWR, wt.mode)
 	if err != nil {
 		return err
 	}
 
-	// TODO trunc or hints depending on size to prealloc ext4/xfs etc?
-	// could possibly optimize this here for sequential writes etc...
-	// Don't truncate for now as it confuses finding address on a new file
-	// fp.Truncate(int64(wt.slabSizeHint))
-	wt.fp = fp
-	wt.wt = bufio.NewWriter(wt.fp)
-	wt.Flush()
+	// stamp the slab with its format, the header occupies log address space
+	// and must be on disk before a concurrent Reader can see the slab
+	wt.slabVersion = wt.version
+	wt.slabPrealloc = (wt.prealloc || wt.direct || wt.mmap) && wt.version >= FormatV3
+	hdr := slabHeader(wt.version)
+	if wt.slabPrealloc {
+		hdr[5] |= slabPreallocated
+	}
+	if wt.slabPrealloc && wt.prealloc {
+		err = preallocate(fp, int64(wt.slabSizeHint))
+		if err != nil {
+			fp.Close()
+			return err
+		}
+	}
+	_, err = fp.Write(hdr)
+	if err == nil {
+		err = writeSlabMeta(fname, slabMeta{base: wt.base, end: uint64(len(hdr))})
+	}
+	if err == nil {
+		err = os.Rename(fname+".tmp", fname)
+	}
+	if err == nil {
+		// make the new slab name, and any sealed slab's sidecar, durable
+		err = syncDir(wt.topic)
+	}
+	if err != nil {
+		fp.Close()
+		return err
+	}
+
+	err = wt.setFile(fp, int64(len(hdr)))
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	wt.address += uint64(len(hdr))
+	wt.count, wt.counted, wt.index, wt.times = 0, true, nil, nil
+	wt.minTime, wt.maxTime = 0, 0
+	wt.slabStart = 0
 
 	return nil
 }
 
 // NewWriter returns a Writer after creating a topic or seeking address properly
-func NewWriter(topic string, slabSizeHint uint64) (*Writer, error) {
+// New slabs are created in FormatLatest so message headers are checksummed,
+// unless WithFormat says otherwise.  An existing slab is always appended to in
+// the format it was created with, and an existing topic keeps the SlabNaming
+// it was created with.  A new topic records slabSizeHint and its options in
+// a TopicConfig which later Writers start from, see ReadTopicConfig, a zero
+// slabSizeHint then meaning the recorded one.
+func NewWriter(topic string, slabSizeHint uint64, opts ...Option) (*Writer, error) {
+	return newWriter(topic, slabSizeHint, nil, opts)
+}
+
+// newWriter returns a Writer like NewWriter with defaults applied before
+// the topic's recorded configuration and opts after it
+func newWriter(topic string, slabSizeHint uint64, defaults, opts []Option) (*Writer, error) {
+	given := opts
+	var stored []Option
+	c, err := ReadTopicConfig(topic)
+	if err == nil {
+		stored, err = c.options()
+		if err != nil {
+			return nil, err
+		}
+		if slabSizeHint == 0 {
+			slabSizeHint = c.SegmentSize
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	opts = append(append(append([]Option{}, defaults...), stored...), opts...)
+
+	o := defaultOptions(opts)
+	if o.Format > FormatLatest {
+		return nil, ErrBadFormat
+	}
+	if (o.Preallocate || o.DirectIO || o.Mmap) && o.Format < FormatV3 {
+		return nil, ErrOldFormat
+	}
+
 	var wt *Writer
-	wt = &Writer{slabSizeHint: slabSizeHint}
+	wt = &Writer{
+		slabSizeHint: slabSizeHint,
+		indexEvery:   uint64(o.IndexInterval),
+		maxAge:       o.MaxSegmentAge,
+		version:      o.Format,
+		varint:       o.VarintLength,
+		codec:        o.Codec,
+		maxSize:      o.MaxRecordSize,
+		bufSize:      o.BufferSize,
+		mode:         o.FileMode,
+		prealloc:     o.Preallocate,
+		direct:       o.DirectIO,
+		mmap:         o.Mmap,
+		slabCodec:    o.SegmentCodec,
+	}
 
 	wt.topic = topic
+	wt.synced = sync.NewCond(&wt.Mutex)
+	wt.quota.set(o.Quota, o.RejectOverQuota)
+	wt.groupCommit = o.GroupCommit
+
+	// the slabs of a partitioned topic are in its partitions
+	if n, _ := Partitions(topic); n > 0 && len(SlabFiles(topic)) == 0 {
+		return nil, ErrPartitioned
+	}
+
+	wt.Lock()
+	defer wt.Unlock()
+
+	// only one Writer may append to a topic at a time
+	lock, err := lockTopic(topic, o.FileMode, o.StealLock)
+	if err != nil {
+		return nil, err
+	}
+	wt.lock = lock
 
 	if len(SlabFiles(wt.topic)) == 0 {
 		// create a new topic
-		wt.create()
+		err := wt.createNaming(o.SlabNaming)
+		if err == nil {
+			err = writeTopicConfig(wt.topic, createdConfig(slabSizeHint, o, defaults, given))
+		}
+		if err == nil {
+			err = wt.create()
+		}
+		if err != nil {
+			unlockTopic(lock)
+			return nil, err
+		}
 	} else {
 		// load existing topic with cursor at the end of the highest address file
+		wt.naming = topicNaming(wt.topic)
 		wt.load()
 	}
 
+	wt.pooled = o.pooled
+	wt.syncPolicy = o.SyncPolicy
+	if o.SyncPolicy.Interval > 0 && !wt.pooled {
+		wt.syncStop = make(chan struct{})
+		go wt.syncLoop(o.SyncPolicy.Interval, wt.syncStop)
+	}
+
+	wt.retention = o.Retention
+	if o.Retention.Interval > 0 && !wt.pooled {
+		wt.retentionStop = make(chan struct{})
+		go wt.retentionLoop(o.Retention.Interval, wt.retentionStop)
+	}
+
 	return wt, nil
 }
 
+// NewWriterFormat returns a Writer like NewWriter which creates any new slabs
+// in the given on disk format version.
+func NewWriterFormat(topic string, slabSizeHint uint64, version uint8) (*Writer, error) {
+	return NewWriter(topic, slabSizeHint, WithFormat(version))
+}
+
 func (wt *Writer) Close() error {
-	wt.Flush()
-	return wt.fp.Close()
+	wt.compressed.Wait()
+	wt.Lock()
+	defer wt.Unlock()
+
+	wt.stopSyncLoop()
+	wt.stopRetentionLoop()
+	err := wt.abortAll()
+	if err == nil {
+		err = wt.sync()
+	}
+	if err == nil {
+		err = wt.writeMeta()
+	}
+	wt.unmap()
+	cerr := wt.fp.Close()
+	unlockTopic(wt.lock)
+	if err != nil {
+		return err
+	}
+	return cerr
 }
 
 func (wt *Writer) Write(d []byte) error {
-	var dlen, xx32 uint32
-	buf := make([]byte, 4)
+	return wt.write(context.Background(), &message{value: d})
+}
+
+// WriteKeyed appends a message with a key, which requires FormatV2 or later.
+func (wt *Writer) WriteKeyed(key, value []byte) error {
+	return wt.write(context.Background(), &message{key: key, value: value})
+}
+
+// WriteHeaders appends a message with an optional key and metadata headers,
+// which requires FormatV2 or later.
+func (wt *Writer) WriteHeaders(key, value []byte, headers []Header) error {
+	return wt.write(context.Background(), &message{key: key, value: value, headers: headers})
+}
+
+// write frames and appends a single message unless ctx is done first
+func (wt *Writer) write(ctx context.Context, m *message) error {
+	err := wt.quota.wait(ctx, len(m.value), 1)
+	if err != nil {
+		return err
+	}
 
-	dlen = uint32(len(d))
-	xx32 = xxhash.Checksum32(d)
+	err = wt.lockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer wt.Unlock()
+
+	return wt.writeLocked(ctx, m)
+}
+
+// writeLocked is write for a caller which already holds the lock
+func (wt *Writer) writeLocked(ctx context.Context, m *message) error {
+	// frame the message in whatever format the current slab uses, rolling
+	// first if the slab is too old to take it
+	m.timestamp = time.Now().UnixNano()
+	if wt.aged(m.timestamp) {
+		err := wt.roll()
+		if err != nil {
+			return err
+		}
+	}
+	hdr, d, err := wt.frame(m)
+	if err != nil {
+		return err
+	}
+	if wt.tooLarge(len(d)) {
+		return ErrRecordTooLarge
+	}
+
+	// drop a retry of an idempotent write which was already appended
+	if m.producer != 0 {
+		dup, err := wt.checkSequence(m.producer, m.sequence)
+		if err != nil || dup {
+			return err
+		}
+	}
+
+	// last chance to give up before the log changes
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	// transaction markers are not messages a Reader returns
+	n := uint64(1)
+	if m.control != 0 {
+		n = 0
+	}
+	err = wt.append(hdr, d, n, m.timestamp)
+	if err != nil {
+		return err
+	}
+	if m.producer != 0 {
+		wt.producers[m.producer] = m.sequence
+	}
+
+	return wt.appended()
+}
+
+// WriteBatch appends several messages in one burst under a single lock.  The
+// whole batch always lands in one slab, rolling over to a fresh slab first if
+// it would push a partially filled one past its size hint.  If a Codec has
+// been set the batch is compressed into a single frame.
+func (wt *Writer) WriteBatch(batch [][]byte) error {
+	var total int
+	for _, d := range batch {
+		total += len(d)
+	}
+	err := wt.quota.wait(context.Background(), total, len(batch))
+	if err != nil {
+		return err
+	}
 
 	wt.Lock()
+	defer wt.Unlock()
+
+	timestamp := time.Now().UnixNano()
+	frames := func() ([][]byte, uint64, error) {
+		var size uint64
+		frames := make([][]byte, 0, 2*len(batch))
+		if wt.codec != nil {
+			if wt.slabVersion < FormatV2 {
+				return nil, 0, ErrOldFormat
+			}
+			msgs := make([]message, len(batch))
+			for i, d := range batch {
+				msgs[i] = message{timestamp: timestamp, value: d}
+			}
+			max := uint32(maxBatchSize)
+			if wt.maxSize > 0 {
+				max = wt.maxSize
+			}
+			m, err := encodeBatch(wt.codec, timestamp, msgs, max)
+			if err != nil {
+				return nil, 0, err
+			}
+			hdr, d, err := wt.frame(m)
+			if err != nil {
+				return nil, 0, err
+			}
+			if wt.tooLarge(len(d)) {
+				return nil, 0, ErrRecordTooLarge
+			}
+			return append(frames, hdr, d), uint64(len(hdr) + len(d)), nil
+		}
+		// the whole batch is rejected if any message is too large
+		for _, d := range batch {
+			hdr, d, err := wt.frame(&message{timestamp: timestamp, value: d})
+			if err != nil {
+				return nil, 0, err
+			}
+			if wt.tooLarge(len(d)) {
+				return nil, 0, ErrRecordTooLarge
+			}
+			frames = append(frames, hdr, d)
+			size += uint64(len(hdr) + len(d))
+		}
+		return frames, size, nil
+	}
 
+	f, size, err := frames()
+	if err != nil {
+		return err
+	}
+
+	// roll first unless the slab is empty, re-framing in case the new slab
+	// is in a different format
+	empty := wt.address-wt.base == uint64(len(slabHeader(wt.slabVersion)))
+	if !empty && ((wt.address-wt.base)+size > wt.slabSizeHint || wt.aged(timestamp)) {
+		err = wt.roll()
+		if err != nil {
+			return err
+		}
+		f, _, err = frames()
+		if err != nil {
+			return err
+		}
+	}
+
+	// a compressed frame holds every message of the batch
+	n := uint64(1)
+	if wt.codec != nil {
+		n = uint64(len(batch))
+	}
+	for i := 0; i < len(f); i += 2 {
+		err = wt.append(f[i], f[i+1], n, timestamp)
+		if err != nil {
+			return err
+		}
+	}
+
+	return wt.appended()
+}
+
+// appended applies the sync policy and rolls over a full slab after messages
+// have been appended, caller must hold the lock
+func (wt *Writer) appended() error {
+	err := wt.maybeSync()
+	if err != nil {
+		return err
+	}
+
+	// roll over slab file if it is big enough
+	if (wt.address - wt.base) > wt.slabSizeHint {
+		err = wt.roll()
+		if err != nil {
+			return err
+		}
+	}
+
+	if wt.groupCommit {
+		return wt.commit(wt.appendSeq)
+	}
+
+	return nil
+}
+
+// frame returns the header and payload of a message in the format of the
+// current slab, caller must hold the lock
+func (wt *Writer) frame(m *message) ([]byte, []byte, error) {
+	d, err := encodeBody(wt.slabVersion, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	hdr := encodeFrameHeader(wt.slabVersion, wt.varint, uint32(len(d)), xxhash.Checksum32(d))
+	return hdr, d, nil
+}
+
+// append writes a frame holding n messages written at timestamp to the
+// current slab, caller must hold the lock
+func (wt *Writer) append(hdr, d []byte, n uint64, timestamp int64) error {
 	// FIXME -- make a function like WriteAll() to write until all written
 	// e.g.
 	// for cnt = 0; cnt < len(key); {
@@ -283,45 +1264,175 @@ func (wt *Writer) Write(d []byte) error {
 	//     cnt += tx
 	// }
 
+	wt.indexFrame(timestamp)
+	if wt.slabStart == 0 {
+		wt.slabStart = timestamp
+	}
+
 	// write header
-	binary.LittleEndian.PutUint32(buf, dlen)
-	tx, err := wt.wt.Write(buf)
+	_, err := wt.wt.Write(hdr)
 	if err != nil {
 		return err
 	}
 
-	binary.LittleEndian.PutUint32(buf, xx32)
-	tx, err = wt.wt.Write(buf)
+	// write payload
+	tx, err := wt.wt.Write(d)
 	if err != nil {
 		return err
 	}
 
-	// write payload
-	tx, err = wt.wt.Write(d)
+	wt.advance(uint64(len(hdr)+tx), n)
+	return nil
+}
+
+// advance accounts for a frame of size bytes holding n messages having been
+// written, caller must hold the lock
+func (wt *Writer) advance(size, n uint64) {
+	wt.address = wt.address + size
+	wt.count += n
+	wt.unsyncedMessages += n
+	wt.appendSeq += n
+	wt.unsyncedBytes += size
+}
+
+// aged reports whether a message written at timestamp should go in a fresh
+// slab as the current one is older than the maximum segment age, caller must
+// hold the lock
+func (wt *Writer) aged(timestamp int64) bool {
+	return wt.maxAge > 0 && wt.slabStart != 0 && timestamp-wt.slabStart >= int64(wt.maxAge)
+}
+
+// firstTimestamp returns when the first message of slab was written, 0 if it
+// is empty, or now if that is unknown e.g. before FormatV2
+func (wt *Writer) firstTimestamp(slab string) int64 {
+	start, err := (&Reader{topic: wt.topic}).slabTime(slab)
+	if err == ErrEndOfLog {
+		return 0
+	} else if err != nil || start.IsZero() {
+		return time.Now().UnixNano()
+	}
+	return start.UnixNano()
+}
+
+// roll seals the current slab and starts a fresh one, caller must hold the lock
+func (wt *Writer) roll() error {
+	// a sealed slab is exactly as long as its frames
+	if wt.slabPrealloc {
+		err := wt.trim()
+		if err != nil {
+			return err
+		}
+	}
+
+	// a sealed slab must be durable before anything is written after it
+	err := wt.sync()
 	if err != nil {
 		return err
 	}
+	wt.fp.Close()
 
-	// update address
-	wt.address = wt.address + uint64(8+tx)
-
-	// roll over slab file if it is big enough
-	if (wt.address - wt.base) > wt.slabSizeHint {
-		wt.Flush()
-		wt.fp.Close()
-		wt.create()
+	// record message count and indexes of the sealed slab for CountMessages
+	// and seeking
+	if wt.counted {
+		err = writeSlabCount(wt.slabPath(wt.base), wt.count)
+		if err != nil {
+			return err
+		}
+		err = wt.writeIndexes()
+		if err != nil {
+			return err
+		}
+	}
+	err = wt.writeFooter()
+	if err == nil {
+		err = wt.writeMeta()
+	}
+	if err != nil {
+		return err
 	}
 
-	wt.Unlock()
+	err = wt.rollProducers()
+	if err != nil {
+		return err
+	}
 
+	err = wt.create()
+	if err != nil {
+		return err
+	}
+	wt.compressLoop()
 	return nil
 }
 
 func (wt *Writer) Flush() error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	return wt.flush()
+}
+
+// SetQuota limits how fast messages may be written to the topic, by every
+// goroutine sharing the Writer together.  Writes over the quota wait until
+// they are within it, or fail with ErrQuotaExceeded if reject is set.  A zero
+// RateLimit removes the quota.
+func (wt *Writer) SetQuota(limit RateLimit, reject bool) {
+	wt.quota.set(limit, reject)
+}
+
+// SetMaxRecordSize makes Write and WriteBatch reject any message whose
+// encoded length, which includes its key and headers, is larger than size
+// bytes with ErrRecordTooLarge, the same length a Reader's SetMaxRecordSize
+// limits.  A compressed batch is limited as a whole, both compressed and
+// before.  Zero means unlimited.
+func (wt *Writer) SetMaxRecordSize(size uint32) {
+	wt.Lock()
+	defer wt.Unlock()
+
+	wt.maxSize = size
+}
+
+// tooLarge reports whether a frame body of size bytes is over the maximum
+// record size, caller must hold the lock
+func (wt *Writer) tooLarge(size int) bool {
+	return wt.maxSize > 0 && uint64(size) > uint64(wt.maxSize)
+}
+
+// SetCodec compresses each subsequent WriteBatch with codec, which requires
+// FormatV2 or later.  A nil codec turns compression off.
+func (wt *Writer) SetCodec(codec Codec) {
+	wt.Lock()
+	defer wt.Unlock()
+
+	wt.codec = codec
+}
+
+// SetVarintLength selects compact frames whose length is a uvarint, saving
+// a few bytes per message.  It only applies to slabs of FormatV3 or later,
+// Readers handle either kind of frame transparently.
+func (wt *Writer) SetVarintLength(enabled bool) {
+	wt.Lock()
+	defer wt.Unlock()
+
+	wt.varint = enabled
+}
+
+// flush buffered data to the current slab, caller must hold the lock
+func (wt *Writer) flush() error {
 	return wt.wt.Flush()
 }
 
+// Address returns the absolute log address the next message will be written at
+func (wt *Writer) Address() uint64 {
+	wt.Lock()
+	defer wt.Unlock()
+
+	return wt.address
+}
+
 func (wt *Writer) Status() {
+	wt.Lock()
+	defer wt.Unlock()
+
 	stat, _ := wt.fp.Stat()
 	log.Printf("===================================================\n")
 	log.Printf("Queuefka Log Status\n")
@@ -329,7 +1440,7 @@ func (wt *Writer) Status() {
 	log.Printf("    no of segments   : %d\n", len(SlabFiles(wt.topic)))
 	log.Printf("    total size       : %.1fMB\n", float32(wt.address/1024.0/1024.0))
 	log.Printf("    log directory    : %s\n", wt.topic)
-	log.Printf("    current segment  : %s\n", stat.Name())
+	log.Printf("    current segment  : %s\n", filepath.Base(wt.slabPath(wt.base)))
 	log.Printf("    segment size     : %.1fMB\n", float32((stat.Size() / 1024.0 / 1024.0)))
 	log.Printf("===================================================\n")
 }
@@ -6,9 +6,17 @@ package queuefka_test
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ubergarm/queuefka"
 
@@ -103,6 +111,659 @@ func Test_Queuefka_Sequential(t *testing.T) {
 	wt.Status()
 }
 
+func Test_Queuefka_EndAddress(t *testing.T) {
+	boundedTopic := topic + ".bounded"
+	os.RemoveAll(boundedTopic)
+	defer os.RemoveAll(boundedTopic)
+
+	wt, err := queuefka.NewWriter(boundedTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	var start, end uint64
+	for i := 0; i < 100; i++ {
+		switch i {
+		case 10:
+			start = wt.Address()
+		case 20:
+			end = wt.Address()
+		}
+		wt.Write([]byte(fmt.Sprintf("message %012d", i)))
+	}
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(boundedTopic, start)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	rd.SetEndAddress(end)
+
+	for i := 10; i < 20; i++ {
+		raw, err := rd.Read()
+		if err != nil {
+			panic(err)
+		}
+		if string(raw) != fmt.Sprintf("message %012d", i) {
+			println(string(raw))
+			panic("queuefka: bounded Read returned the wrong message:")
+		}
+	}
+
+	_, err = rd.Read()
+	if err != queuefka.ErrEndOfLog {
+		panic("queuefka: bounded Read did not stop at EndAddress:")
+	}
+}
+
+// run with `go test -race` to check the Writer locking
+func Test_Queuefka_ConcurrentWrite(t *testing.T) {
+	raceTopic := topic + ".race"
+	os.RemoveAll(raceTopic)
+	defer os.RemoveAll(raceTopic)
+
+	// tiny slabs so the writers roll over many times
+	wt, err := queuefka.NewWriter(raceTopic, 1024)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	workers, count := 8, 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				if err := wt.Write(value); err != nil {
+					panic(err)
+				}
+				if i%50 == 0 {
+					wt.Flush()
+					wt.Address()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(raceTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	for i := 0; i < workers*count; i++ {
+		raw, err := rd.Read()
+		if err != nil {
+			panic(err)
+		}
+		if string(raw) != string(value) {
+			println(string(raw))
+			panic("queuefka: Read does not match write:")
+		}
+	}
+
+	// every message was accounted for
+	if _, err := rd.Read(); err != queuefka.ErrEndOfLog {
+		panic("queuefka: concurrent writes lost track of the address:")
+	}
+}
+
+func Test_Queuefka_StableRead(t *testing.T) {
+	stableTopic := topic + ".stable"
+	os.RemoveAll(stableTopic)
+	defer os.RemoveAll(stableTopic)
+
+	wt, err := queuefka.NewWriter(stableTopic, 1024*64)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// messages bigger than the bufio buffer get flushed to disk in pieces
+	count := 200
+	message := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("%08d", i)), 1250)
+	}
+
+	done := make(chan error)
+	go func() {
+		rd, err := queuefka.NewReader(stableTopic, 0x0000)
+		if err != nil && err != queuefka.ErrEndOfLog {
+			done <- err
+			return
+		}
+		defer rd.Close()
+
+		for i := 0; i < count; {
+			raw, err := rd.Read()
+			if err == queuefka.ErrEndOfLog {
+				runtime.Gosched()
+				continue
+			} else if err != nil {
+				done <- err
+				return
+			}
+			if !bytes.Equal(raw, message(i)) {
+				done <- fmt.Errorf("queuefka: Read returned a torn message %d", i)
+				return
+			}
+			i++
+		}
+		done <- nil
+	}()
+
+	for i := 0; i < count; i++ {
+		wt.Write(message(i))
+		if i%7 == 0 {
+			wt.Flush()
+		}
+	}
+	wt.Flush()
+
+	if err := <-done; err != nil {
+		panic(err)
+	}
+}
+
+func Test_Queuefka_TornTail(t *testing.T) {
+	tornTopic := topic + ".torn"
+	os.RemoveAll(tornTopic)
+	defer os.RemoveAll(tornTopic)
+
+	wt, err := queuefka.NewWriter(tornTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	wt.Write([]byte("first"))
+	frameStart := wt.Address()
+	wt.Write(bytes.Repeat([]byte("torn"), 100))
+	wt.Close()
+
+	// cut the last frame off and put it back a piece at a time, as a Writer
+	// in another process flushing it in several writes would
+	slab := queuefka.SlabFiles(tornTopic)[0]
+	raw, err := ioutil.ReadFile(slab)
+	if err != nil {
+		panic(err)
+	}
+	frame := raw[frameStart:]
+	err = os.Truncate(slab, int64(frameStart))
+	if err != nil {
+		panic(err)
+	}
+
+	rd, err := queuefka.NewReader(tornTopic, 0, queuefka.WithFollow(true))
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	d, err := rd.Read()
+	if err != nil || string(d) != "first" {
+		panic("queuefka: Read did not return the message before the torn tail:")
+	}
+
+	fp, err := os.OpenFile(slab, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		panic(err)
+	}
+	defer fp.Close()
+	for _, n := range []int{3, 10, 200} {
+		fp.Write(frame[:n])
+		frame = frame[n:]
+
+		// a torn frame is the end of the log, not an error
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err = rd.ReadContext(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			println(n, err)
+			panic("queuefka: Read did not wait at a torn frame:")
+		}
+	}
+
+	// the rest of the frame turns up while a following Read waits
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		fp.Write(frame)
+	}()
+	d, err = rd.Read()
+	if err != nil || !bytes.Equal(d, bytes.Repeat([]byte("torn"), 100)) {
+		println(len(d), err)
+		panic("queuefka: Read did not return the completed frame:")
+	}
+}
+
+func Test_Queuefka_WriteBatch(t *testing.T) {
+	batchTopic := topic + ".batch"
+	os.RemoveAll(batchTopic)
+	defer os.RemoveAll(batchTopic)
+
+	wt, err := queuefka.NewWriter(batchTopic, 1024)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// batches of 10 messages fit in a slab but not alongside another batch
+	count := 0
+	for b := 0; b < 20; b++ {
+		batch := make([][]byte, 10)
+		for i := range batch {
+			batch[i] = []byte(fmt.Sprintf("message %012d", count))
+			count++
+		}
+		if err := wt.WriteBatch(batch); err != nil {
+			panic(err)
+		}
+	}
+	wt.Flush()
+
+	// every sealed slab holds whole batches
+	segments, err := queuefka.SealedSegments(batchTopic)
+	if err != nil {
+		panic(err)
+	}
+	if len(segments) < 2 {
+		panic("queuefka: WriteBatch did not roll over:")
+	}
+	i := 0
+	for _, seg := range segments {
+		rd, err := queuefka.NewSegmentReader(seg)
+		if err != nil {
+			panic(err)
+		}
+		n := 0
+		for {
+			raw, err := rd.Read()
+			if err == queuefka.ErrEndOfLog {
+				break
+			} else if err != nil {
+				panic(err)
+			}
+			if string(raw) != fmt.Sprintf("message %012d", i) {
+				println(string(raw))
+				panic("queuefka: Read does not match WriteBatch:")
+			}
+			i++
+			n++
+		}
+		rd.Close()
+
+		if n%10 != 0 {
+			println(seg.Path, n)
+			panic("queuefka: WriteBatch split a batch across slabs:")
+		}
+	}
+}
+
+func Test_Queuefka_MaxRecordSize(t *testing.T) {
+	maxTopic := topic + ".maxsize"
+	os.RemoveAll(maxTopic)
+	defer os.RemoveAll(maxTopic)
+
+	wt, err := queuefka.NewWriter(maxTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	big := bytes.Repeat(value, 100)
+	wt.Write(value)
+	wt.Write(big)
+	wt.Write(value)
+	wt.Flush()
+
+	wt.SetMaxRecordSize(uint32(len(big) - 1))
+	if wt.Write(big) != queuefka.ErrRecordTooLarge {
+		panic("queuefka: Write accepted a message over the maximum size:")
+	}
+	if wt.WriteBatch([][]byte{value, big}) != queuefka.ErrRecordTooLarge {
+		panic("queuefka: WriteBatch accepted a message over the maximum size:")
+	}
+
+	// the Reader skips the oversized message and carries on
+	rd, err := queuefka.NewReader(maxTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	rd.SetMaxRecordSize(uint32(len(big) - 1))
+
+	for _, expect := range []error{nil, queuefka.ErrRecordTooLarge, nil, queuefka.ErrEndOfLog} {
+		raw, err := rd.Read()
+		if err != expect {
+			panic(fmt.Sprintf("queuefka: expected %v got %v", expect, err))
+		}
+		if err == nil && string(raw) != string(value) {
+			println(string(raw))
+			panic("queuefka: Read does not match write:")
+		}
+	}
+
+	// the limit is on the encoded message, key and headers included, the
+	// same on both sides right up to the boundary: attributes and timestamp,
+	// the key and headers each with their lengths, then the value
+	key, headers := []byte("k"), []queuefka.Header{{Key: "h", Value: []byte("v")}}
+	size := uint32(9 + 2 + 1 + 2 + 2 + len(value))
+	start := wt.Address()
+	wt.SetMaxRecordSize(size)
+	if err := wt.WriteHeaders(key, value, headers); err != nil {
+		panic(err)
+	}
+	if err := wt.WriteBatch([][]byte{bytes.Repeat([]byte("x"), int(size)-9)}); err != nil {
+		panic(err)
+	}
+	wt.SetMaxRecordSize(size - 1)
+	if wt.WriteHeaders(key, value, headers) != queuefka.ErrRecordTooLarge {
+		panic("queuefka: WriteHeaders accepted a message whose key and headers take it over the maximum size:")
+	}
+	if wt.WriteBatch([][]byte{bytes.Repeat([]byte("x"), int(size)-9)}) != queuefka.ErrRecordTooLarge {
+		panic("queuefka: WriteBatch accepted a message one byte over the maximum size:")
+	}
+	wt.Flush()
+
+	for _, max := range []uint32{size, size - 1} {
+		rd, err := queuefka.NewReader(maxTopic, start)
+		if err != nil {
+			panic(err)
+		}
+		defer rd.Close()
+		rd.SetMaxRecordSize(max)
+		for i := 0; i < 2; i++ {
+			rec, err := rd.ReadRecord()
+			if max == size && (err != nil || (i == 0 && (string(rec.Key) != "k" || len(rec.Headers) != 1))) {
+				println(max, i, err)
+				panic("queuefka: Read refused a message of exactly the maximum size:")
+			}
+			if max < size && err != queuefka.ErrRecordTooLarge {
+				println(max, i, err)
+				panic("queuefka: Read accepted a message one byte over the maximum size:")
+			}
+		}
+	}
+}
+
+func Test_Queuefka_ReadRecord(t *testing.T) {
+	recTopic := topic + ".record"
+	os.RemoveAll(recTopic)
+	defer os.RemoveAll(recTopic)
+
+	wt, err := queuefka.NewWriter(recTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	var addresses []uint64
+	for i := 0; i < 3; i++ {
+		addresses = append(addresses, wt.Address())
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.SetCodec(queuefka.Gzip)
+	batch := wt.Address()
+	wt.WriteBatch([][]byte{value, value})
+	end := wt.Address()
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(recTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	for i := 0; i < 3; i++ {
+		rec, err := rd.ReadRecord()
+		if err != nil {
+			panic(err)
+		}
+		want := batch
+		if i < 2 {
+			want = addresses[i+1]
+		}
+		if rec.Address != addresses[i] || rec.NextAddress != want || string(rec.Value) != fmt.Sprintf("message %d", i) {
+			println(i, rec.Address, rec.NextAddress, string(rec.Value))
+			panic("queuefka: ReadRecord returned the wrong addresses:")
+		}
+	}
+
+	// a batch is only resumed past once its last message is read
+	rec, _ := rd.ReadRecord()
+	if rec.Address != batch || rec.NextAddress != batch {
+		println(rec.Address, rec.NextAddress)
+		panic("queuefka: ReadRecord resumed past a partly read batch:")
+	}
+	rec, _ = rd.ReadRecord()
+	if rec.Address != batch || rec.NextAddress != end {
+		println(rec.Address, rec.NextAddress)
+		panic("queuefka: ReadRecord did not resume past a read batch:")
+	}
+
+	// resuming from a next address picks up the following message
+	resumed, err := queuefka.NewReader(recTopic, addresses[2])
+	if err != nil {
+		panic(err)
+	}
+	defer resumed.Close()
+	d, err := resumed.Read()
+	if err != nil || string(d) != "message 2" {
+		panic("queuefka: ReadRecord address did not resume:")
+	}
+}
+
+func Test_Queuefka_ReadAt(t *testing.T) {
+	atTopic := topic + ".at"
+	os.RemoveAll(atTopic)
+	defer os.RemoveAll(atTopic)
+
+	wt, err := queuefka.NewWriter(atTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	var addresses []uint64
+	for i := 0; i < 3; i++ {
+		addresses = append(addresses, wt.Address())
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(atTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	rd.Read()
+
+	// random access in any order leaves the cursor alone
+	for _, i := range []int{2, 0, 1} {
+		d, err := rd.ReadAt(addresses[i])
+		if err != nil {
+			panic(err)
+		}
+		if string(d) != fmt.Sprintf("message %d", i) {
+			println(i, string(d))
+			panic("queuefka: ReadAt returned the wrong message:")
+		}
+	}
+	d, _ := rd.Read()
+	if string(d) != "message 1" {
+		println(string(d))
+		panic("queuefka: ReadAt moved the Reader cursor:")
+	}
+	rec, err := rd.ReadRecordAt(addresses[1])
+	if err != nil || rec.Address != addresses[1] || rec.NextAddress != addresses[2] || string(rec.Value) != "message 1" {
+		println(rec.Address, rec.NextAddress, string(rec.Value), err)
+		panic("queuefka: ReadRecordAt returned the wrong record:")
+	}
+
+	// an address in the middle of a frame is rejected
+	_, err = rd.ReadAt(addresses[1] + 1)
+	if err == nil {
+		panic("queuefka: ReadAt accepted an address inside a frame:")
+	}
+	_, err = rd.ReadAt(wt.Address())
+	if err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("queuefka: ReadAt past the last message did not return ErrEndOfLog:")
+	}
+}
+
+func Test_Queuefka_ReadInto(t *testing.T) {
+	intoTopic := topic + ".into"
+	os.RemoveAll(intoTopic)
+	defer os.RemoveAll(intoTopic)
+
+	wt, err := queuefka.NewWriter(intoTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	wt.Write([]byte("short"))
+	wt.Write([]byte("a much longer message"))
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(intoTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	buf := make([]byte, 8)
+	n, err := rd.ReadInto(buf)
+	if err != nil || string(buf[:n]) != "short" {
+		println(n, err)
+		panic("queuefka: ReadInto returned the wrong message:")
+	}
+
+	// a message too big for buf is left to be read again
+	n, err = rd.ReadInto(buf)
+	if err != io.ErrShortBuffer || n != 21 {
+		println(n, err)
+		panic("queuefka: ReadInto did not report a short buffer:")
+	}
+	buf = make([]byte, n)
+	n, err = rd.ReadInto(buf)
+	if err != nil || string(buf[:n]) != "a much longer message" {
+		println(n, err)
+		panic("queuefka: ReadInto did not reread the message:")
+	}
+}
+
+func Test_Queuefka_Clone(t *testing.T) {
+	cloneTopic := topic + ".clone"
+	os.RemoveAll(cloneTopic)
+	defer os.RemoveAll(cloneTopic)
+
+	wt, err := queuefka.NewWriter(cloneTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	wt.SetCodec(queuefka.Gzip)
+	wt.WriteBatch([][]byte{[]byte("message 0"), []byte("message 1"), []byte("message 2")})
+	wt.SetCodec(nil)
+	for i := 3; i < 50; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(cloneTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	rd.Read()
+
+	// a clone part way through a batch carries on from the same message
+	clone, err := rd.Clone()
+	if err != nil {
+		panic(err)
+	}
+	defer clone.Close()
+
+	// and each may then be read from its own goroutine
+	var wg sync.WaitGroup
+	for _, r := range []*queuefka.Reader{rd, clone} {
+		wg.Add(1)
+		go func(r *queuefka.Reader) {
+			defer wg.Done()
+			for i := 1; i < 50; i++ {
+				d, err := r.Read()
+				if err != nil || string(d) != fmt.Sprintf("message %d", i) {
+					println(i, string(d), err)
+					panic("queuefka: cloned Reader returned the wrong message:")
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+func Test_Queuefka_SeekSlabs(t *testing.T) {
+	slabTopic := topic + ".seekslabs"
+	os.RemoveAll(slabTopic)
+	defer os.RemoveAll(slabTopic)
+
+	// tiny slabs so there are many to search through
+	wt, err := queuefka.NewWriter(slabTopic, 64)
+	if err != nil {
+		panic(err)
+	}
+	var addresses []uint64
+	for i := 0; i < 200; i++ {
+		addresses = append(addresses, wt.Address())
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+
+	// files which merely look like slabs are ignored
+	for _, name := range []string{"notes.slab", "1e3.slab", "-1.slab"} {
+		ioutil.WriteFile(slabTopic+"/"+name, []byte("junk"), 0600)
+	}
+
+	rd, err := queuefka.NewReader(slabTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	for _, i := range []int{199, 0, 100, 37, 150, 1} {
+		err := rd.Seek(slabTopic, addresses[i])
+		if err != nil {
+			panic(err)
+		}
+		d, err := rd.Read()
+		if err != nil || string(d) != fmt.Sprintf("message %d", i) {
+			println(i, string(d), err)
+			panic("queuefka: Seek found the wrong slab:")
+		}
+	}
+
+	// and the Writer appends to the real last slab
+	wt, err = queuefka.NewWriter(slabTopic, 64)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	wt.Write([]byte("message 200"))
+	wt.Flush()
+	for i := 2; i < 200; i++ {
+		rd.Read()
+	}
+	d, err := rd.Read()
+	if err != nil || string(d) != "message 200" {
+		println(string(d), err)
+		panic("queuefka: reopened Writer did not append to the last slab:")
+	}
+}
+
 func Benchmark_Leveldb_Put(b *testing.B) {
 	key := make([]byte, 8)
 	db, _ := leveldb.OpenFile(myLevelDB, nil)
@@ -162,6 +823,23 @@ func Benchmark_Queuefka_Write(b *testing.B) {
 	wt.Close()
 }
 
+func Benchmark_Queuefka_ReadInto(b *testing.B) {
+	rd, _ := queuefka.NewReader(topic, 0x0000)
+	buf := make([]byte, 64*1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := rd.ReadInto(buf)
+		if err != nil {
+			if err == queuefka.ErrEndOfLog {
+				println("Not enough data in queuefka log to test fully benchmark ReadInto()")
+				break
+			}
+			panic(err)
+		}
+	}
+	rd.Close()
+}
+
 func Benchmark_Queuefka_Read(b *testing.B) {
 	rd, _ := queuefka.NewReader(topic, 0x0000)
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,118 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit caps the throughput of a Reader, or of a Writer as its quota.  A
+// zero field means that dimension is unlimited.
+type RateLimit struct {
+	BytesPerSec    float64 // payload bytes read or written per second
+	MessagesPerSec float64 // messages read or written per second
+}
+
+// tokenBucket is a simple token-bucket limiter.  Tokens accrue at rate per
+// second up to one second worth of burst.  A caller may take more tokens
+// than are available, in which case it owes the bucket and must wait for
+// the debt to be paid back before proceeding.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+// refill adds the tokens accrued since the last refill
+func (tb *tokenBucket) refill() {
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+}
+
+// allow reports whether n tokens are available, or a full burst for more
+// than a burst's worth
+func (tb *tokenBucket) allow(n float64) bool {
+	tb.refill()
+	return tb.tokens >= math.Min(n, tb.burst)
+}
+
+// take removes n tokens and returns how long the caller must wait
+func (tb *tokenBucket) take(n float64) time.Duration {
+	tb.refill()
+	tb.tokens -= n
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// quota enforces a Writer's RateLimit.  It has its own lock so a Write waiting
+// on it holds up other writes but not Flush, Sync or Close.
+type quota struct {
+	bytes  *tokenBucket
+	msgs   *tokenBucket
+	reject bool // fail with ErrQuotaExceeded rather than wait
+	sync.Mutex
+}
+
+// set replaces the limits, a zero RateLimit removes them
+func (q *quota) set(limit RateLimit, reject bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.bytes, q.msgs, q.reject = nil, nil, reject
+	if limit.BytesPerSec > 0 {
+		q.bytes = newTokenBucket(limit.BytesPerSec)
+	}
+	if limit.MessagesPerSec > 0 {
+		q.msgs = newTokenBucket(limit.MessagesPerSec)
+	}
+}
+
+// wait charges n bytes in m messages against the quota, sleeping until they
+// are within it or ctx is done
+func (q *quota) wait(ctx context.Context, n, m int) error {
+	q.Lock()
+	if q.reject && ((q.bytes != nil && !q.bytes.allow(float64(n))) || (q.msgs != nil && !q.msgs.allow(float64(m)))) {
+		q.Unlock()
+		return ErrQuotaExceeded
+	}
+	var wait time.Duration
+	if q.bytes != nil {
+		if d := q.bytes.take(float64(n)); d > wait {
+			wait = d
+		}
+	}
+	if q.msgs != nil {
+		if d := q.msgs.take(float64(m)); d > wait {
+			wait = d
+		}
+	}
+	q.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_RateLimit(t *testing.T) {
+	rateTopic := topic + ".ratelimit"
+	os.RemoveAll(rateTopic)
+	defer os.RemoveAll(rateTopic)
+
+	wt, err := queuefka.NewWriter(rateTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	count := 30
+	for i := 0; i < count; i++ {
+		wt.Write(value)
+	}
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(rateTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	// bucket starts with one second of burst, the rest must be paced
+	rate := 20.0
+	rd.SetRateLimit(queuefka.RateLimit{MessagesPerSec: rate})
+	minimum := time.Duration(float64(count-int(rate)) / rate * float64(time.Second))
+
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		if _, err := rd.Read(); err != nil {
+			panic(err)
+		}
+	}
+	elapsed := time.Since(start)
+
+	if elapsed < minimum {
+		println(elapsed.String(), "<", minimum.String())
+		panic("queuefka: RateLimit did not throttle Read:")
+	}
+}
+
+func Test_Queuefka_RateLimitContext(t *testing.T) {
+	rateTopic := topic + ".ratelimit-ctx"
+	os.RemoveAll(rateTopic)
+	defer os.RemoveAll(rateTopic)
+
+	wt, err := queuefka.NewWriter(rateTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	wt.Write([]byte("one"))
+	wt.Write([]byte("two"))
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(rateTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	// one message a second, so the second waits far longer than ctx lasts
+	rd.SetRateLimit(queuefka.RateLimit{MessagesPerSec: 1})
+	d, err := rd.Read()
+	if err != nil {
+		panic(err)
+	}
+	if string(d) != "one" {
+		panic("queuefka: RateLimit Read returned the wrong message:")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = rd.ReadContext(ctx)
+	if err != context.DeadlineExceeded || time.Since(start) > 500*time.Millisecond {
+		println(err, time.Since(start).String())
+		panic("queuefka: ReadContext did not give up on the rate limit with ctx:")
+	}
+
+	// the message held back is read once the limit is lifted
+	rd.SetRateLimit(queuefka.RateLimit{})
+	d, err = rd.Read()
+	if err != nil {
+		panic(err)
+	}
+	if string(d) != "two" {
+		println(string(d))
+		panic("queuefka: ReadContext lost the message it gave up on:")
+	}
+}
+
+func Test_Queuefka_Quota(t *testing.T) {
+	quotaTopic := topic + ".quota"
+	os.RemoveAll(quotaTopic)
+	defer os.RemoveAll(quotaTopic)
+
+	wt, err := queuefka.NewWriter(quotaTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// one second of burst is written straight away, the rest is paced
+	count := 30
+	rate := 20.0
+	wt.SetQuota(queuefka.RateLimit{MessagesPerSec: rate}, false)
+	minimum := time.Duration(float64(count-int(rate)) / rate * float64(time.Second))
+
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		if err := wt.Write(value); err != nil {
+			panic(err)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed < minimum {
+		println(elapsed.String(), "<", minimum.String())
+		panic("queuefka: Quota did not throttle Write:")
+	}
+
+	// rejecting fails as soon as the burst is used up
+	wt.SetQuota(queuefka.RateLimit{BytesPerSec: float64(10 * size)}, true)
+	for i := 0; i < 10; i++ {
+		if err := wt.Write(value); err != nil {
+			panic(err)
+		}
+	}
+	if err := wt.Write(value); err != queuefka.ErrQuotaExceeded {
+		println(err)
+		panic("queuefka: Quota did not reject Write:")
+	}
+
+	wt.SetQuota(queuefka.RateLimit{}, true)
+	if err := wt.Write(value); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"bufio"
+)
+
+const (
+	defaultReadBuffer = 4096        // bufio's own default
+	minReadAhead      = 64 * 1024   // smallest buffer chosen by read-ahead
+	maxReadAhead      = 1024 * 1024 // largest buffer chosen by read-ahead
+)
+
+// SetBufferSize sets the size of the Reader's bufio buffer, 0 for the
+// default which is 4KB or with read-ahead sized to the slab.  A buffer
+// smaller than a message is fine, it is just read in several pieces.
+func (rd *Reader) SetBufferSize(size int) {
+	rd.bufSize = size
+	rd.rebuffer()
+}
+
+// SetReadAhead tunes the Reader for sequential scans.  Unless SetBufferSize
+// is used the buffer grows to a sixteenth of each slab, between 64KB and 1MB,
+// and on Linux the kernel is advised to read ahead of the cursor.
+func (rd *Reader) SetReadAhead(enabled bool) {
+	rd.readAhead = enabled
+	rd.rebuffer()
+}
+
+// bufferSize returns the bufio buffer size for a slab of the given size
+func (rd *Reader) bufferSize(slab int64) int {
+	if rd.bufSize > 0 {
+		return rd.bufSize
+	}
+	if !rd.readAhead {
+		return defaultReadBuffer
+	}
+	size := slab / 16
+	if size < minReadAhead {
+		size = minReadAhead
+	} else if size > maxReadAhead {
+		size = maxReadAhead
+	}
+	return int(size)
+}
+
+// buffer sets up the bufio reader for the current slab at the file cursor
+func (rd *Reader) buffer(slab int64) {
+	size := rd.bufferSize(slab)
+	if rd.rd == nil || rd.rd.Size() != size {
+		rd.rd = bufio.NewReaderSize(rd.fp, size)
+	} else {
+		rd.rd.Reset(rd.fp)
+	}
+	if rd.readAhead {
+		fadvise(rd.fp, int64(rd.address-rd.base), int64(size))
+	}
+}
+
+// rebuffer applies a changed buffer setting at the current address
+func (rd *Reader) rebuffer() {
+	if rd.fp == nil {
+		return
+	}
+	stat, err := rd.fp.Stat()
+	if err != nil {
+		return
+	}
+	rd.buffer(stat.Size())
+	rd.rewind(nil)
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && !386 && !arm && !mips && !mipsle
+
+package queuefka
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fadvSequential = 2 // POSIX_FADV_SEQUENTIAL
+	fadvWillNeed   = 3 // POSIX_FADV_WILLNEED
+)
+
+// fadvise tells the kernel fp is read sequentially and to start reading
+// size bytes from offset in the background, it is only a hint so errors
+// are ignored
+func fadvise(fp *os.File, offset, size int64) {
+	fd := fp.Fd()
+	syscall.Syscall6(syscall.SYS_FADVISE64, fd, 0, 0, fadvSequential, 0, 0)
+	syscall.Syscall6(syscall.SYS_FADVISE64, fd, uintptr(offset), uintptr(size), fadvWillNeed, 0, 0)
+}
@@ -0,0 +1,12 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux || 386 || arm || mips || mipsle
+
+package queuefka
+
+import "os"
+
+// fadvise does nothing as posix_fadvise is only called on 64 bit Linux
+func fadvise(fp *os.File, offset, size int64) {}
@@ -0,0 +1,68 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_ReadBuffer(t *testing.T) {
+	bufTopic := topic + ".readbuffer"
+	os.RemoveAll(bufTopic)
+	defer os.RemoveAll(bufTopic)
+
+	wt, err := queuefka.NewWriter(bufTopic, 4096)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// messages several times larger than the smallest buffer
+	large := bytes.Repeat([]byte("0123456789"), 50)
+	for i := 0; i < 40; i++ {
+		wt.Write(large)
+	}
+	wt.Flush()
+
+	opts := [][]queuefka.Option{
+		{queuefka.WithReadBufferSize(16)},
+		{queuefka.WithReadAhead(true)},
+		{queuefka.WithReadAhead(true), queuefka.WithReadBufferSize(100)},
+	}
+	for i, o := range opts {
+		rd, err := queuefka.NewReader(bufTopic, 0, o...)
+		if err != nil {
+			panic(err)
+		}
+		n := 0
+		for {
+			d, err := rd.Read()
+			if err == queuefka.ErrEndOfLog {
+				break
+			} else if err != nil {
+				panic(err)
+			}
+			if !bytes.Equal(d, large) {
+				println(i, n)
+				panic("queuefka: Reader returned a corrupt message with a resized buffer:")
+			}
+			n++
+
+			// switching buffers mid slab carries on from the same message
+			if n == 10 {
+				rd.SetBufferSize(32)
+			}
+		}
+		rd.Close()
+		if n != 40 {
+			println(i, n)
+			panic("queuefka: Reader with a resized buffer missed messages:")
+		}
+	}
+}
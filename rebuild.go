@@ -0,0 +1,125 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+)
+
+// RebuildIndexes regenerates the sidecar files of every slab of topic by
+// reading the slabs, for when they are missing or may be stale, e.g. after
+// restoring a topic from a backup.  The count, index, time index, footer and
+// metadata of each sealed slab are rewritten, along with the metadata of the
+// active slab and the producer table carried from slab to slab for
+// WriteIdempotent.  Holes punched in slabs are kept.  No Writer may have the
+// topic open, ErrTopicLocked is returned if one does.
+func RebuildIndexes(topic string) error {
+	slabs := SlabFiles(topic)
+	if len(slabs) <= 0 {
+		return ErrInvalidTopic
+	}
+	lock, err := lockTopic(topic, 0600, false)
+	if err != nil {
+		return err
+	}
+	defer unlockTopic(lock)
+
+	// the producer table before the oldest slab cannot be rebuilt
+	producers, err := readProducers(slabs[0])
+	if os.IsNotExist(err) {
+		producers = map[uint64]uint64{}
+	} else if err != nil {
+		return err
+	}
+
+	for i, slab := range slabs {
+		base, err := slabBase(slab)
+		if err != nil {
+			return err
+		}
+		size, err := slabLength(slab)
+		if err != nil {
+			return err
+		}
+		seg := Segment{Base: base, Path: slab, Size: size}
+
+		// a slab has the table as of its start if there is anything in it or
+		// the slab holds idempotent messages
+		start := make(map[uint64]uint64, len(producers))
+		for producer, sequence := range producers {
+			start[producer] = sequence
+		}
+		end, idempotent, err := rebuildProducers(seg, producers)
+		if err != nil {
+			return err
+		}
+		if len(start) > 0 || idempotent {
+			err = writeProducers(slab, start)
+			if err != nil {
+				return err
+			}
+		}
+		err = writeSlabMeta(slab, slabMeta{base: base, end: end})
+		if err != nil {
+			return err
+		}
+		if i < len(slabs)-1 {
+			err = rebuildSealed(seg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return syncDir(topic)
+}
+
+// rebuildSealed regenerates the count, indexes and footer of a sealed slab
+func rebuildSealed(seg Segment) error {
+	f, err := scanSlabFooter(seg)
+	if err != nil {
+		return err
+	}
+	index, err := scanSlabIndex(seg)
+	if err != nil {
+		return err
+	}
+	times, err := scanSlabTimeIndex(seg)
+	if err != nil {
+		return err
+	}
+
+	err = writeSlabCount(seg.Path, f.Records)
+	if err == nil {
+		err = writeSlabIndex(seg.Path, index)
+	}
+	if err == nil {
+		err = writeSlabTimeIndex(seg.Path, times)
+	}
+	if err == nil {
+		err = writeSlabFooter(seg.Path, f)
+	}
+	return err
+}
+
+// rebuildProducers updates producers with the idempotent messages of seg,
+// reporting whether there were any, and returns the offset just past its
+// last whole frame
+func rebuildProducers(seg Segment, producers map[uint64]uint64) (uint64, bool, error) {
+	var idempotent bool
+	rd, err := NewSegmentReader(seg)
+	defer rd.Close()
+	for err == nil {
+		_, err = rd.Read()
+		if err == nil && rd.msg.producer != 0 {
+			producers[rd.msg.producer] = rd.msg.sequence
+			idempotent = true
+		}
+	}
+	if err != ErrEndOfLog {
+		return 0, false, err
+	}
+	return rd.address - seg.Base, idempotent, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_RebuildIndexes(t *testing.T) {
+	rebuildTopic := topic + ".rebuild"
+	os.RemoveAll(rebuildTopic)
+	defer os.RemoveAll(rebuildTopic)
+
+	wt, err := queuefka.NewWriter(rebuildTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 60; i++ {
+		wt.WriteIdempotent(7, uint64(i+1), []byte(fmt.Sprintf("message %d", i)))
+	}
+
+	// not while a Writer has the topic open
+	if err = queuefka.RebuildIndexes(rebuildTopic); err != queuefka.ErrTopicLocked {
+		println(err)
+		panic("queuefka: RebuildIndexes ran under an open Writer:")
+	}
+	wt.Close()
+
+	// lose every sidecar as a backup of just the slabs would
+	names, _ := filepath.Glob(rebuildTopic + "/*")
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".slab") {
+			os.Remove(name)
+		}
+	}
+	err = queuefka.RebuildIndexes(rebuildTopic)
+	if err != nil {
+		panic(err)
+	}
+
+	segments, _ := queuefka.SealedSegments(rebuildTopic)
+	for _, seg := range segments {
+		stem := strings.TrimSuffix(seg.Path, ".slab")
+		for _, ext := range []string{".count", ".index", ".timeindex", ".footer", ".meta", ".producers"} {
+			if _, err := os.Stat(stem + ext); err != nil {
+				println(stem + ext)
+				panic("queuefka: RebuildIndexes did not rebuild a sidecar:")
+			}
+		}
+		err = queuefka.VerifySegment(seg)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if count, _ := queuefka.CountMessages(rebuildTopic); count != 60 {
+		println(count)
+		panic("queuefka: rebuilt sidecars miscount the topic:")
+	}
+
+	// a retry of an idempotent message is still recognised
+	wt, err = queuefka.NewWriter(rebuildTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	end := wt.Address()
+	wt.WriteIdempotent(7, 60, []byte("retry"))
+	wt.Flush()
+	if wt.Address() != end {
+		panic("queuefka: rebuilt producer table let a retry through:")
+	}
+}
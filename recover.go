@@ -0,0 +1,47 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+)
+
+// scanEnd returns the offset just past the last intact frame of the current
+// slab, reading on from the frame at offset start or from the first frame if
+// there is none there, caller must hold the lock
+func (wt *Writer) scanEnd(start uint64) int64 {
+	rd := &Reader{topic: wt.topic}
+	err := rd.Seek(wt.topic, wt.base+start)
+	if err != nil && err != ErrEndOfLog {
+		err = rd.Seek(wt.topic, wt.base+slabHeaderSize)
+	}
+	end := rd.address
+	for err == nil {
+		end = rd.address
+		_, err = rd.Read()
+		switch {
+		case err == nil || err == ErrEndOfLog:
+			end = rd.address
+		case err == ErrBadChecksum || err == ErrBadHeader:
+			// a torn frame ends the slab, it is cut off where it starts
+		case rd.address > end:
+			// skip a frame which passes its checksum but cannot be decoded
+			err = nil
+		}
+	}
+	rd.Close()
+	return int64(end - wt.base)
+}
+
+// recoverTail truncates the slab being loaded to end, the end of its last
+// whole frame, dropping any frame torn by a crash part way through an append
+// so the next one is not written after it
+func recoverTail(fp *os.File, end int64) error {
+	err := fp.Truncate(end)
+	if err != nil {
+		return err
+	}
+	return fp.Sync()
+}
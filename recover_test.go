@@ -0,0 +1,125 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_RecoverTornTail(t *testing.T) {
+	tornTopic := topic + ".torn"
+	os.RemoveAll(tornTopic)
+	defer os.RemoveAll(tornTopic)
+
+	wt, err := queuefka.NewWriter(tornTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 10; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+	start := wt.Address()
+	wt.Write([]byte("torn message"))
+	wt.Close()
+
+	// leave only the first half of the last frame as a crash mid-append would
+	slabs := queuefka.SlabFiles(tornTopic)
+	slab := slabs[len(slabs)-1]
+	stat, err := os.Stat(slab)
+	if err != nil {
+		panic(err)
+	}
+	torn := stat.Size() - 6
+	err = os.Truncate(slab, torn)
+	if err != nil {
+		panic(err)
+	}
+
+	wt, err = queuefka.NewWriter(tornTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	if wt.Address() != start {
+		println(wt.Address(), start)
+		panic("queuefka: NewWriter did not truncate the torn frame:")
+	}
+	wt.Write([]byte("after crash"))
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(tornTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 11 || values[9] != "message 9" || values[10] != "after crash" {
+		println(len(values))
+		panic("queuefka: messages after a torn frame were not readable:")
+	}
+}
+
+func Test_Queuefka_RecoverCorruptTail(t *testing.T) {
+	tornTopic := topic + ".corrupt-tail"
+	os.RemoveAll(tornTopic)
+	defer os.RemoveAll(tornTopic)
+
+	wt, err := queuefka.NewWriter(tornTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	for _, v := range []string{"one", "two"} {
+		wt.Write([]byte(v))
+	}
+	wt.Flush()
+	start := wt.Address()
+	wt.Write([]byte("three"))
+	wt.Close()
+
+	// the last frame is all there but its payload never reached the disk,
+	// and the crash left no record of where the slab ends
+	slabs := queuefka.SlabFiles(tornTopic)
+	slab := slabs[len(slabs)-1]
+	stat, err := os.Stat(slab)
+	if err != nil {
+		panic(err)
+	}
+	fp, err := os.OpenFile(slab, os.O_WRONLY, 0600)
+	if err != nil {
+		panic(err)
+	}
+	fp.WriteAt([]byte{0, 0, 0}, stat.Size()-3)
+	fp.Close()
+	os.Remove(strings.TrimSuffix(slab, ".slab") + ".meta")
+
+	wt, err = queuefka.NewWriter(tornTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	if wt.Address() != start {
+		println(wt.Address(), start)
+		panic("queuefka: NewWriter kept a frame which fails its checksum:")
+	}
+	wt.Write([]byte("four"))
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(tornTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 3 || values[1] != "two" || values[2] != "four" {
+		println(len(values))
+		panic("queuefka: a corrupt frame was left in the middle of the log:")
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"time"
+)
+
+// ReplaySince returns an Iterator over topic from the first message written
+// d ago or since, for jobs reprocessing e.g. the last 6 hours, see
+// ReplayFrom.
+func ReplaySince(topic string, d time.Duration, opts ...Option) (*Iterator, error) {
+	return ReplayFrom(topic, time.Now().Add(-d), opts...)
+}
+
+// ReplayFrom returns an Iterator over topic from the first message written
+// at or after t, found with the time index of each sealed slab as by
+// SeekToTime, so replaying the last hour of a long topic reads little more
+// than that hour.  A t after the newest message iterates over only messages
+// written from now on.
+func ReplayFrom(topic string, t time.Time, opts ...Option) (*Iterator, error) {
+	rd, err := NewReader(topic, 0, opts...)
+	if err == nil || err == ErrEndOfLog {
+		err = rd.SeekToTime(t)
+	}
+	if err != nil && err != ErrEndOfLog {
+		if rd != nil {
+			rd.Close()
+		}
+		return nil, err
+	}
+	return rd.Iterator(), nil
+}
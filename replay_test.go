@@ -0,0 +1,75 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// iterated returns the values of every message left in it
+func iterated(it *queuefka.Iterator) []string {
+	var values []string
+	for it.Next() {
+		values = append(values, string(it.Record().Value))
+	}
+	if it.Err() != nil {
+		panic(it.Err())
+	}
+	return values
+}
+
+func Test_Queuefka_Replay(t *testing.T) {
+	replayTopic := topic + ".replay"
+	os.RemoveAll(replayTopic)
+	defer os.RemoveAll(replayTopic)
+
+	wt, err := queuefka.NewWriter(replayTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 30; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+	time.Sleep(20 * time.Millisecond)
+	cut := time.Now()
+	time.Sleep(20 * time.Millisecond)
+	for i := 30; i < 60; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+
+	it, err := queuefka.ReplayFrom(replayTopic, cut)
+	if err != nil {
+		panic(err)
+	}
+	values := iterated(it)
+	it.Close()
+	if len(values) != 30 || values[0] != "message 30" {
+		println(len(values))
+		panic("queuefka: ReplayFrom did not start at the time given:")
+	}
+
+	for _, c := range []struct {
+		since time.Duration
+		n     int
+	}{{time.Since(cut), 30}, {time.Hour, 60}, {0, 0}} {
+		it, err = queuefka.ReplaySince(replayTopic, c.since)
+		if err != nil {
+			panic(err)
+		}
+		values = iterated(it)
+		it.Close()
+		if len(values) != c.n {
+			println(c.since, len(values), c.n)
+			panic("queuefka: ReplaySince did not replay the duration given:")
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"errors"
+	"os"
+)
+
+// A follower keeps a replica of a leader's topic by asking for the frames
+// from its own high watermark with ReadChunk and appending them as they are
+// with Replica.Append.  Addresses are byte offsets into the slabs, so the
+// replica's slabs are byte for byte those of the leader as they were
+// written, and a Reader of the replica sees the same messages at the same
+// addresses.  Sidecar files such as indexes and counts are rebuilt locally
+// as they are needed, and retention, compaction and compression on the
+// leader are not replicated.
+
+// ErrReplicaGap is returned by Replica.Append for a Chunk which does not
+// start where the replica ends.
+var ErrReplicaGap = errors.New("queuefka: Append() chunk does not follow the replica")
+
+// Chunk is a run of whole frames of one slab of a topic as they were
+// written, see ReadChunk.
+type Chunk struct {
+	Base   uint64     // base address of the slab
+	Offset uint64     // of Data in the slab, 0 for a chunk starting with the slab header
+	Data   []byte     // empty at the end of the log
+	Sealed bool       // Data runs to the end of the slab, a later slab follows
+	Naming SlabNaming // of the topic, for the replica to name its slabs alike
+}
+
+// ReadChunk returns whole frames of topic from address, the high watermark
+// of a replica, about max bytes of them but at least one.  The frames come
+// from a single slab, at the end of which the next chunk starts the next
+// slab with its header.  Every frame is checked against its checksum on the
+// way.  At the end of the log the Chunk has no Data, and an address before
+// the low watermark, other than 0 for an empty replica, returns
+// ErrAddressTruncated.
+func ReadChunk(topic string, address uint64, max int) (Chunk, error) {
+	slabs := SlabFiles(topic)
+	if len(slabs) <= 0 {
+		return Chunk{}, ErrInvalidTopic
+	}
+	i, base := findSlab(slabs, address)
+	if address < base {
+		if address != 0 {
+			return Chunk{}, ErrAddressTruncated
+		}
+		address = base
+	}
+
+	// a sealed slab read to its end moves on to the start of the next
+	size, err := slabLength(slabs[i])
+	if err != nil {
+		return Chunk{}, err
+	}
+	if i < len(slabs)-1 && address >= base+uint64(size) {
+		i++
+		base, _ = slabBase(slabs[i])
+		address = base
+	}
+	c := Chunk{Base: base, Offset: address - base, Naming: topicNaming(topic)}
+	sealed := i < len(slabs)-1
+	if sealed {
+		size, err = slabLength(slabs[i])
+		if err != nil {
+			return Chunk{}, err
+		}
+	}
+
+	// read frames to find where the last whole one within max ends
+	rd := &Reader{topic: topic}
+	defer rd.Close()
+	err = rd.Seek(topic, address)
+	if sealed {
+		rd.end = base + uint64(size)
+	}
+	for err == nil && rd.address-address < uint64(max) {
+		_, err = rd.Read()
+	}
+	if err != nil && err != ErrEndOfLog {
+		return Chunk{}, err
+	}
+	end := rd.address - base
+	if rd.base != base {
+		// the active slab was sealed meanwhile and the Reader rolled on
+		size, err = slabLength(slabs[i])
+		if err != nil {
+			return Chunk{}, err
+		}
+		sealed, end = true, uint64(size)
+	}
+	if end == c.Offset {
+		return c, nil
+	}
+
+	d, err := openSlab(slabs[i])
+	if err != nil {
+		return Chunk{}, err
+	}
+	defer d.Close()
+	c.Data = make([]byte, end-c.Offset)
+	_, err = d.ReadAt(c.Data, int64(c.Offset))
+	if err != nil {
+		return Chunk{}, err
+	}
+	c.Sealed = sealed && end == uint64(size)
+	return c, nil
+}
+
+// Replica is the follower's copy of a topic, holding the topic's lock so no
+// Writer appends to it meanwhile.  Readers may read it as it grows.
+type Replica struct {
+	topic  string
+	lock   *os.File
+	naming SlabNaming
+	fp     *os.File // active slab, nil before the first chunk
+	path   string   // of the active slab
+	base   uint64   // of the active slab
+	end    uint64   // offset just past its last whole frame
+}
+
+// OpenReplica opens topic as a replica, creating it if need be.  Whatever a
+// crash left of a torn frame at the end of the newest slab is cut off.
+func OpenReplica(topic string) (*Replica, error) {
+	lock, err := lockTopic(topic, 0600, true)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replica{topic: topic, lock: lock, naming: topicNaming(topic)}
+
+	slabs := SlabFiles(topic)
+	if len(slabs) > 0 {
+		err = r.load(slabs[len(slabs)-1])
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// load opens the newest slab of the replica to append to
+func (r *Replica) load(slab string) error {
+	base, err := slabBase(slab)
+	if err != nil {
+		return err
+	}
+	end, err := activeEnd(Segment{Base: base, Path: slab})
+	if err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(slab, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	err = fp.Truncate(end)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	r.fp, r.path, r.base, r.end = fp, slab, base, uint64(end)
+	return nil
+}
+
+// HighWatermark returns the address just past the last whole frame of the
+// replica, to pass to ReadChunk for the next Chunk, 0 if it is empty.
+func (r *Replica) HighWatermark() uint64 {
+	if r.fp == nil {
+		return 0
+	}
+	return r.base + r.end
+}
+
+// Append appends c, read from the leader from HighWatermark, to the replica.
+// A Chunk starting a slab creates it, fully written before Readers see it.
+func (r *Replica) Append(c Chunk) error {
+	if c.Offset == 0 {
+		if len(c.Data) == 0 {
+			return nil
+		}
+		if r.fp != nil && c.Base < r.base+r.end {
+			return ErrReplicaGap
+		}
+		return r.create(c)
+	}
+	if r.fp == nil || c.Base != r.base || c.Offset != r.end {
+		return ErrReplicaGap
+	}
+	_, err := r.fp.WriteAt(c.Data, int64(r.end))
+	if err != nil {
+		return err
+	}
+	r.end += uint64(len(c.Data))
+	if c.Sealed {
+		return r.seal()
+	}
+	return nil
+}
+
+// create starts a new slab with the slab header and frames of c
+func (r *Replica) create(c Chunk) error {
+	if r.fp == nil && len(SlabFiles(r.topic)) == 0 && c.Naming != r.naming {
+		if !c.Naming.valid() {
+			return ErrBadNaming
+		}
+		err := writeNaming(r.topic, c.Naming)
+		if err != nil {
+			return err
+		}
+		r.naming = c.Naming
+	}
+	if r.fp != nil {
+		err := r.seal()
+		if err != nil {
+			return err
+		}
+	}
+
+	slab := r.topic + "/" + r.naming.name(c.Base)
+	fp, err := os.OpenFile(slab+".tmp", os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(c.Data)
+	if err == nil {
+		err = writeSlabMeta(slab, slabMeta{base: c.Base, end: uint64(len(c.Data))})
+	}
+	if err == nil {
+		err = os.Rename(slab+".tmp", slab)
+	}
+	if err == nil {
+		err = syncDir(r.topic)
+	}
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	if r.fp != nil {
+		r.fp.Close()
+	}
+	r.fp, r.path, r.base, r.end = fp, slab, c.Base, uint64(len(c.Data))
+	if c.Sealed {
+		return r.seal()
+	}
+	return nil
+}
+
+// seal syncs the active slab, which the leader will append no more to, and
+// records its end
+func (r *Replica) seal() error {
+	if r.fp == nil {
+		return nil
+	}
+	err := r.fp.Sync()
+	if err == nil {
+		err = writeSlabMeta(r.path, slabMeta{base: r.base, end: r.end})
+	}
+	return err
+}
+
+// Sync makes everything appended so far durable.
+func (r *Replica) Sync() error {
+	if r.fp == nil {
+		return nil
+	}
+	return r.fp.Sync()
+}
+
+// Close records the end of the active slab and releases the topic, which
+// may then be opened by a Writer to promote the replica to a leader.
+func (r *Replica) Close() error {
+	var err error
+	if r.fp != nil {
+		err = r.seal()
+		if cerr := r.fp.Close(); err == nil {
+			err = cerr
+		}
+		r.fp = nil
+	}
+	unlockTopic(r.lock)
+	return err
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// replicate appends chunks of leader to r until it has caught up
+func replicate(leader string, r *queuefka.Replica) {
+	for {
+		c, err := queuefka.ReadChunk(leader, r.HighWatermark(), 300)
+		if err != nil {
+			panic(err)
+		}
+		if len(c.Data) == 0 {
+			return
+		}
+		err = r.Append(c)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// sameSlabs panics unless every slab of leader and follower is identical
+func sameSlabs(leader, follower string) {
+	lslabs, fslabs := queuefka.SlabFiles(leader), queuefka.SlabFiles(follower)
+	if len(lslabs) != len(fslabs) {
+		println(len(lslabs), len(fslabs))
+		panic("queuefka: replica does not have the slabs of the leader:")
+	}
+	for i := range lslabs {
+		l, err := ioutil.ReadFile(lslabs[i])
+		if err != nil {
+			panic(err)
+		}
+		f, err := ioutil.ReadFile(fslabs[i])
+		if err != nil {
+			panic(err)
+		}
+		if filepath.Base(lslabs[i]) != filepath.Base(fslabs[i]) || !bytes.Equal(l, f) {
+			println(lslabs[i], len(l), len(f))
+			panic("queuefka: replica slab differs from the leader's:")
+		}
+	}
+}
+
+func Test_Queuefka_Replica(t *testing.T) {
+	leader, follower := topic+".leader", topic+".follower"
+	os.RemoveAll(leader)
+	os.RemoveAll(follower)
+	defer os.RemoveAll(leader)
+	defer os.RemoveAll(follower)
+
+	wt, err := queuefka.NewWriter(leader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	for i := 0; i < 100; i++ {
+		err = wt.WriteKeyed([]byte("key"), []byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = wt.Flush()
+	if err != nil {
+		panic(err)
+	}
+
+	r, err := queuefka.OpenReplica(follower)
+	if err != nil {
+		panic(err)
+	}
+	replicate(leader, r)
+	if r.HighWatermark() != wt.Address() {
+		println(r.HighWatermark(), wt.Address())
+		panic("queuefka: replica did not catch up with the leader:")
+	}
+	sameSlabs(leader, follower)
+
+	// a Writer cannot append to a replica
+	_, err = queuefka.NewWriter(follower, 1024)
+	if err != queuefka.ErrTopicLocked {
+		println(err)
+		panic("queuefka: a Writer opened a replica:")
+	}
+
+	// more messages follow, after a restart which left a torn frame behind
+	for i := 100; i < 150; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+	err = r.Close()
+	if err != nil {
+		panic(err)
+	}
+	slabs := queuefka.SlabFiles(follower)
+	fp, err := os.OpenFile(slabs[len(slabs)-1], os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		panic(err)
+	}
+	fp.Write([]byte{0x51, 3, 0xff})
+	fp.Close()
+	r, err = queuefka.OpenReplica(follower)
+	if err != nil {
+		panic(err)
+	}
+	defer r.Close()
+	replicate(leader, r)
+	sameSlabs(leader, follower)
+
+	// the replica reads as the leader does
+	rd, err := queuefka.NewReader(follower, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	for i := 0; i < 150; i++ {
+		d, err := rd.Read()
+		if err != nil || string(d) != fmt.Sprintf("message %d", i) {
+			println(i, err)
+			panic("queuefka: replica did not return the messages of the leader:")
+		}
+	}
+
+	// a chunk from elsewhere in the log is refused
+	c, err := queuefka.ReadChunk(leader, 0, 300)
+	if err != nil {
+		panic(err)
+	}
+	err = r.Append(c)
+	if err != queuefka.ErrReplicaGap {
+		println(err)
+		panic("queuefka: replica appended a chunk which does not follow it:")
+	}
+}
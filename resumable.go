@@ -0,0 +1,54 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+// ResumableReader is a Reader under a named cursor which starts where the
+// cursor was last committed, so a single consumer gets checkpointing without
+// keeping track of addresses itself.  The cursor is kept in the topic's
+// OffsetStore like the offset of a consumer group.
+type ResumableReader struct {
+	*Reader
+	store  *OffsetStore
+	cursor string
+}
+
+// NewResumableReader returns a Reader of topic, opened with opts, positioned
+// where cursor was last committed, or at the start of the topic if it never
+// has been.  A committed address since deleted by retention resumes from the
+// oldest message left.
+func NewResumableReader(topic, cursor string, opts ...Option) (*ResumableReader, error) {
+	store, err := NewOffsetStore(topic)
+	if err != nil {
+		return nil, err
+	}
+	address, err := store.Fetch(cursor)
+	if err != nil && err != ErrNoOffset {
+		return nil, err
+	}
+
+	rd, err := NewReader(topic, address, opts...)
+	if err == ErrAddressTruncated || err == ErrEndOfLog {
+		err = nil
+	}
+	if err != nil {
+		if rd != nil {
+			rd.Close()
+		}
+		return nil, err
+	}
+	return &ResumableReader{Reader: rd, store: store, cursor: cursor}, nil
+}
+
+// Position returns the address Commit would record, just after the message
+// last read or where the Reader was opened.
+func (rr *ResumableReader) Position() uint64 {
+	return rr.resume()
+}
+
+// Commit records the Reader's position under its cursor, atomically and
+// durably, for the next NewResumableReader to start from.
+func (rr *ResumableReader) Commit() error {
+	return rr.store.Commit(rr.cursor, rr.resume())
+}
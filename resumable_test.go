@@ -0,0 +1,93 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_ResumableReader(t *testing.T) {
+	resumeTopic := topic + ".resumable"
+	os.RemoveAll(resumeTopic)
+	defer os.RemoveAll(resumeTopic)
+
+	wt, err := queuefka.NewWriter(resumeTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	for i := 0; i < 20; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	rr, err := queuefka.NewResumableReader(resumeTopic, "app")
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 5; i++ {
+		_, err = rr.Read()
+		if err != nil {
+			panic(err)
+		}
+	}
+	committed := rr.Position()
+	err = rr.Commit()
+	if err != nil {
+		panic(err)
+	}
+	// read on without committing
+	rr.Read()
+	rr.Close()
+
+	rr, err = queuefka.NewResumableReader(resumeTopic, "app")
+	if err != nil {
+		panic(err)
+	}
+	if rr.Position() != committed {
+		println(rr.Position(), committed)
+		panic("queuefka: ResumableReader did not open at its commit:")
+	}
+	values := readValues(rr.Reader)
+	if len(values) != 15 || values[0] != "message 5" {
+		println(len(values))
+		panic("queuefka: ResumableReader did not resume from its commit:")
+	}
+	err = rr.Commit()
+	if err != nil {
+		panic(err)
+	}
+	rr.Close()
+
+	// only messages written since are read next time
+	wt.Write([]byte("message 20"))
+	wt.Flush()
+	rr, err = queuefka.NewResumableReader(resumeTopic, "app")
+	if err != nil {
+		panic(err)
+	}
+	values = readValues(rr.Reader)
+	rr.Close()
+	if len(values) != 1 || values[0] != "message 20" {
+		println(len(values))
+		panic("queuefka: ResumableReader did not resume at the end:")
+	}
+
+	// another cursor has its own position
+	rr, err = queuefka.NewResumableReader(resumeTopic, "audit")
+	if err != nil {
+		panic(err)
+	}
+	values = readValues(rr.Reader)
+	rr.Close()
+	if len(values) != 21 {
+		println(len(values))
+		panic("queuefka: cursors shared a position:")
+	}
+}
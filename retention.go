@@ -0,0 +1,281 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RetentionPolicy controls which sealed slabs a Writer deletes, always the
+// oldest first so the log stays contiguous, and never the active slab.  A
+// slab is deleted once any field says so, a zero field is ignored.  The
+// address after the last deleted slab becomes the topic's low watermark.
+type RetentionPolicy struct {
+	MaxAge      time.Duration       `json:"max_age,omitempty"`      // delete slabs whose newest message is older than this
+	MaxBytes    int64               `json:"max_bytes,omitempty"`    // delete the oldest slabs while the topic is larger than this
+	MaxSegments int                 `json:"max_segments,omitempty"` // keep only this many of the newest slabs, counting the active one
+	Interval    time.Duration       `json:"interval,omitempty"`     // apply the policy on a timer, 0 for only ApplyRetention
+	Archive     func(Segment) error `json:"-"`                      // called before a slab is deleted, which is kept if it fails
+	Archiver    Archiver            `json:"-"`                      // uploaded to before a slab is deleted, which is kept if it fails
+}
+
+// SetRetention changes which slabs the Writer deletes, starting or stopping
+// a background goroutine applying a RetentionPolicy with an Interval.
+func (wt *Writer) SetRetention(policy RetentionPolicy) {
+	wt.Lock()
+	defer wt.Unlock()
+
+	wt.retention = policy
+	wt.stopRetentionLoop()
+	if policy.Interval > 0 && !wt.pooled {
+		wt.retentionStop = make(chan struct{})
+		go wt.retentionLoop(policy.Interval, wt.retentionStop)
+	}
+}
+
+// ApplyRetention deletes the sealed slabs the RetentionPolicy no longer
+// keeps, handing each to its Archive func and Archiver first if set.  A Reader still in
+// the middle of a deleted slab may finish reading it.
+func (wt *Writer) ApplyRetention() error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	return wt.applyRetention(time.Now())
+}
+
+// applyRetention deletes slabs expired as of now, caller must hold the lock
+func (wt *Writer) applyRetention(now time.Time) error {
+	p := wt.retention
+	if p.MaxAge <= 0 && p.MaxBytes <= 0 && p.MaxSegments <= 0 {
+		return nil
+	}
+
+	segments, err := SealedSegments(wt.topic)
+	if err != nil {
+		return err
+	}
+
+	// however many of the oldest slabs the strictest policy drops
+	var drop int
+	if p.MaxAge > 0 {
+		for ; drop < len(segments); drop++ {
+			newest, err := segmentNewest(segments[drop])
+			if err != nil {
+				return err
+			}
+			if now.Sub(newest) < p.MaxAge {
+				break
+			}
+		}
+	}
+	if p.MaxBytes > 0 {
+		size := int64(wt.address - wt.base)
+		for _, seg := range segments {
+			size += seg.Size
+		}
+		for i := 0; i < len(segments) && size > p.MaxBytes; i++ {
+			size -= segments[i].Size
+			if drop < i+1 {
+				drop = i + 1
+			}
+		}
+	}
+
+	if p.MaxSegments > 0 {
+		excess := len(segments) + 1 - p.MaxSegments
+		if drop < excess {
+			drop = excess
+		}
+	}
+
+	return wt.removeSegments(segments[:drop])
+}
+
+// removeSegments deletes the oldest sealed segments of the topic, archiving
+// each first if the policy says to, and raises the low watermark past them,
+// caller must hold the lock
+func (wt *Writer) removeSegments(segments []Segment) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	mark, err := readWatermark(wt.topic)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		count, err := segmentCount(seg)
+		if err != nil {
+			return err
+		}
+		if wt.retention.Archive != nil {
+			err = wt.retention.Archive(seg)
+			if err != nil {
+				return err
+			}
+		}
+		if wt.retention.Archiver != nil {
+			err = ArchiveSegment(wt.retention.Archiver, seg)
+			if err == nil {
+				err = recordArchived(wt.topic, seg.Base)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// record the new watermark first so record numbers stay right, unless
+		// a crash already did so before the slab was deleted
+		if seg.Base >= mark.address {
+			mark = watermark{address: seg.Base + uint64(seg.Size), records: mark.records + count}
+		}
+		err = writeWatermark(wt.topic, mark)
+		if err == nil {
+			err = removeSlab(seg.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncDir(wt.topic)
+}
+
+// segmentNewest returns when the last message of a sealed segment was
+// written from its footer or time index, or when the slab was last modified
+// if its messages have no timestamp
+func segmentNewest(seg Segment) (time.Time, error) {
+	f, err := readSlabFooter(seg.Path)
+	if err == nil && f.Size == seg.Size && !f.MaxTime.IsZero() {
+		return f.MaxTime, nil
+	}
+	times, err := segmentTimeIndex(seg)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(times) > 0 {
+		return time.Unix(0, times[len(times)-1].timestamp), nil
+	}
+
+	stat, err := os.Stat(seg.Path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return stat.ModTime(), nil
+}
+
+// watermark is the oldest address still in a topic along with how many
+// records were deleted before it, recorded in a low.watermark file once
+// retention first deletes a slab
+type watermark struct {
+	address uint64 // base address of the oldest slab
+	records uint64 // records in the slabs deleted before it
+}
+
+// watermarkPath returns the file recording a topic's low watermark
+func watermarkPath(topic string) string {
+	return filepath.Join(topic, "low.watermark")
+}
+
+// writeWatermark atomically and durably records the low watermark of topic
+func writeWatermark(topic string, mark watermark) error {
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf, mark.address)
+	binary.LittleEndian.PutUint64(buf[8:], mark.records)
+
+	tmp := watermarkPath(topic) + ".tmp"
+	err := writeFileSync(tmp, buf, 0600)
+	if err == nil {
+		err = os.Rename(tmp, watermarkPath(topic))
+	}
+	if err == nil {
+		err = syncDir(topic)
+	}
+	return err
+}
+
+// readWatermark returns the low watermark recorded for topic, the zero
+// watermark if retention has never deleted a slab
+func readWatermark(topic string) (watermark, error) {
+	buf, err := ioutil.ReadFile(watermarkPath(topic))
+	if os.IsNotExist(err) {
+		return watermark{}, nil
+	} else if err != nil {
+		return watermark{}, err
+	}
+	if len(buf) != 16 {
+		return watermark{}, ErrBadChecksum
+	}
+	return watermark{address: binary.LittleEndian.Uint64(buf), records: binary.LittleEndian.Uint64(buf[8:])}, nil
+}
+
+// LowWatermark returns the base address of the oldest slab left in topic
+// once retention has deleted the slabs before it, along with the number of
+// its first record.  Reading from an earlier address returns
+// ErrAddressTruncated.
+func LowWatermark(topic string) (uint64, uint64, error) {
+	slabs := SlabFiles(topic)
+	if len(slabs) <= 0 {
+		return 0, 0, ErrInvalidTopic
+	}
+	base, err := slabBase(slabs[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	mark, err := readWatermark(topic)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// slabs deleted by hand leave the record number unknown
+	if mark.address != base {
+		return base, 0, nil
+	}
+	return base, mark.records, nil
+}
+
+// removeSlab deletes a sealed slab followed by its sidecar files
+func removeSlab(slab string) error {
+	err := os.Remove(slab)
+	if err != nil {
+		return err
+	}
+	for _, sidecar := range []string{countPath(slab), indexPath(slab), timeIndexPath(slab), producersPath(slab), endPath(slab), footerPath(slab), metaPath(slab), holesPath(slab)} {
+		err = os.Remove(sidecar)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// retentionLoop applies the retention policy every interval until stop is
+// closed
+func (wt *Writer) retentionLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			wt.ApplyRetention()
+		}
+	}
+}
+
+// stopRetentionLoop stops any running retentionLoop, caller must hold the
+// lock
+func (wt *Writer) stopRetentionLoop() {
+	if wt.retentionStop != nil {
+		close(wt.retentionStop)
+		wt.retentionStop = nil
+	}
+}
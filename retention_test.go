@@ -0,0 +1,219 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_RetentionAge(t *testing.T) {
+	ageTopic := topic + ".retentionage"
+	os.RemoveAll(ageTopic)
+	defer os.RemoveAll(ageTopic)
+
+	wt, err := queuefka.NewWriter(ageTopic, 256, queuefka.WithMaxSegmentAge(50*time.Millisecond))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// old messages spread over several slabs, then new ones in their own
+	for i := 0; i < 40; i++ {
+		wt.Write([]byte(fmt.Sprintf("old %d", i)))
+	}
+	time.Sleep(200 * time.Millisecond)
+	for i := 0; i < 40; i++ {
+		wt.Write([]byte(fmt.Sprintf("new %d", i)))
+	}
+	wt.Flush()
+	slabs := len(queuefka.SlabFiles(ageTopic))
+
+	var archived []queuefka.Segment
+	wt.SetRetention(queuefka.RetentionPolicy{
+		MaxAge: 150 * time.Millisecond,
+		Archive: func(seg queuefka.Segment) error {
+			archived = append(archived, seg)
+			return nil
+		},
+	})
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+
+	remaining := queuefka.SlabFiles(ageTopic)
+	if len(archived) == 0 || len(remaining) != slabs-len(archived) {
+		println(slabs, len(archived), len(remaining))
+		panic("queuefka: ApplyRetention did not delete the old slabs:")
+	}
+	for _, seg := range archived {
+		sidecars, _ := filepath.Glob(seg.Path[:len(seg.Path)-5] + ".*")
+		if len(sidecars) != 0 {
+			println(sidecars[0])
+			panic("queuefka: ApplyRetention left a deleted slab's files behind:")
+		}
+	}
+
+	// only the new messages are left
+	base := archived[len(archived)-1].Base + uint64(archived[len(archived)-1].Size)
+	rd, err := queuefka.NewReader(ageTopic, base)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 40 || values[0] != "new 0" {
+		println(len(values), values[0])
+		panic("queuefka: ApplyRetention deleted the wrong slabs:")
+	}
+
+	// a background goroutine applies the policy on its own
+	time.Sleep(200 * time.Millisecond)
+	wt.Write([]byte("latest"))
+	wt.SetRetention(queuefka.RetentionPolicy{MaxAge: 150 * time.Millisecond, Interval: 10 * time.Millisecond})
+	for i := 0; len(queuefka.SlabFiles(ageTopic)) > 1; i++ {
+		if i == 100 {
+			panic("queuefka: retention goroutine did not delete the old slabs:")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_Queuefka_RetentionBytes(t *testing.T) {
+	sizeTopic := topic + ".retentionbytes"
+	os.RemoveAll(sizeTopic)
+	defer os.RemoveAll(sizeTopic)
+
+	wt, err := queuefka.NewWriter(sizeTopic, 256, queuefka.WithRetention(queuefka.RetentionPolicy{MaxBytes: 2048}))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	var addresses []uint64
+	for i := 0; i < 200; i++ {
+		addresses = append(addresses, wt.Address())
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+
+	var size int64
+	for _, slab := range queuefka.SlabFiles(sizeTopic) {
+		stat, _ := os.Stat(slab)
+		size += stat.Size()
+	}
+	if size > 2048 || size < 2048-256 {
+		println(size)
+		panic("queuefka: ApplyRetention did not trim the topic to its byte budget:")
+	}
+
+	// the low watermark is the start of the slab of the first message left
+	low, first, err := queuefka.LowWatermark(sizeTopic)
+	if err != nil {
+		panic(err)
+	}
+	if first == 0 || addresses[first-1] >= low || addresses[first] < low {
+		println(low, first)
+		panic("queuefka: LowWatermark does not match the first message left:")
+	}
+
+	// reading from 0 starts there but an older address is an error
+	rd, err := queuefka.NewReader(sizeTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	d, _ := rd.Read()
+	if string(d) != fmt.Sprintf("message %d", first) {
+		println(string(d), first)
+		panic("queuefka: Reader at address 0 did not start at the low watermark:")
+	}
+	err = rd.Seek(sizeTopic, addresses[1])
+	if err != queuefka.ErrAddressTruncated {
+		println(err)
+		panic("queuefka: Seek before the low watermark did not return ErrAddressTruncated:")
+	}
+	d, _ = rd.Read()
+	if string(d) != fmt.Sprintf("message %d", first) {
+		println(string(d), first)
+		panic("queuefka: Reader was not left at the low watermark:")
+	}
+
+	// record numbers still count the deleted records
+	err = rd.SeekToRecord(first - 1)
+	if err != queuefka.ErrAddressTruncated {
+		println(err)
+		panic("queuefka: SeekToRecord of a deleted record did not return ErrAddressTruncated:")
+	}
+	err = rd.SeekToRecord(first + 10)
+	if err != nil {
+		panic(err)
+	}
+	d, _ = rd.Read()
+	if string(d) != fmt.Sprintf("message %d", first+10) {
+		println(string(d), first+10)
+		panic("queuefka: SeekToRecord after retention returned the wrong message:")
+	}
+}
+
+func Test_Queuefka_RetentionSegments(t *testing.T) {
+	countTopic := topic + ".retentionsegments"
+	os.RemoveAll(countTopic)
+	defer os.RemoveAll(countTopic)
+
+	wt, err := queuefka.NewWriter(countTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	for i := 0; i < 200; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+
+	// a generous byte budget leaves the count to decide
+	wt.SetRetention(queuefka.RetentionPolicy{MaxSegments: 3, MaxBytes: 1 << 20})
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+	if n := len(queuefka.SlabFiles(countTopic)); n != 3 {
+		println(n)
+		panic("queuefka: ApplyRetention did not keep the last 3 slabs:")
+	}
+
+	// and the strictest policy wins
+	wt.SetRetention(queuefka.RetentionPolicy{MaxSegments: 3, MaxBytes: 300})
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+	if n := len(queuefka.SlabFiles(countTopic)); n != 2 {
+		println(n)
+		panic("queuefka: ApplyRetention did not apply the stricter policy:")
+	}
+
+	rd, err := queuefka.NewReader(countTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) == 0 || values[len(values)-1] != "message 199" {
+		println(len(values))
+		panic("queuefka: ApplyRetention deleted the newest messages:")
+	}
+}
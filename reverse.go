@@ -0,0 +1,167 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"time"
+)
+
+// ReverseReader returns the messages of a topic newest first, walking back
+// from the end of the log as it was when the ReverseReader was created.
+// Frames can only be found by reading forwards, so as it reaches each slab
+// the address of every frame in it is collected by skipping over frame
+// headers, which are then read in reverse.
+type ReverseReader struct {
+	rd      *Reader
+	slabs   []uint64  // base addresses of the slabs still to read, oldest first
+	frames  []uint64  // addresses of the frames still to read in the current slab
+	msgs    []message // messages of the current frame still to return
+	address uint64    // address of the frame of the message last returned
+	next    uint64    // address just past that frame
+}
+
+// NewReverseReader returns a ReverseReader starting at the end of topic.
+// Options apply as for NewReader except WithFollow.
+func NewReverseReader(topic string, opts ...Option) (*ReverseReader, error) {
+	rd, err := NewReader(topic, 0, opts...)
+	if err != nil && err != ErrEndOfLog {
+		rd.Close()
+		return nil, err
+	}
+	rd.SetFollow(false)
+
+	var bases []uint64
+	for _, slab := range SlabFiles(topic) {
+		base, err := slabBase(slab)
+		if err != nil {
+			rd.Close()
+			return nil, err
+		}
+		bases = append(bases, base)
+	}
+
+	// find the end of the log now, later messages are not returned
+	rr := &ReverseReader{rd: rd, slabs: bases}
+	if len(bases) > 0 {
+		rr.slabs = bases[:len(bases)-1]
+		err = rr.load(bases[len(bases)-1])
+		if err != nil {
+			rd.Close()
+			return nil, err
+		}
+	}
+
+	return rr, nil
+}
+
+// Read returns the message before the one it last returned, or ErrEndOfLog
+// once it has returned the first message of the log.
+func (rr *ReverseReader) Read() ([]byte, error) {
+	for len(rr.msgs) == 0 {
+		if len(rr.frames) > 0 {
+			address := rr.frames[len(rr.frames)-1]
+			rr.frames = rr.frames[:len(rr.frames)-1]
+			err := rr.readFrame(address)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if len(rr.slabs) == 0 {
+			return nil, ErrEndOfLog
+		}
+		base := rr.slabs[len(rr.slabs)-1]
+		rr.slabs = rr.slabs[:len(rr.slabs)-1]
+		err := rr.load(base)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rr.rd.msg = rr.msgs[len(rr.msgs)-1]
+	rr.msgs = rr.msgs[:len(rr.msgs)-1]
+	return rr.rd.msg.value, nil
+}
+
+// ReadRecord is like Read but returns the message as a Record.  Its
+// NextAddress is the address just past its frame, where a forward Reader
+// would resume after the whole frame.
+func (rr *ReverseReader) ReadRecord() (Record, error) {
+	d, err := rr.Read()
+	if err != nil {
+		return Record{}, err
+	}
+	return rr.rd.record(rr.address, rr.next, d), nil
+}
+
+// load collects the address of every complete frame of the slab at base
+func (rr *ReverseReader) load(base uint64) error {
+	rd := rr.rd
+	rd.end = 0
+	err := rd.Seek(rd.topic, base)
+	for err == nil {
+		address := rd.address
+		err = rd.skip()
+		if err == nil {
+			rr.frames = append(rr.frames, address)
+		}
+	}
+	if err == ErrEndOfLog {
+		return nil
+	}
+	return err
+}
+
+// readFrame reads every message out of the frame at address.  Transaction
+// markers hold none, nor in committed mode do uncommitted transactions.
+func (rr *ReverseReader) readFrame(address uint64) error {
+	rd := rr.rd
+	rd.address, rd.pending = address, nil
+	err := rd.rewind(nil)
+	if err != nil {
+		return err
+	}
+
+	// read only this frame, never on past a marker
+	rd.end = address + 1
+	_, err = rd.next(context.Background(), false)
+	if err == ErrEndOfLog {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rr.msgs = append([]message{rd.msg}, rd.pending...)
+	rr.address, rr.next, rd.pending = address, rd.address, nil
+	return nil
+}
+
+// Address returns the address of the frame holding the message last
+// returned by Read, messages of a compressed batch share the address of the
+// batch.
+func (rr *ReverseReader) Address() uint64 {
+	return rr.address
+}
+
+// Timestamp returns when the message last returned by Read was written
+func (rr *ReverseReader) Timestamp() time.Time {
+	return rr.rd.Timestamp()
+}
+
+// Key returns the key of the message last returned by Read
+func (rr *ReverseReader) Key() []byte {
+	return rr.rd.Key()
+}
+
+// Headers returns the headers of the message last returned by Read
+func (rr *ReverseReader) Headers() []Header {
+	return rr.rd.Headers()
+}
+
+// Close closes the underlying Reader
+func (rr *ReverseReader) Close() error {
+	return rr.rd.Close()
+}
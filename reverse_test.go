@@ -0,0 +1,82 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_ReverseReader(t *testing.T) {
+	revTopic := topic + ".reverse"
+	os.RemoveAll(revTopic)
+	defer os.RemoveAll(revTopic)
+
+	// small slabs so reading back crosses several
+	wt, err := queuefka.NewWriter(revTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	for i := 0; i < 30; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.SetCodec(queuefka.Gzip)
+	wt.WriteBatch([][]byte{[]byte("message 30"), []byte("message 31"), []byte("message 32")})
+	wt.SetCodec(nil)
+	txn, err := wt.Begin()
+	if err != nil {
+		panic(err)
+	}
+	txn.Write([]byte("message 33"))
+	txn.Commit()
+	wt.Write([]byte("message 34"))
+	wt.Flush()
+
+	rr, err := queuefka.NewReverseReader(revTopic)
+	if err != nil {
+		panic(err)
+	}
+	defer rr.Close()
+
+	// messages written after it was created are not returned
+	wt.Write([]byte("too late"))
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(revTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	for i := 34; i >= 0; i-- {
+		rec, err := rr.ReadRecord()
+		if err != nil {
+			panic(err)
+		}
+		if string(rec.Value) != fmt.Sprintf("message %d", i) {
+			println(i, string(rec.Value))
+			panic("queuefka: ReverseReader returned the wrong message:")
+		}
+
+		// the address of a plain message leads a forward Reader back to it
+		if i < 30 {
+			at, err := rd.ReadRecordAt(rec.Address)
+			if err != nil || string(at.Value) != string(rec.Value) || at.NextAddress != rec.NextAddress {
+				println(i, string(at.Value), at.NextAddress, rec.NextAddress, err)
+				panic("queuefka: ReverseReader returned the wrong address:")
+			}
+		}
+	}
+	_, err = rr.Read()
+	if err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("queuefka: ReverseReader did not stop at the start of the log:")
+	}
+}
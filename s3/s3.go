@@ -0,0 +1,240 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package s3 archives sealed queuefka slabs to Amazon S3, or any object
+// store speaking its API with AWS signature version 4 such as Google Cloud
+// Storage or MinIO, using nothing but the standard library.
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnexpectedStatus is returned for any response the object store should
+// not have given.
+var ErrUnexpectedStatus = errors.New("s3: unexpected response status")
+
+// unsignedPayload stands in for the hash of a body streamed without reading
+// it twice, allowed by S3 over https
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyPayload is the hash of an empty body
+const emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Archiver stores slabs as objects named Prefix followed by the slab file
+// name in Bucket.  It implements queuefka.Archiver and queuefka.Fetcher.
+type Archiver struct {
+	Bucket       string       // bucket the objects go in
+	Prefix       string       // prepended to every object name e.g. "mytopic/"
+	Region       string       // region of the bucket e.g. "us-east-1"
+	Endpoint     string       // e.g. "https://storage.googleapis.com", empty for AWS
+	AccessKey    string       // access key id
+	SecretKey    string       // secret access key
+	SessionToken string       // for temporary credentials, may be empty
+	Client       *http.Client // nil for http.DefaultClient
+}
+
+// New returns an Archiver for bucket with credentials and region taken from
+// the usual AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and
+// AWS_REGION environment variables.
+func New(bucket, prefix string) *Archiver {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &Archiver{
+		Bucket:       bucket,
+		Prefix:       prefix,
+		Region:       region,
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Upload stores size bytes from r as the object for name.
+func (a *Archiver) Upload(name string, r io.Reader, size int64) error {
+	req, err := a.request("PUT", name, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := a.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	discard(resp)
+	if resp.StatusCode != http.StatusOK {
+		return ErrUnexpectedStatus
+	}
+	return nil
+}
+
+// Exists reports whether the object for name has been stored.
+func (a *Archiver) Exists(name string) (bool, error) {
+	req, err := a.request("HEAD", name, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := a.do(req, emptyPayload)
+	if err != nil {
+		return false, err
+	}
+	discard(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, ErrUnexpectedStatus
+}
+
+// Download copies the object for name to w.
+func (a *Archiver) Download(name string, w io.Writer) error {
+	req, err := a.request("GET", name, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do(req, emptyPayload)
+	if err != nil {
+		return err
+	}
+	defer discard(resp)
+	if resp.StatusCode != http.StatusOK {
+		return ErrUnexpectedStatus
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Delete removes the object for name, which need not exist.
+func (a *Archiver) Delete(name string) error {
+	req, err := a.request("DELETE", name, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do(req, emptyPayload)
+	if err != nil {
+		return err
+	}
+	discard(resp)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return ErrUnexpectedStatus
+	}
+	return nil
+}
+
+// request returns an unsigned request for the object holding name, virtual
+// host style on AWS and path style on any other endpoint
+func (a *Archiver) request(method, name string, body io.Reader) (*http.Request, error) {
+	key := "/" + uriEncode(a.Prefix+name, false)
+	url := fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", a.Bucket, a.Region, key)
+	if a.Endpoint != "" {
+		url = strings.TrimSuffix(a.Endpoint, "/") + "/" + uriEncode(a.Bucket, true) + key
+	}
+	return http.NewRequest(method, url, body)
+}
+
+// do signs and sends req whose body hashes to payload
+func (a *Archiver) do(req *http.Request, payload string) (*http.Response, error) {
+	a.sign(req, payload, time.Now().UTC())
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// discard drains and closes the body of resp so the connection can be
+// reused
+func discard(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// sign adds an AWS signature version 4 Authorization header to req, signing
+// every header already set along with the host
+func (a *Archiver) sign(req *http.Request, payload string, t time.Time) {
+	stamp := t.Format("20060102T150405Z")
+	date := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	// canonical headers are lower case, sorted and trimmed
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical bytes.Buffer
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	request := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonical.String(), signed, payload}, "\n")
+
+	scope := date + "/" + a.Region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := hmacSHA256([]byte("AWS4"+a.SecretKey), date)
+	key = hmacSHA256(key, a.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.AccessKey, scope, signed, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode percent encodes s as S3 expects, leaving only unreserved
+// characters alone, and slashes too unless slash is set
+func uriEncode(s string, slash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+)
+
+// NewReaderAtEnd returns a new Reader positioned at the end of topic, see
+// SeekToEnd.
+func NewReaderAtEnd(topic string, opts ...Option) (*Reader, error) {
+	rd, err := NewReader(topic, 0, opts...)
+	if err != nil && err != ErrEndOfLog {
+		return rd, err
+	}
+	return rd, rd.SeekToEnd()
+}
+
+// SeekToEnd positions the Reader after the last message currently in the
+// log, its high watermark, so that it only returns messages written from
+// now on.  The active slab is scanned frame by frame to find where its last
+// complete frame ends, as the slab may end part way through a frame or with
+// preallocated zeros.
+func (rd *Reader) SeekToEnd() error {
+	slabs := SlabFiles(rd.topic)
+	if len(slabs) <= 0 {
+		return ErrInvalidTopic
+	}
+	base, err := slabBase(slabs[len(slabs)-1])
+	if err != nil {
+		return err
+	}
+
+	err = rd.Seek(rd.topic, base)
+	for err == nil {
+		err = rd.skip()
+	}
+	if err == ErrEndOfLog {
+		return nil
+	}
+	return err
+}
+
+// skip moves past the next frame without reading its payload, returning
+// ErrEndOfLog if there is no complete frame in the current slab
+func (rd *Reader) skip() error {
+	err := rd.skipHole()
+	if err != nil {
+		return err
+	}
+	size, err := rd.slabSize()
+	if err != nil {
+		return err
+	}
+	avail := rd.base + uint64(size) - rd.address
+	if avail == 0 {
+		return ErrEndOfLog
+	}
+
+	n := uint64(maxFrameHeaderSize)
+	if avail < n {
+		n = avail
+	}
+	peek, err := rd.peek(int(n))
+	if err != nil {
+		return rd.rewind(err)
+	}
+	fh, err := decodeFrameHeader(rd.version, peek)
+	if err == io.ErrUnexpectedEOF || (err != nil && rd.unwritten(1)) {
+		return rd.rewind(ErrEndOfLog)
+	} else if err != nil {
+		return err
+	}
+	flen := uint64(fh.size) + uint64(fh.dlen)
+	if avail < flen {
+		return rd.rewind(ErrEndOfLog)
+	}
+
+	_, err = rd.discard(int(flen))
+	if err != nil {
+		return rd.rewind(err)
+	}
+	rd.address += flen
+	return nil
+}
+
+// SeekToTime positions the Reader at the first message written at or after
+// t, or at the end of the log if there is none yet, assuming timestamps
+// increase through the log.  Slabs are binary searched by the timestamp of
+// their first message and the one holding t is then scanned, from the closest
+// frame before t in its .timeindex sidecar file if it is sealed.  Messages of
+// slabs older than FormatV2 have no timestamp so are always skipped.
+func (rd *Reader) SeekToTime(t time.Time) error {
+	slabs := SlabFiles(rd.topic)
+	if len(slabs) <= 0 {
+		return ErrInvalidTopic
+	}
+
+	// the first slab starting at or after t, t is in the one before it
+	var serr error
+	i := sort.Search(len(slabs), func(i int) bool {
+		start, err := rd.slabTime(slabs[i])
+		if err != nil && err != ErrEndOfLog {
+			serr = err
+		}
+		return err != nil || !start.Before(t)
+	})
+	if serr != nil {
+		return serr
+	}
+	if i > 0 {
+		i--
+	}
+	start, err := slabBase(slabs[i])
+	if err != nil {
+		return err
+	}
+
+	// jump to the last indexed frame of a sealed slab written before t
+	if i < len(slabs)-1 {
+		size, err := slabLength(slabs[i])
+		if err != nil {
+			return err
+		}
+		times, err := segmentTimeIndex(Segment{Base: start, Path: slabs[i], Size: size})
+		if err != nil {
+			return err
+		}
+		j := sort.Search(len(times), func(j int) bool { return times[j].timestamp >= t.UnixNano() })
+		if j > 0 {
+			start += times[j-1].offset
+		}
+	}
+
+	defer rd.scanning()()
+	err = rd.Seek(rd.topic, start)
+	for err == nil {
+		batched := len(rd.pending) > 0
+		_, err = rd.next(context.Background(), false)
+		if err == nil && !rd.Timestamp().Before(t) {
+			return rd.unread(batched)
+		}
+	}
+	if err == ErrEndOfLog {
+		return nil
+	}
+	return err
+}
+
+// slabTime returns the timestamp of the first message in slab
+func (rd *Reader) slabTime(slab string) (time.Time, error) {
+	base, err := slabBase(slab)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	first := &Reader{topic: rd.topic}
+	defer first.Close()
+	err = first.Seek(rd.topic, base)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, err = first.next(context.Background(), false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return first.Timestamp(), nil
+}
+
+// NewReaderAtRecord returns a new Reader positioned at record number n of
+// topic, see SeekToRecord.
+func NewReaderAtRecord(topic string, n uint64, opts ...Option) (*Reader, error) {
+	rd, err := NewReader(topic, 0, opts...)
+	if err != nil && err != ErrEndOfLog {
+		return rd, err
+	}
+	return rd, rd.SeekToRecord(n)
+}
+
+// SeekToRecord positions the Reader at record number n, where records are
+// numbered from zero at the start of the log.  Every message of a compressed
+// batch is a record, transaction markers are not, and messages of aborted
+// transactions are records even though a committed mode Reader skips them.
+// The slab holding n is found from the .count sidecar files of the sealed
+// slabs, which record how many records each holds, and is then scanned from
+// the closest frame before n in its .index sidecar file.
+// Seeking to the record after the last one is seeking to the end of the log,
+// any further returns ErrOutOfBounds, and to a record deleted by retention
+// returns ErrAddressTruncated with the Reader at the low watermark.
+func (rd *Reader) SeekToRecord(n uint64) error {
+	segments, err := SealedSegments(rd.topic)
+	if err != nil {
+		return err
+	}
+
+	// records before the low watermark were deleted by retention
+	low, deleted, err := LowWatermark(rd.topic)
+	if err != nil {
+		return err
+	}
+	if n < deleted {
+		rd.Seek(rd.topic, low)
+		return ErrAddressTruncated
+	}
+	n -= deleted
+
+	slabs := SlabFiles(rd.topic)
+	start, err := slabBase(slabs[len(slabs)-1])
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		count, err := segmentCount(seg)
+		if err != nil {
+			return err
+		}
+		if n >= count {
+			n -= count
+			continue
+		}
+
+		// jump to the closest indexed frame before n
+		index, err := segmentIndex(seg)
+		if err != nil {
+			return err
+		}
+		i := sort.Search(len(index), func(i int) bool { return index[i].record > n })
+		start = seg.Base
+		if i > 0 {
+			start += index[i-1].offset
+			n -= index[i-1].record
+		}
+		break
+	}
+
+	// every record counts whatever the outcome of its transaction
+	defer rd.scanning()()
+	committed := rd.committed
+	rd.committed = false
+	defer func() { rd.committed = committed }()
+
+	err = rd.Seek(rd.topic, start)
+	for err == nil && n > 0 {
+		_, err = rd.next(context.Background(), false)
+		if err == nil {
+			n--
+		}
+	}
+	if err == ErrEndOfLog {
+		if n > 0 {
+			return ErrOutOfBounds
+		}
+		return nil
+	}
+	return err
+}
+
+// scanning lifts the rate limit while seeking reads through messages, call
+// the returned func to restore it
+func (rd *Reader) scanning() func() {
+	byteLimit, msgLimit := rd.byteLimit, rd.msgLimit
+	rd.byteLimit, rd.msgLimit = nil, nil
+	return func() { rd.byteLimit, rd.msgLimit = byteLimit, msgLimit }
+}
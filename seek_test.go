@@ -0,0 +1,188 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_SeekToEnd(t *testing.T) {
+	endTopic := topic + ".seekend"
+	os.RemoveAll(endTopic)
+	defer os.RemoveAll(endTopic)
+
+	for _, opts := range [][]queuefka.Option{nil, {queuefka.WithPreallocate(true)}} {
+		os.RemoveAll(endTopic)
+		wt, err := queuefka.NewWriter(endTopic, 512, opts...)
+		if err != nil {
+			panic(err)
+		}
+
+		// an empty topic is already at its end
+		rd, err := queuefka.NewReaderAtEnd(endTopic)
+		if err != nil {
+			panic(err)
+		}
+
+		for i := 0; i < 50; i++ {
+			wt.Write(value)
+		}
+		wt.Flush()
+		err = rd.SeekToEnd()
+		if err != nil {
+			panic(err)
+		}
+		_, err = rd.Read()
+		if err != queuefka.ErrEndOfLog {
+			println(err)
+			panic("queuefka: Reader after SeekToEnd returned an old message:")
+		}
+
+		// only messages written afterwards are read
+		wt.Write([]byte("latest"))
+		wt.Flush()
+		d, err := rd.Read()
+		if err != nil || string(d) != "latest" {
+			println(string(d), err)
+			panic("queuefka: Reader after SeekToEnd missed a new message:")
+		}
+
+		rd.Close()
+		wt.Close()
+	}
+}
+
+func Test_Queuefka_SeekToTime(t *testing.T) {
+	timeTopic := topic + ".seektime"
+	os.RemoveAll(timeTopic)
+	defer os.RemoveAll(timeTopic)
+
+	// small slabs so the search has several to choose from
+	wt, err := queuefka.NewWriter(timeTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	var starts []time.Time
+	for group := 0; group < 3; group++ {
+		time.Sleep(5 * time.Millisecond)
+		starts = append(starts, time.Now())
+		for i := 0; i < 20; i++ {
+			wt.Write([]byte(fmt.Sprintf("message %d.%d", group, i)))
+		}
+		wt.SetCodec(queuefka.Gzip)
+		wt.WriteBatch([][]byte{[]byte(fmt.Sprintf("batch %d", group))})
+		wt.SetCodec(nil)
+	}
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(timeTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	for group, start := range starts {
+		err = rd.SeekToTime(start)
+		if err != nil {
+			panic(err)
+		}
+		d, err := rd.Read()
+		if err != nil || string(d) != fmt.Sprintf("message %d.0", group) {
+			println(group, string(d), err)
+			panic("queuefka: SeekToTime did not find the first message at its time:")
+		}
+		if rd.Timestamp().Before(start) {
+			panic("queuefka: SeekToTime returned a message from before its time:")
+		}
+	}
+
+	// a time after every message is the end of the log
+	err = rd.SeekToTime(time.Now())
+	if err != nil {
+		panic(err)
+	}
+	_, err = rd.Read()
+	if err != queuefka.ErrEndOfLog {
+		println(err)
+		panic("queuefka: SeekToTime past the last message did not seek to the end:")
+	}
+}
+
+func Test_Queuefka_SeekToRecord(t *testing.T) {
+	recTopic := topic + ".seekrecord"
+	os.RemoveAll(recTopic)
+	defer os.RemoveAll(recTopic)
+
+	// small slabs so records are spread over several
+	wt, err := queuefka.NewWriter(recTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// records 0-29 are plain, 30-32 a compressed batch and 33-35 a committed
+	// transaction whose marker is not a record
+	for i := 0; i < 30; i++ {
+		wt.Write([]byte(fmt.Sprintf("record %d", i)))
+	}
+	wt.SetCodec(queuefka.Gzip)
+	wt.WriteBatch([][]byte{[]byte("record 30"), []byte("record 31"), []byte("record 32")})
+	wt.SetCodec(nil)
+	txn, err := wt.Begin()
+	if err != nil {
+		panic(err)
+	}
+	for i := 33; i < 36; i++ {
+		txn.Write([]byte(fmt.Sprintf("record %d", i)))
+	}
+	txn.Commit()
+	wt.Write([]byte("record 36"))
+	wt.Flush()
+
+	rd, err := queuefka.NewReaderAtRecord(recTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+
+	for _, n := range []uint64{36, 0, 17, 31, 29, 34, 5} {
+		err = rd.SeekToRecord(n)
+		if err != nil {
+			panic(err)
+		}
+		d, err := rd.Read()
+		if err != nil || string(d) != fmt.Sprintf("record %d", n) {
+			println(n, string(d), err)
+			panic("queuefka: SeekToRecord positioned at the wrong record:")
+		}
+	}
+
+	count, err := queuefka.CountMessages(recTopic)
+	if err != nil || count != 37 {
+		println(count, err)
+		panic("queuefka: CountMessages disagrees with record numbers:")
+	}
+
+	err = rd.SeekToRecord(37)
+	if err != nil {
+		panic(err)
+	}
+	_, err = rd.Read()
+	if err != queuefka.ErrEndOfLog {
+		panic("queuefka: SeekToRecord past the last record did not seek to the end:")
+	}
+	err = rd.SeekToRecord(38)
+	if err != queuefka.ErrOutOfBounds {
+		println(err)
+		panic("queuefka: SeekToRecord beyond the end did not return ErrOutOfBounds:")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Segment describes a single slab file of a topic.
+type Segment struct {
+	Base uint64 // absolute address of the start of the slab
+	Path string // path to the slab file
+	Size int64  // size of the slab file in bytes
+}
+
+// slabPath returns the slab file name for a base address e.g. <base>.slab
+func (wt *Writer) slabPath(base uint64) string {
+	return fmt.Sprintf("%s/%s", wt.topic, wt.naming.name(base))
+}
+
+// slabBase parses the base address out of a slab file name e.g. <base>.slab,
+// whatever its extension
+func slabBase(path string) (uint64, error) {
+	return strconv.ParseUint(leadingDigits(filepath.Base(path)), 10, 64)
+}
+
+// slabStem returns the path of a slab without its extension, to which each
+// sidecar file adds its own e.g. <base>.count
+func slabStem(slab string) string {
+	return filepath.Join(filepath.Dir(slab), leadingDigits(filepath.Base(slab)))
+}
+
+// findSlab returns the position in slabs, ordered as by SlabFiles, of the
+// last slab starting at or before address along with its base address, or
+// the first slab if every one starts after address
+func findSlab(slabs []string, address uint64) (int, uint64) {
+	i := sort.Search(len(slabs), func(i int) bool {
+		base, _ := slabBase(slabs[i])
+		return base > address
+	})
+	if i > 0 {
+		i--
+	}
+	base, _ := slabBase(slabs[i])
+	return i, base
+}
+
+// SealedSegments returns every slab in topic except the active one currently
+// being appended to.  Sealed segments are immutable so each may be handed to
+// a separate worker and read concurrently with NewSegmentReader.
+func SealedSegments(topic string) ([]Segment, error) {
+	slabs := SlabFiles(topic)
+	if len(slabs) <= 0 {
+		return nil, ErrInvalidTopic
+	}
+
+	segments := make([]Segment, 0, len(slabs)-1)
+	for _, slab := range slabs[:len(slabs)-1] {
+		base, err := slabBase(slab)
+		if err != nil {
+			return nil, err
+		}
+		size, err := slabLength(slab)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, Segment{Base: base, Path: slab, Size: size})
+	}
+
+	return segments, nil
+}
+
+// NewSegmentReader returns a Reader over a single segment which returns
+// ErrEndOfLog at the end of the segment instead of rolling to the next slab.
+func NewSegmentReader(seg Segment) (*Reader, error) {
+	rd := &Reader{topic: filepath.Dir(seg.Path)}
+
+	err := rd.Seek(rd.topic, seg.Base)
+	if err != nil {
+		return rd, err
+	}
+	rd.end = seg.Base + uint64(seg.Size)
+
+	return rd, nil
+}
+
+// countPath returns the sidecar file recording how many messages a sealed
+// slab holds e.g. <base>.count
+func countPath(slab string) string {
+	return slabStem(slab) + ".count"
+}
+
+// writeSlabCount atomically records the message count of a sealed slab
+func writeSlabCount(slab string, count uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, count)
+
+	tmp := countPath(slab) + ".tmp"
+	err := ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, countPath(slab))
+}
+
+// readSlabCount returns the message count recorded for a sealed slab
+func readSlabCount(slab string) (uint64, error) {
+	buf, err := ioutil.ReadFile(countPath(slab))
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 8 {
+		return 0, ErrBadChecksum
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// scanSlabCount counts the messages in a segment by reading every one
+func scanSlabCount(seg Segment) (uint64, error) {
+	rd, err := NewSegmentReader(seg)
+	if err == ErrEndOfLog {
+		rd.Close()
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer rd.Close()
+
+	var count uint64
+	for {
+		_, err := rd.Read()
+		if err == ErrEndOfLog {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// segmentCount returns the message count of a sealed segment from its .count
+// sidecar file, rebuilding it from its footer or by scanning the segment if
+// missing
+func segmentCount(seg Segment) (uint64, error) {
+	count, err := readSlabCount(seg.Path)
+	if err == nil {
+		return count, nil
+	}
+	f, err := readSlabFooter(seg.Path)
+	if err == nil && f.Size == seg.Size {
+		return f.Records, writeSlabCount(seg.Path, f.Records)
+	}
+	count, err = scanSlabCount(seg)
+	if err != nil {
+		return count, err
+	}
+	return count, writeSlabCount(seg.Path, count)
+}
+
+// CountMessages returns the number of messages in topic.  Sealed segments
+// are counted from their .count sidecar files, which are rebuilt by scanning
+// the segment if missing, so only the active slab is read in full.
+func CountMessages(topic string) (uint64, error) {
+	segments, err := SealedSegments(topic)
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, seg := range segments {
+		count, err := segmentCount(seg)
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+
+	// the active slab is still growing so always scan it
+	slabs := SlabFiles(topic)
+	active := slabs[len(slabs)-1]
+	base, err := slabBase(active)
+	if err != nil {
+		return total, err
+	}
+	stat, err := os.Stat(active)
+	if err != nil {
+		return total, err
+	}
+	count, err := scanSlabCount(Segment{Base: base, Path: active, Size: stat.Size()})
+	return total + count, err
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_SealedSegments(t *testing.T) {
+	segTopic := topic + ".segments"
+	os.RemoveAll(segTopic)
+	defer os.RemoveAll(segTopic)
+
+	wt, err := queuefka.NewWriter(segTopic, 1024)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	for i := 0; i < 200; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %012d", i)))
+	}
+	wt.Flush()
+
+	segments, err := queuefka.SealedSegments(segTopic)
+	if err != nil {
+		panic(err)
+	}
+	if len(segments) < 2 || len(segments) != len(queuefka.SlabFiles(segTopic))-1 {
+		panic("queuefka: SealedSegments should exclude only the active slab:")
+	}
+
+	// reading every sealed segment in turn should yield messages in order
+	i := 0
+	for _, seg := range segments {
+		rd, err := queuefka.NewSegmentReader(seg)
+		if err != nil {
+			panic(err)
+		}
+		for {
+			raw, err := rd.Read()
+			if err == queuefka.ErrEndOfLog {
+				break
+			} else if err != nil {
+				panic(err)
+			}
+			if string(raw) != fmt.Sprintf("message %012d", i) {
+				println(string(raw))
+				panic("queuefka: segment Read returned the wrong message:")
+			}
+			i++
+		}
+		rd.Close()
+	}
+
+	// the rest are in the active slab
+	count, err := queuefka.CountMessages(segTopic)
+	if err != nil {
+		panic(err)
+	}
+	if i == 0 || uint64(i) >= count {
+		panic("queuefka: segment reader crossed its segment boundary:")
+	}
+}
+
+func Test_Queuefka_CountMessages(t *testing.T) {
+	countTopic := topic + ".count"
+	os.RemoveAll(countTopic)
+	defer os.RemoveAll(countTopic)
+
+	wt, err := queuefka.NewWriter(countTopic, 1024)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	// leave the active slab partially filled
+	for i := 0; i < 250; i++ {
+		wt.Write(value)
+	}
+	wt.Flush()
+
+	// full scan to compare against
+	rd, err := queuefka.NewReader(countTopic, 0x0000)
+	if err != nil {
+		panic(err)
+	}
+	scanned := uint64(0)
+	for {
+		_, err := rd.Read()
+		if err == queuefka.ErrEndOfLog {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		scanned++
+	}
+	rd.Close()
+
+	count, err := queuefka.CountMessages(countTopic)
+	if err != nil {
+		panic(err)
+	}
+	if count != scanned || count != 250 {
+		println(count, scanned)
+		panic("queuefka: CountMessages does not match a full scan:")
+	}
+
+	// a missing sidecar is rebuilt
+	segments, _ := queuefka.SealedSegments(countTopic)
+	sidecar := segments[0].Path[:len(segments[0].Path)-5] + ".count"
+	os.Remove(sidecar)
+
+	count, err = queuefka.CountMessages(countTopic)
+	if err != nil {
+		panic(err)
+	}
+	if count != scanned {
+		println(count, scanned)
+		panic("queuefka: CountMessages did not rebuild a missing sidecar:")
+	}
+	if _, err := os.Stat(sidecar); err != nil {
+		panic(err)
+	}
+}
+
+func Test_Queuefka_MaxSegmentAge(t *testing.T) {
+	ageTopic := topic + ".segmentage"
+	os.RemoveAll(ageTopic)
+	defer os.RemoveAll(ageTopic)
+
+	// a size hint which is never reached
+	age := queuefka.WithMaxSegmentAge(50 * time.Millisecond)
+	wt, err := queuefka.NewWriter(ageTopic, segmentSizeHint, age)
+	if err != nil {
+		panic(err)
+	}
+
+	// an empty slab does not age
+	time.Sleep(60 * time.Millisecond)
+	wt.Write([]byte("message 0"))
+	wt.Write([]byte("message 1"))
+	if n := len(queuefka.SlabFiles(ageTopic)); n != 1 {
+		println(n)
+		panic("queuefka: Writer rolled a slab before its first message aged:")
+	}
+
+	// the next message after the first has aged starts a fresh slab
+	time.Sleep(60 * time.Millisecond)
+	wt.WriteBatch([][]byte{[]byte("message 2"), []byte("message 3")})
+	if n := len(queuefka.SlabFiles(ageTopic)); n != 2 {
+		println(n)
+		panic("queuefka: Writer did not roll an aged slab:")
+	}
+
+	// a reopened Writer ages the slab from its first message too
+	wt.Close()
+	time.Sleep(60 * time.Millisecond)
+	wt, err = queuefka.NewWriter(ageTopic, segmentSizeHint, age)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	wt.Write([]byte("message 4"))
+	wt.Flush()
+	if n := len(queuefka.SlabFiles(ageTopic)); n != 3 {
+		println(n)
+		panic("queuefka: reopened Writer did not roll an aged slab:")
+	}
+
+	rd, err := queuefka.NewReader(ageTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 5 || values[4] != "message 4" {
+		println(len(values))
+		panic("queuefka: messages were lost rolling aged slabs:")
+	}
+}
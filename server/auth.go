@@ -0,0 +1,173 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/internal/wire"
+)
+
+// Authenticator decides who a client is, so a Server with one serves only
+// clients it lets in.  A connection over TLS is first authenticated by its
+// TLS state alone, and otherwise by the token of its OpAuth request.  An
+// HTTP request presents its token as "Authorization: Bearer <token>".  A
+// Kafka connection, which has no way to send a token, must be let in by its
+// TLS state.
+type Authenticator interface {
+	// Authenticate returns the principal presenting token over a connection
+	// with TLS state, either of which may be nil, or an error if the client
+	// is not let in.
+	Authenticate(token []byte, state *tls.ConnectionState) (string, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(token []byte, state *tls.ConnectionState) (string, error)
+
+// Authenticate calls f(token, state).
+func (f AuthenticatorFunc) Authenticate(token []byte, state *tls.ConnectionState) (string, error) {
+	return f(token, state)
+}
+
+// StaticTokens authenticates each token it maps as that principal.
+type StaticTokens map[string]string
+
+// Authenticate returns the principal of token.
+func (t StaticTokens) Authenticate(token []byte, state *tls.ConnectionState) (string, error) {
+	principal, ok := t[string(token)]
+	if token == nil || !ok {
+		return "", ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// TLSIdentity authenticates a client by the common name of the certificate
+// it presented, which the Server must verify by setting TLSConfig.ClientCAs
+// and ClientAuth to tls.RequireAndVerifyClientCert or
+// tls.VerifyClientCertIfGiven.
+type TLSIdentity struct{}
+
+// Authenticate returns the common name of the verified client certificate.
+func (TLSIdentity) Authenticate(token []byte, state *tls.ConnectionState) (string, error) {
+	if state == nil || len(state.VerifiedChains) == 0 || state.VerifiedChains[0][0].Subject.CommonName == "" {
+		return "", ErrUnauthenticated
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName, nil
+}
+
+// Errors of authentication
+var (
+	ErrUnauthenticated = errors.New("server: not authenticated")
+	ErrForbidden       = errors.New("server: not authorized for topic")
+)
+
+// authenticate returns the principal presenting token over a connection
+// with TLS state, the empty string if the Server has no Authenticator
+func (s *Server) authenticate(token []byte, state *tls.ConnectionState) (string, error) {
+	if s.Authenticator == nil {
+		return "", nil
+	}
+	principal, err := s.Authenticator.Authenticate(token, state)
+	if err != nil {
+		return "", ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// authorize fails with ErrForbidden unless principal may produce to topic,
+// or consume from it if produce is false
+func (s *Server) authorize(principal, topic string, produce bool) error {
+	allowed := s.CanConsume
+	if produce {
+		allowed = s.CanProduce
+	}
+	if allowed != nil && !allowed(principal, topic) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// visible returns the topics principal may consume from
+func (s *Server) visible(principal string, topics []queuefka.TopicInfo) []queuefka.TopicInfo {
+	if s.CanConsume == nil {
+		return topics
+	}
+	var found []queuefka.TopicInfo
+	for _, t := range topics {
+		if s.CanConsume(principal, t.Name) {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+// handshake completes the TLS handshake of a conn over TLS and lets it in
+// if its TLS state is enough for the Authenticator
+func (c *conn) handshake() error {
+	tc, ok := c.nc.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	err := tc.Handshake()
+	if err != nil {
+		return err
+	}
+	state := tc.ConnectionState()
+	principal, err := c.s.authenticate(nil, &state)
+	if err == nil {
+		c.setPrincipal(principal)
+	}
+	return nil
+}
+
+// auth lets the conn in as the principal of the token of a request
+func (c *conn) auth(d *wire.Decoder, e *wire.Encoder) error {
+	token := d.Bytes()
+	if d.Err != nil || len(d.Buf) > 0 {
+		return ErrBadRequest
+	}
+	var state *tls.ConnectionState
+	if tc, ok := c.nc.(*tls.Conn); ok {
+		s := tc.ConnectionState()
+		state = &s
+	}
+	principal, err := c.s.authenticate(token, state)
+	if err != nil {
+		return err
+	}
+	c.setPrincipal(principal)
+	e.String(principal)
+	return nil
+}
+
+// setPrincipal records who the conn was let in as
+func (c *conn) setPrincipal(principal string) {
+	c.amu.Lock()
+	defer c.amu.Unlock()
+	c.principal, c.authenticated = principal, true
+}
+
+// identity returns who the conn was let in as, or ErrUnauthenticated if the
+// Server has an Authenticator which has not let it in yet
+func (c *conn) identity() (string, error) {
+	c.amu.Lock()
+	defer c.amu.Unlock()
+	if c.s.Authenticator != nil && !c.authenticated {
+		return "", ErrUnauthenticated
+	}
+	return c.principal, nil
+}
+
+// authorize fails unless the conn may produce to topic, or consume from it
+// if produce is false
+func (c *conn) authorize(topic string, produce bool) error {
+	principal, err := c.identity()
+	if err != nil {
+		return err
+	}
+	return c.s.authorize(principal, topic, produce)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ubergarm/queuefka/internal/wire"
+)
+
+func Test_Server_Auth(t *testing.T) {
+	s, addr, done := testServer()
+	defer done()
+	s.Authenticator = StaticTokens{"alice-token": "alice", "bob-token": "bob"}
+	// alice may produce to and consume from anything, bob only consume from public
+	s.CanProduce = func(principal, topic string) bool { return principal == "alice" }
+	s.CanConsume = func(principal, topic string) bool { return principal == "alice" || topic == "public" }
+
+	auth := func(c *testConn, token string) (string, error) {
+		d, err := c.call(OpAuth, func(e *wire.Encoder) { e.Bytes([]byte(token)) })
+		if err != nil {
+			return "", err
+		}
+		return d.String(), nil
+	}
+
+	alice := dial(addr)
+	defer alice.nc.Close()
+	_, err := alice.produce("public", "before")
+	if err != ErrUnauthenticated {
+		println(err)
+		panic("server: served a connection before it authenticated:")
+	}
+	_, err = auth(alice, "wrong")
+	if err != ErrUnauthenticated {
+		println(err)
+		panic("server: let in a connection with a wrong token:")
+	}
+	principal, err := auth(alice, "alice-token")
+	if err != nil || principal != "alice" {
+		println(err, principal)
+		panic("server: did not let in a connection with its token:")
+	}
+	for _, topic := range []string{"public", "private"} {
+		_, err = alice.produce(topic, "message")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	bob := dial(addr)
+	defer bob.nc.Close()
+	_, err = auth(bob, "bob-token")
+	if err != nil {
+		panic(err)
+	}
+	_, err = bob.produce("public", "message")
+	if err != ErrForbidden {
+		println(err)
+		panic("server: let a principal produce without authorization:")
+	}
+	_, records, err := bob.fetch("public", 0, 0, 0)
+	if err != nil || len(records) != 1 {
+		println(err)
+		panic("server: did not let a principal consume with authorization:")
+	}
+	_, _, err = bob.fetch("private", 0, 0, 0)
+	if err != ErrForbidden {
+		println(err)
+		panic("server: let a principal consume without authorization:")
+	}
+	d, err := bob.call(OpMetadata, func(e *wire.Encoder) { e.String("") })
+	if err != nil {
+		panic(err)
+	}
+	if d.Uint32() != 1 || d.String() != "public" {
+		panic("server: metadata listed a topic the principal may not consume from:")
+	}
+
+	// HTTP presents its token as a bearer token
+	srv := httptest.NewServer(s.HTTPHandler())
+	defer srv.Close()
+	post := func(topic, token string) int {
+		req, _ := http.NewRequest("POST", srv.URL+"/topics/"+topic+"/records", strings.NewReader("message"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := post("public", ""); status != http.StatusUnauthorized {
+		println(status)
+		panic("server: served an HTTP request without a token:")
+	}
+	if status := post("public", "bob-token"); status != http.StatusForbidden {
+		println(status)
+		panic("server: let an HTTP request produce without authorization:")
+	}
+	if status := post("public", "alice-token"); status != http.StatusOK {
+		println(status)
+		panic("server: refused an HTTP request with authorization:")
+	}
+}
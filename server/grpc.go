@@ -0,0 +1,710 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// grpcService is the path prefix of the methods of the service defined in
+// queuefka.proto
+const grpcService = "/queuefka.v1.Queuefka/"
+
+// gRPC status codes sent, see
+// https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	grpcOK                 = 0
+	grpcCancelled          = 1
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcOutOfRange         = 11
+	grpcUnimplemented      = 12
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// ListenAndServeGRPC listens on the TCP address addr and serves the gRPC
+// API to it, see ServeGRPC.
+func (s *Server) ListenAndServeGRPC(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeGRPC(l)
+}
+
+// ServeGRPC serves the gRPC API of GRPCHandler on l over unencrypted
+// HTTP/2, until l fails or the Server is closed, when it returns
+// ErrServerClosed.  Over TLS, serve GRPCHandler from an http.Server with a
+// TLS listener instead, which negotiates HTTP/2 itself.
+func (s *Server) ServeGRPC(l net.Listener) error {
+	hs := &http.Server{Handler: s.GRPCHandler(), Protocols: new(http.Protocols)}
+	hs.Protocols.SetUnencryptedHTTP2(true)
+	gl := grpcListener{Listener: l, hs: hs}
+	if !s.track(gl) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(gl)
+
+	err := hs.Serve(l)
+	if s.isClosed() {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// grpcListener is a listener served by an http.Server, which closing the
+// listener closes along with its connections
+type grpcListener struct {
+	net.Listener
+	hs *http.Server
+}
+
+func (gl grpcListener) Close() error {
+	return gl.hs.Close()
+}
+
+// GRPCHandler returns an http.Handler serving the gRPC API of queuefka.proto,
+// the service queuefka.v1.Queuefka, over HTTP/2:
+//
+//	Produce        append messages to a topic, creating it if need be
+//	ProduceStream  append the messages of each request of a stream in turn
+//	Fetch          messages from an address, waiting for the first
+//	Subscribe      stream every message from an address and each new one
+//	ListTopics     the topics with their watermarks
+//	DeleteTopic    remove a topic, for a principal which may produce to it
+//
+// Clients present a token as the bearer token of the authorization
+// metadata.  Messages may not be compressed and requests are limited to
+// MaxFrameSize.  A grpc-timeout is honoured.
+func (s *Server) GRPCHandler() http.Handler {
+	return http.HandlerFunc(s.serveGRPC)
+}
+
+// handling counts a request in the handlers Close waits for, unless the
+// Server is already closed
+func (s *Server) handling() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.handlers.Add(1)
+	return true
+}
+
+// serveGRPC answers a gRPC call, its status in the trailers
+func (s *Server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	var err error
+	if s.handling() {
+		err = s.grpcCall(w, r)
+		s.handlers.Done()
+	} else {
+		err = ErrServerClosed
+	}
+	code, msg := grpcStatus(err)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", grpcEscape(msg))
+	}
+}
+
+// grpcCall authenticates and routes a gRPC call
+func (s *Server) grpcCall(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if v := r.Header.Get("Grpc-Timeout"); v != "" {
+		timeout, err := grpcTimeout(v)
+		if err != nil {
+			return err
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var token []byte
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = []byte(strings.TrimPrefix(auth, "Bearer "))
+	}
+	principal, err := s.authenticate(token, r.TLS)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(r.URL.Path, grpcService) {
+		return errGRPCUnimplemented
+	}
+	switch strings.TrimPrefix(r.URL.Path, grpcService) {
+	case "Produce":
+		return s.grpcProduce(ctx, w, r, principal, false)
+	case "ProduceStream":
+		return s.grpcProduce(ctx, w, r, principal, true)
+	case "Fetch":
+		return s.grpcFetch(ctx, w, r, principal)
+	case "Subscribe":
+		return s.grpcSubscribe(ctx, w, r, principal)
+	case "ListTopics":
+		return s.grpcListTopics(w, r, principal)
+	case "DeleteTopic":
+		return s.grpcDeleteTopic(w, r, principal)
+	}
+	return errGRPCUnimplemented
+}
+
+// grpcProduce appends the messages of a ProduceRequest, or of every one of
+// a stream, and replies with a ProduceResponse
+func (s *Server) grpcProduce(ctx context.Context, w http.ResponseWriter, r *http.Request, principal string, stream bool) error {
+	var address uint64
+	for n := 0; ; n++ {
+		req, err := grpcRead(r.Body, s.maxFrame())
+		if err == io.EOF && (stream || n > 0) {
+			break
+		} else if err == io.EOF {
+			return ErrBadRequest
+		} else if err != nil {
+			return err
+		}
+		d := &pbDecoder{buf: req}
+		var topic string
+		var messages []message
+		for {
+			f, t := d.field()
+			if f == 0 {
+				break
+			}
+			switch {
+			case f == 1 && t == pbBytes:
+				topic = string(d.bytes())
+			case f == 2 && t == pbBytes:
+				messages = append(messages, decodePBMessage(d.bytes(), d))
+			default:
+				d.skip(t)
+			}
+		}
+		if d.err != nil {
+			return d.err
+		}
+		err = s.authorize(principal, topic, true)
+		if err != nil {
+			return err
+		}
+		address, err = s.produce(topic, messages)
+		if err != nil {
+			return err
+		}
+		if !stream || ctx.Err() != nil {
+			break
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var e pbEncoder
+	e.uint64(1, address)
+	return grpcWrite(w, e)
+}
+
+// decodePBMessage returns the Message b, any error left in d
+func decodePBMessage(b []byte, d *pbDecoder) message {
+	md := &pbDecoder{buf: b}
+	var m message
+	for {
+		f, t := md.field()
+		if f == 0 {
+			break
+		}
+		switch {
+		case f == 1 && t == pbBytes:
+			m.key = md.bytes()
+		case f == 2 && t == pbBytes:
+			m.value = md.bytes()
+		case f == 3 && t == pbBytes:
+			hd := &pbDecoder{buf: md.bytes()}
+			var h queuefka.Header
+			for {
+				f, t := hd.field()
+				if f == 0 {
+					break
+				}
+				switch {
+				case f == 1 && t == pbBytes:
+					h.Key = string(hd.bytes())
+				case f == 2 && t == pbBytes:
+					h.Value = hd.bytes()
+				default:
+					hd.skip(t)
+				}
+			}
+			if hd.err != nil {
+				md.err = hd.err
+			}
+			m.headers = append(m.headers, h)
+		default:
+			md.skip(t)
+		}
+	}
+	if md.err != nil {
+		d.err = md.err
+	}
+	if m.value == nil {
+		m.value = []byte{}
+	}
+	return m
+}
+
+// grpcFetch answers a FetchRequest with a FetchResponse
+func (s *Server) grpcFetch(ctx context.Context, w http.ResponseWriter, r *http.Request, principal string) error {
+	req, err := grpcUnary(r, s.maxFrame())
+	if err != nil {
+		return err
+	}
+	d := &pbDecoder{buf: req}
+	var topic string
+	var address, maxRecords, maxBytes, wait uint64
+	for {
+		f, t := d.field()
+		if f == 0 {
+			break
+		}
+		switch {
+		case f == 1 && t == pbBytes:
+			topic = string(d.bytes())
+		case f == 2 && t == pbVarint:
+			address = d.varint()
+		case f == 3 && t == pbVarint:
+			maxRecords = d.varint()
+		case f == 4 && t == pbVarint:
+			maxBytes = d.varint()
+		case f == 5 && t == pbVarint:
+			wait = d.varint()
+		default:
+			d.skip(t)
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	err = s.authorize(principal, topic, false)
+	if err != nil {
+		return err
+	}
+	if maxBytes == 0 || maxBytes > DefaultFetchBytes {
+		maxBytes = DefaultFetchBytes
+	}
+	if limit := uint64(s.maxFrame() / 2); maxBytes > limit {
+		maxBytes = limit
+	}
+
+	var records pbEncoder
+	var count int
+	next, err := s.fetch(ctx, topic, address, int(uint32(maxRecords)), time.Duration(uint32(wait))*time.Millisecond, func(rec queuefka.Record) bool {
+		before := len(records)
+		records.message(2, pbRecord(rec))
+		if count > 0 && uint64(len(records)) > maxBytes {
+			records = records[:before]
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	var e pbEncoder
+	e.uint64(1, next)
+	e = append(e, records...)
+	return grpcWrite(w, e)
+}
+
+// grpcSubscribe streams a Record for every message of the topic of a
+// SubscribeRequest from its address, then for each new one as it is
+// appended, until the client cancels.  As with a WebSocket the Reader only
+// moves on once a message has been written, and a subscriber which takes
+// longer than SubscribeWriteTimeout to accept one is cut off.
+func (s *Server) grpcSubscribe(ctx context.Context, w http.ResponseWriter, r *http.Request, principal string) error {
+	req, err := grpcUnary(r, s.maxFrame())
+	if err != nil {
+		return err
+	}
+	d := &pbDecoder{buf: req}
+	var topic string
+	var address uint64
+	for {
+		f, t := d.field()
+		if f == 0 {
+			break
+		}
+		switch {
+		case f == 1 && t == pbBytes:
+			topic = string(d.bytes())
+		case f == 2 && t == pbVarint:
+			address = d.varint()
+		default:
+			d.skip(t)
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	err = s.authorize(principal, topic, false)
+	if err != nil {
+		return err
+	}
+
+	rd, err := s.Manager.NewReader(topic, address, queuefka.WithFollow(true))
+	if err != nil && err != queuefka.ErrEndOfLog {
+		if rd != nil {
+			rd.Close()
+		}
+		return err
+	}
+	it := rd.Iterator()
+	defer it.Close()
+
+	rc := http.NewResponseController(w)
+	for it.NextContext(ctx) {
+		rc.SetWriteDeadline(time.Now().Add(s.subscribeWriteTimeout()))
+		err = grpcWrite(w, pbRecord(it.Record()))
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return it.Err()
+}
+
+// grpcListTopics answers a ListTopicsRequest with the topics principal may
+// consume from
+func (s *Server) grpcListTopics(w http.ResponseWriter, r *http.Request, principal string) error {
+	topic, err := grpcTopicRequest(r, s.maxFrame())
+	if err != nil {
+		return err
+	}
+	if topic != "" {
+		err = s.authorize(principal, topic, false)
+		if err != nil {
+			return err
+		}
+	}
+	found, err := s.topics(topic)
+	if err != nil {
+		return err
+	}
+
+	var e pbEncoder
+	for _, t := range s.visible(principal, found) {
+		var info pbEncoder
+		info.string(1, t.Name)
+		info.uint64(2, t.LowWatermark)
+		info.uint64(3, t.HighWatermark)
+		info.uint64(4, uint64(len(t.Partitions)))
+		e.message(1, info)
+	}
+	return grpcWrite(w, e)
+}
+
+// grpcDeleteTopic answers a DeleteTopicRequest
+func (s *Server) grpcDeleteTopic(w http.ResponseWriter, r *http.Request, principal string) error {
+	topic, err := grpcTopicRequest(r, s.maxFrame())
+	if err != nil {
+		return err
+	}
+	err = s.authorize(principal, topic, true)
+	if err != nil {
+		return err
+	}
+	if _, err = s.topics(topic); err != nil {
+		return err
+	}
+	err = s.Manager.DeleteTopic(topic)
+	if err != nil {
+		return err
+	}
+	return grpcWrite(w, nil)
+}
+
+// grpcTopicRequest returns the topic, field 1, of a request holding nothing
+// else
+func grpcTopicRequest(r *http.Request, max uint32) (string, error) {
+	req, err := grpcUnary(r, max)
+	if err != nil {
+		return "", err
+	}
+	d := &pbDecoder{buf: req}
+	var topic string
+	for {
+		f, t := d.field()
+		if f == 0 {
+			break
+		}
+		if f == 1 && t == pbBytes {
+			topic = string(d.bytes())
+		} else {
+			d.skip(t)
+		}
+	}
+	return topic, d.err
+}
+
+// pbRecord encodes rec as a Record
+func pbRecord(rec queuefka.Record) pbEncoder {
+	var e pbEncoder
+	e.uint64(1, rec.Address)
+	e.uint64(2, rec.NextAddress)
+	if !rec.Timestamp.IsZero() {
+		e.uint64(3, uint64(rec.Timestamp.UnixNano()))
+	}
+	e.bytes(4, rec.Key)
+	e.bytes(5, rec.Value)
+	for _, h := range rec.Headers {
+		var he pbEncoder
+		he.string(1, h.Key)
+		he.bytes(2, h.Value)
+		e.message(6, he)
+	}
+	return e
+}
+
+// errGRPCUnimplemented is returned for a method the service does not have
+var errGRPCUnimplemented = fmt.Errorf("server: unknown gRPC method")
+
+// grpcStatus returns the status code and message of a call failing with err
+func grpcStatus(err error) (int, string) {
+	switch err {
+	case nil:
+		return grpcOK, ""
+	case ErrBadRequest:
+		return grpcInvalidArgument, err.Error()
+	case queuefka.ErrInvalidTopic:
+		return grpcNotFound, err.Error()
+	case queuefka.ErrPartitioned:
+		return grpcFailedPrecondition, err.Error()
+	case queuefka.ErrAddressTruncated, queuefka.ErrOutOfBounds:
+		return grpcOutOfRange, err.Error()
+	case queuefka.ErrRecordTooLarge, ErrFrameTooLarge, queuefka.ErrQuotaExceeded:
+		return grpcResourceExhausted, err.Error()
+	case ErrUnauthenticated:
+		return grpcUnauthenticated, err.Error()
+	case ErrForbidden:
+		return grpcPermissionDenied, err.Error()
+	case ErrServerClosed:
+		return grpcUnavailable, err.Error()
+	case errGRPCUnimplemented:
+		return grpcUnimplemented, err.Error()
+	case context.Canceled:
+		return grpcCancelled, err.Error()
+	case context.DeadlineExceeded:
+		return grpcDeadlineExceeded, err.Error()
+	}
+	return grpcUnknown, err.Error()
+}
+
+// grpcEscape percent encodes msg for the grpc-message trailer
+func grpcEscape(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcTimeout parses a grpc-timeout, e.g. 100m for 100 milliseconds
+func grpcTimeout(v string) (time.Duration, error) {
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[v[len(v)-1]]
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 32)
+	if !ok || err != nil || len(v) > 9 {
+		return 0, ErrBadRequest
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// grpcRead returns the next length prefixed message from r no longer than
+// max, or io.EOF at the end of the stream
+func grpcRead(r io.Reader, max uint32) ([]byte, error) {
+	hdr := make([]byte, 5)
+	_, err := io.ReadFull(r, hdr)
+	if err == io.ErrUnexpectedEOF {
+		return nil, ErrBadRequest
+	} else if err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		// no grpc-encoding is accepted, so nothing may be compressed
+		return nil, ErrBadRequest
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > max {
+		return nil, ErrFrameTooLarge
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		return nil, ErrBadRequest
+	}
+	return msg, nil
+}
+
+// grpcUnary returns the one request message of a call
+func grpcUnary(r *http.Request, max uint32) ([]byte, error) {
+	msg, err := grpcRead(r.Body, max)
+	if err == io.EOF {
+		return nil, ErrBadRequest
+	}
+	return msg, err
+}
+
+// grpcWrite sends msg length prefixed and flushes it to the client
+func grpcWrite(w http.ResponseWriter, msg pbEncoder) error {
+	hdr := make([]byte, 5)
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	_, err := w.Write(append(hdr, msg...))
+	if err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// protobuf wire types used
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+// pbEncoder appends the fields of a protobuf message, leaving out those
+// with zero values as proto3 does
+type pbEncoder []byte
+
+func (e *pbEncoder) key(field int, wireType uint8) {
+	*e = binary.AppendUvarint(*e, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *pbEncoder) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.key(field, pbVarint)
+	*e = binary.AppendUvarint(*e, v)
+}
+
+func (e *pbEncoder) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.key(field, pbBytes)
+	*e = binary.AppendUvarint(*e, uint64(len(b)))
+	*e = append(*e, b...)
+}
+
+func (e *pbEncoder) string(field int, s string) {
+	e.bytes(field, []byte(s))
+}
+
+// message appends m as an embedded message, even an empty one
+func (e *pbEncoder) message(field int, m pbEncoder) {
+	e.key(field, pbBytes)
+	*e = binary.AppendUvarint(*e, uint64(len(m)))
+	*e = append(*e, m...)
+}
+
+// pbDecoder reads the fields of a protobuf message, anything malformed
+// leaving err set to ErrBadRequest and ending the fields
+type pbDecoder struct {
+	buf []byte
+	err error
+}
+
+// field returns the number and wire type of the next field, 0 at the end
+func (d *pbDecoder) field() (int, uint8) {
+	if d.err != nil || len(d.buf) == 0 {
+		return 0, 0
+	}
+	k := d.varint()
+	if d.err == nil && k>>3 == 0 {
+		d.err = ErrBadRequest
+	}
+	if d.err != nil {
+		return 0, 0
+	}
+	return int(k >> 3), uint8(k & 7)
+}
+
+func (d *pbDecoder) varint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrBadRequest
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// next returns the next n bytes
+func (d *pbDecoder) next(n uint64) []byte {
+	if d.err != nil || n > uint64(len(d.buf)) {
+		d.err = ErrBadRequest
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *pbDecoder) bytes() []byte {
+	n := d.varint()
+	return d.next(n)
+}
+
+// skip passes over a field of an unknown number, or of an unexpected type
+func (d *pbDecoder) skip(wireType uint8) {
+	switch wireType {
+	case pbVarint:
+		d.varint()
+	case pbFixed64:
+		d.next(8)
+	case pbBytes:
+		d.bytes()
+	case pbFixed32:
+		d.next(4)
+	default:
+		d.err = ErrBadRequest
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// grpcClient calls the gRPC API of a Server over unencrypted HTTP/2
+type grpcClient struct {
+	hc    *http.Client
+	url   string
+	token string
+}
+
+// testGRPC serves s.ServeGRPC on a new listener and returns a client for it
+func testGRPC(s *Server) (*grpcClient, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.ServeGRPC(l) }()
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	c := &grpcClient{hc: &http.Client{Transport: tr}, url: "http://" + l.Addr().String()}
+	return c, func() {
+		tr.CloseIdleConnections()
+		s.Close()
+		if err := <-served; err != ErrServerClosed {
+			println(err)
+			panic("server: ServeGRPC did not report the Server closed:")
+		}
+	}
+}
+
+// start sends the request messages to method and returns the response,
+// whose body holds the response messages
+func (c *grpcClient) start(ctx context.Context, method string, reqs ...pbEncoder) *http.Response {
+	var body []byte
+	for _, req := range reqs {
+		hdr := make([]byte, 5)
+		binary.BigEndian.PutUint32(hdr[1:], uint32(len(req)))
+		body = append(append(body, hdr...), req...)
+	}
+	r, err := http.NewRequestWithContext(ctx, "POST", c.url+grpcService+method, bytes.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	if c.token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.hc.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" {
+		println(resp.StatusCode, resp.Header.Get("Content-Type"))
+		panic("server: gRPC call was not answered as one:")
+	}
+	return resp
+}
+
+// call makes a call and returns its response messages and grpc-status
+func (c *grpcClient) call(method string, reqs ...pbEncoder) ([][]byte, string) {
+	resp := c.start(context.Background(), method, reqs...)
+	defer resp.Body.Close()
+	var msgs [][]byte
+	for {
+		msg, err := grpcRead(resp.Body, 1<<20)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, resp.Trailer.Get("Grpc-Status")
+}
+
+// pbMessage encodes a Message with a header if key is not nil
+func pbMessage(key, value string) pbEncoder {
+	var m pbEncoder
+	if key != "" {
+		m.string(1, key)
+		var h pbEncoder
+		h.string(1, "trace")
+		h.string(2, "id-"+key)
+		m.message(3, h)
+	}
+	m.string(2, value)
+	return m
+}
+
+// pbTopic encodes a request naming topic and, for a fetch or subscribe, an
+// address
+func pbTopic(topic string, address uint64) pbEncoder {
+	var e pbEncoder
+	e.string(1, topic)
+	e.uint64(2, address)
+	return e
+}
+
+// readPBRecord returns the address, value and header values of a Record
+func readPBRecord(b []byte) (uint64, string, []string) {
+	d := &pbDecoder{buf: b}
+	var address uint64
+	var value string
+	var headers []string
+	for {
+		f, t := d.field()
+		if f == 0 {
+			break
+		}
+		switch {
+		case f == 1:
+			address = d.varint()
+		case f == 5:
+			value = string(d.bytes())
+		case f == 6:
+			hd := &pbDecoder{buf: d.bytes()}
+			for f, _ := hd.field(); f != 0; f, _ = hd.field() {
+				if v := string(hd.bytes()); f == 2 {
+					headers = append(headers, v)
+				}
+			}
+		default:
+			d.skip(t)
+		}
+	}
+	if d.err != nil {
+		panic(d.err)
+	}
+	return address, value, headers
+}
+
+func Test_Server_GRPC(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	c, stop := testGRPC(s)
+	defer stop()
+
+	var produce pbEncoder
+	produce.string(1, "orders")
+	produce.message(2, pbMessage("", "one"))
+	produce.message(2, pbMessage("k", "two"))
+	msgs, status := c.call("Produce", produce)
+	if status != "0" || len(msgs) != 1 {
+		println(status, len(msgs))
+		panic("server: gRPC Produce failed:")
+	}
+
+	var stream []pbEncoder
+	for _, v := range []string{"three", "four"} {
+		var req pbEncoder
+		req.string(1, "orders")
+		req.message(2, pbMessage("", v))
+		stream = append(stream, req)
+	}
+	msgs, status = c.call("ProduceStream", stream...)
+	if status != "0" || len(msgs) != 1 {
+		println(status, len(msgs))
+		panic("server: gRPC ProduceStream failed:")
+	}
+	d := &pbDecoder{buf: msgs[0]}
+	d.field()
+	high := d.varint()
+
+	msgs, status = c.call("Fetch", pbTopic("orders", 0))
+	if status != "0" || len(msgs) != 1 {
+		println(status, len(msgs))
+		panic("server: gRPC Fetch failed:")
+	}
+	var next uint64
+	var values []string
+	d = &pbDecoder{buf: msgs[0]}
+	for {
+		f, _ := d.field()
+		if f == 0 {
+			break
+		} else if f == 1 {
+			next = d.varint()
+			continue
+		}
+		_, value, headers := readPBRecord(d.bytes())
+		if value == "two" && (len(headers) != 1 || headers[0] != "id-k") {
+			println(len(headers))
+			panic("server: gRPC Fetch lost the header of a keyed message:")
+		}
+		values = append(values, value)
+	}
+	if next != high || len(values) != 4 || values[0] != "one" || values[3] != "four" {
+		println(next, high, len(values))
+		panic("server: gRPC Fetch returned the wrong messages:")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp := c.start(ctx, "Subscribe", pbTopic("orders", 0))
+	for i := 0; i < 5; i++ {
+		if i == 4 {
+			var req pbEncoder
+			req.string(1, "orders")
+			req.message(2, pbMessage("", "five"))
+			c.call("Produce", req)
+		}
+		msg, err := grpcRead(resp.Body, 1<<20)
+		if err != nil {
+			panic(err)
+		}
+		address, value, _ := readPBRecord(msg)
+		if i == 4 && (address != high || value != "five") {
+			println(address, high, value)
+			panic("server: gRPC Subscribe did not stream a new message:")
+		}
+	}
+	cancel()
+	resp.Body.Close()
+
+	var list pbEncoder
+	msgs, status = c.call("ListTopics", list)
+	if status != "0" || len(msgs) != 1 {
+		println(status, len(msgs))
+		panic("server: gRPC ListTopics failed:")
+	}
+	d = &pbDecoder{buf: msgs[0]}
+	d.field()
+	info := &pbDecoder{buf: d.bytes()}
+	info.field()
+	if name := string(info.bytes()); name != "orders" {
+		println(name)
+		panic("server: gRPC ListTopics did not list the topic:")
+	}
+
+	_, status = c.call("DeleteTopic", pbTopic("orders", 0))
+	if status != "0" {
+		println(status)
+		panic("server: gRPC DeleteTopic failed:")
+	}
+	_, status = c.call("DeleteTopic", pbTopic("orders", 0))
+	if status != "5" {
+		println(status)
+		panic("server: gRPC DeleteTopic of a missing topic was not NOT_FOUND:")
+	}
+	_, status = c.call("Nope", list)
+	if status != "12" {
+		println(status)
+		panic("server: unknown gRPC method was not UNIMPLEMENTED:")
+	}
+}
+
+func Test_Server_GRPCAuth(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	s.Authenticator = StaticTokens{"alice-token": "alice", "bob-token": "bob"}
+	s.CanProduce = func(principal, topic string) bool { return principal == "alice" }
+	c, stop := testGRPC(s)
+	defer stop()
+
+	var req pbEncoder
+	req.string(1, "orders")
+	req.message(2, pbMessage("", "one"))
+	if _, status := c.call("Produce", req); status != "16" {
+		println(status)
+		panic("server: gRPC call without a token was not UNAUTHENTICATED:")
+	}
+	c.token = "bob-token"
+	if _, status := c.call("Produce", req); status != "7" {
+		println(status)
+		panic("server: gRPC Produce by a consumer was not PERMISSION_DENIED:")
+	}
+	c.token = "alice-token"
+	if _, status := c.call("Produce", req); status != "0" {
+		println(status)
+		panic("server: gRPC Produce by a producer failed:")
+	}
+	c.token = "bob-token"
+	if _, status := c.call("DeleteTopic", pbTopic("orders", 0)); status != "7" {
+		println(status)
+		panic("server: gRPC DeleteTopic by a consumer was not PERMISSION_DENIED:")
+	}
+}
+
+func Test_Server_GRPCTimeout(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	c, stop := testGRPC(s)
+	defer stop()
+
+	var produce pbEncoder
+	produce.string(1, "orders")
+	produce.message(2, pbMessage("", "one"))
+	msgs, _ := c.call("Produce", produce)
+	d := &pbDecoder{buf: msgs[0]}
+	d.field()
+	high := d.varint()
+
+	// a subscriber waiting past the end is ended by its grpc-timeout
+	r, err := http.NewRequest("POST", c.url+grpcService+"Subscribe", nil)
+	if err != nil {
+		panic(err)
+	}
+	req := pbTopic("orders", high)
+	hdr := make([]byte, 5)
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(req)))
+	r.Body = io.NopCloser(bytes.NewReader(append(hdr, req...)))
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("Grpc-Timeout", "100m")
+	start := time.Now()
+	resp, err := c.hc.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if status := resp.Trailer.Get("Grpc-Status"); status != "4" || time.Since(start) > 5*time.Second {
+		println(status)
+		panic("server: gRPC Subscribe did not end with DEADLINE_EXCEEDED:")
+	}
+}
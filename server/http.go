@@ -0,0 +1,268 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// DefaultFetchRecords is how many messages an HTTP fetch returns at most
+// unless it asks for another number.
+const DefaultFetchRecords = 100
+
+// httpTopic is a topic listed by GET /topics
+type httpTopic struct {
+	Name          string `json:"name"`
+	LowWatermark  uint64 `json:"low_watermark"`
+	HighWatermark uint64 `json:"high_watermark"`
+	Partitions    int    `json:"partitions"`
+}
+
+// httpHeader is a header of a message returned by GET /topics/{t}/records
+type httpHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// httpRecord is a message returned by GET /topics/{t}/records, its key and
+// value base64 encoded
+type httpRecord struct {
+	Address     uint64       `json:"address"`
+	NextAddress uint64       `json:"next_address"`
+	Timestamp   string       `json:"timestamp,omitempty"`
+	Key         []byte       `json:"key,omitempty"`
+	Value       []byte       `json:"value"`
+	Headers     []httpHeader `json:"headers,omitempty"`
+}
+
+// newHTTPRecord copies rec, which is only valid until the next read
+func newHTTPRecord(rec queuefka.Record) httpRecord {
+	r := httpRecord{
+		Address:     rec.Address,
+		NextAddress: rec.NextAddress,
+		Key:         append([]byte(nil), rec.Key...),
+		Value:       append([]byte{}, rec.Value...),
+	}
+	if !rec.Timestamp.IsZero() {
+		r.Timestamp = rec.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	for _, h := range rec.Headers {
+		r.Headers = append(r.Headers, httpHeader{Key: h.Key, Value: append([]byte{}, h.Value...)})
+	}
+	return r
+}
+
+// HTTPHandler returns an http.Handler serving the topics of the Server for
+// curl level tooling and webhooks:
+//
+//	GET  /topics                   every topic with its watermarks
+//	POST /topics/{t}/records       append the request body as one message,
+//	                               keyed by the key parameter if given
+//	GET  /topics/{t}/records       messages from the address in the from
+//	                               parameter, at most max of them and about
+//	                               max_bytes, waiting up to wait, e.g. 30s,
+//	                               for the first if there are none yet
+//	GET  /topics/{t}/subscribe     a WebSocket streaming every message from
+//	                               the address in the from parameter and
+//	                               each new one as it is appended
+//
+// Responses are JSON, keys and values base64 encoded, and each fetch gives
+// the next_address to fetch from next.  Errors are JSON too, with a status
+// such as 404 for a missing topic or 410 for messages deleted by retention.
+func (s *Server) HTTPHandler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+// serveHTTP routes an HTTP request once it is authenticated and authorized
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var token []byte
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = []byte(strings.TrimPrefix(auth, "Bearer "))
+	}
+	principal, err := s.authenticate(token, r.TLS)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpError(w, err)
+		return
+	}
+
+	path := r.URL.Path
+	if path == "/topics" || path == "/topics/" {
+		if r.Method != "GET" {
+			httpMethodNotAllowed(w, "GET")
+			return
+		}
+		s.httpTopics(w, r, principal)
+		return
+	}
+	if !strings.HasPrefix(path, "/topics/") {
+		http.NotFound(w, r)
+		return
+	}
+	path = strings.TrimPrefix(path, "/topics/")
+	switch {
+	case strings.HasSuffix(path, "/records"):
+		topic := strings.TrimSuffix(path, "/records")
+		switch r.Method {
+		case "GET":
+			if err := s.authorize(principal, topic, false); err != nil {
+				httpError(w, err)
+				return
+			}
+			s.httpFetch(w, r, topic)
+		case "POST":
+			if err := s.authorize(principal, topic, true); err != nil {
+				httpError(w, err)
+				return
+			}
+			s.httpProduce(w, r, topic)
+		default:
+			httpMethodNotAllowed(w, "GET, POST")
+		}
+	case strings.HasSuffix(path, "/subscribe"):
+		if r.Method != "GET" {
+			httpMethodNotAllowed(w, "GET")
+			return
+		}
+		topic := strings.TrimSuffix(path, "/subscribe")
+		if err := s.authorize(principal, topic, false); err != nil {
+			httpError(w, err)
+			return
+		}
+		s.httpSubscribe(w, r, topic)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// httpTopics lists every topic principal may consume from
+func (s *Server) httpTopics(w http.ResponseWriter, r *http.Request, principal string) {
+	topics, err := s.topics("")
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	topics = s.visible(principal, topics)
+	list := make([]httpTopic, 0, len(topics))
+	for _, t := range topics {
+		list = append(list, httpTopic{Name: t.Name, LowWatermark: t.LowWatermark, HighWatermark: t.HighWatermark, Partitions: len(t.Partitions)})
+	}
+	httpJSON(w, http.StatusOK, list)
+}
+
+// httpProduce appends the body of r to topic
+func (s *Server) httpProduce(w http.ResponseWriter, r *http.Request, topic string) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(s.maxFrame())))
+	if err != nil {
+		httpError(w, queuefka.ErrRecordTooLarge)
+		return
+	}
+	m := message{value: body}
+	if key, ok := r.URL.Query()["key"]; ok {
+		m.key = []byte(key[0])
+	}
+	address, err := s.produce(topic, []message{m})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	httpJSON(w, http.StatusOK, map[string]uint64{"next_address": address})
+}
+
+// httpFetch returns the messages of topic asked for by r
+func (s *Server) httpFetch(w http.ResponseWriter, r *http.Request, topic string) {
+	q := r.URL.Query()
+	var address, max, maxBytes uint64 = 0, DefaultFetchRecords, DefaultFetchBytes
+	var wait time.Duration
+	var err error
+	if v := q.Get("from"); v != "" && err == nil {
+		address, err = strconv.ParseUint(v, 10, 64)
+	}
+	if v := q.Get("max"); v != "" && err == nil {
+		max, err = strconv.ParseUint(v, 10, 32)
+	}
+	if v := q.Get("max_bytes"); v != "" && err == nil {
+		maxBytes, err = strconv.ParseUint(v, 10, 32)
+	}
+	if v := q.Get("wait"); v != "" && err == nil {
+		wait, err = time.ParseDuration(v)
+	}
+	if err != nil || wait < 0 {
+		httpError(w, ErrBadRequest)
+		return
+	}
+	if maxBytes == 0 || maxBytes > DefaultFetchBytes {
+		maxBytes = DefaultFetchBytes
+	}
+
+	records := []httpRecord{}
+	var size uint64
+	next, err := s.fetch(r.Context(), topic, address, int(max), wait, func(rec queuefka.Record) bool {
+		size += uint64(len(rec.Key) + len(rec.Value))
+		if len(records) > 0 && size > maxBytes {
+			return false
+		}
+		records = append(records, newHTTPRecord(rec))
+		return true
+	})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	httpJSON(w, http.StatusOK, struct {
+		NextAddress uint64       `json:"next_address"`
+		Records     []httpRecord `json:"records"`
+	}{next, records})
+}
+
+// httpStatus returns the status of a response failing with err
+func httpStatus(err error) int {
+	switch err {
+	case ErrBadRequest:
+		return http.StatusBadRequest
+	case queuefka.ErrInvalidTopic:
+		return http.StatusNotFound
+	case queuefka.ErrPartitioned:
+		return http.StatusConflict
+	case queuefka.ErrAddressTruncated:
+		return http.StatusGone
+	case queuefka.ErrOutOfBounds:
+		return http.StatusRequestedRangeNotSatisfiable
+	case queuefka.ErrRecordTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case queuefka.ErrQuotaExceeded:
+		return http.StatusTooManyRequests
+	case ErrUnauthenticated:
+		return http.StatusUnauthorized
+	case ErrForbidden:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// httpError sends err as a JSON error response
+func httpError(w http.ResponseWriter, err error) {
+	httpJSON(w, httpStatus(err), map[string]string{"error": err.Error()})
+}
+
+// httpMethodNotAllowed refuses a request with a method other than allow
+func httpMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	httpJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+}
+
+// httpJSON sends v as a JSON response with status
+func httpJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
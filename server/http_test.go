@@ -0,0 +1,113 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fetched is the body of a GET /topics/{t}/records response
+type fetched struct {
+	NextAddress uint64       `json:"next_address"`
+	Records     []httpRecord `json:"records"`
+}
+
+func httpGet(url string, v interface{}) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		panic(err)
+	}
+	return resp.StatusCode
+}
+
+func httpPost(url, body string) (int, uint64) {
+	resp, err := http.Post(url, "text/plain", strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	var produced struct {
+		NextAddress uint64 `json:"next_address"`
+	}
+	json.NewDecoder(resp.Body).Decode(&produced)
+	return resp.StatusCode, produced.NextAddress
+}
+
+func Test_Server_HTTP(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	srv := httptest.NewServer(s.HTTPHandler())
+	defer srv.Close()
+
+	var high uint64
+	for i := 0; i < 10; i++ {
+		var status int
+		status, high = httpPost(srv.URL+"/topics/orders/records?key=k", fmt.Sprintf("message %d", i))
+		if status != http.StatusOK {
+			println(status)
+			panic("server: POST did not append a message:")
+		}
+	}
+
+	var f fetched
+	status := httpGet(srv.URL+"/topics/orders/records?from=0&max=4", &f)
+	if status != http.StatusOK || len(f.Records) != 4 || string(f.Records[0].Value) != "message 0" || string(f.Records[0].Key) != "k" {
+		println(status, len(f.Records))
+		panic("server: GET did not return the first messages:")
+	}
+	httpGet(fmt.Sprintf("%s/topics/orders/records?from=%d", srv.URL, f.NextAddress), &f)
+	if len(f.Records) != 6 || string(f.Records[5].Value) != "message 9" || f.NextAddress != high {
+		println(len(f.Records), f.NextAddress, high)
+		panic("server: GET did not return the rest of the messages:")
+	}
+
+	// long polling returns as soon as a message is appended
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		httpPost(srv.URL+"/topics/orders/records", "late")
+	}()
+	start := time.Now()
+	httpGet(fmt.Sprintf("%s/topics/orders/records?from=%d&wait=5s", srv.URL, high), &f)
+	if len(f.Records) != 1 || string(f.Records[0].Value) != "late" || time.Since(start) > 4*time.Second {
+		println(len(f.Records), time.Since(start).String())
+		panic("server: GET did not wait for the next message:")
+	}
+
+	var topics []httpTopic
+	httpGet(srv.URL+"/topics", &topics)
+	if len(topics) != 1 || topics[0].Name != "orders" || topics[0].HighWatermark <= high {
+		println(len(topics))
+		panic("server: GET /topics did not list the topic:")
+	}
+
+	var failed map[string]string
+	if httpGet(srv.URL+"/topics/missing/records", &failed) != http.StatusNotFound || failed["error"] == "" {
+		panic("server: GET of a missing topic was not a 404:")
+	}
+	if httpGet(srv.URL+"/topics/orders/records?wait=soon", &failed) != http.StatusBadRequest {
+		panic("server: GET with a bad wait was not a 400:")
+	}
+	req, _ := http.NewRequest("DELETE", srv.URL+"/topics/orders/records", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		println(resp.StatusCode)
+		panic("server: DELETE was allowed:")
+	}
+}
@@ -0,0 +1,849 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// Kafka API keys served, see the Kafka protocol guide
+const (
+	kafkaProduce     = 0
+	kafkaFetch       = 1
+	kafkaListOffsets = 2
+	kafkaMetadata    = 3
+	kafkaAPIVersions = 18
+)
+
+// kafkaAPIs are the versions served of each API
+var kafkaAPIs = []struct{ key, min, max int16 }{
+	{kafkaProduce, 3, 5},
+	{kafkaFetch, 4, 4},
+	{kafkaListOffsets, 1, 1},
+	{kafkaMetadata, 0, 1},
+	{kafkaAPIVersions, 0, 2},
+}
+
+// Kafka error codes sent
+const (
+	kafkaNone               = 0
+	kafkaUnknown            = -1
+	kafkaOffsetOutOfRange   = 1
+	kafkaCorruptMessage     = 2
+	kafkaUnknownPartition   = 3
+	kafkaMessageTooLarge    = 10
+	kafkaInvalidTopic       = 17
+	kafkaTopicAuthorization = 29
+	kafkaUnsupportedVersion = 35
+	kafkaUnsupportedCodec   = 76
+)
+
+// kafkaNode is the node id of the broker, the only one
+const kafkaNode = 0
+
+// kafkaPoll is how often a fetch with nothing to return looks again while
+// it waits
+const kafkaPoll = 100 * time.Millisecond
+
+// crc32c checksums record batches
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ListenAndServeKafka listens on the TCP address addr, e.g. ":9092", and
+// serves Kafka clients, see ServeKafka.
+func (s *Server) ListenAndServeKafka(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeKafka(l)
+}
+
+// ServeKafka accepts connections from stock Kafka clients on l, as Serve
+// does for the queuefka protocol, for single node use, e.g. testing against
+// or migrating from Kafka.  Enough of the Kafka protocol is spoken for them
+// to produce and consume: ApiVersions, Metadata, Produce with uncompressed
+// record batches, Fetch and ListOffsets.  Each topic has one partition, 0,
+// unless it is partitioned with queuefka.CreatePartitions, and a Metadata
+// request naming a topic which does not exist creates it, as a Kafka broker
+// does by default.  Kafka offsets number the messages of a partition from
+// zero, as Reader.SeekToRecord does, so the Writers of the topics should
+// only be used through the Server to keep the offsets returned by Produce
+// right.
+func (s *Server) ServeKafka(l net.Listener) error {
+	return s.accept(l, (*conn).serveKafka)
+}
+
+// serveKafka answers the Kafka requests of the conn in turn, as Kafka
+// clients expect the responses in order
+func (c *conn) serveKafka() {
+	defer func() {
+		c.close()
+		c.untrack()
+	}()
+
+	// with no way to send a token a client must be let in by TLS alone
+	if c.handshake() != nil {
+		return
+	}
+	if _, err := c.identity(); err != nil {
+		return
+	}
+	br := bufio.NewReader(c.nc)
+	for {
+		frame, err := readKafkaFrame(br, c.s.maxFrame())
+		if err != nil {
+			return
+		}
+		d := &kafkaDecoder{buf: frame}
+		key, version, id := d.int16(), d.int16(), d.int32()
+		d.string() // client id
+		if d.err != nil {
+			return
+		}
+
+		e := newKafkaEncoder()
+		e.int32(id)
+		respond, ok := c.kafka(key, version, d, e)
+		if !ok {
+			// as a Kafka broker does with a request it cannot parse
+			return
+		}
+		if !respond {
+			continue
+		}
+		_, err = c.bw.Write(e.frame())
+		if err == nil {
+			err = c.bw.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// kafka answers a request with key and version into e, reporting whether to
+// send the response, and false for ok if the request cannot be answered
+func (c *conn) kafka(key, version int16, d *kafkaDecoder, e *kafkaEncoder) (bool, bool) {
+	supported := false
+	for _, api := range kafkaAPIs {
+		supported = supported || (api.key == key && version >= api.min && version <= api.max)
+	}
+	if !supported {
+		if key != kafkaAPIVersions {
+			return false, false
+		}
+		// a newer client learns the versions served from a version 0 reply
+		kafkaVersions(e, 0, kafkaUnsupportedVersion)
+		return true, true
+	}
+
+	respond := true
+	switch key {
+	case kafkaAPIVersions:
+		kafkaVersions(e, version, kafkaNone)
+	case kafkaMetadata:
+		c.kafkaMetadata(version, d, e)
+	case kafkaProduce:
+		respond = c.kafkaProduce(version, d, e)
+	case kafkaFetch:
+		c.kafkaFetch(d, e)
+	case kafkaListOffsets:
+		c.kafkaListOffsets(d, e)
+	}
+	return respond, d.err == nil
+}
+
+// kafkaVersions answers ApiVersions
+func kafkaVersions(e *kafkaEncoder, version, code int16) {
+	e.int16(code)
+	e.int32(int32(len(kafkaAPIs)))
+	for _, api := range kafkaAPIs {
+		e.int16(api.key)
+		e.int16(api.min)
+		e.int16(api.max)
+	}
+	if version >= 1 {
+		e.int32(0) // throttle time
+	}
+}
+
+// kafkaMetadata answers Metadata with the Server as the only broker
+func (c *conn) kafkaMetadata(version int16, d *kafkaDecoder, e *kafkaEncoder) {
+	n := d.int32()
+	var names []string
+	for i := int32(0); i < n && d.err == nil; i++ {
+		names = append(names, d.string())
+	}
+	if d.err != nil {
+		return
+	}
+	// every topic for none in version 0, for null from version 1
+	if (version == 0 && n == 0) || n < 0 {
+		topics, _ := c.s.topics("")
+		principal, _ := c.identity()
+		for _, t := range c.s.visible(principal, topics) {
+			if kafkaTopicName(t.Name) {
+				names = append(names, t.Name)
+			}
+		}
+	}
+
+	host, port, _ := net.SplitHostPort(c.nc.LocalAddr().String())
+	p, _ := strconv.Atoi(port)
+	e.int32(1)
+	e.int32(kafkaNode)
+	e.string(host)
+	e.int32(int32(p))
+	if version >= 1 {
+		e.int16(-1) // rack
+		e.int32(kafkaNode)
+	}
+
+	e.int32(int32(len(names)))
+	for _, name := range names {
+		code := int16(kafkaNone)
+		partitions := c.s.kafkaPartitions(name)
+		if !kafkaTopicName(name) {
+			code = kafkaInvalidTopic
+		} else if c.authorize(name, false) != nil {
+			code, partitions = kafkaTopicAuthorization, nil
+		} else if partitions == nil {
+			// only a principal which may produce to a missing topic creates it
+			err := c.authorize(name, true)
+			if err == nil {
+				_, err = c.s.Manager.OpenTopic(name)
+			}
+			partitions = []string{name}
+			if err != nil {
+				code, partitions = kafkaUnknownPartition, nil
+			}
+		}
+		e.int16(code)
+		e.string(name)
+		if version >= 1 {
+			e.int8(0) // internal
+		}
+		e.int32(int32(len(partitions)))
+		for i := range partitions {
+			e.int16(kafkaNone)
+			e.int32(int32(i))
+			e.int32(kafkaNode)
+			e.int32(1)
+			e.int32(kafkaNode)
+			e.int32(1)
+			e.int32(kafkaNode)
+		}
+	}
+}
+
+// kafkaTopicName reports whether name is a legal Kafka topic name
+func kafkaTopicName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > 249 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// kafkaPartitions returns the Manager topics holding each partition of the
+// Kafka topic name, or nil if it does not exist
+func (s *Server) kafkaPartitions(name string) []string {
+	if !kafkaTopicName(name) {
+		return nil
+	}
+	path := filepath.Join(s.Manager.Root(), name)
+	if len(queuefka.SlabFiles(path)) > 0 {
+		return []string{name}
+	}
+	n, _ := queuefka.Partitions(path)
+	var partitions []string
+	for i := 0; i < n; i++ {
+		partitions = append(partitions, queuefka.PartitionPath(name, i))
+	}
+	return partitions
+}
+
+// kafkaPartition returns the Manager topic holding partition p of the Kafka
+// topic name, or false if there is no such partition
+func (s *Server) kafkaPartition(name string, p int32) (string, bool) {
+	partitions := s.kafkaPartitions(name)
+	if p < 0 || int(p) >= len(partitions) {
+		return "", false
+	}
+	return partitions[p], true
+}
+
+// kafkaOffsets returns the offset of the first message of the Manager topic
+// name left by retention and the offset the next message will be written at
+func (s *Server) kafkaOffsets(name string) (int64, int64, error) {
+	path := filepath.Join(s.Manager.Root(), name)
+	_, deleted, err := queuefka.LowWatermark(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, err := queuefka.CountMessages(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(deleted), int64(deleted + count), nil
+}
+
+// kafkaLock returns the lock serializing produces to the Manager topic name,
+// so each knows the offset its messages are written at
+func (s *Server) kafkaLock(name string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kafka == nil {
+		s.kafka = make(map[string]*sync.Mutex)
+	}
+	mu, ok := s.kafka[name]
+	if !ok {
+		mu = &sync.Mutex{}
+		s.kafka[name] = mu
+	}
+	return mu
+}
+
+// kafkaProduce answers Produce, reporting whether to send the response,
+// which a request with acks 0 does not want
+func (c *conn) kafkaProduce(version int16, d *kafkaDecoder, e *kafkaEncoder) bool {
+	d.string() // transactional id
+	acks := d.int16()
+	d.int32() // timeout
+	topics := d.int32()
+	e.int32(topics)
+	for t := int32(0); t < topics && d.err == nil; t++ {
+		name := d.string()
+		e.string(name)
+		partitions := d.int32()
+		e.int32(partitions)
+		for i := int32(0); i < partitions && d.err == nil; i++ {
+			p := d.int32()
+			records := d.bytes()
+			code, base, start := int16(kafkaTopicAuthorization), int64(-1), int64(-1)
+			if c.authorize(name, true) == nil {
+				code, base, start = c.s.kafkaAppend(name, p, records)
+			}
+			e.int32(p)
+			e.int16(code)
+			e.int64(base)
+			e.int64(-1) // log append time
+			if version >= 5 {
+				e.int64(start)
+			}
+		}
+	}
+	e.int32(0) // throttle time
+	return acks != 0
+}
+
+// kafkaAppend appends the record batches of a Produce request to partition
+// p of the Kafka topic name, returning the error code, the offset of the
+// first message and the log start offset
+func (s *Server) kafkaAppend(name string, p int32, records []byte) (int16, int64, int64) {
+	partition, ok := s.kafkaPartition(name, p)
+	if !ok {
+		return kafkaUnknownPartition, -1, -1
+	}
+	messages, code := kafkaMessages(records)
+	if code != kafkaNone {
+		return code, -1, -1
+	}
+
+	mu := s.kafkaLock(partition)
+	mu.Lock()
+	defer mu.Unlock()
+	start, high, err := s.kafkaOffsets(partition)
+	if err == nil && len(messages) > 0 {
+		_, err = s.produce(partition, messages)
+	}
+	if err == queuefka.ErrRecordTooLarge {
+		return kafkaMessageTooLarge, -1, -1
+	} else if err != nil {
+		return kafkaUnknown, -1, -1
+	}
+	return kafkaNone, high, start
+}
+
+// kafkaMessages returns the messages of the uncompressed record batches in
+// records, skipping control batches
+func kafkaMessages(records []byte) ([]message, int16) {
+	var messages []message
+	d := &kafkaDecoder{buf: records}
+	for len(d.buf) > 0 && d.err == nil {
+		d.int64() // base offset
+		n := d.int32()
+		batch := &kafkaDecoder{buf: d.next(int(n))}
+		batch.int32() // partition leader epoch
+		magic := batch.int8()
+		crc := uint32(batch.int32())
+		if d.err != nil || batch.err != nil || magic != 2 || crc32.Checksum(batch.buf, crc32c) != crc {
+			return nil, kafkaCorruptMessage
+		}
+		attributes := batch.int16()
+		if attributes&0x07 != 0 {
+			return nil, kafkaUnsupportedCodec
+		}
+		batch.next(4 + 8 + 8 + 8 + 2 + 4) // offsets, timestamps, producer
+		count := batch.int32()
+		if attributes&0x20 != 0 {
+			continue
+		}
+		for i := int32(0); i < count && batch.err == nil; i++ {
+			r := &kafkaDecoder{buf: batch.next(int(batch.varint()))}
+			r.int8()   // attributes
+			r.varint() // timestamp delta
+			r.varint() // offset delta
+			var m message
+			m.key = r.varbytes()
+			m.value = r.varbytes()
+			headers := r.varint()
+			for h := int64(0); h < headers && r.err == nil; h++ {
+				m.headers = append(m.headers, queuefka.Header{Key: string(r.varbytes()), Value: r.varbytes()})
+			}
+			if r.err != nil {
+				batch.err = r.err
+			}
+			messages = append(messages, m)
+		}
+		if batch.err != nil {
+			return nil, kafkaCorruptMessage
+		}
+	}
+	return messages, kafkaNone
+}
+
+// kafkaFetch answers Fetch, waiting up to its max wait for a message if
+// there are none for any partition asked for
+func (c *conn) kafkaFetch(d *kafkaDecoder, e *kafkaEncoder) {
+	type fetch struct {
+		partition int32
+		offset    int64
+		maxBytes  int32
+	}
+	type topicFetch struct {
+		name       string
+		partitions []fetch
+	}
+	d.int32() // replica id
+	wait := time.Duration(d.int32()) * time.Millisecond
+	d.int32() // min bytes, any message will do
+	maxBytes := d.int32()
+	d.int8() // isolation level, every message is returned
+	var fetches []topicFetch
+	topics := d.int32()
+	for t := int32(0); t < topics && d.err == nil; t++ {
+		tf := topicFetch{name: d.string()}
+		n := d.int32()
+		for i := int32(0); i < n && d.err == nil; i++ {
+			tf.partitions = append(tf.partitions, fetch{partition: d.int32(), offset: d.int64(), maxBytes: d.int32()})
+		}
+		fetches = append(fetches, tf)
+	}
+	if d.err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, wait)
+	defer cancel()
+	for {
+		body := newKafkaEncoder()
+		body.int32(0) // throttle time
+		body.int32(int32(len(fetches)))
+		left, fetched := maxBytes, false
+		for _, tf := range fetches {
+			body.string(tf.name)
+			body.int32(int32(len(tf.partitions)))
+			for _, f := range tf.partitions {
+				limit := f.maxBytes
+				if left < limit {
+					limit = left
+				}
+				code, high, batch := int16(kafkaTopicAuthorization), int64(-1), []byte(nil)
+				if c.authorize(tf.name, false) == nil {
+					code, high, batch = c.s.kafkaRead(tf.name, f.partition, f.offset, limit)
+				}
+				left -= int32(len(batch))
+				fetched = fetched || len(batch) > 0
+				body.int32(f.partition)
+				body.int16(code)
+				body.int64(high)
+				body.int64(high) // last stable offset
+				body.int32(0)    // aborted transactions
+				body.bytes(batch)
+			}
+		}
+
+		if fetched || ctx.Err() != nil {
+			*e = append(*e, (*body)[4:]...)
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(kafkaPoll):
+		}
+	}
+}
+
+// kafkaRead returns the error code, high watermark and a record batch of the
+// messages from offset of partition p of the Kafka topic name, about limit
+// bytes of them but at least one
+func (s *Server) kafkaRead(name string, p int32, offset int64, limit int32) (int16, int64, []byte) {
+	partition, ok := s.kafkaPartition(name, p)
+	if !ok {
+		return kafkaUnknownPartition, -1, nil
+	}
+	start, high, err := s.kafkaOffsets(partition)
+	if err != nil {
+		return kafkaUnknown, -1, nil
+	}
+	if offset < start || offset > high {
+		return kafkaOffsetOutOfRange, high, nil
+	}
+	if offset == high {
+		return kafkaNone, high, nil
+	}
+
+	rd, err := queuefka.NewReaderAtRecord(filepath.Join(s.Manager.Root(), partition), uint64(offset))
+	defer rd.Close()
+	if err != nil {
+		return kafkaUnknown, high, nil
+	}
+	b := &kafkaBatch{base: offset}
+	for b.size() < int(limit) || b.count == 0 {
+		value, err := rd.Read()
+		if err == queuefka.ErrEndOfLog {
+			break
+		} else if err != nil {
+			return kafkaUnknown, high, nil
+		}
+		b.add(rd.Timestamp(), rd.Key(), value, rd.Headers())
+	}
+	return kafkaNone, high, b.bytes()
+}
+
+// kafkaListOffsets answers ListOffsets, the earliest offset for timestamp
+// -2, the next to be written for -1 and otherwise the first message written
+// at or after the timestamp in milliseconds
+func (c *conn) kafkaListOffsets(d *kafkaDecoder, e *kafkaEncoder) {
+	d.int32() // replica id
+	topics := d.int32()
+	e.int32(topics)
+	for t := int32(0); t < topics && d.err == nil; t++ {
+		name := d.string()
+		e.string(name)
+		partitions := d.int32()
+		e.int32(partitions)
+		for i := int32(0); i < partitions && d.err == nil; i++ {
+			p, timestamp := d.int32(), d.int64()
+			code, offset := int16(kafkaTopicAuthorization), int64(-1)
+			if c.authorize(name, false) == nil {
+				code, offset = c.s.kafkaOffset(name, p, timestamp)
+			}
+			e.int32(p)
+			e.int16(code)
+			e.int64(-1) // timestamp
+			e.int64(offset)
+		}
+	}
+}
+
+// kafkaOffset returns the error code and offset ListOffsets answers for
+// timestamp of partition p of the Kafka topic name
+func (s *Server) kafkaOffset(name string, p int32, timestamp int64) (int16, int64) {
+	partition, ok := s.kafkaPartition(name, p)
+	if !ok {
+		return kafkaUnknownPartition, -1
+	}
+	start, high, err := s.kafkaOffsets(partition)
+	if err != nil {
+		return kafkaUnknown, -1
+	}
+	switch timestamp {
+	case -2:
+		return kafkaNone, start
+	case -1:
+		return kafkaNone, high
+	}
+
+	// the messages from the one found to the end are counted back from the
+	// high watermark
+	path := filepath.Join(s.Manager.Root(), partition)
+	rd, err := queuefka.NewReader(path, 0)
+	if rd == nil {
+		return kafkaUnknown, -1
+	}
+	defer rd.Close()
+	err = rd.SeekToTime(time.Unix(0, timestamp*int64(time.Millisecond)))
+	if err == queuefka.ErrEndOfLog {
+		return kafkaNone, high
+	} else if err != nil && err != queuefka.ErrAddressTruncated {
+		return kafkaUnknown, -1
+	}
+	rec, err := rd.ReadRecord()
+	if err == queuefka.ErrEndOfLog {
+		return kafkaNone, high
+	} else if err != nil {
+		return kafkaUnknown, -1
+	}
+	lag, err := queuefka.Lag(path, rec.Address)
+	if err != nil || !lag.Counted {
+		return kafkaUnknown, -1
+	}
+	return kafkaNone, high - int64(lag.Records)
+}
+
+// kafkaBatch builds an uncompressed record batch
+type kafkaBatch struct {
+	base    int64
+	first   int64 // timestamp of the first record in milliseconds
+	max     int64
+	count   int32
+	records []byte
+}
+
+// add appends a record
+func (b *kafkaBatch) add(t time.Time, key, value []byte, headers []queuefka.Header) {
+	var ms int64
+	if !t.IsZero() {
+		ms = t.UnixNano() / int64(time.Millisecond)
+	}
+	if b.count == 0 {
+		b.first = ms
+	}
+	if ms > b.max {
+		b.max = ms
+	}
+
+	r := &kafkaEncoder{}
+	r.int8(0) // attributes
+	r.varint(ms - b.first)
+	r.varint(int64(b.count))
+	r.varbytes(key)
+	r.varbytes(value)
+	r.varint(int64(len(headers)))
+	for _, h := range headers {
+		r.varbytes([]byte(h.Key))
+		r.varbytes(h.Value)
+	}
+	e := (*kafkaEncoder)(&b.records)
+	e.varint(int64(len(*r)))
+	*e = append(*e, *r...)
+	b.count++
+}
+
+// size returns about how large the batch is so far
+func (b *kafkaBatch) size() int {
+	return 61 + len(b.records)
+}
+
+// bytes returns the batch, or nil if it holds no records
+func (b *kafkaBatch) bytes() []byte {
+	if b.count == 0 {
+		return nil
+	}
+	e := &kafkaEncoder{}
+	e.int64(b.base)
+	e.int32(0) // batch length
+	e.int32(-1)
+	e.int8(2)  // magic
+	e.int32(0) // crc
+	e.int16(0) // attributes, no compression and create time
+	e.int32(b.count - 1)
+	e.int64(b.first)
+	e.int64(b.max)
+	e.int64(-1) // producer id
+	e.int16(-1) // producer epoch
+	e.int32(-1) // base sequence
+	e.int32(b.count)
+	*e = append(*e, b.records...)
+
+	batch := []byte(*e)
+	binary.BigEndian.PutUint32(batch[8:], uint32(len(batch)-12))
+	binary.BigEndian.PutUint32(batch[17:], crc32.Checksum(batch[21:], crc32c))
+	return batch
+}
+
+// readKafkaFrame returns the next frame from r no longer than max, each a
+// big endian int32 length followed by that many bytes
+func readKafkaFrame(r io.Reader, max uint32) ([]byte, error) {
+	hdr := make([]byte, 4)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr)
+	if n > max {
+		return nil, ErrFrameTooLarge
+	}
+	frame := make([]byte, n)
+	_, err = io.ReadFull(r, frame)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return frame, err
+}
+
+// kafkaEncoder appends the big endian fields of the Kafka protocol
+type kafkaEncoder []byte
+
+// newKafkaEncoder returns a kafkaEncoder with room for the frame length
+func newKafkaEncoder() *kafkaEncoder {
+	e := make(kafkaEncoder, 4, 64)
+	return &e
+}
+
+// frame returns the encoded frame with its length filled in
+func (e *kafkaEncoder) frame() []byte {
+	binary.BigEndian.PutUint32(*e, uint32(len(*e)-4))
+	return *e
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	*e = append(*e, byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	*e = append(*e, byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	*e = append(*e, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	*e = append(*e, s...)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	*e = append(*e, b...)
+}
+
+func (e *kafkaEncoder) varint(v int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	*e = append(*e, buf[:binary.PutVarint(buf, v)]...)
+}
+
+func (e *kafkaEncoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	*e = append(*e, b...)
+}
+
+// kafkaDecoder reads the big endian fields of the Kafka protocol, any short
+// field leaving err set to ErrBadRequest and every later field zero
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+// next returns the next n bytes
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		d.err = ErrBadRequest
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *kafkaDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrBadRequest
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *kafkaDecoder) varbytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
@@ -0,0 +1,305 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// kafkaClient sends Kafka requests one at a time
+type kafkaClient struct {
+	nc net.Conn
+	br *bufio.Reader
+	id int32
+}
+
+// call sends a request and returns the body of its response
+func (c *kafkaClient) call(key, version int16, body func(e *kafkaEncoder)) *kafkaDecoder {
+	c.send(key, version, body)
+	return c.receive()
+}
+
+func (c *kafkaClient) send(key, version int16, body func(e *kafkaEncoder)) {
+	c.id++
+	e := newKafkaEncoder()
+	e.int16(key)
+	e.int16(version)
+	e.int32(c.id)
+	e.string("test")
+	body(e)
+	_, err := c.nc.Write(e.frame())
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (c *kafkaClient) receive() *kafkaDecoder {
+	frame, err := readKafkaFrame(c.br, DefaultMaxFrameSize)
+	if err != nil {
+		panic(err)
+	}
+	d := &kafkaDecoder{buf: frame}
+	if d.int32() != c.id {
+		panic("server: Kafka response has the wrong correlation id:")
+	}
+	return d
+}
+
+// produce sends a Produce request for partition 0 of topic and returns the
+// error code and base offset
+func (c *kafkaClient) produce(topic string, acks int16, values ...string) (int16, int64) {
+	b := &kafkaBatch{}
+	for _, v := range values {
+		b.add(time.Now(), []byte("key"), []byte(v), []queuefka.Header{{Key: "h", Value: []byte(v)}})
+	}
+	body := func(e *kafkaEncoder) {
+		e.int16(-1) // transactional id
+		e.int16(acks)
+		e.int32(1000)
+		e.int32(1)
+		e.string(topic)
+		e.int32(1)
+		e.int32(0)
+		e.bytes(b.bytes())
+	}
+	if acks == 0 {
+		c.send(kafkaProduce, 3, body)
+		return 0, -1
+	}
+	d := c.call(kafkaProduce, 3, body)
+	d.int32()
+	d.string()
+	d.int32()
+	d.int32()
+	code, base := d.int16(), d.int64()
+	return code, base
+}
+
+// fetch sends a Fetch request for partition 0 of topic and returns the
+// error code, high watermark, base offset and messages
+func (c *kafkaClient) fetch(topic string, offset int64, wait time.Duration) (int16, int64, int64, []message) {
+	d := c.call(kafkaFetch, 4, func(e *kafkaEncoder) {
+		e.int32(-1)
+		e.int32(int32(wait / time.Millisecond))
+		e.int32(1)
+		e.int32(1 << 20)
+		e.int8(0)
+		e.int32(1)
+		e.string(topic)
+		e.int32(1)
+		e.int32(0)
+		e.int64(offset)
+		e.int32(1 << 20)
+	})
+	d.int32() // throttle
+	d.int32()
+	d.string()
+	d.int32()
+	d.int32() // partition
+	code, high := d.int16(), d.int64()
+	d.int64()
+	d.int32()
+	records := d.bytes()
+	if d.err != nil {
+		panic(d.err)
+	}
+	base := int64(-1)
+	if len(records) > 0 {
+		base = (&kafkaDecoder{buf: records}).int64()
+	}
+	messages, crc := kafkaMessages(records)
+	if crc != kafkaNone {
+		panic("server: Kafka fetch returned a corrupt record batch:")
+	}
+	return code, high, base, messages
+}
+
+// listOffset sends a ListOffsets request for partition 0 of topic
+func (c *kafkaClient) listOffset(topic string, timestamp int64) int64 {
+	d := c.call(kafkaListOffsets, 1, func(e *kafkaEncoder) {
+		e.int32(-1)
+		e.int32(1)
+		e.string(topic)
+		e.int32(1)
+		e.int32(0)
+		e.int64(timestamp)
+	})
+	d.int32()
+	d.string()
+	d.int32()
+	d.int32()
+	if d.int16() != kafkaNone {
+		panic("server: Kafka ListOffsets failed:")
+	}
+	d.int64()
+	return d.int64()
+}
+
+func Test_Server_Kafka(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go s.ServeKafka(l)
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	defer nc.Close()
+	c := &kafkaClient{nc: nc, br: bufio.NewReader(nc)}
+
+	// a client too new is told the versions served
+	d := c.call(kafkaAPIVersions, 3, func(e *kafkaEncoder) {})
+	if d.int16() != kafkaUnsupportedVersion || d.int32() != int32(len(kafkaAPIs)) {
+		panic("server: Kafka ApiVersions did not refuse an unsupported version:")
+	}
+	d = c.call(kafkaAPIVersions, 2, func(e *kafkaEncoder) {})
+	if d.int16() != kafkaNone || d.int32() != int32(len(kafkaAPIs)) {
+		panic("server: Kafka ApiVersions failed:")
+	}
+
+	// metadata for a topic which does not exist creates it
+	d = c.call(kafkaMetadata, 1, func(e *kafkaEncoder) {
+		e.int32(1)
+		e.string("orders")
+	})
+	d.int32()
+	d.int32()
+	host, port := d.string(), d.int32()
+	d.int16()
+	d.int32()
+	d.int32()
+	code, name := d.int16(), d.string()
+	d.int8()
+	if code != kafkaNone || name != "orders" || d.int32() != 1 || fmt.Sprintf("%s:%d", host, port) != l.Addr().String() {
+		println(code, name, host, port)
+		panic("server: Kafka Metadata did not create the topic:")
+	}
+
+	code, base := c.produce("orders", 1, "a", "b", "c")
+	if code != kafkaNone || base != 0 {
+		println(code, base)
+		panic("server: Kafka Produce failed:")
+	}
+	c.produce("orders", 0, "d")
+	code, base = c.produce("orders", -1, "e")
+	if code != kafkaNone || base != 4 {
+		println(code, base)
+		panic("server: Kafka Produce did not return the offset of its messages:")
+	}
+
+	code, high, base, messages := c.fetch("orders", 1, 0)
+	if code != kafkaNone || high != 5 || base != 1 || len(messages) != 4 ||
+		string(messages[0].value) != "b" || string(messages[0].key) != "key" || string(messages[3].headers[0].Value) != "e" {
+		println(code, high, base, len(messages))
+		panic("server: Kafka Fetch did not return the messages from its offset:")
+	}
+	start := time.Now()
+	_, _, _, messages = c.fetch("orders", 5, 200*time.Millisecond)
+	if len(messages) != 0 || time.Since(start) < 200*time.Millisecond {
+		panic("server: Kafka Fetch returned early with nothing to return:")
+	}
+	code, _, _, _ = c.fetch("orders", 6, 0)
+	if code != kafkaOffsetOutOfRange {
+		println(code)
+		panic("server: Kafka Fetch past the end was not out of range:")
+	}
+
+	if c.listOffset("orders", -2) != 0 || c.listOffset("orders", -1) != 5 {
+		panic("server: Kafka ListOffsets did not return the watermarks:")
+	}
+	if c.listOffset("orders", time.Now().Add(-time.Hour).UnixNano()/int64(time.Millisecond)) != 0 {
+		panic("server: Kafka ListOffsets did not find the first message since a time:")
+	}
+}
+
+func Test_Server_KafkaFetchNoPartitions(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go s.ServeKafka(l)
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	defer nc.Close()
+	c := &kafkaClient{nc: nc, br: bufio.NewReader(nc)}
+
+	// a topic asking for no partitions gets none back
+	d := c.call(kafkaFetch, 4, func(e *kafkaEncoder) {
+		e.int32(-1)
+		e.int32(0)
+		e.int32(1)
+		e.int32(1 << 20)
+		e.int8(0)
+		e.int32(1)
+		e.string("orders")
+		e.int32(0)
+	})
+	d.int32() // throttle
+	if d.int32() != 1 || d.string() != "orders" || d.int32() != 0 || d.err != nil {
+		panic("server: Kafka fetch of no partitions was not answered:")
+	}
+
+	// and the broker carries on serving
+	d = c.call(kafkaAPIVersions, 2, func(e *kafkaEncoder) {})
+	if d.int16() != kafkaNone {
+		panic("server: Kafka broker stopped serving after a fetch of no partitions:")
+	}
+}
+
+func Test_Server_KafkaMetadataConsumeOnly(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	s.CanProduce = func(principal, topic string) bool { return false }
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go s.ServeKafka(l)
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	defer nc.Close()
+	c := &kafkaClient{nc: nc, br: bufio.NewReader(nc)}
+
+	// a principal which may only consume asks about a missing topic
+	d := c.call(kafkaMetadata, 1, func(e *kafkaEncoder) {
+		e.int32(1)
+		e.string("orders")
+	})
+	d.int32()
+	d.int32()
+	d.string()
+	d.int32()
+	d.int16()
+	d.int32()
+	d.int32()
+	code, name := d.int16(), d.string()
+	d.int8()
+	if code != kafkaUnknownPartition || name != "orders" || d.int32() != 0 {
+		println(code, name)
+		panic("server: Kafka Metadata did not refuse to create the topic:")
+	}
+	topics, err := s.Manager.ListTopics()
+	if err != nil {
+		panic(err)
+	}
+	if len(topics) != 0 {
+		panic("server: Kafka Metadata created a topic for a principal which may not produce:")
+	}
+}
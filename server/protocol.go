@@ -0,0 +1,132 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/internal/wire"
+)
+
+// The protocol is a stream of frames in each direction, every frame a
+// little endian uint32 length followed by that many bytes.  A request is
+//
+//	op (1 byte) + id (4 bytes) + body
+//
+// and its response, which may come after those of later requests,
+//
+//	id (4 bytes) + code (1 byte) + body
+//
+// where code 0 is success, codeOther is followed by an error message and any
+// other code is one of codes with no body.  Strings are a uint16 length and
+// bytes a uint32 length followed by the data, a length of 0xffffffff
+// meaning nil.
+//
+// OpProduce
+//
+//	request:  topic string + count uint32 + count * message
+//	message:  key bytes + value bytes + headers uint16 + headers * (string + bytes)
+//	response: address uint64 the next message will be written at
+//
+// OpFetch
+//
+//	request:  topic string + address uint64 + max records uint32 +
+//	          max bytes uint32 + max wait in milliseconds uint32
+//	response: next address uint64 + count uint32 + count * record
+//	record:   address uint64 + next address uint64 + unix nanoseconds int64 +
+//	          key bytes + value bytes + headers uint16 + headers * (string + bytes)
+//
+// OpMetadata
+//
+//	request:  topic string, empty for every topic
+//	response: count uint32 + count * (name string + low watermark uint64 +
+//	          high watermark uint64 + partitions uint32)
+//
+// OpAuth
+//
+//	request:  token bytes
+//	response: principal string the connection is let in as
+//
+// OpReplicate
+//
+//	request:  topic string + address uint64 + max bytes uint32 +
+//	          max wait in milliseconds uint32
+//	response: slab base uint64 + offset uint64 + sealed uint8 +
+//	          slab naming width uint8 + slab naming extension string +
+//	          frames bytes, see queuefka.Chunk
+
+// Op is the kind of a request.
+type Op uint8
+
+// Ops understood by the Server.
+const (
+	OpProduce   Op = 1 // append messages to a topic, creating it if need be
+	OpFetch     Op = 2 // read messages from an address, waiting for some
+	OpMetadata  Op = 3 // list topics with their watermarks
+	OpAuth      Op = 4 // let the connection in by a token, see Authenticator
+	OpReplicate Op = 5 // read frames as written for a follower's replica
+)
+
+// DefaultMaxFrameSize is the largest frame a Server accepts or sends unless
+// set otherwise.
+const DefaultMaxFrameSize = 16 << 20
+
+// requestHeaderSize is op (1 byte) + id (4 bytes)
+const requestHeaderSize = 5
+
+// responseHeaderSize is id (4 bytes) + code (1 byte)
+const responseHeaderSize = 5
+
+// codeOther is sent for an error not in codes, followed by its message
+const codeOther = 255
+
+// codes numbers the errors a response carries without a message, code 0
+// being success, new errors are only ever added to the end
+var codes = []error{
+	nil,
+	ErrBadRequest,
+	ErrFrameTooLarge,
+	queuefka.ErrInvalidTopic,
+	queuefka.ErrEndOfLog,
+	queuefka.ErrOutOfBounds,
+	queuefka.ErrAddressTruncated,
+	queuefka.ErrRecordTooLarge,
+	queuefka.ErrQuotaExceeded,
+	queuefka.ErrPartitioned,
+	queuefka.ErrBadChecksum,
+	queuefka.ErrBadHeader,
+	ErrUnauthenticated,
+	ErrForbidden,
+}
+
+// Errors
+var (
+	ErrBadRequest    = wire.ErrBadRequest
+	ErrFrameTooLarge = wire.ErrFrameTooLarge
+	ErrServerClosed  = errors.New("server: Server closed")
+)
+
+// errorCode returns the code sending err in a response
+func errorCode(err error) uint8 {
+	for code, e := range codes {
+		if e == err {
+			return uint8(code)
+		}
+	}
+	return codeOther
+}
+
+// CodeError returns the error carried by a response with a nonzero code and
+// body, for clients of the protocol.
+func CodeError(code uint8, body []byte) error {
+	if code == codeOther {
+		return errors.New(string(body))
+	}
+	if int(code) >= len(codes) {
+		return ErrBadRequest
+	}
+	return codes[code]
+}
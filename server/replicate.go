@@ -0,0 +1,67 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/internal/wire"
+)
+
+// replicate answers a follower with the frames of a topic from its high
+// watermark, waiting up to the max wait for some if there are none yet
+func (c *conn) replicate(d *wire.Decoder, e *wire.Encoder) error {
+	topic := d.String()
+	address := d.Uint64()
+	maxBytes := d.Uint32()
+	wait := time.Duration(d.Uint32()) * time.Millisecond
+	if d.Err != nil || len(d.Buf) > 0 {
+		return ErrBadRequest
+	}
+	err := c.authorize(topic, false)
+	if err != nil {
+		return err
+	}
+	if maxBytes == 0 || maxBytes > DefaultFetchBytes {
+		maxBytes = DefaultFetchBytes
+	}
+	if limit := c.s.maxFrame() / 2; maxBytes > limit {
+		maxBytes = limit
+	}
+
+	chunk, err := c.s.chunk(c.ctx, topic, address, int(maxBytes), wait)
+	if err != nil {
+		return err
+	}
+	e.Uint64(chunk.Base)
+	e.Uint64(chunk.Offset)
+	if chunk.Sealed {
+		e.Uint8(1)
+	} else {
+		e.Uint8(0)
+	}
+	e.Uint8(uint8(chunk.Naming.Width))
+	e.String(chunk.Naming.Extension)
+	e.Bytes(chunk.Data)
+	return nil
+}
+
+// chunk returns the frames of topic from address as queuefka.ReadChunk
+// does, waiting up to wait for a message if there are none yet
+func (s *Server) chunk(ctx context.Context, topic string, address uint64, max int, wait time.Duration) (queuefka.Chunk, error) {
+	chunk, err := s.Manager.ReadChunk(topic, address, max)
+	if err != nil || len(chunk.Data) > 0 || wait <= 0 {
+		return chunk, err
+	}
+
+	// the next message is waited for without taking it
+	_, err = s.fetch(ctx, topic, address, 1, wait, func(queuefka.Record) bool { return false })
+	if err != nil {
+		return queuefka.Chunk{}, err
+	}
+	return s.Manager.ReadChunk(topic, address, max)
+}
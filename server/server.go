@@ -0,0 +1,504 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package server makes the topics of a queuefka.Manager available to remote
+// clients over TCP, turning the library into a lightweight single node
+// broker.  Clients produce messages to topics, fetch them from an address,
+// waiting a while for new ones, and list the topics with their watermarks,
+// over a simple length prefixed binary protocol described in protocol.go.
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/internal/wire"
+)
+
+// DefaultFetchBytes is how much a fetch returns at most, besides its first
+// message, when it asks for no limit.
+const DefaultFetchBytes = 1 << 20
+
+// Server answers requests for the topics of a Manager on any number of
+// listeners.  Each connection may have several requests in flight, each
+// answered as soon as it is done, so a fetch waiting for messages holds up
+// nothing else.
+type Server struct {
+	Manager      *queuefka.Manager // topics served, which the Server does not close
+	MaxFrameSize uint32            // largest request accepted, 0 for DefaultMaxFrameSize
+	TLSConfig    *tls.Config       // for ServeTLS, which sets a certificate and minimum version if not given
+
+	// SubscribeWriteTimeout is how long a WebSocket or gRPC subscriber may
+	// take to accept each message before it is hung up on, 0 for
+	// DefaultSubscribeWriteTimeout.
+	SubscribeWriteTimeout time.Duration
+
+	// Authenticator, if set, must let each client in before it is served.
+	Authenticator Authenticator
+	// CanProduce and CanConsume, if set, report whether the principal a
+	// client was let in as may produce to or consume from topic.  Topics a
+	// client may not consume from are left out of its metadata.
+	CanProduce func(principal, topic string) bool
+	CanConsume func(principal, topic string) bool
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	hijacked  map[net.Conn]struct{}  // WebSockets taken over from HTTP
+	kafka     map[string]*sync.Mutex // serializes Kafka produces by topic
+	closed    bool
+	handlers  sync.WaitGroup
+}
+
+// New returns a Server of the topics of m.
+func New(m *queuefka.Manager) *Server {
+	return &Server{Manager: m}
+}
+
+// ListenAndServe listens on the TCP address addr, e.g. ":9092", and serves
+// connections to it, see Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l, answering the requests of each in its own
+// goroutines, until l fails or the Server is closed, when it returns
+// ErrServerClosed.  l is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	return s.accept(l, (*conn).serve)
+}
+
+// accept accepts connections on l until it fails or the Server is closed,
+// calling serve in a goroutine for each
+func (s *Server) accept(l net.Listener, serve func(*conn)) error {
+	if !s.track(l) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(l)
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		c := s.newConn(nc)
+		if c == nil {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go serve(c)
+	}
+}
+
+// Close stops every listener and closes every connection, waiting for the
+// requests in flight to finish.  Fetches waiting for messages give up at
+// once.  WebSockets served by HTTPHandler are closed too, while other HTTP
+// requests are left to their http.Server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for c := range s.conns {
+		c.close()
+	}
+	for nc := range s.hijacked {
+		nc.Close()
+	}
+	s.mu.Unlock()
+
+	s.handlers.Wait()
+	return err
+}
+
+// track adds l to the listeners closed by Close, unless already closed
+func (s *Server) track(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// untrack closes l and forgets it
+func (s *Server) untrack(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+	l.Close()
+}
+
+// isClosed reports whether Close has been called
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// maxFrame returns the largest frame the Server accepts
+func (s *Server) maxFrame() uint32 {
+	if s.MaxFrameSize > 0 {
+		return s.MaxFrameSize
+	}
+	return DefaultMaxFrameSize
+}
+
+// subscribeWriteTimeout returns how long a subscriber's message may take to
+// write
+func (s *Server) subscribeWriteTimeout() time.Duration {
+	if s.SubscribeWriteTimeout > 0 {
+		return s.SubscribeWriteTimeout
+	}
+	return DefaultSubscribeWriteTimeout
+}
+
+// conn is a connection being served
+type conn struct {
+	s      *Server
+	nc     net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	wmu    sync.Mutex // serializes responses
+	bw     *bufio.Writer
+
+	amu           sync.Mutex
+	principal     string // who the conn was let in as
+	authenticated bool
+}
+
+// newConn returns a conn for nc tracked by the Server, or nil once closed
+func (s *Server) newConn(nc net.Conn) *conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	c := &conn{s: s, nc: nc, bw: bufio.NewWriter(nc)}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if s.conns == nil {
+		s.conns = make(map[*conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.handlers.Add(1)
+	return c
+}
+
+// close makes the conn give up, its reads then fail and serve returns
+func (c *conn) close() {
+	c.cancel()
+	c.nc.Close()
+}
+
+// untrack forgets the closed conn, once it has finished its requests
+func (c *conn) untrack() {
+	c.s.mu.Lock()
+	delete(c.s.conns, c)
+	c.s.mu.Unlock()
+	c.s.handlers.Done()
+}
+
+// serve reads requests until the connection fails, answering each in its
+// own goroutine
+func (c *conn) serve() {
+	var inflight sync.WaitGroup
+	defer func() {
+		c.close()
+		inflight.Wait()
+		c.untrack()
+	}()
+
+	if c.handshake() != nil {
+		return
+	}
+	br := bufio.NewReader(c.nc)
+	for {
+		frame, err := wire.ReadFrame(br, c.s.maxFrame())
+		if err != nil {
+			// a frame too large cannot be skipped, so hang up
+			return
+		}
+		if len(frame) < requestHeaderSize {
+			return
+		}
+		inflight.Add(1)
+		go func() {
+			defer inflight.Done()
+			c.handle(frame)
+		}()
+	}
+}
+
+// handle answers a single request frame
+func (c *conn) handle(frame []byte) {
+	op := Op(frame[0])
+	d := &wire.Decoder{Buf: frame[1:]}
+	id := d.Uint32()
+
+	e := wire.NewEncoder()
+	e.Uint32(id)
+	e.Uint8(0)
+	var err error
+	switch op {
+	case OpProduce:
+		err = c.produce(d, &e)
+	case OpFetch:
+		err = c.fetch(d, &e)
+	case OpMetadata:
+		err = c.metadata(d, &e)
+	case OpAuth:
+		err = c.auth(d, &e)
+	case OpReplicate:
+		err = c.replicate(d, &e)
+	default:
+		err = ErrBadRequest
+	}
+	if err != nil {
+		e = e[:4+responseHeaderSize]
+		code := errorCode(err)
+		e[4+4] = code
+		if code == codeOther {
+			e = append(e, err.Error()...)
+		}
+	}
+	c.respond(e.Frame())
+}
+
+// respond sends a response frame, which the client may have stopped waiting
+// for if the connection failed
+func (c *conn) respond(frame []byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.bw.Write(frame)
+	if err == nil {
+		err = c.bw.Flush()
+	}
+	if err != nil {
+		c.close()
+	}
+}
+
+// message is a message to produce
+type message struct {
+	key, value []byte
+	headers    []queuefka.Header
+}
+
+// produce appends the messages of a request to its topic
+func (c *conn) produce(d *wire.Decoder, e *wire.Encoder) error {
+	topic := d.String()
+	n := d.Uint32()
+	var messages []message
+	for i := uint32(0); i < n && d.Err == nil; i++ {
+		var m message
+		m.key, m.value, m.headers = d.Message()
+		messages = append(messages, m)
+	}
+	if d.Err != nil || len(d.Buf) > 0 {
+		return ErrBadRequest
+	}
+	err := c.authorize(topic, true)
+	if err != nil {
+		return err
+	}
+
+	address, err := c.s.produce(topic, messages)
+	if err != nil {
+		return err
+	}
+	e.Uint64(address)
+	return nil
+}
+
+// produce appends messages to topic, creating it if need be, and returns
+// the address the next message will be written at
+func (s *Server) produce(topic string, messages []message) (uint64, error) {
+	plain := true
+	for _, m := range messages {
+		plain = plain && m.key == nil && m.headers == nil
+	}
+
+	wt, err := s.Manager.OpenTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+	// messages without keys or headers land together in one slab
+	if plain {
+		batch := make([][]byte, len(messages))
+		for i, m := range messages {
+			batch[i] = m.value
+		}
+		err = wt.WriteBatch(batch)
+	} else {
+		for _, m := range messages {
+			err = wt.WriteHeaders(m.key, m.value, m.headers)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = wt.Flush()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return wt.Address(), nil
+}
+
+// fetch reads the messages a request asks for, waiting up to its max wait
+// for the first if there are none yet
+func (c *conn) fetch(d *wire.Decoder, e *wire.Encoder) error {
+	topic := d.String()
+	address := d.Uint64()
+	maxRecords := d.Uint32()
+	maxBytes := d.Uint32()
+	wait := time.Duration(d.Uint32()) * time.Millisecond
+	if d.Err != nil || len(d.Buf) > 0 {
+		return ErrBadRequest
+	}
+	err := c.authorize(topic, false)
+	if err != nil {
+		return err
+	}
+	if maxBytes == 0 || maxBytes > DefaultFetchBytes {
+		maxBytes = DefaultFetchBytes
+	}
+	if limit := c.s.maxFrame() / 2; maxBytes > limit {
+		maxBytes = limit
+	}
+
+	records := wire.NewEncoder()
+	var count uint32
+	next, err := c.s.fetch(c.ctx, topic, address, int(maxRecords), wait, func(rec queuefka.Record) bool {
+		before := len(records)
+		records.Record(rec)
+		if count > 0 && uint32(len(records)-4) > maxBytes {
+			records = records[:before]
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	e.Uint64(next)
+	e.Uint32(count)
+	*e = append(*e, records[4:]...)
+	return nil
+}
+
+// fetch calls fn with up to max messages of topic from address, or every
+// one if max is 0, waiting up to wait for the first if there are none yet.
+// fn returns false to stop without taking the message it was called with.
+// The Record is only valid during the call.  fetch returns the address to
+// fetch from next.
+func (s *Server) fetch(ctx context.Context, topic string, address uint64, max int, wait time.Duration, fn func(queuefka.Record) bool) (uint64, error) {
+	rd, err := s.Manager.NewReader(topic, address)
+	if err != nil && err != queuefka.ErrEndOfLog {
+		if rd != nil {
+			rd.Close()
+		}
+		return address, err
+	}
+	it := rd.Iterator()
+	defer it.Close()
+
+	waiting := ctx
+	if wait > 0 {
+		rd.SetFollow(true)
+		var cancel context.CancelFunc
+		waiting, cancel = context.WithTimeout(ctx, wait)
+		defer cancel()
+	}
+
+	next := address
+	for n := 0; (max == 0 || n < max) && it.NextContext(waiting); n++ {
+		rec := it.Record()
+		if !fn(rec) {
+			break
+		}
+		next = rec.NextAddress
+		// only the first message is waited for
+		rd.SetFollow(false)
+	}
+	err = it.Err()
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		err = nil
+	}
+	return next, err
+}
+
+// metadata lists the topic a request names, or every topic
+func (c *conn) metadata(d *wire.Decoder, e *wire.Encoder) error {
+	topic := d.String()
+	if d.Err != nil || len(d.Buf) > 0 {
+		return ErrBadRequest
+	}
+	principal, err := c.identity()
+	if err == nil && topic != "" {
+		err = c.s.authorize(principal, topic, false)
+	}
+	if err != nil {
+		return err
+	}
+	found, err := c.s.topics(topic)
+	if err != nil {
+		return err
+	}
+	found = c.s.visible(principal, found)
+	e.Uint32(uint32(len(found)))
+	for _, t := range found {
+		e.String(t.Name)
+		e.Uint64(t.LowWatermark)
+		e.Uint64(t.HighWatermark)
+		e.Uint32(uint32(len(t.Partitions)))
+	}
+	return nil
+}
+
+// topics returns the topic named, or every topic if name is empty
+func (s *Server) topics(name string) ([]queuefka.TopicInfo, error) {
+	topics, err := queuefka.ListTopics(s.Manager.Root())
+	if err != nil || name == "" {
+		return topics, err
+	}
+	for _, t := range topics {
+		if t.Name == name {
+			return []queuefka.TopicInfo{t}, nil
+		}
+	}
+	return nil, queuefka.ErrInvalidTopic
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+	"github.com/ubergarm/queuefka/internal/wire"
+)
+
+// testServer starts a Server of a fresh data directory on a local port
+func testServer() (*Server, string, func()) {
+	root, err := ioutil.TempDir("", "queuefka-server")
+	if err != nil {
+		panic(err)
+	}
+	m, err := queuefka.NewManager(root, 512)
+	if err != nil {
+		panic(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := New(m)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	return s, l.Addr().String(), func() {
+		s.Close()
+		if err := <-served; err != ErrServerClosed {
+			println(err)
+			panic("server: Serve did not report the Server closed:")
+		}
+		m.Close()
+		os.RemoveAll(root)
+	}
+}
+
+// testConn is a client connection sending one request at a time
+type testConn struct {
+	nc net.Conn
+	br *bufio.Reader
+	id uint32
+}
+
+// call sends a request with body and returns the decoded response
+func (c *testConn) call(op Op, body func(e *wire.Encoder)) (*wire.Decoder, error) {
+	c.id++
+	e := wire.NewEncoder()
+	e.Uint8(uint8(op))
+	e.Uint32(c.id)
+	body(&e)
+	_, err := c.nc.Write(e.Frame())
+	if err != nil {
+		return nil, err
+	}
+	frame, err := wire.ReadFrame(c.br, DefaultMaxFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	d := &wire.Decoder{Buf: frame}
+	if d.Uint32() != c.id {
+		panic("server: response has the wrong id:")
+	}
+	code := d.Uint8()
+	if code != 0 {
+		return nil, CodeError(code, d.Buf)
+	}
+	return d, nil
+}
+
+func (c *testConn) produce(topic string, values ...string) (uint64, error) {
+	d, err := c.call(OpProduce, func(e *wire.Encoder) {
+		e.String(topic)
+		e.Uint32(uint32(len(values)))
+		for _, v := range values {
+			e.Message(nil, []byte(v), nil)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return d.Uint64(), nil
+}
+
+func (c *testConn) fetch(topic string, address uint64, max uint32, wait time.Duration) (uint64, []queuefka.Record, error) {
+	d, err := c.call(OpFetch, func(e *wire.Encoder) {
+		e.String(topic)
+		e.Uint64(address)
+		e.Uint32(max)
+		e.Uint32(0)
+		e.Uint32(uint32(wait / time.Millisecond))
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	next := d.Uint64()
+	records := make([]queuefka.Record, d.Uint32())
+	for i := range records {
+		records[i] = d.Record()
+	}
+	if d.Err != nil {
+		panic(d.Err)
+	}
+	return next, records, nil
+}
+
+func dial(addr string) *testConn {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	return &testConn{nc: nc, br: bufio.NewReader(nc)}
+}
+
+func Test_Server_ProduceFetch(t *testing.T) {
+	_, addr, done := testServer()
+	defer done()
+	c := dial(addr)
+	defer c.nc.Close()
+
+	var values []string
+	for i := 0; i < 30; i++ {
+		values = append(values, fmt.Sprintf("message %d", i))
+	}
+	high, err := c.produce("orders", values[:20]...)
+	if err == nil {
+		high, err = c.produce("orders", values[20:]...)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	// fetch it all back a few at a time
+	var address uint64
+	var got []string
+	for {
+		next, records, err := c.fetch("orders", address, 7, 0)
+		if err != nil {
+			panic(err)
+		}
+		if len(records) == 0 {
+			break
+		}
+		if len(records) > 7 {
+			panic("server: fetch returned more records than asked for:")
+		}
+		for _, rec := range records {
+			got = append(got, string(rec.Value))
+		}
+		address = next
+	}
+	if len(got) != 30 || got[0] != values[0] || got[29] != values[29] || address != high {
+		println(len(got), address, high)
+		panic("server: fetch did not return what was produced:")
+	}
+
+	// keys and headers survive the trip
+	_, err = c.call(OpProduce, func(e *wire.Encoder) {
+		e.String("orders")
+		e.Uint32(1)
+		e.Message([]byte("customer-1"), []byte("keyed"), []queuefka.Header{{Key: "trace", Value: []byte("abc")}})
+	})
+	if err != nil {
+		panic(err)
+	}
+	_, records, err := c.fetch("orders", address, 0, 0)
+	if err != nil {
+		panic(err)
+	}
+	if len(records) != 1 || string(records[0].Key) != "customer-1" || len(records[0].Headers) != 1 || string(records[0].Headers[0].Value) != "abc" {
+		println(len(records))
+		panic("server: fetch lost the key or headers of a message:")
+	}
+
+	_, _, err = c.fetch("missing", 0, 0, 0)
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("server: fetch of a missing topic did not fail with its error:")
+	}
+	_, err = c.produce("../escape", "nowhere")
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("server: produced outside the data directory:")
+	}
+}
+
+func Test_Server_FetchWait(t *testing.T) {
+	_, addr, done := testServer()
+	defer done()
+	c := dial(addr)
+	defer c.nc.Close()
+
+	high, err := c.produce("events", "first")
+	if err != nil {
+		panic(err)
+	}
+
+	// a second connection produces while the first waits
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		p := dial(addr)
+		defer p.nc.Close()
+		p.produce("events", "second")
+	}()
+	start := time.Now()
+	_, records, err := c.fetch("events", high, 0, 5*time.Second)
+	if err != nil {
+		panic(err)
+	}
+	if len(records) != 1 || string(records[0].Value) != "second" || time.Since(start) > 4*time.Second {
+		println(len(records), time.Since(start).String())
+		panic("server: fetch did not wait for the next message:")
+	}
+
+	// nothing comes so the wait runs out
+	start = time.Now()
+	_, records, err = c.fetch("events", records[0].NextAddress, 0, 200*time.Millisecond)
+	if err != nil {
+		panic(err)
+	}
+	if len(records) != 0 || time.Since(start) < 200*time.Millisecond {
+		panic("server: fetch returned early with nothing to return:")
+	}
+}
+
+func Test_Server_Metadata(t *testing.T) {
+	s, addr, done := testServer()
+	defer done()
+	c := dial(addr)
+	defer c.nc.Close()
+
+	for _, topic := range []string{"a", "b"} {
+		_, err := c.produce(topic, "message")
+		if err != nil {
+			panic(err)
+		}
+	}
+	err := queuefka.CreatePartitions(s.Manager.Root()+"/c", 3, 512)
+	if err != nil {
+		panic(err)
+	}
+
+	d, err := c.call(OpMetadata, func(e *wire.Encoder) { e.String("") })
+	if err != nil {
+		panic(err)
+	}
+	n := d.Uint32()
+	partitions := map[string]uint32{}
+	for i := uint32(0); i < n; i++ {
+		name := d.String()
+		d.Uint64()
+		high := d.Uint64()
+		partitions[name] = d.Uint32()
+		if name != "c" && high == 0 {
+			panic("server: metadata has no high watermark:")
+		}
+	}
+	if n != 3 || partitions["c"] != 3 || partitions["a"] != 0 {
+		println(n)
+		panic("server: metadata did not list every topic:")
+	}
+
+	_, err = c.call(OpMetadata, func(e *wire.Encoder) { e.String("missing") })
+	if err != queuefka.ErrInvalidTopic {
+		println(err)
+		panic("server: metadata of a missing topic did not fail:")
+	}
+	_, err = c.call(Op(99), func(e *wire.Encoder) {})
+	if err != ErrBadRequest {
+		println(err)
+		panic("server: an unknown op did not fail:")
+	}
+}
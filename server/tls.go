@@ -0,0 +1,53 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// ListenAndServeTLS listens on the TCP address addr and serves TLS
+// connections to it, see ServeTLS.
+func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(l, certFile, keyFile)
+}
+
+// ServeTLS is like Serve but over TLS, presenting the certificate and key in
+// certFile and keyFile, which may be empty if TLSConfig has a certificate.
+// Set TLSConfig.ClientCAs and ClientAuth to require client certificates.
+// Unless TLSConfig says otherwise TLS 1.2 is the minimum version accepted.
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	return s.Serve(tls.NewListener(l, config))
+}
+
+// tlsConfig returns a copy of TLSConfig with the certificate in certFile and
+// keyFile if given and a minimum version
+func (s *Server) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
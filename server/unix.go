@@ -0,0 +1,87 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNotSocket is returned by ListenAndServeUnix for a path taken by a file
+// other than a socket.
+var ErrNotSocket = errors.New("server: path exists and is not a socket")
+
+// ListenAndServeUnix listens on a unix domain socket at path and serves
+// connections to it, see Serve, for clients on the same host to dial with
+// net.Dial("unix", path) and so skip the TCP stack.  The socket file is
+// given mode, e.g. 0660 to let in only the owner and group, before it
+// appears at path, and removed when the Server is closed.  A socket left at path by a process which did
+// not remove it is replaced.
+func (s *Server) ListenAndServeUnix(path string, mode os.FileMode) error {
+	fi, err := os.Lstat(path)
+	if err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return ErrNotSocket
+		}
+		// a live server would still be accepting on it
+		if nc, err := net.Dial("unix", path); err == nil {
+			nc.Close()
+			return &net.OpError{Op: "listen", Net: "unix", Addr: &net.UnixAddr{Name: path, Net: "unix"}, Err: os.ErrExist}
+		}
+		os.Remove(path)
+	}
+
+	l, err := listenUnix(path, mode)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// listenUnix listens on a socket at path which is never reachable with a
+// looser mode than asked for: it is bound in a private directory beside
+// path, where only this user can reach it, given mode and then renamed
+// into place
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".queuefka-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", private)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(private, mode)
+	if err == nil {
+		err = os.Rename(private, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	// the listener would remove the private name it was bound to
+	ul := l.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+	return &unixListener{UnixListener: ul, path: path}, nil
+}
+
+// unixListener removes its socket file once closed
+type unixListener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+}
+
+func (ul *unixListener) Close() error {
+	err := ul.UnixListener.Close()
+	ul.once.Do(func() { os.Remove(ul.path) })
+	return err
+}
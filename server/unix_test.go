@@ -0,0 +1,105 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Server_Unix(t *testing.T) {
+	root, err := ioutil.TempDir("", "queuefka-server")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(root)
+	m, err := queuefka.NewManager(filepath.Join(root, "data"), 512)
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+	path := filepath.Join(root, "queuefka.sock")
+
+	// a socket left behind by a crashed server is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		panic(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	staleInfo, err := os.Stat(path)
+	if err != nil {
+		panic(err)
+	}
+
+	s := New(m)
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServeUnix(path, 0600) }()
+	var nc net.Conn
+	for i := 0; i < 100; i++ {
+		fi, err := os.Stat(path)
+		if err == nil && os.SameFile(fi, staleInfo) {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if err == nil && fi.Mode().Perm() != 0600 {
+			println(fi.Mode().String())
+			panic("server: the socket was reachable before it was given its mode:")
+		}
+		if err == nil {
+			nc, err = net.Dial("unix", path)
+			if err == nil {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if nc == nil {
+		panic("server: ListenAndServeUnix did not listen with the mode given:")
+	}
+	c := &testConn{nc: nc, br: bufio.NewReader(nc)}
+	defer c.nc.Close()
+	high, err := c.produce("orders", "over a unix socket")
+	if err != nil {
+		panic(err)
+	}
+	_, records, err := c.fetch("orders", 0, 0, 0)
+	if err != nil || len(records) != 1 || records[0].NextAddress != high {
+		println(err)
+		panic("server: fetch over a unix socket did not return what was produced:")
+	}
+
+	// a second server may not take over the live socket
+	if err := New(m).ListenAndServeUnix(path, 0600); err == nil {
+		panic("server: ListenAndServeUnix replaced a live socket:")
+	}
+
+	s.Close()
+	if err := <-served; err != ErrServerClosed {
+		println(err)
+		panic("server: ListenAndServeUnix did not report the Server closed:")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		panic("server: the socket file was left behind:")
+	}
+	if names, _ := filepath.Glob(filepath.Join(root, ".queuefka-sock-*")); len(names) != 0 {
+		println(names[0])
+		panic("server: the private directory the socket was bound in was left behind:")
+	}
+
+	// nor a file which is not a socket
+	ioutil.WriteFile(path, []byte("data"), 0600)
+	if err := New(m).ListenAndServeUnix(path, 0600); err != ErrNotSocket {
+		println(err)
+		panic("server: ListenAndServeUnix removed a file which is not a socket:")
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// DefaultSubscribeWriteTimeout is how long a WebSocket or gRPC subscriber
+// may take to accept each message unless set otherwise.
+const DefaultSubscribeWriteTimeout = 30 * time.Second
+
+// websocketGUID is appended to a client's key to accept a WebSocket, see
+// RFC 6455 section 1.3
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes used, see RFC 6455 section 5.2
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// wsMaxControl is the largest payload of a control frame, and of any frame
+// accepted from a subscriber, who has nothing to send but control frames
+const wsMaxControl = 125
+
+// WebSocket close codes sent, see RFC 6455 section 7.4.1
+const (
+	wsNormal   = 1000
+	wsInternal = 1011
+)
+
+var errWebSocket = errors.New("server: malformed WebSocket frame")
+
+// httpSubscribe upgrades r to a WebSocket and streams each message of topic
+// from the address in its from parameter as a JSON text message, then every
+// new one as it is appended, until the client closes it.  The Reader only
+// moves on once a message has been written to the connection, so a slow
+// subscriber holds back its own stream without buffering it in memory.
+func (s *Server) httpSubscribe(w http.ResponseWriter, r *http.Request, topic string) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		httpError(w, ErrBadRequest)
+		return
+	}
+	var address uint64
+	if v := r.URL.Query().Get("from"); v != "" {
+		var err error
+		address, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			httpError(w, ErrBadRequest)
+			return
+		}
+	}
+
+	// open the topic first so a missing one is an ordinary HTTP error
+	rd, err := s.Manager.NewReader(topic, address, queuefka.WithFollow(true))
+	if err != nil && err != queuefka.ErrEndOfLog {
+		if rd != nil {
+			rd.Close()
+		}
+		httpError(w, err)
+		return
+	}
+	it := rd.Iterator()
+	defer it.Close()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		httpError(w, errors.New("server: connection cannot be upgraded"))
+		return
+	}
+	nc, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer nc.Close()
+	if !s.trackHijacked(nc) {
+		return
+	}
+	defer s.untrackHijacked(nc)
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+	if brw.Flush() != nil {
+		return
+	}
+
+	ws := &wsConn{nc: nc, bw: brw.Writer, timeout: s.subscribeWriteTimeout()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		ws.control(brw.Reader)
+	}()
+
+	for it.NextContext(ctx) {
+		msg, err := json.Marshal(newHTTPRecord(it.Record()))
+		if err == nil {
+			err = ws.write(wsText, msg)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if ctx.Err() == nil && it.Err() != nil {
+		ws.close(wsInternal, it.Err().Error())
+	}
+}
+
+// headerHas reports whether the comma separated header name includes token
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trackHijacked adds nc to the connections closed by Close, unless already
+// closed
+func (s *Server) trackHijacked(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.hijacked == nil {
+		s.hijacked = make(map[net.Conn]struct{})
+	}
+	s.hijacked[nc] = struct{}{}
+	return true
+}
+
+// untrackHijacked forgets nc
+func (s *Server) untrackHijacked(nc net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.hijacked, nc)
+}
+
+// wsConn is the server end of a WebSocket
+type wsConn struct {
+	mu      sync.Mutex // serializes frames
+	nc      net.Conn
+	bw      *bufio.Writer
+	timeout time.Duration // for each frame
+	closed  bool
+}
+
+// write sends a single unfragmented frame, closing the connection if it
+// cannot be written within the timeout
+func (ws *wsConn) write(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return io.ErrClosedPipe
+	}
+	if opcode == wsClose {
+		ws.closed = true
+	}
+
+	hdr := []byte{0x80 | opcode, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	n := 2
+	switch {
+	case len(payload) < 126:
+		hdr[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(payload)))
+		n += 2
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(len(payload)))
+		n += 8
+	}
+	ws.nc.SetWriteDeadline(time.Now().Add(ws.timeout))
+	ws.bw.Write(hdr[:n])
+	ws.bw.Write(payload)
+	err := ws.bw.Flush()
+	if err != nil {
+		ws.closed = true
+		ws.nc.Close()
+	}
+	return err
+}
+
+// close sends a close frame with code and reason
+func (ws *wsConn) close(code uint16, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	if len(reason) > wsMaxControl-2 {
+		reason = reason[:wsMaxControl-2]
+	}
+	return ws.write(wsClose, append(payload, reason...))
+}
+
+// control answers the pings and close of the subscriber, ignoring anything
+// else it sends, until the connection closes or fails
+func (ws *wsConn) control(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsPing:
+			ws.write(wsPong, payload)
+		case wsClose:
+			ws.close(wsNormal, "")
+			return
+		}
+	}
+}
+
+// readWSFrame returns the opcode and unmasked payload of the next frame
+// sent by a client, which must mask it
+func readWSFrame(r *bufio.Reader) (byte, []byte, error) {
+	hdr := make([]byte, 2)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if hdr[1]&0x80 == 0 || hdr[1]&0x7f > wsMaxControl {
+		return 0, nil, errWebSocket
+	}
+	mask := make([]byte, 4)
+	payload := make([]byte, hdr[1]&0x7f)
+	_, err = io.ReadFull(r, mask)
+	if err == nil {
+		_, err = io.ReadFull(r, payload)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return hdr[0] & 0x0f, payload, nil
+}
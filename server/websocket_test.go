@@ -0,0 +1,191 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readServerFrame returns the opcode and payload of a frame from the server
+func readServerFrame(r *bufio.Reader) (byte, []byte) {
+	hdr := make([]byte, 2)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		panic(err)
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		buf := make([]byte, 2)
+		io.ReadFull(r, buf)
+		n = uint64(binary.BigEndian.Uint16(buf))
+	case 127:
+		buf := make([]byte, 8)
+		io.ReadFull(r, buf)
+		n = binary.BigEndian.Uint64(buf)
+	}
+	payload := make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		panic(err)
+	}
+	return hdr[0] & 0x0f, payload
+}
+
+// writeClientFrame sends a masked frame as a client must
+func writeClientFrame(w io.Writer, opcode byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	w.Write(frame)
+}
+
+func Test_Server_WebSocket(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	srv := httptest.NewServer(s.HTTPHandler())
+	defer srv.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := s.produce("events", []message{{value: []byte(fmt.Sprintf("message %d", i))}})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	nc, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		panic(err)
+	}
+	defer nc.Close()
+	nc.SetDeadline(time.Now().Add(10 * time.Second))
+	// the key and accept from the example in RFC 6455 section 1.3
+	fmt.Fprintf(nc, "GET /topics/events/subscribe?from=0 HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		println(resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+		panic("server: WebSocket handshake was not accepted:")
+	}
+
+	// the messages already written, then one appended while subscribed
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.produce("events", []message{{value: []byte("message 3")}})
+	}()
+	for i := 0; i < 4; i++ {
+		opcode, payload := readServerFrame(br)
+		var rec httpRecord
+		err = json.Unmarshal(payload, &rec)
+		if err != nil {
+			panic(err)
+		}
+		if opcode != wsText || string(rec.Value) != fmt.Sprintf("message %d", i) {
+			println(opcode, string(payload))
+			panic("server: WebSocket did not stream the messages in order:")
+		}
+	}
+
+	writeClientFrame(nc, wsPing, []byte("hello"))
+	opcode, payload := readServerFrame(br)
+	if opcode != wsPong || string(payload) != "hello" {
+		println(opcode, string(payload))
+		panic("server: WebSocket did not answer a ping:")
+	}
+	writeClientFrame(nc, wsClose, []byte{0x03, 0xe8})
+	opcode, payload = readServerFrame(br)
+	if opcode != wsClose || binary.BigEndian.Uint16(payload) != wsNormal {
+		println(opcode)
+		panic("server: WebSocket did not answer a close:")
+	}
+
+	// a plain GET is refused
+	resp, err = http.Get(srv.URL + "/topics/events/subscribe")
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		println(resp.StatusCode)
+		panic("server: subscribe without a WebSocket handshake was not a 400:")
+	}
+}
+
+func Test_Server_WebSocketWriteTimeout(t *testing.T) {
+	s, _, done := testServer()
+	defer done()
+	s.SubscribeWriteTimeout = 100 * time.Millisecond
+	srv := httptest.NewServer(s.HTTPHandler())
+	defer srv.Close()
+
+	// far more than the socket buffers hold
+	value := make([]byte, 64<<10)
+	for i := 0; i < 16; i++ {
+		batch := make([]message, 16)
+		for j := range batch {
+			batch[j].value = value
+		}
+		_, err := s.produce("events", batch)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	nc, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		panic(err)
+	}
+	defer nc.Close()
+	nc.(*net.TCPConn).SetReadBuffer(4096)
+	fmt.Fprintf(nc, "GET /topics/events/subscribe?from=0 HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(nc), nil)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		println(resp.StatusCode)
+		panic("server: WebSocket handshake was not accepted:")
+	}
+
+	// a subscriber which reads nothing more is hung up on
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.hijacked)
+		s.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			panic("server: WebSocket was not closed once a write timed out:")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
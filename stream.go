@@ -0,0 +1,181 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/vova616/xxhash"
+)
+
+// WriteFrom appends a single message of exactly size bytes read from r
+// without ever holding the whole payload in memory.  The frame header carries
+// the payload checksum so the payload is read twice: once to checksum it and
+// once to copy it into the slab.  If r is an io.ReadSeeker it is rewound
+// between the two passes, otherwise it is first spooled to a temporary file
+// in the topic directory.  Should r come up short nothing is appended.
+func (wt *Writer) WriteFrom(r io.Reader, size int64) error {
+	if size < 0 || size > math.MaxUint32-bodyHeaderSize {
+		return ErrRecordTooLarge
+	}
+
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		spool, err := wt.spool(r, size)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		rs = spool
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	err = wt.quota.wait(context.Background(), int(size), 1)
+	if err != nil {
+		return err
+	}
+
+	wt.Lock()
+	defer wt.Unlock()
+
+	// the body prefix is empty before FormatV2
+	timestamp := time.Now().UnixNano()
+	if wt.aged(timestamp) {
+		err := wt.roll()
+		if err != nil {
+			return err
+		}
+	}
+	prefix, err := encodeBody(wt.slabVersion, &message{timestamp: timestamp})
+	if err != nil {
+		return err
+	}
+	if wt.tooLarge(len(prefix) + int(size)) {
+		return ErrRecordTooLarge
+	}
+
+	// first pass computes the checksum
+	h := xxhash.New(0)
+	h.Write(prefix)
+	err = copyFull(h, rs, size)
+	if err != nil {
+		return err
+	}
+	_, err = rs.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	hdr := encodeFrameHeader(wt.slabVersion, wt.varint, uint32(len(prefix)+int(size)), h.Sum32())
+
+	// second pass copies the payload, anything buffered beforehand is
+	// flushed so a failed copy can be cut back off the slab
+	err = wt.flush()
+	if err != nil {
+		return err
+	}
+	wt.indexFrame(timestamp)
+	if wt.slabStart == 0 {
+		wt.slabStart = timestamp
+	}
+	_, err = wt.wt.Write(hdr)
+	if err == nil {
+		_, err = wt.wt.Write(prefix)
+	}
+	if err == nil {
+		err = copyFull(wt.wt, rs, size)
+	}
+	if err != nil {
+		return wt.discard(err)
+	}
+
+	wt.advance(uint64(len(hdr)+len(prefix))+uint64(size), 1)
+	return wt.appended()
+}
+
+// spool copies size bytes from r to a temporary file in the topic directory
+// and returns it rewound to the start
+func (wt *Writer) spool(r io.Reader, size int64) (*os.File, error) {
+	fp, err := os.CreateTemp(wt.topic, "spool-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	err = copyFull(fp, r, size)
+	if err == nil {
+		_, err = fp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return nil, err
+	}
+	return fp, nil
+}
+
+// discard drops whatever was written to the slab past wt.address and returns
+// err, caller must hold the lock
+func (wt *Writer) discard(err error) error {
+	offset := int64(wt.address - wt.base)
+	wt.wt.Reset(wt.fp)
+	wt.unmap()
+	terr := wt.fp.Truncate(offset)
+	if terr == nil {
+		terr = wt.setFile(wt.fp, offset)
+	}
+	if terr != nil {
+		return terr
+	}
+	return err
+}
+
+// copyFull copies exactly size bytes from r to w
+func copyFull(w io.Writer, r io.Reader, size int64) error {
+	_, err := io.CopyN(w, r, size)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// payloadReader is the io.Reader returned by Reader.Stream
+type payloadReader struct {
+	rd    *Reader
+	delim []byte
+	buf   []byte // rest of the current payload and delimiter
+	data  []byte // backing array of buf, reused for each payload
+}
+
+// Stream returns an io.Reader over the payloads of the messages rd returns,
+// one after another with delim, if any, after each, so the log can be fed to
+// a bufio.Scanner or a decoder.  The end of the log is io.EOF, unless rd is
+// in follow mode in which case reads wait for more.  rd should not be read
+// directly while the stream is in use.
+func (rd *Reader) Stream(delim []byte) io.Reader {
+	return &payloadReader{rd: rd, delim: delim}
+}
+
+func (pr *payloadReader) Read(p []byte) (int, error) {
+	for len(pr.buf) == 0 {
+		d, err := pr.rd.read(context.Background(), true)
+		if err == ErrEndOfLog {
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, err
+		}
+		pr.data = append(append(pr.data[:0], d...), pr.delim...)
+		pr.buf = pr.data
+	}
+
+	n := copy(p, pr.buf)
+	pr.buf = pr.buf[n:]
+	return n, nil
+}
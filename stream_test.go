@@ -0,0 +1,129 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_WriteFrom(t *testing.T) {
+	streamTopic := topic + ".stream"
+	os.RemoveAll(streamTopic)
+	defer os.RemoveAll(streamTopic)
+
+	wt, err := queuefka.NewWriter(streamTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+
+	// a seekable source is read in place
+	err = wt.WriteFrom(bytes.NewReader(big), int64(len(big)))
+	if err != nil {
+		panic(err)
+	}
+
+	// anything else is spooled first
+	err = wt.WriteFrom(io.MultiReader(bytes.NewReader(big)), int64(len(big)))
+	if err != nil {
+		panic(err)
+	}
+
+	// a short source leaves the log untouched
+	address := wt.Address()
+	err = wt.WriteFrom(bytes.NewReader(value), int64(len(value)+1))
+	if err != io.ErrUnexpectedEOF {
+		println(err)
+		panic("queuefka: WriteFrom accepted a short reader:")
+	}
+	if wt.Address() != address {
+		panic("queuefka: WriteFrom moved the address on failure:")
+	}
+	wt.Write(value)
+	wt.Flush()
+
+	rd, err := queuefka.NewReader(streamTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	for i, want := range [][]byte{big, big, value} {
+		got, err := rd.Read()
+		if err != nil {
+			panic(err)
+		}
+		if !bytes.Equal(got, want) {
+			println(i)
+			panic("queuefka: WriteFrom read back the wrong payload:")
+		}
+	}
+}
+
+func Test_Queuefka_Stream(t *testing.T) {
+	streamTopic := topic + ".stream"
+	os.RemoveAll(streamTopic)
+	defer os.RemoveAll(streamTopic)
+
+	wt, err := queuefka.NewWriter(streamTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	for i := 0; i < 20; i++ {
+		wt.Write([]byte(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+	wt.Flush()
+
+	// delimited payloads can be scanned as lines
+	rd, err := queuefka.NewReader(streamTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	scanner := bufio.NewScanner(rd.Stream([]byte("\n")))
+	n := 0
+	for scanner.Scan() {
+		if scanner.Text() != fmt.Sprintf(`{"n":%d}`, n) {
+			println(n, scanner.Text())
+			panic("queuefka: Stream returned the wrong line:")
+		}
+		n++
+	}
+	if scanner.Err() != nil || n != 20 {
+		println(n, scanner.Err())
+		panic("queuefka: Stream did not end at the end of the log:")
+	}
+
+	// or fed straight to a decoder, even a byte at a time
+	rd, err = queuefka.NewReader(streamTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	dec := json.NewDecoder(iotest.OneByteReader(rd.Stream(nil)))
+	for i := 0; i < 20; i++ {
+		var v struct{ N int }
+		err = dec.Decode(&v)
+		if err != nil || v.N != i {
+			println(i, v.N, err)
+			panic("queuefka: Stream returned the wrong payload:")
+		}
+	}
+	if dec.Decode(&struct{}{}) != io.EOF {
+		panic("queuefka: Stream did not return io.EOF at the end of the log:")
+	}
+}
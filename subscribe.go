@@ -0,0 +1,55 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+)
+
+// Subscribe tails topic from address, sending every message as a Record on
+// the returned channel as it is written, rolling over slabs as needed.  The
+// channel is closed once ctx is done.  Read errors go to WithErrorHandler if
+// given, after which the message is skipped if possible or otherwise retried
+// shortly.  WithQueueLength sets how many records are buffered in the
+// channel, the remaining options apply as for NewReader.
+func Subscribe(ctx context.Context, topic string, address uint64, opts ...Option) (<-chan Record, error) {
+	o := defaultOptions(opts)
+	rd, err := NewReader(topic, address, append(opts, WithFollow(true))...)
+	if err != nil && err != ErrEndOfLog {
+		rd.Close()
+		return nil, err
+	}
+
+	records := make(chan Record, o.QueueLength)
+	go func() {
+		defer close(records)
+		defer rd.Close()
+
+		for {
+			from := rd.address
+			rec, err := rd.readRecord(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if o.ErrorHandler != nil {
+					o.ErrorHandler(err)
+				}
+				if rd.address == from && rd.wait(ctx) != nil {
+					return
+				}
+				continue
+			}
+
+			select {
+			case records <- rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return records, nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Subscribe(t *testing.T) {
+	subTopic := topic + ".subscribe"
+	os.RemoveAll(subTopic)
+	defer os.RemoveAll(subTopic)
+
+	// small slabs so the subscription rolls over several
+	wt, err := queuefka.NewWriter(subTopic, 256)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	wt.Write([]byte("message 0"))
+	wt.Flush()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	records, err := queuefka.Subscribe(ctx, subTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		for i := 1; i < 30; i++ {
+			time.Sleep(time.Millisecond)
+			wt.Write([]byte(fmt.Sprintf("message %d", i)))
+			wt.Flush()
+		}
+	}()
+
+	var next uint64
+	for i := 0; i < 30; i++ {
+		rec := <-records
+		if string(rec.Value) != fmt.Sprintf("message %d", i) {
+			println(i, string(rec.Value))
+			panic("queuefka: Subscribe sent the wrong record:")
+		}
+		// a new slab starts with its 8 byte header
+		if i > 0 && rec.Address != next && rec.Address != next+8 {
+			println(i, rec.Address, next)
+			panic("queuefka: Subscribe skipped part of the log:")
+		}
+		next = rec.NextAddress
+	}
+
+	// cancelling closes the channel
+	cancel()
+	select {
+	case _, ok := <-records:
+		if ok {
+			panic("queuefka: Subscribe sent a record it should not have:")
+		}
+	case <-time.After(time.Second):
+		panic("queuefka: Subscribe did not close its channel when cancelled:")
+	}
+}
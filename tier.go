@@ -0,0 +1,194 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Fetcher downloads the files an Archiver stored, see SetTieredStorage.
+type Fetcher interface {
+	Download(name string, w io.Writer) error // copy the file stored as name to w
+}
+
+// tier lets a Reader read slabs which retention archived and deleted,
+// fetching each into a local cache directory the first time it is needed
+type tier struct {
+	fetcher Fetcher
+	cache   string // directory holding fetched slabs
+}
+
+// archivedPath returns the file listing the base addresses of the slabs of
+// a topic which retention uploaded to an Archiver before deleting them
+func archivedPath(topic string) string {
+	return filepath.Join(topic, "archived.slabs")
+}
+
+// recordArchived atomically adds base to the slabs of topic archived, which
+// are always archived oldest first
+func recordArchived(topic string, base uint64) error {
+	bases, err := readArchived(topic)
+	if err != nil {
+		return err
+	}
+	if len(bases) > 0 && bases[len(bases)-1] >= base {
+		return nil
+	}
+
+	buf := make([]byte, 8*(len(bases)+1))
+	for i, b := range append(bases, base) {
+		binary.LittleEndian.PutUint64(buf[8*i:], b)
+	}
+	tmp := archivedPath(topic) + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, archivedPath(topic))
+}
+
+// readArchived returns the base addresses of the slabs of topic archived,
+// none if retention never archived any
+func readArchived(topic string) ([]uint64, error) {
+	buf, err := ioutil.ReadFile(archivedPath(topic))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(buf)%8 != 0 {
+		return nil, ErrBadChecksum
+	}
+	bases := make([]uint64, len(buf)/8)
+	for i := range bases {
+		bases[i] = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+	return bases, nil
+}
+
+// slabs returns the local slabs of topic preceded by the path in the cache
+// of every archived slab older than them, fetched or not
+func (t *tier) slabs(topic string, local []string) []string {
+	bases, err := readArchived(topic)
+	if err != nil || len(bases) == 0 {
+		return local
+	}
+	oldest := ^uint64(0)
+	if len(local) > 0 {
+		oldest, _ = slabBase(local[0])
+	}
+
+	naming := topicNaming(topic)
+	slabs := make([]string, 0, len(bases)+len(local))
+	for _, base := range bases {
+		if base < oldest {
+			slabs = append(slabs, filepath.Join(t.cache, naming.name(base)))
+		}
+	}
+	return append(slabs, local...)
+}
+
+// fetch downloads an archived slab into the cache unless it is there
+// already, along with its footer which it is checked against
+func (t *tier) fetch(slab string) error {
+	if filepath.Dir(slab) != filepath.Clean(t.cache) {
+		return nil
+	}
+	_, err := os.Stat(slab)
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.MkdirAll(t.cache, 0700)
+	if err != nil {
+		return err
+	}
+	footer := footerPath(slab)
+	err = t.download(footer)
+	if err != nil {
+		return err
+	}
+	tmp, err := t.downloadTmp(slab)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// the download is checked against the footer beside it
+	base, err := slabBase(slab)
+	if err != nil {
+		return err
+	}
+	size, err := slabLength(tmp)
+	if err != nil {
+		return err
+	}
+	err = VerifySegment(Segment{Base: base, Path: tmp, Size: size})
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, slab)
+}
+
+// download fetches the file stored as the base name of path to path
+func (t *tier) download(path string) error {
+	tmp, err := t.downloadTmp(path)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// downloadTmp fetches the file stored as the base name of path to a
+// temporary file beside path, named so its sidecars are found as path's
+// e.g. <base>.fetch.slab
+func (t *tier) downloadTmp(path string) (string, error) {
+	stem := slabStem(path)
+	tmp := stem + ".fetch" + strings.TrimPrefix(path, stem)
+	fp, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = t.fetcher.Download(filepath.Base(path), fp)
+	if err == nil {
+		err = fp.Sync()
+	}
+	cerr := fp.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// SetTieredStorage lets the Reader read on from before the low watermark,
+// through slabs which retention uploaded to an Archiver before deleting
+// them, by fetching each from fetcher into cache the first time it is
+// needed.  Slabs fetched are checked against their footer and left in cache
+// for later Readers.  A nil fetcher turns tiered storage off.
+func (rd *Reader) SetTieredStorage(fetcher Fetcher, cache string) {
+	rd.tier = nil
+	if fetcher != nil {
+		rd.tier = &tier{fetcher: fetcher, cache: cache}
+	}
+}
+
+// slabs returns the slabs the Reader may read, including archived ones when
+// tiered storage is on
+func (rd *Reader) slabs() []string {
+	slabs := SlabFiles(rd.topic)
+	if rd.tier == nil {
+		return slabs
+	}
+	return rd.tier.slabs(rd.topic, slabs)
+}
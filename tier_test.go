@@ -0,0 +1,88 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_TieredStorage(t *testing.T) {
+	tierTopic := topic + ".tier"
+	cache := topic + ".tiercache"
+	os.RemoveAll(tierTopic)
+	os.RemoveAll(cache)
+	defer os.RemoveAll(tierTopic)
+	defer os.RemoveAll(cache)
+
+	archive := memArchiver{}
+	wt, err := queuefka.NewWriter(tierTopic, 256, queuefka.WithRetention(queuefka.RetentionPolicy{MaxSegments: 2, Archiver: archive}))
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+	var addresses []uint64
+	for i := 0; i < 100; i++ {
+		addresses = append(addresses, wt.Address())
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Flush()
+	err = wt.ApplyRetention()
+	if err != nil {
+		panic(err)
+	}
+	_, first, err := queuefka.LowWatermark(tierTopic)
+	if err != nil || first == 0 {
+		panic("queuefka: ApplyRetention did not delete any slabs:")
+	}
+
+	// without tiered storage the deleted messages are gone
+	_, err = queuefka.NewReader(tierTopic, addresses[1])
+	if err != queuefka.ErrAddressTruncated {
+		println(err)
+		panic("queuefka: Reader read a deleted slab without tiered storage:")
+	}
+
+	// with it they are fetched back and read straight on into local slabs
+	rd, err := queuefka.NewReader(tierTopic, 0, queuefka.WithTieredStorage(archive, cache))
+	if err != nil {
+		panic(err)
+	}
+	defer rd.Close()
+	values := readValues(rd)
+	if len(values) != 100 || values[0] != "message 0" || values[99] != "message 99" {
+		println(len(values))
+		panic("queuefka: Reader did not read archived slabs:")
+	}
+	fetched, _ := filepath.Glob(filepath.Join(cache, "*.slab"))
+	if len(fetched) == 0 || len(fetched) != len(archive)/2 {
+		println(len(fetched), len(archive))
+		panic("queuefka: archived slabs were not cached:")
+	}
+
+	err = rd.Seek(tierTopic, addresses[first/2])
+	if err != nil {
+		panic(err)
+	}
+	d, _ := rd.Read()
+	if string(d) != fmt.Sprintf("message %d", first/2) {
+		println(string(d))
+		panic("queuefka: Seek into an archived slab returned the wrong message:")
+	}
+
+	// a damaged download is refused
+	os.RemoveAll(cache)
+	name := filepath.Base(fetched[0])
+	archive[name][len(archive[name])/2] ^= 0x10
+	err = rd.Seek(tierTopic, 0)
+	if err != queuefka.ErrBadChecksum {
+		println(err)
+		panic("queuefka: Reader accepted a damaged archived slab:")
+	}
+}
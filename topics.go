@@ -0,0 +1,146 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// TopicInfo summarizes a topic found by ListTopics.
+type TopicInfo struct {
+	Name          string      // directory relative to the data directory
+	Path          string      // directory to open the topic with
+	Segments      int         // slabs, counting the active one
+	Bytes         int64       // logical size of the slabs
+	LowWatermark  uint64      // base address of the oldest slab left
+	HighWatermark uint64      // address just past the last whole message
+	Partitions    []TopicInfo // of a partitioned topic, adding up to its totals
+}
+
+// ListTopics returns every topic in the data directory root with a summary
+// of each, for dashboards and admin tools, sorted by name.  Topics nested in
+// namespaces are found too, named e.g. orders/eu/created.  The partitions of
+// a partitioned topic are listed within it, each with its own watermarks.
+// Only the active slab of each topic is read, to find its high watermark, so
+// ListTopics may be run while Writers append.
+func ListTopics(root string) ([]TopicInfo, error) {
+	var topics []TopicInfo
+	err := walkTopics(root, func(name, path string) error {
+		t, err := statTopic(path)
+		if err != nil {
+			return err
+		}
+		t.Name = name
+		topics = append(topics, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	return topics, nil
+}
+
+// walkTopics calls fn with the name, relative to root and separated by
+// slashes, and the path of every topic within root, looking inside the
+// directories which are not topics as namespaces
+func walkTopics(root string, fn func(name, path string) error) error {
+	infos, err := readDirInfos(root)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !info.IsDir() || transient(info.Name()) {
+			continue
+		}
+		path := filepath.Join(root, info.Name())
+		if isTopic(path) {
+			err = fn(info.Name(), path)
+		} else {
+			err = walkTopics(path, func(name, path string) error {
+				return fn(info.Name()+"/"+name, path)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTopic reports whether path holds a topic, partitioned or not
+func isTopic(path string) bool {
+	if len(SlabFiles(path)) > 0 {
+		return true
+	}
+	n, _ := Partitions(path)
+	return n > 0
+}
+
+// readDirInfos returns the entries of dir
+func readDirInfos(dir string) ([]os.FileInfo, error) {
+	fp, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return fp.Readdir(-1)
+}
+
+// statTopic summarizes the topic in path
+func statTopic(path string) (TopicInfo, error) {
+	t := TopicInfo{Name: filepath.Base(path), Path: path}
+	n, err := Partitions(path)
+	if err != nil {
+		return t, err
+	}
+	slabs := SlabFiles(path)
+	if n > 0 && len(slabs) == 0 {
+		for i := 0; i < n; i++ {
+			p, err := statTopic(PartitionPath(path, i))
+			if err != nil {
+				return t, err
+			}
+			t.Partitions = append(t.Partitions, p)
+			t.Segments += p.Segments
+			t.Bytes += p.Bytes
+		}
+		return t, nil
+	}
+	if len(slabs) == 0 {
+		return t, nil
+	}
+
+	t.Segments = len(slabs)
+	t.LowWatermark, _, err = LowWatermark(path)
+	if err != nil {
+		return t, err
+	}
+	for _, slab := range slabs[:len(slabs)-1] {
+		size, err := slabLength(slab)
+		if os.IsNotExist(err) {
+			// deleted by retention since the slabs were listed
+			t.Segments--
+			continue
+		} else if err != nil {
+			return t, err
+		}
+		t.Bytes += size
+	}
+	active := slabs[len(slabs)-1]
+	base, err := slabBase(active)
+	if err != nil {
+		return t, err
+	}
+	end, err := activeEnd(Segment{Base: base, Path: active})
+	if err != nil {
+		return t, err
+	}
+	t.Bytes += end
+	t.HighWatermark = base + uint64(end)
+	return t, nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_ListTopics(t *testing.T) {
+	root := topic + ".topics"
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+
+	wt, err := queuefka.NewWriter(filepath.Join(root, "plain"), 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 40; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	high := wt.Address()
+	wt.Close()
+
+	parted := filepath.Join(root, "parted")
+	err = queuefka.CreatePartitions(parted, 2, 512)
+	if err != nil {
+		panic(err)
+	}
+	pw, err := queuefka.NewPartitionedWriter(parted, nil, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 40; i++ {
+		pw.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	pw.Close()
+
+	// neither is a topic
+	os.MkdirAll(filepath.Join(root, "empty"), 0700)
+	os.MkdirAll(filepath.Join(root, "gone.deleted-1"), 0700)
+
+	topics, err := queuefka.ListTopics(root)
+	if err != nil {
+		panic(err)
+	}
+	if len(topics) != 2 || topics[0].Name != "parted" || topics[1].Name != "plain" {
+		println(len(topics))
+		panic("queuefka: ListTopics did not find the topics:")
+	}
+
+	plain := topics[1]
+	if plain.Segments != len(queuefka.SlabFiles(plain.Path)) || plain.HighWatermark != high ||
+		plain.LowWatermark != 0 || plain.Bytes != int64(high) {
+		println(plain.Segments, plain.HighWatermark, high, plain.Bytes)
+		panic("queuefka: ListTopics summarized a topic wrongly:")
+	}
+
+	p := topics[0]
+	if len(p.Partitions) != 2 || p.Partitions[1].Path != queuefka.PartitionPath(parted, 1) ||
+		p.Segments != p.Partitions[0].Segments+p.Partitions[1].Segments ||
+		p.Bytes != p.Partitions[0].Bytes+p.Partitions[1].Bytes || p.Partitions[0].HighWatermark == 0 {
+		println(len(p.Partitions), p.Segments, p.Bytes)
+		panic("queuefka: ListTopics summarized a partitioned topic wrongly:")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"io"
+	"os"
+)
+
+// Truncate rolls the log back so the next message is written at address,
+// deleting every slab after the one holding it and trimming that one, which
+// becomes the active slab again.  The address must be that of a frame, e.g.
+// one returned by ReadRecord, or the current end of the log, otherwise
+// ErrOutOfBounds is returned and nothing changes.  A Truncate with
+// transactions still open fails with ErrTxnOpen.  Readers past address are
+// not told and may see the messages written from now on at addresses they
+// have already read.
+func (wt *Writer) Truncate(address uint64) error {
+	wt.Lock()
+	defer wt.Unlock()
+
+	if len(wt.openTxns) > 0 {
+		return ErrTxnOpen
+	}
+	if address > wt.address {
+		return ErrOutOfBounds
+	}
+	err := wt.flush()
+	if err != nil {
+		return err
+	}
+
+	slabs := SlabFiles(wt.topic)
+	i, base := findSlab(slabs, address)
+	if address < base {
+		return ErrAddressTruncated
+	}
+
+	// the slab cut becomes the one appended to so it cannot stay compressed
+	err = decompressSlab(slabs[i])
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(slabs[i])
+	if err != nil {
+		return err
+	}
+	seg := Segment{Base: base, Path: slabs[i], Size: stat.Size()}
+	if i == len(slabs)-1 {
+		seg.Size = int64(wt.address - wt.base)
+	}
+
+	// only cut at the start of a frame or the end of the last one, whatever
+	// comes after address may well be corrupt
+	fp, err := os.Open(seg.Path)
+	if err != nil {
+		return err
+	}
+	_, hdrLen, err := slabVersion(fp)
+	fp.Close()
+	if err != nil {
+		return err
+	}
+	if address < base+hdrLen {
+		address = base + hdrLen
+	}
+	next := base + hdrLen
+	err = walkFrames(seg, func(frame uint64, raw []byte, _ *message) error {
+		if frame >= address {
+			return io.EOF
+		}
+		next = frame + uint64(len(raw))
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return err
+	}
+	holes, err := readSlabHoles(seg.Path)
+	if err != nil {
+		return err
+	}
+	if end, ok := holeEnd(holes, next-base); ok && base+end == address {
+		// the end of a hole punched after the last frame kept
+		next = address
+	}
+	if next != address {
+		return ErrOutOfBounds
+	}
+
+	// the current slab is reopened below, whichever it turns out to be
+	wt.unmap()
+	err = wt.fp.Close()
+	if err != nil {
+		return err
+	}
+
+	// newest first so a crash part way leaves a contiguous log
+	for j := len(slabs) - 1; j > i; j-- {
+		err = removeSlab(slabs[j])
+		if err != nil {
+			return err
+		}
+	}
+
+	// sidecars describe the slab as it was when sealed
+	for _, sidecar := range []string{countPath(seg.Path), indexPath(seg.Path), timeIndexPath(seg.Path), endPath(seg.Path), footerPath(seg.Path), metaPath(seg.Path)} {
+		err = os.Remove(sidecar)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err = writeSlabHoles(seg.Path, clipHoles(holes, address-base))
+	if err != nil {
+		return err
+	}
+	err = os.Truncate(seg.Path, int64(address-base))
+	if err != nil {
+		return err
+	}
+
+	// carry on appending to the trimmed slab as if the Writer had just
+	// been opened on it
+	wt.load()
+	wt.producers = nil
+	err = wt.sync()
+	if err != nil {
+		return err
+	}
+	return syncDir(wt.topic)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Truncate(t *testing.T) {
+	truncTopic := topic + ".truncate"
+	os.RemoveAll(truncTopic)
+	defer os.RemoveAll(truncTopic)
+
+	for _, opts := range [][]queuefka.Option{nil, {queuefka.WithPreallocate(true)}} {
+		os.RemoveAll(truncTopic)
+		wt, err := queuefka.NewWriter(truncTopic, 256, opts...)
+		if err != nil {
+			panic(err)
+		}
+
+		var addresses []uint64
+		for i := 0; i < 100; i++ {
+			addresses = append(addresses, wt.Address())
+			wt.Write([]byte(fmt.Sprintf("message %d", i)))
+		}
+		slabs := len(queuefka.SlabFiles(truncTopic))
+
+		// an address inside a frame is refused
+		err = wt.Truncate(addresses[30] + 1)
+		if err != queuefka.ErrOutOfBounds {
+			println(err)
+			panic("queuefka: Truncate accepted an address inside a frame:")
+		}
+
+		err = wt.Truncate(addresses[30])
+		if err != nil {
+			panic(err)
+		}
+		if wt.Address() != addresses[30] || len(queuefka.SlabFiles(truncTopic)) >= slabs {
+			println(wt.Address(), addresses[30], len(queuefka.SlabFiles(truncTopic)), slabs)
+			panic("queuefka: Truncate did not cut the log back:")
+		}
+
+		// appending carries on from the cut, rolling new slabs as before
+		for i := 30; i < 60; i++ {
+			wt.Write([]byte(fmt.Sprintf("again %d", i)))
+		}
+		wt.Flush()
+
+		rd, err := queuefka.NewReader(truncTopic, 0)
+		if err != nil {
+			panic(err)
+		}
+		values := readValues(rd)
+		rd.Close()
+		if len(values) != 60 || values[29] != "message 29" || values[30] != "again 30" || values[59] != "again 59" {
+			println(len(values))
+			panic("queuefka: Truncate left the wrong messages:")
+		}
+		count, err := queuefka.CountMessages(truncTopic)
+		if err != nil || count != 60 {
+			println(count, err)
+			panic("queuefka: CountMessages does not match a truncated topic:")
+		}
+
+		// and the cut survives reopening
+		wt.Close()
+		wt, err = queuefka.NewWriter(truncTopic, 256, opts...)
+		if err != nil {
+			panic(err)
+		}
+		wt.Write([]byte("last"))
+		wt.Close()
+		rd, err = queuefka.NewReader(truncTopic, 0)
+		if err != nil {
+			panic(err)
+		}
+		values = readValues(rd)
+		rd.Close()
+		if len(values) != 61 || values[60] != "last" {
+			println(len(values))
+			panic("queuefka: reopened Writer did not append after the cut:")
+		}
+	}
+}
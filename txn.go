@@ -0,0 +1,165 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"context"
+	"time"
+)
+
+// transaction marker types
+const (
+	txnCommit uint8 = 1
+	txnAbort  uint8 = 2
+)
+
+// Txn is a group of messages which a committed mode Reader returns only once
+// a commit marker for them is in the log, and never if they are aborted.  Its
+// messages are appended as they are written, interleaved with any others.
+type Txn struct {
+	wt   *Writer
+	id   uint64
+	done bool
+}
+
+// Begin starts a transaction, which requires FormatV2 or later.  Any
+// transaction still open when the Writer is closed is aborted.  One left open
+// by a crash holds back committed mode Readers at its first message.
+func (wt *Writer) Begin() (*Txn, error) {
+	wt.Lock()
+	defer wt.Unlock()
+
+	if wt.slabVersion < FormatV2 {
+		return nil, ErrOldFormat
+	}
+
+	// ids only need to be unique within a topic
+	id := uint64(time.Now().UnixNano())
+	if id <= wt.txnSeq {
+		id = wt.txnSeq + 1
+	}
+	wt.txnSeq = id
+
+	if wt.openTxns == nil {
+		wt.openTxns = map[uint64]bool{}
+	}
+	wt.openTxns[id] = true
+	return &Txn{wt: wt, id: id}, nil
+}
+
+// Write appends a message as part of the transaction.
+func (tx *Txn) Write(d []byte) error {
+	if tx.done {
+		return ErrTxnDone
+	}
+	return tx.wt.write(context.Background(), &message{txn: tx.id, value: d})
+}
+
+// Commit appends a commit marker making the transaction's messages visible to
+// committed mode Readers.  The marker is synced like any other message.
+func (tx *Txn) Commit() error {
+	return tx.end(txnCommit)
+}
+
+// Abort appends an abort marker so committed mode Readers skip the
+// transaction's messages.
+func (tx *Txn) Abort() error {
+	return tx.end(txnAbort)
+}
+
+// end appends the marker closing the transaction
+func (tx *Txn) end(control uint8) error {
+	if tx.done {
+		return ErrTxnDone
+	}
+
+	wt := tx.wt
+	wt.Lock()
+	defer wt.Unlock()
+
+	err := wt.writeLocked(context.Background(), &message{txn: tx.id, control: control})
+	if err != nil {
+		return err
+	}
+	tx.done = true
+	delete(wt.openTxns, tx.id)
+	return nil
+}
+
+// abortAll aborts every open transaction, caller must hold the lock
+func (wt *Writer) abortAll() error {
+	for id := range wt.openTxns {
+		err := wt.writeLocked(context.Background(), &message{txn: id, control: txnAbort})
+		if err != nil {
+			return err
+		}
+		delete(wt.openTxns, id)
+	}
+	return nil
+}
+
+// SetReadCommitted makes Read skip messages of aborted transactions and hold
+// back at the first message of a transaction until it is committed, returning
+// ErrEndOfLog meanwhile even if later messages are in the log.  Transaction
+// markers are never returned either way.
+func (rd *Reader) SetReadCommitted(enabled bool) {
+	rd.committed = enabled
+}
+
+// outcome reports whether transaction txn, whose message is at address from,
+// committed, reading ahead for its marker if need be.  ErrEndOfLog means the
+// marker is not in the log yet.
+func (rd *Reader) outcome(txn, from uint64) (bool, error) {
+	if rd.txns == nil {
+		rd.txns = map[uint64]bool{}
+	}
+
+	for {
+		committed, ok := rd.txns[txn]
+		if ok {
+			return committed, nil
+		}
+
+		// markers are only known from where the ahead Reader started
+		if rd.ahead == nil || from < rd.aheadFrom || rd.ahead.address < from {
+			if rd.ahead != nil {
+				rd.ahead.Close()
+			}
+			rd.ahead = &Reader{topic: rd.topic, txns: rd.txns, lookahead: true}
+			rd.aheadFrom = from
+			err := rd.ahead.Seek(rd.topic, from)
+			if err != nil {
+				rd.ahead.Close()
+				rd.ahead = nil
+				return false, err
+			}
+		}
+
+		// a marker is consumed by the Read which goes on to the next message
+		address := rd.ahead.address
+		_, err := rd.ahead.Read()
+		if committed, ok := rd.txns[txn]; ok {
+			return committed, nil
+		}
+		if err == ErrEndOfLog || (err != nil && rd.ahead.address == address) {
+			return false, err
+		}
+	}
+}
+
+// resolve handles a transaction marker read from the log
+func (rd *Reader) resolve(m *message) {
+	if rd.lookahead {
+		rd.txns[m.txn] = m.control == txnCommit
+		return
+	}
+
+	// nothing of the transaction follows its marker so forget it, which
+	// also means the ahead Reader no longer knows of markers before here
+	if _, ok := rd.txns[m.txn]; ok {
+		delete(rd.txns, m.txn)
+		rd.aheadFrom = rd.address
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+// readValues returns every message left in rd as strings
+func readValues(rd *queuefka.Reader) []string {
+	var values []string
+	for {
+		d, err := rd.Read()
+		if err == queuefka.ErrEndOfLog {
+			return values
+		} else if err != nil {
+			panic(err)
+		}
+		values = append(values, string(d))
+	}
+}
+
+func Test_Queuefka_Transactions(t *testing.T) {
+	txnTopic := topic + ".txn"
+	os.RemoveAll(txnTopic)
+	defer os.RemoveAll(txnTopic)
+
+	wt, err := queuefka.NewWriter(txnTopic, segmentSizeHint)
+	if err != nil {
+		panic(err)
+	}
+	defer wt.Close()
+
+	rd, err := queuefka.NewReader(txnTopic, 0, queuefka.WithReadCommitted(true))
+	if err != nil && err != queuefka.ErrEndOfLog {
+		panic(err)
+	}
+	defer rd.Close()
+
+	// an open transaction holds back everything after it
+	wt.Write([]byte("a"))
+	committed, _ := wt.Begin()
+	aborted, _ := wt.Begin()
+	committed.Write([]byte("c1"))
+	aborted.Write([]byte("x1"))
+	wt.Write([]byte("b"))
+	committed.Write([]byte("c2"))
+	wt.Flush()
+	if got := readValues(rd); len(got) != 1 || got[0] != "a" {
+		println(len(got))
+		panic("queuefka: ReadCommitted read past an open transaction:")
+	}
+
+	// committing releases it while the aborted one is skipped
+	if err := committed.Commit(); err != nil {
+		panic(err)
+	}
+	wt.Flush()
+	if got := readValues(rd); len(got) != 1 || got[0] != "c1" {
+		println(len(got))
+		panic("queuefka: ReadCommitted read past a second open transaction:")
+	}
+	if err := aborted.Abort(); err != nil {
+		panic(err)
+	}
+	wt.Flush()
+	got := readValues(rd)
+	if len(got) != 2 || got[0] != "b" || got[1] != "c2" {
+		println(len(got))
+		panic("queuefka: ReadCommitted returned the wrong messages:")
+	}
+	if err := committed.Write(value); err != queuefka.ErrTxnDone {
+		panic("queuefka: Txn accepted a Write after Commit:")
+	}
+
+	// an uncommitted Reader sees every message but no markers
+	all, err := queuefka.NewReader(txnTopic, 0)
+	if err != nil {
+		panic(err)
+	}
+	defer all.Close()
+	if got := readValues(all); len(got) != 5 {
+		println(len(got))
+		panic("queuefka: Read returned transaction markers:")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka
+
+import (
+	"os"
+)
+
+// Corruption is a damaged range of a topic found by Verify.
+type Corruption struct {
+	Path string // slab holding the range
+	From uint64 // address of the first byte damaged
+	To   uint64 // address of the next intact frame, or the end of the slab
+	Err  error  // e.g. ErrBadChecksum, or ErrBadFooter for a whole slab
+}
+
+// Report is what Verify found in a topic.
+type Report struct {
+	Segments int          // slabs checked, including the active one
+	Records  uint64       // messages in intact frames
+	Bytes    int64        // logical size of every slab checked
+	Corrupt  []Corruption // damaged ranges ordered by address
+}
+
+// OK reports whether Verify found nothing wrong.
+func (r *Report) OK() bool {
+	return len(r.Corrupt) == 0
+}
+
+// Verify reads every frame of every slab of topic, checking its checksums,
+// and checks each sealed slab against its footer, returning a Report of any
+// damage found.  Damaged frames are skipped as with SetSkipCorrupt so one
+// corrupt frame does not hide those after it.  A sealed slab whose frames
+// are intact but which no longer matches its footer is reported whole as
+// ErrBadFooter.  Verify only reads the topic, so it may be run while a
+// Writer appends to it, the active slab being checked up to its end when
+// Verify reaches it.
+func Verify(topic string) (*Report, error) {
+	slabs := SlabFiles(topic)
+	if len(slabs) <= 0 {
+		return nil, ErrInvalidTopic
+	}
+
+	report := &Report{}
+	for i, slab := range slabs {
+		base, err := slabBase(slab)
+		if err != nil {
+			return nil, err
+		}
+		size, err := slabLength(slab)
+		if err != nil {
+			return nil, err
+		}
+		seg := Segment{Base: base, Path: slab, Size: size}
+		report.Segments++
+		report.Bytes += size
+
+		corrupt, records, err := verifyFrames(seg)
+		if err != nil {
+			return nil, err
+		}
+		report.Records += records
+		report.Corrupt = append(report.Corrupt, corrupt...)
+		if i == len(slabs)-1 || len(corrupt) > 0 {
+			continue
+		}
+
+		// frames can all check out with the slab still changed since it
+		// was sealed, e.g. a frame lost from the end
+		f, err := readSlabFooter(slab)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = VerifySegment(seg)
+		}
+		if err == nil && f.Records != records {
+			err = ErrBadFooter
+		}
+		if err == ErrBadChecksum {
+			err = ErrBadFooter
+		}
+		if err == ErrBadFooter {
+			report.Corrupt = append(report.Corrupt, Corruption{Path: slab, From: base, To: base + uint64(size), Err: err})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// verifyFrames reads every frame of seg returning the damaged ranges and
+// how many messages the intact frames hold
+func verifyFrames(seg Segment) ([]Corruption, uint64, error) {
+	var corrupt []Corruption
+	var records uint64
+	rd, err := NewSegmentReader(seg)
+	defer rd.Close()
+	if err == ErrEndOfLog {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	rd.SetSkipCorrupt(func(from, to uint64, err error) {
+		corrupt = append(corrupt, Corruption{Path: seg.Path, From: from, To: to, Err: err})
+	})
+
+	for {
+		_, err = rd.Read()
+		if err == ErrEndOfLog {
+			return corrupt, records, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		records++
+	}
+}
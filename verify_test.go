@@ -0,0 +1,86 @@
+// Copyright (c) 2015-2016 John W. Leimgruber III <blog.ubergarm.com>
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package queuefka_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ubergarm/queuefka"
+)
+
+func Test_Queuefka_Verify(t *testing.T) {
+	verifyTopic := topic + ".verify"
+	os.RemoveAll(verifyTopic)
+	defer os.RemoveAll(verifyTopic)
+
+	wt, err := queuefka.NewWriter(verifyTopic, 512)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 60; i++ {
+		wt.Write([]byte(fmt.Sprintf("message %d", i)))
+	}
+	wt.Close()
+
+	report, err := queuefka.Verify(verifyTopic)
+	if err != nil {
+		panic(err)
+	}
+	segments, _ := queuefka.SealedSegments(verifyTopic)
+	if !report.OK() || report.Records != 60 || report.Segments != len(segments)+1 {
+		println(len(report.Corrupt), report.Records, report.Segments)
+		panic("queuefka: Verify found fault with an intact topic:")
+	}
+
+	// damage a payload in the first slab
+	fp, err := os.OpenFile(segments[0].Path, os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	damaged := segments[0].Size - 3
+	fp.WriteAt([]byte{'X'}, damaged)
+	fp.Close()
+
+	// drop the last whole frame of the second slab, which leaves every frame
+	// intact but the slab no longer what was sealed
+	rd, err := queuefka.NewSegmentReader(segments[1])
+	if err != nil {
+		panic(err)
+	}
+	var last uint64
+	for {
+		rec, err := rd.ReadRecord()
+		if err == queuefka.ErrEndOfLog {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		last = rec.Address
+	}
+	rd.Close()
+	os.Truncate(segments[1].Path, int64(last-segments[1].Base))
+
+	report, err = queuefka.Verify(verifyTopic)
+	if err != nil {
+		panic(err)
+	}
+	if len(report.Corrupt) != 2 || report.Records != 58 {
+		println(len(report.Corrupt), report.Records)
+		panic("queuefka: Verify did not report the damage:")
+	}
+	c := report.Corrupt[0]
+	at := segments[0].Base + uint64(damaged)
+	if c.Path != segments[0].Path || c.Err != queuefka.ErrBadChecksum || c.From > at || c.To <= at {
+		println(c.Path, c.From, c.To, at, c.Err)
+		panic("queuefka: Verify reported the wrong damaged range:")
+	}
+	c = report.Corrupt[1]
+	if c.Path != segments[1].Path || c.Err != queuefka.ErrBadFooter || c.From != segments[1].Base {
+		println(c.Path, c.From, c.Err)
+		panic("queuefka: Verify did not report a slab changed since it was sealed:")
+	}
+}